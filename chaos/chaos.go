@@ -0,0 +1,236 @@
+//Package chaos 为生产者和消费者注入故障,用于在没有真实集群的情况下测试服务对broker抖动的容忍度
+//故障按规则以概率或固定间隔注入,每种故障使用由种子派生的独立随机数序列,相同种子和相同事件顺序下注入结果完全一致
+//规则可以在运行时修改
+package chaos
+
+import (
+	"hash/fnv"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+)
+
+//Logger 模块的logger
+var Logger *log.Log
+
+func init() {
+	Logger = logging.New(log.Dict{"module": "kafka-chaos"})
+}
+
+//Fault 故障类型
+type Fault string
+
+const (
+	//ProducerDeliveryFailure 发送报告返回错误,消息实际上已经写入,模拟结果未知的超时
+	ProducerDeliveryFailure Fault = "producer_delivery_failure"
+	//ProducerLatency 延迟投递发送报告
+	ProducerLatency Fault = "producer_latency"
+	//ProducerDuplicate 消息被写入两次,模拟重试导致的重复
+	ProducerDuplicate Fault = "producer_duplicate"
+	//ProducerReorder 发送报告与下一个发送报告交换顺序
+	ProducerReorder Fault = "producer_reorder"
+	//ConsumerLatency 延迟投递消息
+	ConsumerLatency Fault = "consumer_latency"
+	//ConsumerDuplicate 消息被投递两次
+	ConsumerDuplicate Fault = "consumer_duplicate"
+	//ConsumerReorder 消息与下一条消息交换顺序
+	ConsumerReorder Fault = "consumer_reorder"
+	//ConsumerRebalance 在消息前插入撤销并重新分配当前分区的事件
+	ConsumerRebalance Fault = "consumer_rebalance"
+	//HandlerPanic 消息处理函数panic,需要使用Injector.Middleware
+	HandlerPanic Fault = "handler_panic"
+)
+
+//Rule 故障的注入规则,Probability和Every可以同时设置,满足任意一个就注入
+type Rule struct {
+	//Probability 每个事件注入的概率,取值0~1
+	Probability float64
+	//Every 每隔多少个事件注入一次,0表示不按间隔注入
+	Every int
+	//After 前多少个事件不注入
+	After int
+	//Limit 最多注入多少次,0表示不限制
+	Limit int
+	//Topics 只对这些topic注入,为空时对全部topic注入
+	Topics []string
+	//Latency 延迟类故障的固定延迟
+	Latency time.Duration
+	//Jitter 延迟类故障在固定延迟上增加的随机延迟上限
+	Jitter time.Duration
+}
+
+//matchTopic 规则是否适用于topic
+func (r Rule) matchTopic(topic string) bool {
+	if len(r.Topics) == 0 {
+		return true
+	}
+	for _, t := range r.Topics {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+//Options 故障注入器的设置
+type Options struct {
+	Rules    map[Fault]Rule
+	Disabled bool
+}
+
+//WithRule 设置故障的注入规则
+//@params fault Fault 故障类型
+//@params rule Rule 注入规则
+func WithRule(fault Fault, rule Rule) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.Rules == nil {
+			o.Rules = map[Fault]Rule{}
+		}
+		o.Rules[fault] = rule
+	})
+}
+
+//WithDisabled 创建时不注入故障,之后可以用Enable开启
+func WithDisabled() optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Disabled = true
+	})
+}
+
+type faultState struct {
+	rng      *rand.Rand
+	seen     int
+	injected int
+}
+
+//Injector 故障注入器,可以同时给多个生产者和消费者使用
+type Injector struct {
+	seed     int64
+	lock     sync.Mutex
+	rules    map[Fault]Rule
+	states   map[Fault]*faultState
+	disabled bool
+}
+
+//New 创建故障注入器
+//@params seed int64 随机数种子,测试失败时用相同的种子复现
+func New(seed int64, opts ...optparams.Option[Options]) *Injector {
+	o := Options{}
+	optparams.GetOption(&o, opts...)
+	inj := &Injector{
+		seed:     seed,
+		rules:    map[Fault]Rule{},
+		states:   map[Fault]*faultState{},
+		disabled: o.Disabled,
+	}
+	for f, r := range o.Rules {
+		inj.rules[f] = r
+	}
+	return inj
+}
+
+//Seed 注入器的随机数种子
+func (inj *Injector) Seed() int64 {
+	return inj.seed
+}
+
+//Set 设置故障的注入规则,会清空该故障的计数并重置随机数序列
+//@params fault Fault 故障类型
+//@params rule Rule 注入规则
+func (inj *Injector) Set(fault Fault, rule Rule) {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+	inj.rules[fault] = rule
+	delete(inj.states, fault)
+}
+
+//Clear 取消故障的注入
+//@params fault Fault 故障类型
+func (inj *Injector) Clear(fault Fault) {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+	delete(inj.rules, fault)
+}
+
+//Reset 取消全部故障并清空计数
+func (inj *Injector) Reset() {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+	inj.rules = map[Fault]Rule{}
+	inj.states = map[Fault]*faultState{}
+}
+
+//Enable 开始注入故障
+func (inj *Injector) Enable() {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+	inj.disabled = false
+}
+
+//Disable 暂停注入故障,规则和计数保留
+func (inj *Injector) Disable() {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+	inj.disabled = true
+}
+
+//Injected 故障已经注入的次数
+//@params fault Fault 故障类型
+func (inj *Injector) Injected(fault Fault) int {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+	if s, ok := inj.states[fault]; ok {
+		return s.injected
+	}
+	return 0
+}
+
+//state 故障的状态,随机数种子由注入器的种子和故障类型派生,需要持有锁
+func (inj *Injector) state(fault Fault) *faultState {
+	s, ok := inj.states[fault]
+	if !ok {
+		h := fnv.New64a()
+		h.Write([]byte(fault))
+		s = &faultState{rng: rand.New(rand.NewSource(inj.seed ^ int64(h.Sum64())))}
+		inj.states[fault] = s
+	}
+	return s
+}
+
+//decide 判断这次事件是否注入故障,注入延迟类故障时同时返回延迟
+//@params fault Fault 故障类型
+//@params topic string 事件所属的topic
+func (inj *Injector) decide(fault Fault, topic string) (bool, time.Duration) {
+	inj.lock.Lock()
+	defer inj.lock.Unlock()
+	if inj.disabled {
+		return false, 0
+	}
+	rule, ok := inj.rules[fault]
+	if !ok || !rule.matchTopic(topic) {
+		return false, 0
+	}
+	s := inj.state(fault)
+	s.seen++
+	hit := false
+	if rule.Probability > 0 && s.rng.Float64() < rule.Probability {
+		hit = true
+	}
+	if rule.Every > 0 && s.seen%rule.Every == 0 {
+		hit = true
+	}
+	if !hit || s.seen <= rule.After || (rule.Limit > 0 && s.injected >= rule.Limit) {
+		return false, 0
+	}
+	s.injected++
+	delay := rule.Latency
+	if rule.Jitter > 0 {
+		delay += time.Duration(s.rng.Int63n(int64(rule.Jitter)))
+	}
+	Logger.Debug("inject fault", log.Dict{"fault": string(fault), "topic": topic, "seq": s.seen})
+	return true, delay
+}
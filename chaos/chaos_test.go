@@ -0,0 +1,285 @@
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//decisions 对topic连续判断n次,注入的记为延迟,不注入的记为-1
+func decisions(inj *Injector, fault Fault, topic string, n int) []time.Duration {
+	result := make([]time.Duration, n)
+	for i := range result {
+		hit, delay := inj.decide(fault, topic)
+		if !hit {
+			delay = -1
+		}
+		result[i] = delay
+	}
+	return result
+}
+
+//hits 注入的事件序号,从1开始
+func hits(d []time.Duration) []int {
+	result := []int{}
+	for i, v := range d {
+		if v >= 0 {
+			result = append(result, i+1)
+		}
+	}
+	return result
+}
+
+func TestSameSeedSameInjections(t *testing.T) {
+	rule := Rule{Probability: 0.3, Latency: time.Millisecond, Jitter: 10 * time.Millisecond}
+	run := func(seed int64) []time.Duration {
+		inj := New(seed, WithRule(ConsumerLatency, rule), WithRule(ConsumerDuplicate, Rule{Probability: 0.5}))
+		return decisions(inj, ConsumerLatency, "t", 200)
+	}
+	first := run(42)
+	if n := len(hits(first)); n == 0 || n == len(first) {
+		t.Fatalf("probability 0.3 should inject some but not all events, got %d of %d", n, len(first))
+	}
+	if !reflect.DeepEqual(first, run(42)) {
+		t.Fatal("same seed should inject the same faults with the same delays")
+	}
+	if reflect.DeepEqual(first, run(43)) {
+		t.Fatal("different seeds should inject different faults")
+	}
+	//每种故障有独立的随机数序列,其他故障的判断穿插其中不影响结果
+	inj := New(42, WithRule(ConsumerLatency, rule), WithRule(ConsumerDuplicate, Rule{Probability: 0.5}))
+	interleaved := make([]time.Duration, 0, len(first))
+	for i := 0; i < len(first); i++ {
+		inj.decide(ConsumerDuplicate, "t")
+		interleaved = append(interleaved, decisions(inj, ConsumerLatency, "t", 1)...)
+	}
+	if !reflect.DeepEqual(first, interleaved) {
+		t.Fatal("decisions of another fault should not change the injections")
+	}
+}
+
+func TestRule(t *testing.T) {
+	cases := []struct {
+		name  string
+		rule  Rule
+		topic string
+		hits  []int
+	}{
+		{name: "every", rule: Rule{Every: 3}, topic: "a", hits: []int{3, 6, 9}},
+		{name: "after", rule: Rule{Every: 2, After: 5}, topic: "a", hits: []int{6, 8, 10}},
+		{name: "limit", rule: Rule{Every: 2, Limit: 2}, topic: "a", hits: []int{2, 4}},
+		{name: "after and limit", rule: Rule{Every: 1, After: 7, Limit: 2}, topic: "a", hits: []int{8, 9}},
+		{name: "matched topic", rule: Rule{Every: 5, Topics: []string{"a", "b"}}, topic: "b", hits: []int{5, 10}},
+		{name: "other topic", rule: Rule{Every: 1, Topics: []string{"a"}}, topic: "c", hits: []int{}},
+		{name: "probability one", rule: Rule{Probability: 1, Limit: 3}, topic: "a", hits: []int{1, 2, 3}},
+		{name: "no condition", rule: Rule{After: 2}, topic: "a", hits: []int{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			inj := New(1, WithRule(ProducerDuplicate, c.rule))
+			if got := hits(decisions(inj, ProducerDuplicate, c.topic, 10)); !reflect.DeepEqual(got, c.hits) {
+				t.Fatalf("expect injections at %v, got %v", c.hits, got)
+			}
+			if n := inj.Injected(ProducerDuplicate); n != len(c.hits) {
+				t.Fatalf("expect Injected %d, got %d", len(c.hits), n)
+			}
+		})
+	}
+}
+
+func TestRuleTopicsCountOnlyMatchedEvents(t *testing.T) {
+	inj := New(1, WithRule(ProducerDuplicate, Rule{Every: 2, Topics: []string{"a"}}))
+	got := []string{}
+	for i, topic := range []string{"a", "b", "a", "b", "a", "a"} {
+		if hit, _ := inj.decide(ProducerDuplicate, topic); hit {
+			got = append(got, fmt.Sprintf("%d:%s", i, topic))
+		}
+	}
+	//不匹配的topic不计入Every的事件数
+	if want := []string{"2:a", "5:a"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expect %v, got %v", want, got)
+	}
+}
+
+func TestRuntimeChanges(t *testing.T) {
+	rule := Rule{Probability: 0.5}
+	inj := New(7, WithRule(ConsumerReorder, rule))
+	first := decisions(inj, ConsumerReorder, "t", 50)
+	injected := inj.Injected(ConsumerReorder)
+	if injected != len(hits(first)) {
+		t.Fatalf("expect Injected %d, got %d", len(hits(first)), injected)
+	}
+
+	//暂停时不注入也不计数,恢复后接着原来的序列
+	inj.Disable()
+	if got := hits(decisions(inj, ConsumerReorder, "t", 10)); len(got) != 0 {
+		t.Fatalf("disabled injector should not inject, got %v", got)
+	}
+	inj.Enable()
+	fresh := New(7, WithRule(ConsumerReorder, rule))
+	want := decisions(fresh, ConsumerReorder, "t", 60)[50:]
+	if got := decisions(inj, ConsumerReorder, "t", 10); !reflect.DeepEqual(got, want) {
+		t.Fatalf("enable should continue the sequence, expect %v, got %v", want, got)
+	}
+
+	//Set重置计数和随机数序列
+	inj.Set(ConsumerReorder, rule)
+	if n := inj.Injected(ConsumerReorder); n != 0 {
+		t.Fatalf("Set should clear the count, got %d", n)
+	}
+	if got := decisions(inj, ConsumerReorder, "t", 50); !reflect.DeepEqual(got, first) {
+		t.Fatal("Set should restart the random sequence")
+	}
+	inj.Set(ConsumerReorder, Rule{Every: 4})
+	if got := hits(decisions(inj, ConsumerReorder, "t", 8)); !reflect.DeepEqual(got, []int{4, 8}) {
+		t.Fatalf("new rule should take effect, got %v", got)
+	}
+
+	//Clear取消注入但保留计数,Reset同时清空计数
+	inj.Clear(ConsumerReorder)
+	if got := hits(decisions(inj, ConsumerReorder, "t", 8)); len(got) != 0 {
+		t.Fatalf("cleared fault should not inject, got %v", got)
+	}
+	if n := inj.Injected(ConsumerReorder); n != 2 {
+		t.Fatalf("Clear should keep the count, got %d", n)
+	}
+	inj.Set(ConsumerLatency, Rule{Every: 1})
+	inj.Reset()
+	if n := inj.Injected(ConsumerReorder); n != 0 {
+		t.Fatalf("Reset should clear the count, got %d", n)
+	}
+	if hit, _ := inj.decide(ConsumerLatency, "t"); hit {
+		t.Fatal("Reset should clear all rules")
+	}
+}
+
+func TestDisabledOnCreate(t *testing.T) {
+	inj := New(1, WithRule(HandlerPanic, Rule{Every: 1}), WithDisabled())
+	if hit, _ := inj.decide(HandlerPanic, "t"); hit {
+		t.Fatal("injector created with WithDisabled should not inject")
+	}
+	inj.Enable()
+	if hit, _ := inj.decide(HandlerPanic, "t"); !hit {
+		t.Fatal("injector should inject after Enable")
+	}
+}
+
+//consumeOffsets 通过包装的消费者读取n条消息的offset
+func consumeOffsets(t *testing.T, inj *Injector, n int) []int64 {
+	t.Helper()
+	b := kafkatest.NewBroker()
+	topic := "chaos"
+	if err := b.CreateTopic(topic, 1); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	p, err := b.NewProducer(nil)
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+	for i := 0; i < 6; i++ {
+		if err := p.Produce(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0}, Value: []byte("v")}, nil); err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+	p.Flush(1000)
+	c, err := ConsumerFactory(inj, b.ConsumerFactory())(&kafka.ConfigMap{"group.id": "chaos", "auto.offset.reset": "earliest"})
+	if err != nil {
+		t.Fatalf("ConsumerFactory: %v", err)
+	}
+	defer c.Close()
+	if err := c.Subscribe(topic, nil); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	offsets := []int64{}
+	timeout := time.After(5 * time.Second)
+	for len(offsets) < n {
+		select {
+		case ev := <-c.Events():
+			if msg, ok := ev.(*kafka.Message); ok {
+				offsets = append(offsets, int64(msg.TopicPartition.Offset))
+			}
+		case <-timeout:
+			t.Fatalf("expect %d messages, got %v", n, offsets)
+		}
+	}
+	return offsets
+}
+
+func TestConsumerFaults(t *testing.T) {
+	cases := []struct {
+		name    string
+		fault   Fault
+		rule    Rule
+		offsets []int64
+	}{
+		{name: "duplicate", fault: ConsumerDuplicate, rule: Rule{Every: 2}, offsets: []int64{0, 1, 1, 2, 3, 3, 4, 5, 5}},
+		//被交换的消息等待下一条消息,交换期间不判断新的交换
+		{name: "reorder", fault: ConsumerReorder, rule: Rule{Every: 2}, offsets: []int64{0, 2, 1, 3, 5, 4}},
+		{name: "limited duplicate", fault: ConsumerDuplicate, rule: Rule{Every: 1, After: 3, Limit: 1}, offsets: []int64{0, 1, 2, 3, 3, 4, 5}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got := consumeOffsets(t, New(1, WithRule(c.fault, c.rule)), len(c.offsets))
+			if !reflect.DeepEqual(got, c.offsets) {
+				t.Fatalf("expect offsets %v, got %v", c.offsets, got)
+			}
+		})
+	}
+}
+
+func TestProducerDeliveryFailure(t *testing.T) {
+	b := kafkatest.NewBroker()
+	topic := "chaos"
+	inj := New(1, WithRule(ProducerDeliveryFailure, Rule{Every: 2}))
+	p, err := ProducerFactory(inj, b.ProducerFactory())(&kafka.ConfigMap{})
+	if err != nil {
+		t.Fatalf("ProducerFactory: %v", err)
+	}
+	defer p.Close()
+	for i := 0; i < 4; i++ {
+		if err := p.Produce(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Value: []byte("v")}, nil); err != nil {
+			t.Fatalf("Produce: %v", err)
+		}
+	}
+	failed := []bool{}
+	timeout := time.After(5 * time.Second)
+	for len(failed) < 4 {
+		select {
+		case ev := <-p.Events():
+			if msg, ok := ev.(*kafka.Message); ok {
+				failed = append(failed, msg.TopicPartition.Error != nil)
+			}
+		case <-timeout:
+			t.Fatalf("expect 4 delivery reports, got %d", len(failed))
+		}
+	}
+	if want := []bool{false, true, false, true}; !reflect.DeepEqual(failed, want) {
+		t.Fatalf("expect failures %v, got %v", want, failed)
+	}
+	//注入的失败只影响发送报告,消息已经写入
+	if n := len(b.Messages(topic)); n != 4 {
+		t.Fatalf("expect 4 messages written, got %d", n)
+	}
+}
+
+func TestMiddlewarePanics(t *testing.T) {
+	inj := New(1, WithRule(HandlerPanic, Rule{Every: 2}))
+	handler := inj.Middleware()(func(ctx context.Context, msg *kafka.Message) error { return nil })
+	topic := "t"
+	panicked := []bool{}
+	for i := 0; i < 4; i++ {
+		func() {
+			defer func() { panicked = append(panicked, recover() != nil) }()
+			handler(context.Background(), &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}})
+		}()
+	}
+	if want := []bool{false, true, false, true}; !reflect.DeepEqual(panicked, want) {
+		t.Fatalf("expect panics %v, got %v", want, panicked)
+	}
+}
@@ -0,0 +1,308 @@
+package chaos
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Consumer 注入故障的消费者,包装另一个consumerproxy.Consumer
+//消息和事件都经过注入后从Events()投递,Poll也从Events()读取;被包装的消费者没有开启events channel时会在后台Poll
+type Consumer struct {
+	consumerproxy.Consumer
+	inj        *Injector
+	events     chan kafka.Event
+	lock       sync.Mutex
+	assignment []kafka.TopicPartition
+	closeOnce  sync.Once
+	done       chan struct{}
+	pumpDone   chan struct{}
+}
+
+var _ consumerproxy.Consumer = (*Consumer)(nil)
+
+//WrapConsumer 包装消费者
+//@params c consumerproxy.Consumer 被包装的消费者
+//@params inj *Injector 故障注入器
+func WrapConsumer(c consumerproxy.Consumer, inj *Injector) *Consumer {
+	size := 1000
+	if events := c.Events(); events != nil {
+		size = cap(events)
+	}
+	w := &Consumer{
+		Consumer: c,
+		inj:      inj,
+		events:   make(chan kafka.Event, size),
+		done:     make(chan struct{}),
+		pumpDone: make(chan struct{}),
+	}
+	go w.pump()
+	return w
+}
+
+//ConsumerFactory 包装消费者的创建函数,用于consumerproxy.WithClientFactory
+//@params inj *Injector 故障注入器
+//@params next consumerproxy.ClientFactory 被包装的创建函数,为nil时使用kafka.NewConsumer
+func ConsumerFactory(inj *Injector, next consumerproxy.ClientFactory) consumerproxy.ClientFactory {
+	return func(conf *kafka.ConfigMap) (consumerproxy.Consumer, error) {
+		var c consumerproxy.Consumer
+		if next != nil {
+			var err error
+			c, err = next(conf)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			cli, err := kafka.NewConsumer(conf)
+			if err != nil {
+				return nil, err
+			}
+			c = cli
+		}
+		return WrapConsumer(c, inj), nil
+	}
+}
+
+//next 从被包装的消费者读取下一个事件,hold为true时最多等待holdTimeout
+//@returns kafka.Event 读到的事件
+//@returns bool 是否已经关闭
+//@returns bool 是否等待超时
+func (w *Consumer) next(hold bool) (kafka.Event, bool, bool) {
+	events := w.Consumer.Events()
+	deadline := time.Now().Add(holdTimeout)
+	for {
+		if events == nil {
+			select {
+			case <-w.done:
+				return nil, true, false
+			default:
+			}
+			ev := w.Consumer.Poll(100)
+			if ev != nil {
+				return ev, false, false
+			}
+			if hold && !time.Now().Before(deadline) {
+				return nil, false, true
+			}
+			continue
+		}
+		var timeout <-chan time.Time
+		if hold {
+			timer := time.NewTimer(time.Until(deadline))
+			defer timer.Stop()
+			timeout = timer.C
+		}
+		select {
+		case ev, ok := <-events:
+			if !ok {
+				return nil, true, false
+			}
+			return ev, false, false
+		case <-timeout:
+			return nil, false, true
+		case <-w.done:
+			return nil, true, false
+		}
+	}
+}
+
+//pump 注入故障后转发消息和事件
+func (w *Consumer) pump() {
+	defer close(w.pumpDone)
+	var held kafka.Event
+	release := func() bool {
+		if held == nil {
+			return true
+		}
+		ok := w.forward(held)
+		held = nil
+		return ok
+	}
+	for {
+		ev, closed, expired := w.next(held != nil)
+		if closed {
+			return
+		}
+		if expired {
+			if !release() {
+				return
+			}
+			continue
+		}
+		msg, isMsg := ev.(*kafka.Message)
+		if !isMsg {
+			if !release() || !w.forward(ev) {
+				return
+			}
+			continue
+		}
+		topic := topicOf(msg)
+		if hit, delay := w.inj.decide(ConsumerLatency, topic); hit && !w.sleep(delay) {
+			return
+		}
+		if hit, _ := w.inj.decide(ConsumerRebalance, topic); hit && !w.rebalance() {
+			return
+		}
+		if held == nil {
+			if hit, _ := w.inj.decide(ConsumerReorder, topic); hit {
+				held = msg
+				continue
+			}
+		}
+		if !w.forward(msg) {
+			return
+		}
+		if hit, _ := w.inj.decide(ConsumerDuplicate, topic); hit {
+			dup := *msg
+			if !w.forward(&dup) {
+				return
+			}
+		}
+		if !release() {
+			return
+		}
+	}
+}
+
+//rebalance 投递撤销并重新分配当前分区的事件,重新分配时从已提交的offset开始
+func (w *Consumer) rebalance() bool {
+	w.lock.Lock()
+	current := append([]kafka.TopicPartition{}, w.assignment...)
+	w.lock.Unlock()
+	if len(current) == 0 {
+		return true
+	}
+	assigned := make([]kafka.TopicPartition, len(current))
+	for i, tp := range current {
+		tp.Offset = kafka.OffsetStored
+		assigned[i] = tp
+	}
+	return w.forward(kafka.RevokedPartitions{Partitions: current}) && w.forward(kafka.AssignedPartitions{Partitions: assigned})
+}
+
+//forward 转发事件,关闭时返回false
+func (w *Consumer) forward(ev kafka.Event) bool {
+	select {
+	case w.events <- ev:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+//sleep 等待延迟,关闭时返回false
+func (w *Consumer) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+//Assign 记录分配的分区,用于注入重平衡
+func (w *Consumer) Assign(partitions []kafka.TopicPartition) error {
+	err := w.Consumer.Assign(partitions)
+	if err == nil {
+		w.lock.Lock()
+		w.assignment = append([]kafka.TopicPartition{}, partitions...)
+		w.lock.Unlock()
+	}
+	return err
+}
+
+//Unassign 清空记录的分区
+func (w *Consumer) Unassign() error {
+	err := w.Consumer.Unassign()
+	if err == nil {
+		w.lock.Lock()
+		w.assignment = nil
+		w.lock.Unlock()
+	}
+	return err
+}
+
+//IncrementalAssign 增加记录的分区,用于注入重平衡
+func (w *Consumer) IncrementalAssign(partitions []kafka.TopicPartition) error {
+	err := w.Consumer.IncrementalAssign(partitions)
+	if err == nil {
+		w.lock.Lock()
+		w.assignment = append(removePartitions(w.assignment, partitions), partitions...)
+		w.lock.Unlock()
+	}
+	return err
+}
+
+//IncrementalUnassign 移除记录的分区
+func (w *Consumer) IncrementalUnassign(partitions []kafka.TopicPartition) error {
+	err := w.Consumer.IncrementalUnassign(partitions)
+	if err == nil {
+		w.lock.Lock()
+		w.assignment = removePartitions(w.assignment, partitions)
+		w.lock.Unlock()
+	}
+	return err
+}
+
+//removePartitions 从分配中移除指定的分区,返回新的切片
+func removePartitions(assignment []kafka.TopicPartition, partitions []kafka.TopicPartition) []kafka.TopicPartition {
+	kept := []kafka.TopicPartition{}
+	for _, tp := range assignment {
+		found := false
+		for _, r := range partitions {
+			if tp.Partition == r.Partition && tp.Topic != nil && r.Topic != nil && *tp.Topic == *r.Topic {
+				found = true
+				break
+			}
+		}
+		if !found {
+			kept = append(kept, tp)
+		}
+	}
+	return kept
+}
+
+//Events 注入故障后的事件channel
+func (w *Consumer) Events() chan kafka.Event {
+	return w.events
+}
+
+//Poll 从Events()读取一个事件,超时返回nil
+//@params timeoutMs int 超时时间,单位ms
+func (w *Consumer) Poll(timeoutMs int) kafka.Event {
+	timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case ev := <-w.events:
+		return ev
+	case <-timer.C:
+		return nil
+	}
+}
+
+//Close 停止转发后关闭被包装的消费者
+func (w *Consumer) Close() error {
+	w.closeOnce.Do(func() { close(w.done) })
+	<-w.pumpDone
+	return w.Consumer.Close()
+}
+
+//Middleware 按HandlerPanic规则让消息处理函数panic的中间件,通常与ConsumerProxy.Recover一起使用
+func (inj *Injector) Middleware() consumerproxy.Middleware {
+	return func(next consumerproxy.OnMsgWithCtxCallback) consumerproxy.OnMsgWithCtxCallback {
+		return func(ctx context.Context, msg *kafka.Message) error {
+			if hit, _ := inj.decide(HandlerPanic, topicOf(msg)); hit {
+				panic("chaos: injected handler panic")
+			}
+			return next(ctx, msg)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package chaos
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//holdTimeout 交换顺序时等待下一个事件的最长时间
+const holdTimeout = 100 * time.Millisecond
+
+//Producer 注入故障的生产者,包装另一个producerproxy.Producer
+//发送报告相关的故障只作用于投递到Events()的报告,Produce时指定了deliveryChan的报告不受影响
+type Producer struct {
+	producerproxy.Producer
+	inj       *Injector
+	events    chan kafka.Event
+	lock      sync.Mutex
+	held      int
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+var _ producerproxy.Producer = (*Producer)(nil)
+
+//WrapProducer 包装生产者
+//@params p producerproxy.Producer 被包装的生产者
+//@params inj *Injector 故障注入器
+func WrapProducer(p producerproxy.Producer, inj *Injector) *Producer {
+	w := &Producer{
+		Producer: p,
+		inj:      inj,
+		done:     make(chan struct{}),
+	}
+	if events := p.Events(); events != nil {
+		w.events = make(chan kafka.Event, cap(events))
+		go w.pump(events)
+	}
+	return w
+}
+
+//ProducerFactory 包装生产者的创建函数,用于producerproxy.WithClientFactory
+//@params inj *Injector 故障注入器
+//@params next producerproxy.ClientFactory 被包装的创建函数,为nil时使用kafka.NewProducer
+func ProducerFactory(inj *Injector, next producerproxy.ClientFactory) producerproxy.ClientFactory {
+	return func(conf *kafka.ConfigMap) (producerproxy.Producer, error) {
+		var p producerproxy.Producer
+		if next != nil {
+			var err error
+			p, err = next(conf)
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			cli, err := kafka.NewProducer(conf)
+			if err != nil {
+				return nil, err
+			}
+			p = cli
+		}
+		return WrapProducer(p, inj), nil
+	}
+}
+
+//Produce 发送消息,注入ProducerDuplicate时再写入一份副本,副本的发送报告投递到Events()
+func (w *Producer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	err := w.Producer.Produce(msg, deliveryChan)
+	if err != nil {
+		return err
+	}
+	if hit, _ := w.inj.decide(ProducerDuplicate, topicOf(msg)); hit {
+		dup := *msg
+		dup.Opaque = nil
+		if derr := w.Producer.Produce(&dup, nil); derr != nil {
+			Logger.Debug("produce duplicate get error", log.Dict{"err": derr})
+		}
+	}
+	return nil
+}
+
+//pump 从被包装的生产者读取事件,注入故障后转发,被包装的Events()关闭后关闭自己的Events()
+//交换顺序的发送报告最多等待holdTimeout,之后没有新的报告也会转发
+func (w *Producer) pump(events chan kafka.Event) {
+	defer close(w.events)
+	var held kafka.Event
+	release := func() bool {
+		if held == nil {
+			return true
+		}
+		ok := w.forward(held)
+		held = nil
+		w.setHeld(0)
+		return ok
+	}
+	for {
+		ev, ok, expired := receive(events, held != nil)
+		if expired {
+			if !release() {
+				return
+			}
+			continue
+		}
+		if !ok {
+			release()
+			return
+		}
+		msg, isMsg := ev.(*kafka.Message)
+		if !isMsg {
+			if !w.forward(ev) {
+				return
+			}
+			continue
+		}
+		topic := topicOf(msg)
+		if hit, _ := w.inj.decide(ProducerDeliveryFailure, topic); hit && msg.TopicPartition.Error == nil {
+			msg.TopicPartition.Error = kafka.NewError(kafka.ErrMsgTimedOut, "chaos: injected delivery failure", false)
+		}
+		if hit, delay := w.inj.decide(ProducerLatency, topic); hit && !w.sleep(delay) {
+			return
+		}
+		if held == nil {
+			if hit, _ := w.inj.decide(ProducerReorder, topic); hit {
+				held = msg
+				w.setHeld(1)
+				continue
+			}
+		}
+		if !w.forward(msg) || !release() {
+			return
+		}
+	}
+}
+
+//receive 读取下一个事件,hold为true时最多等待holdTimeout
+func receive(events chan kafka.Event, hold bool) (kafka.Event, bool, bool) {
+	if !hold {
+		ev, ok := <-events
+		return ev, ok, false
+	}
+	timer := time.NewTimer(holdTimeout)
+	defer timer.Stop()
+	select {
+	case ev, ok := <-events:
+		return ev, ok, false
+	case <-timer.C:
+		return nil, true, true
+	}
+}
+
+//forward 转发事件,关闭时返回false
+func (w *Producer) forward(ev kafka.Event) bool {
+	select {
+	case w.events <- ev:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+//sleep 等待延迟,关闭时返回false
+func (w *Producer) sleep(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func (w *Producer) setHeld(n int) {
+	w.lock.Lock()
+	w.held = n
+	w.lock.Unlock()
+}
+
+//Events 注入故障后的事件channel
+func (w *Producer) Events() chan kafka.Event {
+	return w.events
+}
+
+//Len 被包装的生产者中未处理的事件数加上等待转发的事件数
+func (w *Producer) Len() int {
+	w.lock.Lock()
+	held := w.held
+	w.lock.Unlock()
+	return w.Producer.Len() + len(w.events) + held
+}
+
+//Flush 等待事件全部被读取,直到超时
+//@params timeoutMs int 超时时间,单位ms
+func (w *Producer) Flush(timeoutMs int) int {
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	w.Producer.Flush(timeoutMs)
+	for {
+		n := w.Len()
+		if n == 0 || !time.Now().Before(deadline) {
+			return n
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//Close 关闭被包装的生产者
+func (w *Producer) Close() {
+	w.Producer.Close()
+	w.closeOnce.Do(func() { close(w.done) })
+}
+
+//topicOf 获取消息的topic,topic为空时返回空字符串
+func topicOf(msg *kafka.Message) string {
+	if msg.TopicPartition.Topic == nil {
+		return ""
+	}
+	return *msg.TopicPartition.Topic
+}
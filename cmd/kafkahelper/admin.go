@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//newTable 输出对齐的表格
+func newTable() *tabwriter.Writer {
+	return tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+}
+
+func joinIDs(ids []int32) string {
+	s := make([]string, len(ids))
+	for i, id := range ids {
+		s[i] = fmt.Sprint(id)
+	}
+	return strings.Join(s, ",")
+}
+
+//runTopics 列出topic,带参数时只列出这些topic,-v时列出每个分区的leader,副本和isr
+func runTopics(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("topics", flag.ContinueOnError)
+	c := addCommonFlags(fs)
+	verbose := fs.Bool("v", false, "list partitions with leader, replicas and isr")
+	all := fs.Bool("all", false, "include internal topics starting with \"__\"")
+	err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	admin, _, closeAdmin, err := c.newAdmin()
+	if err != nil {
+		return err
+	}
+	defer closeAdmin()
+	md, err := admin.GetMetadata(nil, true, c.timeoutMs())
+	if err != nil {
+		return err
+	}
+	names := fs.Args()
+	if len(names) == 0 {
+		for name := range md.Topics {
+			if *all || !strings.HasPrefix(name, "__") {
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	tw := newTable()
+	defer tw.Flush()
+	if *verbose {
+		fmt.Fprintln(tw, "TOPIC\tPARTITION\tLEADER\tREPLICAS\tISR\tERROR")
+	} else {
+		fmt.Fprintln(tw, "TOPIC\tPARTITIONS\tERROR")
+	}
+	for _, name := range names {
+		tm, ok := md.Topics[name]
+		if !ok {
+			fmt.Fprintf(tw, "%s\t-\tunknown topic\n", name)
+			continue
+		}
+		topicErr := ""
+		if tm.Error.Code() != kafka.ErrNoError {
+			topicErr = tm.Error.Error()
+		}
+		if !*verbose {
+			fmt.Fprintf(tw, "%s\t%d\t%s\n", name, len(tm.Partitions), topicErr)
+			continue
+		}
+		partitions := append([]kafka.PartitionMetadata{}, tm.Partitions...)
+		sort.Slice(partitions, func(i, j int) bool { return partitions[i].ID < partitions[j].ID })
+		for _, p := range partitions {
+			partErr := topicErr
+			if p.Error.Code() != kafka.ErrNoError {
+				partErr = p.Error.Error()
+			}
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%s\t%s\t%s\n", name, p.ID, p.Leader, joinIDs(p.Replicas), joinIDs(p.Isrs), partErr)
+		}
+	}
+	return nil
+}
+
+//runLag 查看消费组在指定topic每个分区上已提交的offset,末尾offset和积压,没有提交过的分区积压显示为`-`且不计入总计
+func runLag(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("lag", flag.ContinueOnError)
+	c := addCommonFlags(fs)
+	group := fs.String("group", "", "consumer group (required)")
+	var topics listFlag
+	fs.Var(&topics, "topic", "only show these `topics`, can be repeated or separated by commas")
+	err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if *group == "" {
+		if fs.NArg() != 1 {
+			return usageErrorf("-group is required")
+		}
+		*group = fs.Arg(0)
+	}
+	if len(topics) == 0 {
+		return usageErrorf("-topic is required")
+	}
+	cli, closeGroup, err := c.newGroupClient(*group)
+	if err != nil {
+		return err
+	}
+	defer closeGroup()
+	request := []kafka.TopicPartition{}
+	for _, topic := range topics {
+		partitions, err := partitionsOf(cli, topic, c.timeoutMs())
+		if err != nil {
+			return err
+		}
+		for _, id := range partitions {
+			t := topic
+			request = append(request, kafka.TopicPartition{Topic: &t, Partition: id})
+		}
+	}
+	committed, err := cli.Committed(request, c.timeoutMs())
+	if err != nil {
+		return err
+	}
+	sort.Slice(committed, func(i, j int) bool {
+		if *committed[i].Topic != *committed[j].Topic {
+			return *committed[i].Topic < *committed[j].Topic
+		}
+		return committed[i].Partition < committed[j].Partition
+	})
+	tw := newTable()
+	defer tw.Flush()
+	fmt.Fprintln(tw, "TOPIC\tPARTITION\tCOMMITTED\tEND\tLAG")
+	var total int64
+	for _, tp := range committed {
+		if tp.Error != nil {
+			fmt.Fprintf(tw, "%s\t%d\t%v\t\t\n", *tp.Topic, tp.Partition, tp.Error)
+			continue
+		}
+		_, high, err := cli.QueryWatermarkOffsets(*tp.Topic, tp.Partition, c.timeoutMs())
+		if err != nil {
+			fmt.Fprintf(tw, "%s\t%d\t%s\t%v\t\n", *tp.Topic, tp.Partition, tp.Offset, err)
+			continue
+		}
+		//没有提交过offset时实际的起点取决于auto.offset.reset,无法给出积压
+		if tp.Offset < 0 {
+			fmt.Fprintf(tw, "%s\t%d\t-\t%d\t-\n", *tp.Topic, tp.Partition, high)
+			continue
+		}
+		lag := high - int64(tp.Offset)
+		if lag < 0 {
+			lag = 0
+		}
+		total += lag
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%d\t%d\n", *tp.Topic, tp.Partition, tp.Offset, high, lag)
+	}
+	fmt.Fprintf(tw, "TOTAL\t\t\t\t%d\n", total)
+	return nil
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//errUsage 参数错误,退出码为2
+var errUsage = errors.New("invalid usage")
+
+//usageErrorf 构造参数错误
+func usageErrorf(format string, args ...any) error {
+	return fmt.Errorf("%w: %s", errUsage, fmt.Sprintf(format, args...))
+}
+
+//commonFlags 所有子命令共用的参数
+type commonFlags struct {
+	brokers   string
+	config    string
+	env       bool
+	envPrefix string
+	logLevel  string
+	timeout   time.Duration
+}
+
+//addCommonFlags 注册共用的参数
+func addCommonFlags(fs *flag.FlagSet) *commonFlags {
+	c := &commonFlags{}
+	fs.StringVar(&c.brokers, "brokers", "", "`servers` to bootstrap from, separated by commas, overrides bootstrap.servers from -config and -env")
+	fs.StringVar(&c.config, "config", "", "yaml, json or toml config file read with the proxy option loaders")
+	fs.BoolVar(&c.env, "env", false, "read settings from environment variables, e.g. KAFKA_BOOTSTRAP_SERVERS")
+	fs.StringVar(&c.envPrefix, "env-prefix", "KAFKA_", "prefix of the environment variables read by -env")
+	fs.StringVar(&c.logLevel, "log-level", "WARN", "log level of the proxies, logs are written to stderr")
+	fs.DurationVar(&c.timeout, "timeout", 10*time.Second, "timeout of metadata requests and of flushing on exit")
+	return c
+}
+
+//parse 解析参数并设置日志级别
+func (c *commonFlags) parse(fs *flag.FlagSet, args []string) error {
+	err := fs.Parse(args)
+	if err != nil {
+		if err == flag.ErrHelp {
+			return err
+		}
+		return fmt.Errorf("%w: %v", errUsage, err)
+	}
+	log.Set(log.WithLevel(c.logLevel))
+	return nil
+}
+
+//timeoutMs 元数据请求的超时时间,单位ms
+func (c *commonFlags) timeoutMs() int {
+	return int(c.timeout / time.Millisecond)
+}
+
+//producerOptions 由配置文件和环境变量得到生产者代理的设置
+func (c *commonFlags) producerOptions() ([]optparams.Option[producerproxy.Options], error) {
+	opts := []optparams.Option[producerproxy.Options]{producerproxy.WithName("kafkahelper")}
+	if c.config != "" {
+		opt, err := producerproxy.OptionsFromFile(c.config)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	if c.env {
+		opt, err := producerproxy.OptionsFromEnv(c.envPrefix)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+//consumerOptions 由配置文件和环境变量得到消费者代理的设置
+func (c *commonFlags) consumerOptions() ([]optparams.Option[consumerproxy.Options], error) {
+	opts := []optparams.Option[consumerproxy.Options]{consumerproxy.WithName("kafkahelper")}
+	if c.config != "" {
+		opt, err := consumerproxy.OptionsFromFile(c.config)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	if c.env {
+		opt, err := consumerproxy.OptionsFromEnv(c.envPrefix)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, opt)
+	}
+	return opts, nil
+}
+
+//newProducer 创建生产者代理
+//@params opts ...optparams.Option[producerproxy.Options] 命令行参数对应的设置,覆盖配置文件和环境变量
+func (c *commonFlags) newProducer(opts ...optparams.Option[producerproxy.Options]) (*producerproxy.ProducerProxy, error) {
+	base, err := c.producerOptions()
+	if err != nil {
+		return nil, err
+	}
+	proxy := producerproxy.New()
+	err = proxy.Init(c.brokers, append(base, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+//newConsumer 创建消费者代理,开启events channel,没有指定消费组时使用随机的消费组并关闭自动提交
+//@params opts ...optparams.Option[consumerproxy.Options] 命令行参数对应的设置,覆盖配置文件和环境变量
+func (c *commonFlags) newConsumer(opts ...optparams.Option[consumerproxy.Options]) (*consumerproxy.ConsumerProxy, error) {
+	base, err := c.consumerOptions()
+	if err != nil {
+		return nil, err
+	}
+	base = append(base, consumerproxy.WithComsumerSetting("go.events.channel.enable", true))
+	proxy := consumerproxy.New()
+	err = proxy.Init(c.brokers, append(base, opts...)...)
+	if err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+//anonymous 没有消费组时的设置,使用随机的消费组且不提交offset
+func anonymous() []optparams.Option[consumerproxy.Options] {
+	return []optparams.Option[consumerproxy.Options]{
+		consumerproxy.WithUUID4GroupID("kafkahelper"),
+		consumerproxy.WithComsumerSetting("enable.auto.commit", false),
+	}
+}
+
+//newAdmin 创建管理客户端,管理客户端复用匿名消费者的连接,返回的关闭函数会同时关闭两者
+func (c *commonFlags) newAdmin() (*kafka.AdminClient, consumerproxy.Consumer, func(), error) {
+	proxy, err := c.newConsumer(anonymous()...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cli, ok := proxy.Consumer.(*kafka.Consumer)
+	if !ok {
+		proxy.Close()
+		return nil, nil, nil, fmt.Errorf("consumer %T does not support admin requests", proxy.Consumer)
+	}
+	admin, err := kafka.NewAdminClientFromConsumer(cli)
+	if err != nil {
+		proxy.Close()
+		return nil, nil, nil, err
+	}
+	return admin, cli, func() {
+		admin.Close()
+		proxy.Close()
+	}, nil
+}
+
+//newGroupClient 创建使用指定消费组的消费者,只用来查询消费组已提交的offset,不订阅也不加入消费组,返回的关闭函数会关闭它
+func (c *commonFlags) newGroupClient(group string) (*kafka.Consumer, func(), error) {
+	proxy, err := c.newConsumer(consumerproxy.WithGroupID(group), consumerproxy.WithComsumerSetting("enable.auto.commit", false))
+	if err != nil {
+		return nil, nil, err
+	}
+	cli, ok := proxy.Consumer.(*kafka.Consumer)
+	if !ok {
+		proxy.Close()
+		return nil, nil, fmt.Errorf("consumer %T does not support committed offset requests", proxy.Consumer)
+	}
+	return cli, func() { proxy.Close() }, nil
+}
+
+//listFlag 可以重复设置,也可以用`,`分隔的参数
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(v string) error {
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			*l = append(*l, s)
+		}
+	}
+	return nil
+}
+
+//mapFlag 可以重复设置的`key=value`参数
+type mapFlag struct {
+	keys   []string
+	values map[string]string
+}
+
+func (m *mapFlag) String() string {
+	if m == nil {
+		return ""
+	}
+	pairs := make([]string, 0, len(m.keys))
+	for _, k := range m.keys {
+		pairs = append(pairs, k+"="+m.values[k])
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *mapFlag) Set(v string) error {
+	k, val, ok := strings.Cut(v, "=")
+	if !ok || k == "" {
+		return fmt.Errorf("expect key=value, got %q", v)
+	}
+	if m.values == nil {
+		m.values = map[string]string{}
+	}
+	if _, exists := m.values[k]; !exists {
+		m.keys = append(m.keys, k)
+	}
+	m.values[k] = val
+	return nil
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//partitionKey 分区的标识
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+func keyOf(msg *kafka.Message) partitionKey {
+	k := partitionKey{partition: msg.TopicPartition.Partition}
+	if msg.TopicPartition.Topic != nil {
+		k.topic = *msg.TopicPartition.Topic
+	}
+	return k
+}
+
+//position 指定分区模式下的起始位置
+type position struct {
+	fromBeginning bool
+	offset        int64
+	timestamp     string
+	last          int
+	partitions    listFlag
+}
+
+//addPositionFlags 注册起始位置的参数
+//@params last int -last的默认值
+func addPositionFlags(fs *flag.FlagSet, last int) *position {
+	p := &position{}
+	fs.BoolVar(&p.fromBeginning, "from-beginning", false, "start from the earliest offset")
+	fs.Int64Var(&p.offset, "offset", -1, "start from this offset of every partition (assign mode)")
+	fs.StringVar(&p.timestamp, "timestamp", "", "start from the first message at or after a RFC3339 time, unix milliseconds or a negative duration like -1h (assign mode)")
+	fs.IntVar(&p.last, "last", last, "start from the last N messages of every partition (assign mode)")
+	fs.Var(&p.partitions, "partition", "only read these `partitions`, can be repeated or separated by commas (assign mode)")
+	return p
+}
+
+//assignMode 是否设置了只有指定分区模式支持的起始位置
+func (p *position) assignMode() bool {
+	return p.offset >= 0 || p.timestamp != "" || p.last > 0 || len(p.partitions) > 0
+}
+
+//parseTimestamp 解析-timestamp,支持RFC3339,unix毫秒和相对当前时间的负时长
+func parseTimestamp(s string) (time.Time, error) {
+	if strings.HasPrefix(s, "-") {
+		if d, err := time.ParseDuration(s); err == nil {
+			return time.Now().Add(d), nil
+		}
+	}
+	if ms, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return time.UnixMilli(ms), nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return time.Time{}, usageErrorf("invalid -timestamp %q", s)
+	}
+	return t, nil
+}
+
+//partitionsOf 查询topic的全部分区
+func partitionsOf(mc consumerproxy.Consumer, topic string, timeoutMs int) ([]int32, error) {
+	md, err := mc.GetMetadata(&topic, false, timeoutMs)
+	if err != nil {
+		return nil, err
+	}
+	tm, ok := md.Topics[topic]
+	if !ok {
+		return nil, fmt.Errorf("topic %q not found", topic)
+	}
+	if tm.Error.Code() != kafka.ErrNoError {
+		return nil, fmt.Errorf("topic %q: %w", topic, tm.Error)
+	}
+	partitions := make([]int32, 0, len(tm.Partitions))
+	for _, p := range tm.Partitions {
+		partitions = append(partitions, p.ID)
+	}
+	return partitions, nil
+}
+
+//resolve 计算每个分区的起始offset,同时返回开始时每个分区的末尾offset
+func (p *position) resolve(mc consumerproxy.Consumer, topics []string, timeoutMs int) ([]kafka.TopicPartition, map[partitionKey]int64, error) {
+	var at time.Time
+	if p.timestamp != "" {
+		var err error
+		if at, err = parseTimestamp(p.timestamp); err != nil {
+			return nil, nil, err
+		}
+	}
+	assignment := []kafka.TopicPartition{}
+	ends := map[partitionKey]int64{}
+	for _, topic := range topics {
+		var partitions []int32
+		if len(p.partitions) > 0 {
+			for _, s := range p.partitions {
+				id, err := strconv.ParseInt(s, 10, 32)
+				if err != nil {
+					return nil, nil, usageErrorf("invalid -partition %q", s)
+				}
+				partitions = append(partitions, int32(id))
+			}
+		} else {
+			var err error
+			if partitions, err = partitionsOf(mc, topic, timeoutMs); err != nil {
+				return nil, nil, err
+			}
+		}
+		for _, id := range partitions {
+			t := topic
+			low, high, err := mc.QueryWatermarkOffsets(topic, id, timeoutMs)
+			if err != nil {
+				return nil, nil, fmt.Errorf("query watermarks of %s[%d]: %w", topic, id, err)
+			}
+			ends[partitionKey{topic, id}] = high
+			tp := kafka.TopicPartition{Topic: &t, Partition: id, Offset: kafka.OffsetEnd}
+			switch {
+			case !at.IsZero():
+				tp.Offset = kafka.Offset(at.UnixMilli())
+				found, err := mc.OffsetsForTimes([]kafka.TopicPartition{tp}, timeoutMs)
+				if err != nil {
+					return nil, nil, fmt.Errorf("query offset by time of %s[%d]: %w", topic, id, err)
+				}
+				tp.Offset = found[0].Offset
+			case p.offset >= 0:
+				tp.Offset = kafka.Offset(p.offset)
+			case p.fromBeginning:
+				tp.Offset = kafka.Offset(low)
+			case p.last > 0:
+				start := high - int64(p.last)
+				if start < low {
+					start = low
+				}
+				tp.Offset = kafka.Offset(start)
+			}
+			assignment = append(assignment, tp)
+		}
+	}
+	return assignment, ends, nil
+}
+
+//reader 消费消息直到被取消,达到条数上限或读到开始时的末尾
+type reader struct {
+	proxy   *consumerproxy.ConsumerProxy
+	handle  func(msg *kafka.Message) error
+	max     int
+	pending map[partitionKey]int64
+	idle    time.Duration
+
+	count    int
+	stopped  bool
+	lastSeen time.Time
+	lock     sync.Mutex
+	cancel   context.CancelFunc
+}
+
+//onMessage 交给消费者代理的消息处理函数,Watch中按顺序调用
+func (r *reader) onMessage(_ context.Context, msg *kafka.Message) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.stopped {
+		return nil
+	}
+	r.lastSeen = time.Now()
+	if err := r.handle(msg); err != nil {
+		r.stop()
+		return err
+	}
+	r.count++
+	if r.max > 0 && r.count >= r.max {
+		r.stop()
+		return nil
+	}
+	if r.pending != nil {
+		k := keyOf(msg)
+		if end, ok := r.pending[k]; ok && int64(msg.TopicPartition.Offset)+1 >= end {
+			delete(r.pending, k)
+		}
+		if len(r.pending) == 0 {
+			r.stop()
+		}
+	}
+	return nil
+}
+
+//stop 停止读取,需要持有锁
+func (r *reader) stop() {
+	r.stopped = true
+	r.cancel()
+}
+
+//run 开始监听并等待结束,结束后关闭消费者代理
+//@returns int 处理的消息数
+func (r *reader) run(ctx context.Context, c *commonFlags) (int, error) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	defer r.cancel()
+	r.lock.Lock()
+	r.lastSeen = time.Now()
+	if r.pending != nil && len(r.pending) == 0 {
+		r.stop()
+	}
+	r.lock.Unlock()
+	r.proxy.Watch()
+	if r.idle > 0 {
+		go r.watchIdle(ctx)
+	}
+	<-ctx.Done()
+	sctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	err := r.proxy.Shutdown(sctx)
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.count, err
+}
+
+//watchIdle 超过idle没有收到消息时停止
+func (r *reader) watchIdle(ctx context.Context) {
+	ticker := time.NewTicker(r.idle / 4)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.lock.Lock()
+			if time.Since(r.lastSeen) >= r.idle {
+				r.stop()
+			}
+			r.lock.Unlock()
+		}
+	}
+}
+
+//runConsume 消费消息并按格式输出到标准输出
+//指定了-group时加入消费组订阅topic,否则以指定分区模式从起始位置读取
+func runConsume(ctx context.Context, args []string) error {
+	return consume(ctx, "consume", args, 0)
+}
+
+//runTail 以指定分区模式从每个分区的最后几条消息开始持续输出
+func runTail(ctx context.Context, args []string) error {
+	return consume(ctx, "tail", args, 10)
+}
+
+func consume(ctx context.Context, name string, args []string, last int) error {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	c := addCommonFlags(fs)
+	pos := addPositionFlags(fs, last)
+	var topics listFlag
+	fs.Var(&topics, "topic", "`topics` to consume, can be repeated or separated by commas (required)")
+	group := fs.String("group", "", "consume as a member of this group and commit offsets (group mode)")
+	max := fs.Int("n", 0, "exit after this many messages, 0 means no limit")
+	exit := fs.Bool("exit", false, "exit after reaching the end offsets seen at start (assign mode)")
+	idle := fs.Duration("idle", 0, "exit when no message arrives for this long, 0 means never")
+	format := fs.String("format", "value", formatHelp)
+	err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	topics = append(topics, fs.Args()...)
+	if len(topics) == 0 {
+		return usageErrorf("-topic is required")
+	}
+	out, err := newFormatter(*format)
+	if err != nil {
+		return err
+	}
+	if *group != "" && (name == "tail" || pos.assignMode() || *exit) {
+		return usageErrorf("-group can not be used with -offset, -timestamp, -last, -partition, -exit or tail")
+	}
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	r := &reader{
+		max:  *max,
+		idle: *idle,
+		handle: func(msg *kafka.Message) error {
+			if err := out(w, msg); err != nil {
+				return err
+			}
+			return w.Flush()
+		},
+	}
+	if *group != "" {
+		opts := []optparams.Option[consumerproxy.Options]{
+			consumerproxy.WithGroupID(*group),
+			consumerproxy.WithAutoOffsetReset("latest"),
+			consumerproxy.WithComsumerSetting("go.application.rebalance.enable", true),
+		}
+		if pos.fromBeginning {
+			opts[1] = consumerproxy.WithAutoOffsetReset("earliest")
+		}
+		if r.proxy, err = c.newConsumer(opts...); err != nil {
+			return err
+		}
+		if err = r.proxy.SubscribeTopics(topics, nil); err != nil {
+			r.proxy.Close()
+			return err
+		}
+	} else {
+		if r.proxy, err = c.newConsumer(anonymous()...); err != nil {
+			return err
+		}
+		if err = assign(c, r, pos, topics, *exit); err != nil {
+			r.proxy.Close()
+			return err
+		}
+	}
+	if err = r.proxy.OnMessageWithContext(r.onMessage); err != nil {
+		r.proxy.Close()
+		return err
+	}
+	_, err = r.run(ctx, c)
+	return err
+}
+
+//assign 计算起始位置并分配分区,exit为true时记录需要读到的末尾offset
+func assign(c *commonFlags, r *reader, pos *position, topics []string, exit bool) error {
+	assignment, ends, err := pos.resolve(r.proxy.Consumer, topics, c.timeoutMs())
+	if err != nil {
+		return err
+	}
+	if exit {
+		r.pending = map[partitionKey]int64{}
+		for _, tp := range assignment {
+			k := partitionKey{*tp.Topic, tp.Partition}
+			end := ends[k]
+			if tp.Offset < 0 || int64(tp.Offset) >= end {
+				continue
+			}
+			r.pending[k] = end
+		}
+	}
+	return r.proxy.Assign(assignment)
+}
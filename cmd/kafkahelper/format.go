@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/recorder"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//formatHelp -format参数的说明
+const formatHelp = "output `format`: value, kv, json (a record line readable by load) or a text/template such as " +
+	"'{{.Topic}}/{{.Partition}}@{{.Offset}} {{.Key}}={{.Value}} {{index .Headers \"trace\"}}'"
+
+//formatter 将消息写入输出
+type formatter func(w io.Writer, msg *kafka.Message) error
+
+//messageView 模板中使用的消息,key,value和headers都转换为字符串
+type messageView struct {
+	Topic         string
+	Partition     int32
+	Offset        int64
+	Key           string
+	Value         string
+	Headers       map[string]string
+	Timestamp     time.Time
+	TimestampType string
+}
+
+func viewOf(msg *kafka.Message) messageView {
+	v := messageView{
+		Partition:     msg.TopicPartition.Partition,
+		Offset:        int64(msg.TopicPartition.Offset),
+		Key:           string(msg.Key),
+		Value:         string(msg.Value),
+		Headers:       map[string]string{},
+		Timestamp:     msg.Timestamp,
+		TimestampType: msg.TimestampType.String(),
+	}
+	if msg.TopicPartition.Topic != nil {
+		v.Topic = *msg.TopicPartition.Topic
+	}
+	for _, h := range msg.Headers {
+		v.Headers[h.Key] = string(h.Value)
+	}
+	return v
+}
+
+var templateFuncs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	"hex": func(s string) string {
+		return hex.EncodeToString([]byte(s))
+	},
+	"base64": func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	},
+	"unixms": func(t time.Time) int64 {
+		return t.UnixMilli()
+	},
+}
+
+//newFormatter 由-format参数得到输出格式,模板的结果没有以换行结尾时会补上换行
+//@params spec string 预置的格式名或text/template模板
+func newFormatter(spec string) (formatter, error) {
+	switch spec {
+	case "", "value":
+		return func(w io.Writer, msg *kafka.Message) error {
+			_, err := fmt.Fprintf(w, "%s\n", msg.Value)
+			return err
+		}, nil
+	case "kv":
+		return func(w io.Writer, msg *kafka.Message) error {
+			_, err := fmt.Fprintf(w, "%s\t%s\n", msg.Key, msg.Value)
+			return err
+		}, nil
+	case "json":
+		return func(w io.Writer, msg *kafka.Message) error {
+			data, err := json.Marshal(recorder.FromMessage(msg))
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(append(data, '\n'))
+			return err
+		}, nil
+	}
+	tmpl, err := template.New("format").Funcs(templateFuncs).Parse(spec)
+	if err != nil {
+		return nil, usageErrorf("invalid -format: %v", err)
+	}
+	newline := !strings.HasSuffix(spec, "\n")
+	return func(w io.Writer, msg *kafka.Message) error {
+		buf := bytes.Buffer{}
+		if err := tmpl.Execute(&buf, viewOf(msg)); err != nil {
+			return err
+		}
+		if newline {
+			buf.WriteByte('\n')
+		}
+		_, err := w.Write(buf.Bytes())
+		return err
+	}, nil
+}
@@ -0,0 +1,90 @@
+//kafkahelper 基于生产者和消费者代理的命令行工具,用于脚本和值班时排查问题
+//
+//用法:
+//
+//	kafkahelper <command> [flags] [args]
+//
+//子命令:
+//
+//	produce  从标准输入,文件或参数发送消息
+//	consume  消费消息,支持消费组模式和指定分区模式
+//	tail     从每个分区的最后几条消息开始持续输出
+//	dump     将topic导出为录制文件
+//	load     将录制文件重新发送到kafka
+//	topics   列出topic和分区
+//	lag      查看消费组在指定topic上的积压
+//
+//所有子命令都支持`-brokers`,`-config`和`-env`,设置按配置文件,环境变量,命令行参数的顺序覆盖
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	log "github.com/Golang-Tools/loggerhelper/v2"
+)
+
+//command 子命令
+type command struct {
+	name  string
+	usage string
+	run   func(ctx context.Context, args []string) error
+}
+
+var commands = []command{
+	{"produce", "produce messages from stdin, a file or flags", runProduce},
+	{"consume", "consume messages in group or assign mode", runConsume},
+	{"tail", "print the last messages of each partition and follow", runTail},
+	{"dump", "dump topics into a record file", runDump},
+	{"load", "republish a record file", runLoad},
+	{"topics", "list topics and partitions", runTopics},
+	{"lag", "show the lag of a consumer group", runLag},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: kafkahelper <command> [flags] [args]\n\ncommands:\n")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nrun `kafkahelper <command> -h` for the flags of a command\n")
+}
+
+func main() {
+	log.Set(log.WithOutput(os.Stderr), log.WithLevel("WARN"))
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	name := os.Args[1]
+	if name == "-h" || name == "-help" || name == "--help" || name == "help" {
+		usage()
+		return
+	}
+	for _, c := range commands {
+		if c.name != name {
+			continue
+		}
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		err := c.run(ctx, os.Args[2:])
+		stop()
+		if errors.Is(err, flag.ErrHelp) {
+			return
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "kafkahelper %s: %v\n", name, err)
+			if errors.Is(err, errUsage) {
+				os.Exit(2)
+			}
+			os.Exit(1)
+		}
+		return
+	}
+	fmt.Fprintf(os.Stderr, "kafkahelper: unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
+}
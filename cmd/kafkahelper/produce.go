@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//runProduce 发送消息,设置了-value时只发送这些值,否则从-file或标准输入按行读取消息
+func runProduce(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("produce", flag.ContinueOnError)
+	c := addCommonFlags(fs)
+	topic := fs.String("topic", "", "topic to produce to (required)")
+	key := fs.String("key", "", "key of every message")
+	keySep := fs.String("key-sep", "", "split each input line into key and value at the first separator")
+	partition := fs.Int("partition", int(kafka.PartitionAny), "partition to produce to, -1 lets the partitioner choose")
+	file := fs.String("file", "-", "file to read messages from, one message per line, '-' for stdin")
+	sync := fs.Bool("sync", false, "wait for the delivery report of each message before sending the next")
+	var values listFlag
+	var headers mapFlag
+	fs.Var(&values, "value", "value to send instead of reading input, can be repeated")
+	fs.Var(&headers, "header", "header `key=value` added to every message, can be repeated")
+	err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if *topic == "" {
+		return usageErrorf("-topic is required")
+	}
+	msgOpts := []optparams.Option[kafka.Message]{}
+	if *partition >= 0 {
+		msgOpts = append(msgOpts, msghelper.WithPartition(int32(*partition)))
+	}
+	for _, k := range headers.keys {
+		msgOpts = append(msgOpts, msghelper.AddHeader(k, []byte(headers.values[k])))
+	}
+	build := func(k, v []byte) *kafka.Message {
+		opts := msgOpts
+		if len(k) > 0 {
+			opts = append([]optparams.Option[kafka.Message]{msghelper.WithKey(k)}, msgOpts...)
+		}
+		return msghelper.NewMsg(*topic, v, opts...)
+	}
+
+	proxy, err := c.newProducer()
+	if err != nil {
+		return err
+	}
+	send := proxy.SendWithContext
+	if *sync {
+		send = proxy.SendAndWaitWithContext
+	}
+	count := 0
+	if len(values) > 0 {
+		for _, v := range values {
+			if err = send(ctx, build([]byte(*key), []byte(v))); err != nil {
+				break
+			}
+			count++
+		}
+	} else {
+		var in io.Reader = os.Stdin
+		if *file != "-" {
+			f, ferr := os.Open(*file)
+			if ferr != nil {
+				proxy.Close()
+				return ferr
+			}
+			defer f.Close()
+			in = f
+		}
+		count, err = produceLines(ctx, in, func(line []byte) error {
+			k := []byte(*key)
+			if *keySep != "" {
+				if before, after, ok := bytes.Cut(line, []byte(*keySep)); ok {
+					k, line = before, after
+				}
+			}
+			return send(ctx, build(k, line))
+		})
+	}
+	serr := shutdownProducer(c, proxy, count)
+	if err == nil {
+		err = serr
+	}
+	return err
+}
+
+//produceLines 按行读取输入并发送,行尾的换行符会被去掉,空行被跳过
+func produceLines(ctx context.Context, in io.Reader, send func(line []byte) error) (int, error) {
+	r := bufio.NewReader(in)
+	count := 0
+	for {
+		line, err := r.ReadBytes('\n')
+		line = bytes.TrimRight(line, "\r\n")
+		if len(line) > 0 {
+			if ctx.Err() != nil {
+				return count, ctx.Err()
+			}
+			if serr := send(line); serr != nil {
+				return count, serr
+			}
+			count++
+		}
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+	}
+}
+
+//shutdownProducer 在超时时间内flush并关闭生产者代理,在标准错误输出发送的结果
+//@params sent int 交给代理的消息数
+func shutdownProducer(c *commonFlags, proxy *producerproxy.ProducerProxy, sent int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+	_, err := proxy.Shutdown(ctx)
+	delivered := proxy.DeliveredRecords()
+	fmt.Fprintf(os.Stderr, "delivered %d/%d messages\n", delivered, sent)
+	if err == nil && delivered < int64(sent) {
+		err = fmt.Errorf("%d messages were not delivered", int64(sent)-delivered)
+	}
+	return err
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/Golang-Tools/kafkahelper/recorder"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//runDump 以指定分区模式读取topic并写入录制文件,读到开始时的末尾后退出
+func runDump(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("dump", flag.ContinueOnError)
+	c := addCommonFlags(fs)
+	pos := addPositionFlags(fs, 0)
+	var topics listFlag
+	fs.Var(&topics, "topic", "`topics` to dump, can be repeated or separated by commas (required)")
+	output := fs.String("o", "-", "record file to write, '-' for stdout")
+	formatName := fs.String("format", "ndjson", "record format, ndjson or binary")
+	max := fs.Int("n", 0, "stop after this many messages, 0 means no limit")
+	idle := fs.Duration("idle", 0, "stop when no message arrives for this long, 0 means never")
+	err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	topics = append(topics, fs.Args()...)
+	if len(topics) == 0 {
+		return usageErrorf("-topic is required")
+	}
+	format, err := recorder.ParseFormat(*formatName)
+	if err != nil {
+		return usageErrorf("%v", err)
+	}
+	//没有指定起始位置时导出整个topic
+	if !pos.assignMode() {
+		pos.fromBeginning = true
+	}
+	var w *recorder.Writer
+	if *output == "-" {
+		w, err = recorder.NewWriter(os.Stdout, format)
+	} else {
+		w, err = recorder.Create(*output, format)
+	}
+	if err != nil {
+		return err
+	}
+	r := &reader{
+		max:  *max,
+		idle: *idle,
+		handle: func(msg *kafka.Message) error {
+			return w.Write(recorder.FromMessage(msg))
+		},
+	}
+	if r.proxy, err = c.newConsumer(anonymous()...); err != nil {
+		w.Close()
+		return err
+	}
+	if err = assign(c, r, pos, topics, true); err != nil {
+		r.proxy.Close()
+		w.Close()
+		return err
+	}
+	if err = r.proxy.OnMessageWithContext(r.onMessage); err != nil {
+		r.proxy.Close()
+		w.Close()
+		return err
+	}
+	count, err := r.run(ctx, c)
+	if cerr := w.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	fmt.Fprintf(os.Stderr, "dumped %d messages\n", count)
+	return err
+}
+
+//runLoad 将录制文件通过生产者代理重新发送
+func runLoad(ctx context.Context, args []string) error {
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	c := addCommonFlags(fs)
+	input := fs.String("i", "-", "record file to read, ndjson or binary, '-' for stdin")
+	topic := fs.String("topic", "", "send every record to this topic instead of the recorded one")
+	var topicMap mapFlag
+	fs.Var(&topicMap, "topic-map", "rename a recorded topic with `from=to`, can be repeated")
+	speed := fs.Float64("speed", 0, "replay speed relative to the recorded intervals, 0 sends as fast as possible")
+	keepPartition := fs.Bool("keep-partition", false, "send to the recorded partition instead of letting the partitioner choose")
+	wait := fs.Bool("sync", false, "wait for the delivery report of each record before sending the next")
+	err := c.parse(fs, args)
+	if err != nil {
+		return err
+	}
+	if *topic != "" && len(topicMap.keys) > 0 {
+		return usageErrorf("-topic and -topic-map can not be used together")
+	}
+	var r *recorder.Reader
+	if *input == "-" {
+		r, err = recorder.NewReader(os.Stdin)
+	} else {
+		r, err = recorder.Open(*input)
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	opts := []optparams.Option[recorder.ReplayOptions]{recorder.WithSpeed(recorder.Speed(*speed))}
+	switch {
+	case *topic != "":
+		opts = append(opts, recorder.WithTopicMap(func(string) string { return *topic }))
+	case len(topicMap.keys) > 0:
+		opts = append(opts, recorder.WithTopicMap(func(t string) string {
+			if to, ok := topicMap.values[t]; ok {
+				return to
+			}
+			return t
+		}))
+	}
+	if *keepPartition {
+		opts = append(opts, recorder.WithKeepPartition())
+	}
+	if *wait {
+		opts = append(opts, recorder.WithWaitDelivery())
+	}
+	proxy, err := c.newProducer()
+	if err != nil {
+		return err
+	}
+	count, err := recorder.Republish(ctx, r, proxy, opts...)
+	if serr := shutdownProducer(c, proxy, count); err == nil {
+		err = serr
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+package consumerproxy
+
+import (
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Consumer 代理使用到的消费者方法,*kafka.Consumer满足该接口,单元测试中可以使用kafkatest模块中的内存实现
+//需要调用接口之外的方法时可以断言为*kafka.Consumer
+type Consumer interface {
+	Subscribe(topic string, rebalanceCb kafka.RebalanceCb) error
+	SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error
+	Unsubscribe() error
+	Assign(partitions []kafka.TopicPartition) error
+	Unassign() error
+	IncrementalAssign(partitions []kafka.TopicPartition) error
+	IncrementalUnassign(partitions []kafka.TopicPartition) error
+	GetRebalanceProtocol() string
+	AssignmentLost() bool
+	Assignment() (partitions []kafka.TopicPartition, err error)
+	Pause(partitions []kafka.TopicPartition) error
+	Resume(partitions []kafka.TopicPartition) error
+	Seek(partition kafka.TopicPartition, ignoredTimeoutMs int) error
+	Position(partitions []kafka.TopicPartition) (offsets []kafka.TopicPartition, err error)
+	GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error)
+	QueryWatermarkOffsets(topic string, partition int32, timeoutMs int) (low, high int64, err error)
+	OffsetsForTimes(times []kafka.TopicPartition, timeoutMs int) (offsets []kafka.TopicPartition, err error)
+	Events() chan kafka.Event
+	Logs() chan kafka.LogEvent
+	Poll(timeoutMs int) kafka.Event
+	StoreMessage(m *kafka.Message) ([]kafka.TopicPartition, error)
+	Commit() ([]kafka.TopicPartition, error)
+	Close() error
+	SetOAuthBearerToken(oauthBearerToken kafka.OAuthBearerToken) error
+	SetOAuthBearerTokenFailure(errstr string) error
+}
+
+var _ Consumer = (*kafka.Consumer)(nil)
+
+//ClientFactory 由设置创建消费者的函数
+type ClientFactory func(conf *kafka.ConfigMap) (Consumer, error)
+
+//WithClientFactory 设置创建消费者的函数,默认使用kafka.NewConsumer
+//@params factory ClientFactory 创建消费者的函数
+func WithClientFactory(factory ClientFactory) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.ClientFactory = factory
+	})
+}
+
+//newClient 按设置创建消费者
+func (proxy *ConsumerProxy) newClient() (Consumer, error) {
+	return proxy.newClientWith(&proxy.Opt.ConfigMap)
+}
+
+//newClientWith 使用代理的创建函数按指定的设置创建消费者
+//@params conf *kafka.ConfigMap 消费者的设置
+func (proxy *ConsumerProxy) newClientWith(conf *kafka.ConfigMap) (Consumer, error) {
+	if proxy.Opt.ClientFactory != nil {
+		return proxy.Opt.ClientFactory(conf)
+	}
+	cli, err := kafka.NewConsumer(conf)
+	if err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
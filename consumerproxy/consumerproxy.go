@@ -1,62 +1,105 @@
 package consumerproxy
 
 import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
 	log "github.com/Golang-Tools/loggerhelper/v2"
 	"github.com/Golang-Tools/optparams"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
 
+//Logger 模块默认的logger,代理未设置WithLogger时使用
 var Logger *log.Log
 
 func init() {
-	log.Set(log.WithExtFields(log.Dict{"module": "kafka-consumer-proxy"}))
-	Logger = log.Export()
-	log.Set(log.WithExtFields(log.Dict{}))
+	Logger = logging.New(log.Dict{"module": "kafka-consumer-proxy"})
 }
 
 //Callback redis操作的回调函数
-type Callback func(cli *kafka.Consumer) error
+type Callback func(cli Consumer) error
 
 type OnMsgCallback func(evt *kafka.Message)
+
+//OnMsgWithCtxCallback 带上下文的消息处理函数,开启链路追踪时ctx中带有consumer span
+type OnMsgWithCtxCallback func(ctx context.Context, evt *kafka.Message) error
 type OnErrorCallback func(err kafka.Error)
 
+//OnPartitionEOFCallback 读到分区末尾时的回调,partition的Offset为分区末尾的offset
+type OnPartitionEOFCallback func(partition kafka.TopicPartition)
+
+//Middleware 消息处理中间件,包装消息处理函数,可以在处理前后做额外的事情,比如去重
+type Middleware func(next OnMsgWithCtxCallback) OnMsgWithCtxCallback
+
 //ConsumerProxy redis客户端的代理
 type ConsumerProxy struct {
-	*kafka.Consumer
+	Consumer
 	Opt           Options
 	callBacks     []Callback
-	msgCallback   OnMsgCallback
+	msgCallback   OnMsgWithCtxCallback
+	middlewares   []Middleware
+	handler       OnMsgWithCtxCallback
 	errorCallback OnErrorCallback
+	eofCallback   OnPartitionEOFCallback
+	onAssigned    OnRebalanceCallback
+	onRevoked     OnRebalanceCallback
+	onLost        OnRebalanceCallback
+	flow          *flowControl
+	namedLogger   *log.Log
+	loggerName    string
+	stopWatch     func()
+	watchDone     chan struct{}
+	closed        bool
 }
 
 // New 创建一个新的数据库客户端代理
 func New() *ConsumerProxy {
 	proxy := new(ConsumerProxy)
 	proxy.Opt = DefaultOptions
+	proxy.Opt.ConfigMap = kafka.ConfigMap{}
+	for k, v := range DefaultOptions.ConfigMap {
+		proxy.Opt.ConfigMap[k] = v
+	}
 	proxy.callBacks = []Callback{}
+	proxy.flow = newFlowControl()
 	return proxy
 }
 
+//Name 代理的名字,未设置时为default
+func (proxy *ConsumerProxy) Name() string {
+	if proxy.Opt.Name == "" {
+		return "default"
+	}
+	return proxy.Opt.Name
+}
+
 // IsOk 检查代理是否已经可用
 func (proxy *ConsumerProxy) IsOk() bool {
 	return proxy.Consumer != nil
 }
 
 //SetConnect 设置连接的客户端
-//@params cli UniversalClient 满足redis.UniversalClient接口的对象的指针
-func (proxy *ConsumerProxy) SetConnect(cli *kafka.Consumer) error {
+//@params cli Consumer 被代理的消费者,可以是*kafka.Consumer或其他满足Consumer接口的实现
+func (proxy *ConsumerProxy) SetConnect(cli Consumer) error {
 	if proxy.IsOk() {
 		return ErrProxyAllreadySettedClient
 	}
 	proxy.Consumer = cli
+	if logs := cli.Logs(); logs != nil {
+		go proxy.pumpLogs(logs)
+	}
+	proxy.refreshOAuthBearerToken()
 	if proxy.Opt.ParallelCallback {
 		for _, cb := range proxy.callBacks {
 			go func(cb Callback) {
 				err := cb(proxy.Consumer)
 				if err != nil {
-					Logger.Error("regist callback get error", log.Dict{"err": err})
+					proxy.logger().Error("regist callback get error", log.Dict{"err": err})
 				} else {
-					Logger.Debug("regist callback done")
+					proxy.logger().Debug("regist callback done")
 				}
 			}(cb)
 		}
@@ -64,9 +107,9 @@ func (proxy *ConsumerProxy) SetConnect(cli *kafka.Consumer) error {
 		for _, cb := range proxy.callBacks {
 			err := cb(proxy.Consumer)
 			if err != nil {
-				Logger.Error("regist callback get error", log.Dict{"err": err})
+				proxy.logger().Error("regist callback get error", log.Dict{"err": err})
 			} else {
-				Logger.Debug("regist callback done")
+				proxy.logger().Debug("regist callback done")
 			}
 		}
 	}
@@ -74,15 +117,29 @@ func (proxy *ConsumerProxy) SetConnect(cli *kafka.Consumer) error {
 }
 
 //Init 从配置条件初始化代理对象
-//@params endpoints string 设置etcd连接的地址端点,以`,`分隔
+//@params endpoints string 设置kafka连接的地址端点,以`,`分隔,为空时使用设置中的`bootstrap.servers`
 //@params opts ...optparams.Option[Options]
 func (proxy *ConsumerProxy) Init(endpoints string, opts ...optparams.Option[Options]) error {
 	optparams.GetOption(&proxy.Opt, opts...)
+	proxy.refreshLogger()
 	if proxy.Opt.ConfigMap == nil {
 		proxy.Opt.ConfigMap = kafka.ConfigMap{}
 	}
-	proxy.Opt.ConfigMap["bootstrap.servers"] = endpoints
-	cli, err := kafka.NewConsumer(&proxy.Opt.ConfigMap)
+	if endpoints != "" {
+		proxy.Opt.ConfigMap["bootstrap.servers"] = endpoints
+	}
+	proxy.Opt.ConfigMap = proxy.Opt.Effective()
+	if !proxy.Opt.SkipValidation {
+		err := proxy.Opt.Validate()
+		if err != nil {
+			return err
+		}
+		for _, k := range kafkaconf.Unknown(proxy.Opt.ConfigMap) {
+			proxy.logger().Warn("unknown config key, left to librdkafka", log.Dict{"key": k})
+		}
+	}
+	proxy.logger().Debug("init client", proxy.Opt.LogValue())
+	cli, err := proxy.newClient()
 	if err != nil {
 		return err
 	}
@@ -103,6 +160,19 @@ func (proxy *ConsumerProxy) Regist(cb ...Callback) error {
 //OnMessage 注册消息处理函数
 //@params cb OnMsgCallback 消息处理的回调
 func (proxy *ConsumerProxy) OnMessage(cb OnMsgCallback) error {
+	if proxy.msgCallback != nil {
+		return ErrProxyAllreadySettedCallback
+	}
+	proxy.msgCallback = func(_ context.Context, evt *kafka.Message) error {
+		cb(evt)
+		return nil
+	}
+	return nil
+}
+
+//OnMessageWithContext 注册带上下文的消息处理函数,与OnMessage只能设置一个
+//@params cb OnMsgWithCtxCallback 消息处理的回调,返回的错误会被记录到span和日志中
+func (proxy *ConsumerProxy) OnMessageWithContext(cb OnMsgWithCtxCallback) error {
 	if proxy.msgCallback != nil {
 		return ErrProxyAllreadySettedCallback
 	}
@@ -120,35 +190,89 @@ func (proxy *ConsumerProxy) OnError(cb OnErrorCallback) error {
 	return nil
 }
 
+//OnPartitionEOF 注册读到分区末尾时的回调,需要开启`enable.partition.eof`
+//回调与消息处理函数在同一个goroutine中按顺序调用,调用时该分区在末尾之前的消息都已经交给了处理函数
+//@params cb OnPartitionEOFCallback 读到分区末尾时的回调
+func (proxy *ConsumerProxy) OnPartitionEOF(cb OnPartitionEOFCallback) error {
+	if proxy.eofCallback != nil {
+		return ErrProxyAllreadySettedCallback
+	}
+	proxy.eofCallback = cb
+	return nil
+}
+
+//Use 注册消息处理中间件,先注册的在外层,需要在Watch前注册
+//@params mw ...Middleware 消息处理中间件
+func (proxy *ConsumerProxy) Use(mw ...Middleware) {
+	proxy.middlewares = append(proxy.middlewares, mw...)
+}
+
+//buildHandler 使用中间件包装消息处理函数,未设置处理函数时只打印消息
+func (proxy *ConsumerProxy) buildHandler() OnMsgWithCtxCallback {
+	handler := proxy.msgCallback
+	if handler == nil {
+		handler = func(ctx context.Context, msg *kafka.Message) error {
+			proxy.logger().Info("Get Message", log.Dict{"topic": topicOf(msg), "key": string(msg.Key), "value": string(msg.Value)})
+			return nil
+		}
+	}
+	for i := len(proxy.middlewares) - 1; i >= 0; i-- {
+		handler = proxy.middlewares[i](handler)
+	}
+	return handler
+}
+
+//topicOf 获取消息的topic,topic为空时返回空字符串
+func topicOf(msg *kafka.Message) string {
+	if msg.TopicPartition.Topic == nil {
+		return ""
+	}
+	return *msg.TopicPartition.Topic
+}
+
 //Watch 开始监听kafka
+//@returns func() 停止监听,可以重复调用
 func (proxy *ConsumerProxy) Watch() func() {
 	stopCh := make(chan struct{}, 1)
+	done := make(chan struct{})
+	var once sync.Once
+	proxy.stopWatch = func() { once.Do(func() { close(stopCh) }) }
+	proxy.watchDone = done
+	proxy.handler = proxy.buildHandler()
 	go func() {
+		defer close(done)
 		run := true
 		for run {
 			select {
 			case <-stopCh:
-				Logger.Info("Stop Watching")
+				proxy.logger().Info("Stop Watching")
 				run = false
 			case ev := <-proxy.Events():
 				switch e := ev.(type) {
 				case kafka.AssignedPartitions:
-					Logger.Error("Get AssignedPartitions event", log.Dict{"event": e})
-					proxy.Assign(e.Partitions)
+					proxy.handleAssigned(e.Partitions)
 				case kafka.RevokedPartitions:
-					Logger.Error("Get RevokedPartitions event", log.Dict{"event": e})
-					proxy.Unassign()
+					proxy.handleRevoked(e.Partitions)
 				case *kafka.Message:
-					if proxy.msgCallback == nil {
-						Logger.Info("Get Message", log.Dict{"topic": *e.TopicPartition.Topic, "key": string(e.Key), "value": string(e.Value)})
+					if proxy.backpressure() {
+						proxy.dispatch(e)
 					} else {
-						proxy.msgCallback(e)
+						proxy.handleMessage(e)
+					}
+				case kafka.OAuthBearerTokenRefresh:
+					proxy.refreshOAuthBearerToken()
+				case *kafka.Stats:
+					if proxy.Opt.Metrics != nil {
+						proxy.Opt.Metrics.Stats(proxy.Name(), e.String())
 					}
 				case kafka.PartitionEOF:
-					Logger.Info("Reached", log.Dict{"event": e})
+					proxy.logger().Info("Reached", log.Dict{"event": e})
+					if proxy.eofCallback != nil {
+						proxy.eofCallback(kafka.TopicPartition(e))
+					}
 				case kafka.Error:
 					if proxy.errorCallback == nil {
-						Logger.Error("Get error", log.Dict{"error": e})
+						proxy.logger().Error("Get error", log.Dict{"error": e})
 					} else {
 						proxy.errorCallback(e)
 					}
@@ -156,7 +280,45 @@ func (proxy *ConsumerProxy) Watch() func() {
 			}
 		}
 	}()
-	return func() { close(stopCh) }
+	return proxy.stopWatch
+}
+
+//handleMessage 处理一条消息,设置了指标记录器时会记录处理情况
+func (proxy *ConsumerProxy) handleMessage(msg *kafka.Message) {
+	topic := topicOf(msg)
+	partition := msg.TopicPartition.Partition
+	m := proxy.Opt.Metrics
+	if m != nil {
+		m.MessageConsumed(proxy.Name(), topic, partition)
+		m.ConsumerInFlight(proxy.Name(), topic, partition, 1)
+		start := time.Now()
+		defer func() {
+			m.ConsumerInFlight(proxy.Name(), topic, partition, -1)
+			m.HandlerDuration(proxy.Name(), topic, partition, time.Since(start))
+		}()
+	}
+	ctx, span := proxy.startSpan(context.Background(), msg)
+	handler := proxy.handler
+	if handler == nil {
+		handler = proxy.buildHandler()
+	}
+	err := handler(ctx, msg)
+	if err != nil {
+		proxy.logger().Error("handle message get error", log.Dict{"err": err, "topic": topic, "partition": partition, "offset": msg.TopicPartition.Offset})
+	}
+	if err == nil && proxy.manualOffsetStore() {
+		_, err := proxy.StoreMessage(msg)
+		if err != nil {
+			proxy.logger().Error("store offset get error", log.Dict{"err": err, "topic": topic, "partition": partition, "offset": msg.TopicPartition.Offset})
+		}
+	}
+	endSpan(span, err)
+}
+
+//manualOffsetStore 设置了enable.auto.offset.store=false时,消息处理成功后才记录offset
+func (proxy *ConsumerProxy) manualOffsetStore() bool {
+	v, ok := proxy.Opt.ConfigMap["enable.auto.offset.store"].(bool)
+	return ok && !v
 }
 
 //Default 默认的kafka Consumer代理对象
@@ -1,9 +1,44 @@
 package consumerproxy
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	"github.com/Golang-Tools/kafkahelper/internal/registry"
+)
 
 //ErrProxyAllreadySettedClient 代理已经设置过kafka消费者客户端
 var ErrProxyAllreadySettedClient = errors.New("cannot reset consumer")
 
 //ErrProxyAllreadySettedCallback 代理已经设置过回调函数
 var ErrProxyAllreadySettedCallback = errors.New("cannot reset callback")
+
+//ErrProxyAllreadyRegistered 注册表中已经有同名的代理
+var ErrProxyAllreadyRegistered = registry.ErrAlreadyRegistered
+
+//ErrProxyNotRegistered 注册表中没有这个名字的代理
+var ErrProxyNotRegistered = registry.ErrNotRegistered
+
+//ErrProxyNotYetSettedClient 代理还未设置客户端对象
+var ErrProxyNotYetSettedClient = errors.New("not set consumer yet")
+
+//ErrProxyClosed 代理已经关闭
+var ErrProxyClosed = errors.New("consumer proxy closed")
+
+//ErrConfigUnknownKey 设置中有未知的设置项
+var ErrConfigUnknownKey = kafkaconf.ErrUnknownKey
+
+//ErrConfigInvalidValue 设置项的值类型或取值不对
+var ErrConfigInvalidValue = kafkaconf.ErrInvalidValue
+
+//ErrConfigWrongRole 设置项不适用于这类客户端
+var ErrConfigWrongRole = kafkaconf.ErrWrongRole
+
+//ErrConfigMissingKey 缺少必须的设置项
+var ErrConfigMissingKey = kafkaconf.ErrMissingKey
+
+//ErrConfigConflict 设置项之间相互冲突
+var ErrConfigConflict = kafkaconf.ErrConflict
+
+//ErrHandlerPanic 消息处理函数panic,由Recover中间件返回
+var ErrHandlerPanic = errors.New("message handler panic")
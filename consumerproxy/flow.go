@@ -0,0 +1,368 @@
+package consumerproxy
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//WithBackpressure 开启按分区的背压控制
+//开启后Watch将消息按分区放入队列,每个分区由单独的goroutine按顺序处理,不同分区并行处理.
+//分区上缓冲和正在处理的消息数达到high时暂停拉取该分区,处理到不超过low时恢复.
+//暂停前已经进入events channel的消息仍会被投递,需要更严格的上限时可以调小`go.events.channel.size`.
+//被撤销的分区会先处理完已缓冲的消息再提交offset,建议配合`enable.auto.offset.store=false`使用,让offset只在消息处理完后记录
+//@params high int 暂停拉取的消息数,小于等于0时不开启
+//@params low int 恢复拉取的消息数,不在[0,high)之间时使用high/2
+func WithBackpressure(high, low int) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if high <= 0 {
+			o.HighWatermark, o.LowWatermark = 0, 0
+			return
+		}
+		if low < 0 || low >= high {
+			low = high / 2
+		}
+		o.HighWatermark, o.LowWatermark = high, low
+	})
+}
+
+//WithRevokeTimeout 设置开启背压控制时分区被撤销后等待已缓冲消息处理完的最长时间,默认30s,小于等于0时一直等待
+//超时后不再等待,直接提交已记录的offset并撤销分区,还没处理完的消息可能被接手的成员重复处理
+//@params timeout time.Duration 等待的最长时间
+func WithRevokeTimeout(timeout time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.RevokeTimeout = timeout
+	})
+}
+
+//topicPartition 分区的标识
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+//kafkaPartition 转换为kafka.TopicPartition
+func (k topicPartition) kafkaPartition() kafka.TopicPartition {
+	topic := k.topic
+	return kafka.TopicPartition{Topic: &topic, Partition: k.partition}
+}
+
+//partitionWorker 一个分区的消息队列和处理goroutine
+type partitionWorker struct {
+	key     topicPartition
+	queue   []*kafka.Message
+	pending int
+	paused  bool
+	closing bool
+	done    chan struct{}
+}
+
+//flowControl 背压和手动暂停的状态,由lock保护
+//Pause和Resume不在lock中调用,由pauseLock保证按顺序执行,applied记录已经对消费者生效的暂停状态
+//revoked记录已经撤销的分区,stopped表示全部分区已经停止,此后events channel中残留的这些分区的消息会被丢弃
+type flowControl struct {
+	lock      sync.Mutex
+	cond      *sync.Cond
+	workers   map[topicPartition]*partitionWorker
+	topics    map[string]bool
+	revoked   map[topicPartition]bool
+	stopped   bool
+	pauseLock sync.Mutex
+	applied   map[topicPartition]bool
+}
+
+func newFlowControl() *flowControl {
+	f := &flowControl{
+		workers: map[topicPartition]*partitionWorker{},
+		topics:  map[string]bool{},
+		revoked: map[topicPartition]bool{},
+		applied: map[topicPartition]bool{},
+	}
+	f.cond = sync.NewCond(&f.lock)
+	return f
+}
+
+//wantPaused 分区应该处于的暂停状态,需要持有lock
+func (f *flowControl) wantPaused(key topicPartition) bool {
+	if f.topics[key.topic] {
+		return true
+	}
+	w, ok := f.workers[key]
+	return ok && w.paused
+}
+
+//backpressure 是否开启了背压控制
+func (proxy *ConsumerProxy) backpressure() bool {
+	return proxy.Opt.HighWatermark > 0
+}
+
+//dispatch 将消息放入分区的队列,达到高水位时暂停拉取该分区
+//已经撤销或停止的分区的消息不再处理,由接手的成员从提交的offset重新读取
+func (proxy *ConsumerProxy) dispatch(msg *kafka.Message) {
+	f := proxy.flow
+	key := topicPartition{topicOf(msg), msg.TopicPartition.Partition}
+	f.lock.Lock()
+	if f.stopped || f.revoked[key] {
+		f.lock.Unlock()
+		proxy.logger().Debug("drop message of revoked partition", log.Dict{"topic": key.topic, "partition": key.partition, "offset": msg.TopicPartition.Offset})
+		return
+	}
+	w, ok := f.workers[key]
+	if !ok {
+		w = &partitionWorker{key: key, done: make(chan struct{})}
+		f.workers[key] = w
+		go proxy.runWorker(w)
+	}
+	w.queue = append(w.queue, msg)
+	w.pending++
+	pause := !w.paused && w.pending >= proxy.Opt.HighWatermark
+	if pause {
+		w.paused = true
+	}
+	f.cond.Broadcast()
+	f.lock.Unlock()
+	if pause {
+		proxy.syncPaused([]topicPartition{key}, "backpressure")
+	}
+}
+
+//runWorker 按顺序处理分区队列中的消息,处理到低水位时恢复拉取,关闭时处理完队列中的消息后退出
+func (proxy *ConsumerProxy) runWorker(w *partitionWorker) {
+	f := proxy.flow
+	defer close(w.done)
+	for {
+		f.lock.Lock()
+		for len(w.queue) == 0 && !w.closing {
+			f.cond.Wait()
+		}
+		if len(w.queue) == 0 {
+			f.lock.Unlock()
+			return
+		}
+		msg := w.queue[0]
+		w.queue[0] = nil
+		w.queue = w.queue[1:]
+		f.lock.Unlock()
+		proxy.handleMessage(msg)
+		f.lock.Lock()
+		w.pending--
+		resume := w.paused && w.pending <= proxy.Opt.LowWatermark
+		if resume {
+			w.paused = false
+		}
+		closing := w.closing
+		f.lock.Unlock()
+		if resume && !closing {
+			proxy.syncPaused([]topicPartition{w.key}, "backpressure")
+		}
+	}
+}
+
+//syncPaused 让分区的暂停状态与背压和PauseTopic的状态一致
+//状态在pauseLock中重新读取,因此并发调用时最后生效的总是最新的状态
+//@returns error 第一个Pause或Resume的错误
+func (proxy *ConsumerProxy) syncPaused(keys []topicPartition, reason string) error {
+	f := proxy.flow
+	f.pauseLock.Lock()
+	defer f.pauseLock.Unlock()
+	pause, resume := []kafka.TopicPartition{}, []kafka.TopicPartition{}
+	f.lock.Lock()
+	for _, key := range keys {
+		want := f.wantPaused(key)
+		if want == f.applied[key] {
+			continue
+		}
+		if want {
+			pause = append(pause, key.kafkaPartition())
+		} else {
+			resume = append(resume, key.kafkaPartition())
+		}
+	}
+	f.lock.Unlock()
+	perr := proxy.setPaused(pause, true, reason)
+	rerr := proxy.setPaused(resume, false, reason)
+	f.lock.Lock()
+	if perr == nil {
+		for _, tp := range pause {
+			f.applied[topicPartition{*tp.Topic, tp.Partition}] = true
+		}
+	}
+	if rerr == nil {
+		for _, tp := range resume {
+			delete(f.applied, topicPartition{*tp.Topic, tp.Partition})
+		}
+	}
+	f.lock.Unlock()
+	if perr != nil {
+		return perr
+	}
+	return rerr
+}
+
+//stopWorkers 停止分区的处理goroutine并等待它们处理完已缓冲的消息,之后收到的这些分区的消息会被丢弃
+//@params ctx context.Context 控制等待的时间,结束时不再等待并返回ctx的错误
+//@params partitions []kafka.TopicPartition 要停止的分区,为nil时停止全部分区
+func (proxy *ConsumerProxy) stopWorkers(ctx context.Context, partitions []kafka.TopicPartition) error {
+	f := proxy.flow
+	f.lock.Lock()
+	stopping := []*partitionWorker{}
+	stop := func(key topicPartition) {
+		delete(f.applied, key)
+		if w, ok := f.workers[key]; ok {
+			w.closing = true
+			delete(f.workers, key)
+			stopping = append(stopping, w)
+		}
+	}
+	if partitions == nil {
+		f.stopped = true
+		for key := range f.workers {
+			stop(key)
+		}
+	} else {
+		for _, tp := range partitions {
+			if tp.Topic != nil {
+				key := topicPartition{*tp.Topic, tp.Partition}
+				f.revoked[key] = true
+				stop(key)
+			}
+		}
+	}
+	f.cond.Broadcast()
+	f.lock.Unlock()
+	for _, w := range stopping {
+		select {
+		case <-w.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+//setPaused 暂停或恢复拉取分区,失败时记录日志并返回错误
+func (proxy *ConsumerProxy) setPaused(partitions []kafka.TopicPartition, paused bool, reason string) error {
+	if len(partitions) == 0 {
+		return nil
+	}
+	var err error
+	if paused {
+		err = proxy.Pause(partitions)
+	} else {
+		err = proxy.Resume(partitions)
+	}
+	if err != nil {
+		proxy.logger().Error("pause or resume partitions get error", log.Dict{"err": err, "paused": paused, "partitions": partitions, "reason": reason})
+		return err
+	}
+	proxy.logger().Info("partitions paused or resumed", log.Dict{"paused": paused, "partitions": partitions, "reason": reason})
+	return nil
+}
+
+//partitionsOfTopic 当前分配中属于topic的分区
+func (proxy *ConsumerProxy) partitionsOfTopic(topic string) ([]topicPartition, error) {
+	assignment, err := proxy.Assignment()
+	if err != nil {
+		return nil, err
+	}
+	partitions := []topicPartition{}
+	for _, tp := range assignment {
+		if tp.Topic != nil && *tp.Topic == topic {
+			partitions = append(partitions, topicPartition{topic, tp.Partition})
+		}
+	}
+	return partitions, nil
+}
+
+//PauseTopic 暂停拉取topic的全部分区,之后分配到的该topic的分区也会被暂停,直到调用ResumeTopic
+//已经拉取到的消息仍会被处理,可以用于下游不可用时(比如熔断器打开)停止消费
+//@params topic string topic名
+func (proxy *ConsumerProxy) PauseTopic(topic string) error {
+	if !proxy.IsOk() {
+		return ErrProxyNotYetSettedClient
+	}
+	partitions, err := proxy.partitionsOfTopic(topic)
+	if err != nil {
+		return err
+	}
+	f := proxy.flow
+	f.lock.Lock()
+	f.topics[topic] = true
+	f.lock.Unlock()
+	err = proxy.syncPaused(partitions, "topic paused")
+	if err != nil {
+		return err
+	}
+	proxy.logger().Info("topic paused", log.Dict{"topic": topic, "partitions": len(partitions)})
+	return nil
+}
+
+//ResumeTopic 恢复拉取PauseTopic暂停的topic,仍处于背压暂停的分区会在处理到低水位后恢复
+//@params topic string topic名
+func (proxy *ConsumerProxy) ResumeTopic(topic string) error {
+	if !proxy.IsOk() {
+		return ErrProxyNotYetSettedClient
+	}
+	partitions, err := proxy.partitionsOfTopic(topic)
+	if err != nil {
+		return err
+	}
+	f := proxy.flow
+	f.lock.Lock()
+	delete(f.topics, topic)
+	f.lock.Unlock()
+	err = proxy.syncPaused(partitions, "topic resumed")
+	if err != nil {
+		return err
+	}
+	proxy.logger().Info("topic resumed", log.Dict{"topic": topic, "partitions": len(partitions)})
+	return nil
+}
+
+//PausedTopics 被PauseTopic暂停的topic
+func (proxy *ConsumerProxy) PausedTopics() []string {
+	f := proxy.flow
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	topics := make([]string, 0, len(f.topics))
+	for topic := range f.topics {
+		topics = append(topics, topic)
+	}
+	return topics
+}
+
+//Buffered 分区上已经拉取但还没有处理完的消息数,只在开启背压控制时统计
+//@params topic string topic名
+//@params partition int32 分区
+func (proxy *ConsumerProxy) Buffered(topic string, partition int32) int {
+	f := proxy.flow
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if w, ok := f.workers[topicPartition{topic, partition}]; ok {
+		return w.pending
+	}
+	return 0
+}
+
+//pauseAssigned 新分配的分区属于被PauseTopic暂停的topic时暂停拉取
+//分配后分区在消费者中处于未暂停的状态,之前撤销的分区重新分配时恢复处理它们的消息
+func (proxy *ConsumerProxy) pauseAssigned(partitions []kafka.TopicPartition) {
+	f := proxy.flow
+	keys := []topicPartition{}
+	f.lock.Lock()
+	for _, tp := range partitions {
+		if tp.Topic == nil {
+			continue
+		}
+		key := topicPartition{*tp.Topic, tp.Partition}
+		delete(f.revoked, key)
+		delete(f.applied, key)
+		keys = append(keys, key)
+	}
+	f.lock.Unlock()
+	proxy.syncPaused(keys, "topic paused")
+}
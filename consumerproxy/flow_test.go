@@ -0,0 +1,240 @@
+package consumerproxy_test
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//waitFor 等待条件成立
+func waitFor(t *testing.T, timeout time.Duration, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(timeout)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestPauseTopicWithBackpressure(t *testing.T) {
+	b := kafkatest.NewBroker()
+	topic := "flow"
+	if err := b.CreateTopic(topic, 2); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	producer, err := b.NewProducerProxy()
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	defer producer.Close()
+	consumer, err := b.NewConsumerProxy("flow-group",
+		consumerproxy.WithBackpressure(4, 1),
+		consumerproxy.WithComsumerSetting("go.events.channel.enable", true),
+		consumerproxy.WithComsumerSetting("go.application.rebalance.enable", true),
+	)
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	got := make(chan *kafka.Message, 100)
+	consumer.OnMessage(func(msg *kafka.Message) {
+		time.Sleep(time.Millisecond)
+		got <- msg
+	})
+	assigned := make(chan struct{}, 1)
+	consumer.OnAssigned(func(partitions []kafka.TopicPartition) { assigned <- struct{}{} })
+	if err := consumer.Subscribe(topic, nil); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	consumer.Watch()
+	defer consumer.Shutdown(context.Background())
+	select {
+	case <-assigned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("partitions not assigned")
+	}
+	waitFor(t, time.Second, "partitions assigned", func() bool {
+		a, _ := consumer.Assignment()
+		return len(a) == 2
+	})
+	if err := consumer.PauseTopic(topic); err != nil {
+		t.Fatalf("PauseTopic: %v", err)
+	}
+	send := func(n int) {
+		for i := 0; i < n; i++ {
+			if err := producer.SendAndWait(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: int32(i % 2)}, Value: []byte("v")}); err != nil {
+				t.Fatalf("SendAndWait: %v", err)
+			}
+		}
+	}
+	send(20)
+	select {
+	case msg := <-got:
+		t.Fatalf("paused topic should not be consumed, got %v", msg.TopicPartition)
+	case <-time.After(300 * time.Millisecond):
+	}
+	if err := consumer.ResumeTopic(topic); err != nil {
+		t.Fatalf("ResumeTopic: %v", err)
+	}
+	for i := 0; i < 20; i++ {
+		select {
+		case <-got:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expect 20 messages after resume, got %d", i)
+		}
+	}
+	if topics := consumer.PausedTopics(); len(topics) != 0 {
+		t.Fatalf("expect no paused topic, got %v", topics)
+	}
+}
+
+//pauseRecorder 记录Pause和Resume调用时分区中缓冲的消息数
+type pauseRecorder struct {
+	consumerproxy.Consumer
+	buffered func() int
+	lock     sync.Mutex
+	events   []string
+}
+
+func (r *pauseRecorder) record(action string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, fmt.Sprintf("%s@%d", action, r.buffered()))
+}
+
+func (r *pauseRecorder) snapshot() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string{}, r.events...)
+}
+
+func (r *pauseRecorder) Pause(partitions []kafka.TopicPartition) error {
+	r.record("pause")
+	return r.Consumer.Pause(partitions)
+}
+
+func (r *pauseRecorder) Resume(partitions []kafka.TopicPartition) error {
+	r.record("resume")
+	return r.Consumer.Resume(partitions)
+}
+
+func TestBackpressureWatermarks(t *testing.T) {
+	const (
+		high  = 4
+		low   = 1
+		total = 10
+	)
+	b := kafkatest.NewBroker()
+	topic := "watermark"
+	if err := b.CreateTopic(topic, 1); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	producer, err := b.NewProducerProxy()
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	defer producer.Close()
+	recorder := &pauseRecorder{}
+	newConsumer := b.ConsumerFactory()
+	consumer, err := b.NewConsumerProxy("watermark-group",
+		consumerproxy.WithBackpressure(high, low),
+		consumerproxy.WithComsumerSetting("go.events.channel.enable", true),
+		consumerproxy.WithComsumerSetting("go.events.channel.size", 1),
+		consumerproxy.WithComsumerSetting("go.application.rebalance.enable", true),
+		consumerproxy.WithClientFactory(func(conf *kafka.ConfigMap) (consumerproxy.Consumer, error) {
+			c, err := newConsumer(conf)
+			if err != nil {
+				return nil, err
+			}
+			recorder.Consumer = c
+			return recorder, nil
+		}),
+	)
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	buffered := func() int { return consumer.Buffered(topic, 0) }
+	recorder.buffered = buffered
+	//处理函数每处理一条消息需要从gate中取得一次许可
+	gate := make(chan struct{})
+	release := make(chan struct{})
+	var handled int32
+	consumer.OnMessage(func(msg *kafka.Message) {
+		select {
+		case <-gate:
+		case <-release:
+		}
+		atomic.AddInt32(&handled, 1)
+	})
+	if err := consumer.Subscribe(topic, nil); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	consumer.Watch()
+	defer consumer.Shutdown(context.Background())
+	defer close(release)
+	waitFor(t, 5*time.Second, "partition assigned", func() bool {
+		a, _ := consumer.Assignment()
+		return len(a) == 1
+	})
+	for i := 0; i < total; i++ {
+		if err := producer.SendAndWait(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0}, Value: []byte("v")}); err != nil {
+			t.Fatalf("SendAndWait: %v", err)
+		}
+	}
+	//缓冲达到高水位时自动暂停,暂停前已经取出的消息仍会进入缓冲
+	waitFor(t, 5*time.Second, "pause at the high watermark", func() bool { return len(recorder.snapshot()) == 1 })
+	if events := recorder.snapshot(); events[0] != fmt.Sprintf("pause@%d", high) {
+		t.Fatalf("expect pause@%d, got %v", high, events)
+	}
+	time.Sleep(200 * time.Millisecond)
+	peak := buffered()
+	if peak < high || peak >= total {
+		t.Fatalf("expect %d <= buffered < %d while paused, got %d", high, total, peak)
+	}
+	//逐条放行,缓冲降到低水位之前不恢复
+	for n := peak; n > low; n-- {
+		if events := recorder.snapshot(); len(events) != 1 {
+			t.Fatalf("should stay paused with %d buffered, got %v", n, events)
+		}
+		gate <- struct{}{}
+		n := n
+		waitFor(t, 5*time.Second, "buffered message handled", func() bool { return buffered() < n || len(recorder.snapshot()) > 1 })
+	}
+	waitFor(t, 5*time.Second, "resume at the low watermark", func() bool { return len(recorder.snapshot()) >= 2 })
+	if events := recorder.snapshot(); events[1] != fmt.Sprintf("resume@%d", low) {
+		t.Fatalf("expect resume@%d, got %v", low, events)
+	}
+	//恢复后剩余的消息继续被拉取和处理,可能再次达到高水位
+	go func() {
+		for atomic.LoadInt32(&handled) < total {
+			select {
+			case gate <- struct{}{}:
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}()
+	waitFor(t, 5*time.Second, "all messages handled", func() bool {
+		return atomic.LoadInt32(&handled) == total && buffered() == 0
+	})
+	events := recorder.snapshot()
+	for i, e := range events {
+		want := fmt.Sprintf("pause@%d", high)
+		if i%2 == 1 {
+			want = fmt.Sprintf("resume@%d", low)
+		}
+		if e != want {
+			t.Fatalf("transitions should alternate at the watermarks, got %v", events)
+		}
+	}
+	if len(events)%2 != 0 {
+		t.Fatalf("partition should be resumed at the end, got %v", events)
+	}
+}
@@ -0,0 +1,67 @@
+package consumerproxy
+
+import (
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//goKeys go端设置项,在配置文件中可以写作`parallel_callback`,环境变量中写作`KAFKA_PARALLEL_CALLBACK`
+var goKeys = map[string]kafkaconf.Type{
+	"name":              kafkaconf.String,
+	"parallel.callback": kafkaconf.Bool,
+	"backpressure.high": kafkaconf.Int,
+	"backpressure.low":  kafkaconf.Int,
+}
+
+func optionsFrom(raw map[string]any) (optparams.Option[Options], error) {
+	cm, flags, err := kafkaconf.Parse(raw, goKeys)
+	if err != nil {
+		return nil, err
+	}
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		for k, v := range cm {
+			o.ConfigMap[k] = v
+		}
+		if v, ok := flags["name"]; ok {
+			o.Name = v.(string)
+		}
+		if v, ok := flags["parallel.callback"]; ok {
+			o.ParallelCallback = v.(bool)
+		}
+		if v, ok := flags["backpressure.high"]; ok {
+			low := -1
+			if l, ok := flags["backpressure.low"]; ok {
+				low = l.(int)
+			}
+			WithBackpressure(v.(int), low).Apply(o)
+		}
+	}), nil
+}
+
+//OptionsFromFile 从配置文件中读取代理的设置,根据扩展名支持yaml,yml,json和toml
+//librdkafka的设置项可以平铺也可以按`.`嵌套,go端的设置使用`name`,`parallel_callback`以及背压控制的`backpressure_high`和`backpressure_low`
+//未知的设置项和类型不对的值会返回错误
+//@params path string 配置文件路径
+func OptionsFromFile(path string) (optparams.Option[Options], error) {
+	raw, err := kafkaconf.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return optionsFrom(raw)
+}
+
+//OptionsFromEnv 从环境变量中读取代理的设置,比如`KAFKA_GROUP_ID`对应`group.id`,`KAFKA_PARALLEL_CALLBACK`对应`ParallelCallback`
+//与前缀相同但不是设置项的环境变量(如`KAFKA_PORT`,`KAFKA_OPTS`)会被跳过并打印警告,类型不对的值会返回错误
+//@params prefix string 环境变量前缀,为空时使用`KAFKA_`
+func OptionsFromEnv(prefix string) (optparams.Option[Options], error) {
+	raw := kafkaconf.ReadEnv(prefix)
+	for _, k := range kafkaconf.DropUnknown(raw, goKeys) {
+		Logger.Warn("ignore unknown setting from environment", log.Dict{"key": k})
+	}
+	return optionsFrom(raw)
+}
@@ -0,0 +1,17 @@
+package consumerproxy
+
+import "time"
+
+//MetricsRecorder 消费者代理的指标记录器,可以直接使用子模块`metrics`中的`Collector`
+type MetricsRecorder interface {
+	//MessageConsumed 收到一条消息
+	MessageConsumed(proxy, topic string, partition int32)
+	//HandlerDuration 消息处理函数的耗时
+	HandlerDuration(proxy, topic string, partition int32, duration time.Duration)
+	//ConsumerInFlight 正在处理的消息数量变化
+	ConsumerInFlight(proxy, topic string, partition int32, delta int)
+	//Rebalance 发生分区再平衡,event为assigned,revoked或lost
+	Rebalance(proxy string, event string)
+	//Stats librdkafka的统计信息,需要设置`statistics.interval.ms`才会有
+	Stats(proxy string, stats string)
+}
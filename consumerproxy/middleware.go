@@ -0,0 +1,26 @@
+package consumerproxy
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Recover 捕获消息处理函数的panic并作为错误返回,避免监听goroutine退出
+//返回的错误包装了ErrHandlerPanic,消息会按处理失败处理(不记录offset)
+func (proxy *ConsumerProxy) Recover() Middleware {
+	return func(next OnMsgWithCtxCallback) OnMsgWithCtxCallback {
+		return func(ctx context.Context, msg *kafka.Message) (err error) {
+			defer func() {
+				if r := recover(); r != nil {
+					proxy.logger().Error("message handler panic", log.Dict{"panic": fmt.Sprint(r), "topic": topicOf(msg), "partition": msg.TopicPartition.Partition, "offset": msg.TopicPartition.Offset, "stack": string(debug.Stack())})
+					err = fmt.Errorf("%w: %v", ErrHandlerPanic, r)
+				}
+			}()
+			return next(ctx, msg)
+		}
+	}
+}
@@ -3,20 +3,101 @@ package consumerproxy
 import (
 	"bytes"
 	"strings"
+	"time"
 
+	log "github.com/Golang-Tools/loggerhelper/v2"
 	"github.com/Golang-Tools/optparams"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //Options 设置代理对象初始化方法的可选参数
 type Options struct {
 	kafka.ConfigMap
-	ParallelCallback bool
+	Name                     string
+	ParallelCallback         bool
+	Metrics                  MetricsRecorder
+	Tracing                  bool
+	TracerProvider           trace.TracerProvider
+	OAuthBearerTokenProvider OAuthBearerTokenProvider
+	Profile                  string
+	ProfileConfig            kafka.ConfigMap
+	SkipValidation           bool
+	Logger                   *log.Log
+	Propagator               propagation.TextMapPropagator
+	ClientFactory            ClientFactory
+	HighWatermark            int
+	LowWatermark             int
+	RevokeTimeout            time.Duration
 }
 
 var DefaultOptions = Options{
-	ConfigMap: kafka.ConfigMap{},
+	ConfigMap:     kafka.ConfigMap{},
+	RevokeTimeout: 30 * time.Second,
+}
+
+//WithName 设置代理的名字,会作为指标的proxy标签
+//@params name string 代理的名字
+func WithName(name string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Name = name
+	})
+}
+
+//WithMetrics 设置指标记录器
+//@params m MetricsRecorder 指标记录器,可以使用子模块metrics中的Collector
+func WithMetrics(m MetricsRecorder) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Metrics = m
+	})
+}
+
+//WithStatisticsInterval 设置librdkafka统计信息的发送间隔,统计信息会交给指标记录器,其中包含消费延迟(lag)
+//@params interval int 统计信息的发送间隔,单位ms,0表示不发送
+func WithStatisticsInterval(interval int) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		o.ConfigMap["statistics.interval.ms"] = interval
+	})
+}
+
+//WithTracing 开启opentelemetry链路追踪,处理消息时会从消息的headers中提取上下文并创建consumer span包裹消息处理函数
+//@params tp trace.TracerProvider 使用的TracerProvider,为nil时使用otel的全局TracerProvider
+func WithTracing(tp trace.TracerProvider) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Tracing = true
+		o.TracerProvider = tp
+	})
+}
+
+//WithPropagator 设置链路追踪上下文的传播方式,默认使用w3c的trace-context和baggage
+//@params p propagation.TextMapPropagator 上下文的传播方式
+func WithPropagator(p propagation.TextMapPropagator) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Propagator = p
+	})
+}
+
+//WithLogger 设置代理使用的logger,默认使用模块的Logger
+//@params logger *log.Log 代理使用的logger
+func WithLogger(logger *log.Log) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Logger = logger
+	})
+}
+
+//WithLibrdkafkaLogs 将librdkafka的日志转发到代理的logger中,而不是直接打印到stderr
+func WithLibrdkafkaLogs() optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		o.ConfigMap["go.logs.channel.enable"] = true
+	})
 }
 
 //WithParallelCallback 设置callback并行执行
@@ -0,0 +1,56 @@
+package consumerproxy
+
+import (
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//withProfile 设置预设的调优参数,显式设置的同名设置项(包括别名)优先,后设置的profile会替换之前的
+func withProfile(name string, cm kafka.ConfigMap) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Profile = name
+		o.ProfileConfig = cm
+	})
+}
+
+//ProfileLowLatency 低延迟的预设,broker有消息就立即返回
+func ProfileLowLatency() optparams.Option[Options] {
+	return withProfile("low-latency", kafka.ConfigMap{
+		"fetch.wait.max.ms":    10,
+		"fetch.min.bytes":      1,
+		"socket.nagle.disable": true,
+	})
+}
+
+//ProfileHighThroughput 高吞吐的预设,每次拉取更大批量的消息并在本地缓冲更多消息
+func ProfileHighThroughput() optparams.Option[Options] {
+	return withProfile("high-throughput", kafka.ConfigMap{
+		"fetch.min.bytes":            1048576,
+		"fetch.wait.max.ms":          500,
+		"max.partition.fetch.bytes":  10485760,
+		"fetch.max.bytes":            104857600,
+		"queued.min.messages":        500000,
+		"queued.max.messages.kbytes": 1048576,
+	})
+}
+
+//ProfileDurable 可靠的预设,只读取已提交事务的消息,消息处理成功后才会记录offset
+func ProfileDurable() optparams.Option[Options] {
+	return withProfile("durable", kafka.ConfigMap{
+		"isolation.level":          "read_committed",
+		"enable.auto.offset.store": false,
+		"enable.auto.commit":       true,
+		"auto.offset.reset":        "earliest",
+	})
+}
+
+//Effective 实际生效的设置,即预设的profile被显式设置覆盖后的结果
+func (o Options) Effective() kafka.ConfigMap {
+	return kafkaconf.Merge(o.ProfileConfig, o.ConfigMap)
+}
+
+//EffectiveConfig 代理实际使用的设置,敏感设置项会被隐藏
+func (proxy *ConsumerProxy) EffectiveConfig() kafka.ConfigMap {
+	return kafkaconf.Redact(proxy.Opt.Effective())
+}
@@ -0,0 +1,132 @@
+package consumerproxy
+
+import (
+	"context"
+
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//OnRebalanceCallback 分区再平衡的回调,在分配变化生效前调用
+type OnRebalanceCallback func(partitions []kafka.TopicPartition)
+
+//WithCooperativeSticky 使用cooperative-sticky分配策略,再平衡时只移动需要移动的分区,其余分区不停止消费
+//同时开启`go.application.rebalance.enable`和`go.events.channel.enable`,由Watch处理增量的分配和撤销
+func WithCooperativeSticky() optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		o.ConfigMap["partition.assignment.strategy"] = "cooperative-sticky"
+		o.ConfigMap["go.application.rebalance.enable"] = true
+		o.ConfigMap["go.events.channel.enable"] = true
+	})
+}
+
+//OnAssigned 注册分区分配的回调,在Watch分配分区前调用
+//使用cooperative-sticky时partitions只包含新增的分区
+//@params cb OnRebalanceCallback 分区分配的回调
+func (proxy *ConsumerProxy) OnAssigned(cb OnRebalanceCallback) error {
+	if proxy.onAssigned != nil {
+		return ErrProxyAllreadySettedCallback
+	}
+	proxy.onAssigned = cb
+	return nil
+}
+
+//OnRevoked 注册分区撤销的回调,在Watch撤销分区前调用,可以在这里提交offset或清理分区相关的状态
+//使用cooperative-sticky时partitions只包含被撤销的分区
+//@params cb OnRebalanceCallback 分区撤销的回调
+func (proxy *ConsumerProxy) OnRevoked(cb OnRebalanceCallback) error {
+	if proxy.onRevoked != nil {
+		return ErrProxyAllreadySettedCallback
+	}
+	proxy.onRevoked = cb
+	return nil
+}
+
+//OnLost 注册分区丢失的回调,会话超时等原因导致分区已经被分给其他成员时调用,此时提交offset会失败
+//未注册时分区丢失会调用OnRevoked注册的回调
+//@params cb OnRebalanceCallback 分区丢失的回调
+func (proxy *ConsumerProxy) OnLost(cb OnRebalanceCallback) error {
+	if proxy.onLost != nil {
+		return ErrProxyAllreadySettedCallback
+	}
+	proxy.onLost = cb
+	return nil
+}
+
+//cooperative 消费组是否使用增量再平衡协议
+func (proxy *ConsumerProxy) cooperative() bool {
+	return proxy.GetRebalanceProtocol() == "COOPERATIVE"
+}
+
+//handleAssigned 处理AssignedPartitions事件,先调用回调再分配分区
+func (proxy *ConsumerProxy) handleAssigned(partitions []kafka.TopicPartition) {
+	cooperative := proxy.cooperative()
+	proxy.logger().Info("partitions assigned", log.Dict{"partitions": partitions, "cooperative": cooperative})
+	if proxy.Opt.Metrics != nil {
+		proxy.Opt.Metrics.Rebalance(proxy.Name(), "assigned")
+	}
+	if proxy.onAssigned != nil {
+		proxy.onAssigned(partitions)
+	}
+	var err error
+	if cooperative {
+		err = proxy.IncrementalAssign(partitions)
+	} else {
+		err = proxy.Assign(partitions)
+	}
+	if err != nil {
+		proxy.logger().Error("assign partitions get error", log.Dict{"err": err, "partitions": partitions})
+		return
+	}
+	proxy.pauseAssigned(partitions)
+}
+
+//handleRevoked 处理RevokedPartitions事件,先调用回调,开启背压控制时最多等待RevokeTimeout让这些分区已缓冲的消息处理完,再提交已记录的offset并撤销分区,让接手的成员从处理完的位置继续
+//分区丢失时调用OnLost的回调,不提交offset
+func (proxy *ConsumerProxy) handleRevoked(partitions []kafka.TopicPartition) {
+	cooperative := proxy.cooperative()
+	lost := proxy.AssignmentLost()
+	event, cb := "revoked", proxy.onRevoked
+	if lost {
+		event = "lost"
+		if proxy.onLost != nil {
+			cb = proxy.onLost
+		}
+		proxy.logger().Warn("partitions lost", log.Dict{"partitions": partitions, "cooperative": cooperative})
+	} else {
+		proxy.logger().Info("partitions revoked", log.Dict{"partitions": partitions, "cooperative": cooperative})
+	}
+	if proxy.Opt.Metrics != nil {
+		proxy.Opt.Metrics.Rebalance(proxy.Name(), event)
+	}
+	if cb != nil {
+		cb(partitions)
+	}
+	ctx := context.Background()
+	if proxy.Opt.RevokeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, proxy.Opt.RevokeTimeout)
+		defer cancel()
+	}
+	if err := proxy.stopWorkers(ctx, partitions); err != nil {
+		proxy.logger().Error("wait buffered messages on revoke get error", log.Dict{"err": err})
+	}
+	if !lost {
+		if err := proxy.commitStored(); err != nil {
+			proxy.logger().Error("commit offsets on revoke get error", log.Dict{"err": err})
+		}
+	}
+	var err error
+	if cooperative {
+		err = proxy.IncrementalUnassign(partitions)
+	} else {
+		err = proxy.Unassign()
+	}
+	if err != nil {
+		proxy.logger().Error("unassign partitions get error", log.Dict{"err": err, "partitions": partitions})
+	}
+}
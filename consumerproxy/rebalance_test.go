@@ -0,0 +1,188 @@
+package consumerproxy_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//rebalanceRecorder 由测试投递再平衡事件,记录分配变化和提交的顺序
+//其他方法交给内存broker的消费者
+type rebalanceRecorder struct {
+	consumerproxy.Consumer
+	events   chan kafka.Event
+	protocol string
+	lock     sync.Mutex
+	lost     bool
+	calls    []string
+}
+
+func (r *rebalanceRecorder) record(format string, args ...interface{}) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls = append(r.calls, fmt.Sprintf(format, args...))
+}
+
+func (r *rebalanceRecorder) snapshot() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return append([]string{}, r.calls...)
+}
+
+func (r *rebalanceRecorder) setLost(lost bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.lost = lost
+}
+
+func (r *rebalanceRecorder) Events() chan kafka.Event { return r.events }
+
+func (r *rebalanceRecorder) GetRebalanceProtocol() string { return r.protocol }
+
+func (r *rebalanceRecorder) AssignmentLost() bool {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.lost
+}
+
+func (r *rebalanceRecorder) Assign(partitions []kafka.TopicPartition) error {
+	r.record("assign %v", ids(partitions))
+	return r.Consumer.Assign(partitions)
+}
+
+func (r *rebalanceRecorder) Unassign() error {
+	r.record("unassign")
+	return r.Consumer.Unassign()
+}
+
+func (r *rebalanceRecorder) IncrementalAssign(partitions []kafka.TopicPartition) error {
+	r.record("incremental-assign %v", ids(partitions))
+	return r.Consumer.IncrementalAssign(partitions)
+}
+
+func (r *rebalanceRecorder) IncrementalUnassign(partitions []kafka.TopicPartition) error {
+	r.record("incremental-unassign %v", ids(partitions))
+	return r.Consumer.IncrementalUnassign(partitions)
+}
+
+func (r *rebalanceRecorder) Commit() ([]kafka.TopicPartition, error) {
+	r.record("commit")
+	return r.Consumer.Commit()
+}
+
+//ids 分区号
+func ids(partitions []kafka.TopicPartition) []int32 {
+	result := []int32{}
+	for _, tp := range partitions {
+		result = append(result, tp.Partition)
+	}
+	return result
+}
+
+func TestRebalanceHookOrder(t *testing.T) {
+	topic := "rebalance"
+	tp := func(partitions ...int32) []kafka.TopicPartition {
+		result := []kafka.TopicPartition{}
+		for _, p := range partitions {
+			result = append(result, kafka.TopicPartition{Topic: &topic, Partition: p})
+		}
+		return result
+	}
+	cases := []struct {
+		name     string
+		protocol string
+		onLost   bool
+		want     []string
+	}{
+		{
+			name:     "cooperative",
+			protocol: "COOPERATIVE",
+			onLost:   true,
+			want: []string{
+				"on-assigned [0 1] while assigned []", "incremental-assign [0 1]",
+				"on-assigned [2] while assigned [0 1]", "incremental-assign [2]",
+				"on-revoked [1] while assigned [0 1 2]", "commit", "incremental-unassign [1]",
+				//分区丢失时不提交offset
+				"on-lost [0 2] while assigned [0 2]", "incremental-unassign [0 2]",
+			},
+		},
+		{
+			name:     "eager",
+			protocol: "EAGER",
+			//没有注册OnLost时分区丢失调用OnRevoked的回调
+			onLost: false,
+			want: []string{
+				"on-assigned [0 1] while assigned []", "assign [0 1]",
+				"on-assigned [2] while assigned [0 1]", "assign [2]",
+				"on-revoked [1] while assigned [2]", "commit", "unassign",
+				"on-revoked [0 2] while assigned []", "unassign",
+			},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			b := kafkatest.NewBroker()
+			if err := b.CreateTopic(topic, 3); err != nil {
+				t.Fatalf("CreateTopic: %v", err)
+			}
+			recorder := &rebalanceRecorder{events: make(chan kafka.Event), protocol: c.protocol}
+			newConsumer := b.ConsumerFactory()
+			proxy, err := b.NewConsumerProxy("rebalance-group",
+				consumerproxy.WithCooperativeSticky(),
+				consumerproxy.WithClientFactory(func(conf *kafka.ConfigMap) (consumerproxy.Consumer, error) {
+					cli, err := newConsumer(conf)
+					if err != nil {
+						return nil, err
+					}
+					recorder.Consumer = cli
+					return recorder, nil
+				}),
+			)
+			if err != nil {
+				t.Fatalf("NewConsumerProxy: %v", err)
+			}
+			//回调中记录当时的分配,确认回调在分配变化生效之前调用
+			hook := func(name string) consumerproxy.OnRebalanceCallback {
+				return func(partitions []kafka.TopicPartition) {
+					assigned, err := proxy.Assignment()
+					if err != nil {
+						t.Errorf("Assignment: %v", err)
+					}
+					recorder.record("%s %v while assigned %v", name, ids(partitions), ids(assigned))
+				}
+			}
+			proxy.OnAssigned(hook("on-assigned"))
+			proxy.OnRevoked(hook("on-revoked"))
+			if c.onLost {
+				proxy.OnLost(hook("on-lost"))
+			}
+			proxy.Watch()
+			defer proxy.Shutdown(context.Background())
+			steps := []struct {
+				ev    kafka.Event
+				lost  bool
+				calls int
+			}{
+				{ev: kafka.AssignedPartitions{Partitions: tp(0, 1)}, calls: 2},
+				{ev: kafka.AssignedPartitions{Partitions: tp(2)}, calls: 4},
+				{ev: kafka.RevokedPartitions{Partitions: tp(1)}, calls: 7},
+				{ev: kafka.RevokedPartitions{Partitions: tp(0, 2)}, lost: true, calls: 9},
+			}
+			for _, step := range steps {
+				recorder.setLost(step.lost)
+				recorder.events <- step.ev
+				waitFor(t, 5*time.Second, fmt.Sprintf("%v handled", step.ev), func() bool { return len(recorder.snapshot()) >= step.calls })
+			}
+			if got := recorder.snapshot(); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("expect\n%v\ngot\n%v", c.want, got)
+			}
+		})
+	}
+}
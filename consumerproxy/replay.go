@@ -0,0 +1,307 @@
+package consumerproxy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//requestTimeoutMs 查询元数据和offset的超时时间
+const requestTimeoutMs = 10000
+
+//Partitions 查询topic的全部分区,topic不存在时返回ErrUnknownTopicOrPart
+//@params topic string topic名
+func (proxy *ConsumerProxy) Partitions(topic string) ([]int32, error) {
+	if !proxy.IsOk() {
+		return nil, ErrProxyNotYetSettedClient
+	}
+	md, err := proxy.GetMetadata(&topic, false, requestTimeoutMs)
+	if err != nil {
+		return nil, err
+	}
+	tm, ok := md.Topics[topic]
+	if !ok {
+		return nil, kafka.NewError(kafka.ErrUnknownTopicOrPart, fmt.Sprintf("topic %q not found", topic), false)
+	}
+	if tm.Error.Code() != kafka.ErrNoError {
+		return nil, tm.Error
+	}
+	partitions := make([]int32, 0, len(tm.Partitions))
+	for _, p := range tm.Partitions {
+		partitions = append(partitions, p.ID)
+	}
+	return partitions, nil
+}
+
+//OffsetForTime 查询分区上时间戳不早于t的第一条消息的offset,没有这样的消息时返回分区的末尾
+//@params topic string topic名
+//@params partition int32 分区
+//@params t time.Time 消息的时间戳
+func (proxy *ConsumerProxy) OffsetForTime(topic string, partition int32, t time.Time) (int64, error) {
+	if !proxy.IsOk() {
+		return 0, ErrProxyNotYetSettedClient
+	}
+	found, err := proxy.OffsetsForTimes([]kafka.TopicPartition{{Topic: &topic, Partition: partition, Offset: kafka.Offset(t.UnixMilli())}}, requestTimeoutMs)
+	if err != nil {
+		return 0, err
+	}
+	if len(found) == 1 {
+		if found[0].Error != nil {
+			return 0, found[0].Error
+		}
+		if found[0].Offset >= 0 {
+			return int64(found[0].Offset), nil
+		}
+	}
+	_, high, err := proxy.QueryWatermarkOffsets(topic, partition, requestTimeoutMs)
+	return high, err
+}
+
+//SeekToOffset 将已分配的分区移动到指定offset,下一条读到的消息从该offset开始
+//@params topic string topic名
+//@params partition int32 分区,必须是当前已分配的分区
+//@params offset int64 分区上的offset,可以使用kafka.OffsetBeginning和kafka.OffsetEnd
+func (proxy *ConsumerProxy) SeekToOffset(topic string, partition int32, offset int64) error {
+	if !proxy.IsOk() {
+		return ErrProxyNotYetSettedClient
+	}
+	return proxy.Seek(kafka.TopicPartition{Topic: &topic, Partition: partition, Offset: kafka.Offset(offset)}, 0)
+}
+
+//SeekToTimestamp 将已分配的分区移动到时间戳不早于t的第一条消息,没有这样的消息时移动到分区末尾
+//@params topic string topic名
+//@params partition int32 分区,必须是当前已分配的分区
+//@params t time.Time 消息的时间戳
+func (proxy *ConsumerProxy) SeekToTimestamp(topic string, partition int32, t time.Time) error {
+	offset, err := proxy.OffsetForTime(topic, partition, t)
+	if err != nil {
+		return err
+	}
+	return proxy.SeekToOffset(topic, partition, offset)
+}
+
+//OffsetRange 分区上要重放的offset范围,包含From不包含To
+type OffsetRange struct {
+	Partition int32
+	From      int64
+	To        int64
+}
+
+//ReplayProgress 重放的进度
+type ReplayProgress struct {
+	Topic      string
+	Partitions int
+	Finished   int
+	Processed  int64
+	Total      int64
+	Elapsed    time.Duration
+}
+
+//ReplayOptions 重放的可选参数
+type ReplayOptions struct {
+	Partitions       []int32
+	Progress         func(ReplayProgress)
+	ProgressInterval time.Duration
+}
+
+var DefaultReplayOptions = ReplayOptions{
+	ProgressInterval: time.Second,
+}
+
+//WithReplayPartitions 只重放指定的分区,只对ReplayRange有效,默认重放topic的全部分区
+//@params partitions ...int32 分区
+func WithReplayPartitions(partitions ...int32) optparams.Option[ReplayOptions] {
+	return optparams.NewFuncOption(func(o *ReplayOptions) {
+		o.Partitions = append(o.Partitions, partitions...)
+	})
+}
+
+//WithProgress 设置进度回调,重放中按间隔调用,结束时再调用一次
+//@params fn func(ReplayProgress) 进度回调,在重放的goroutine中调用,不要阻塞
+//@params interval time.Duration 调用间隔,小于等于0时使用默认的1s
+func WithProgress(fn func(ReplayProgress), interval time.Duration) optparams.Option[ReplayOptions] {
+	return optparams.NewFuncOption(func(o *ReplayOptions) {
+		o.Progress = fn
+		if interval > 0 {
+			o.ProgressInterval = interval
+		}
+	})
+}
+
+//ReplayRange 重放topic上时间戳在[from,to)之间的消息
+//每个分区的范围在开始时计算,from为零值时从最早的消息开始,to为零值时到开始时的末尾为止.
+//重放使用临时创建的消费者,以指定分区模式读取,不加入消费组也不提交offset,不影响代理本身的订阅
+//@params ctx context.Context 控制重放的时间,结束时返回ctx的错误
+//@params topic string topic名
+//@params from time.Time 开始时间
+//@params to time.Time 结束时间
+//@params handler OnMsgWithCtxCallback 消息处理函数,返回错误时重放停止并返回该错误
+//@params opts ...optparams.Option[ReplayOptions] 重放的可选参数
+func (proxy *ConsumerProxy) ReplayRange(ctx context.Context, topic string, from, to time.Time, handler OnMsgWithCtxCallback, opts ...optparams.Option[ReplayOptions]) (ReplayProgress, error) {
+	opt := DefaultReplayOptions
+	optparams.GetOption(&opt, opts...)
+	partitions := opt.Partitions
+	if len(partitions) == 0 {
+		var err error
+		if partitions, err = proxy.Partitions(topic); err != nil {
+			return ReplayProgress{Topic: topic}, err
+		}
+	}
+	ranges := make([]OffsetRange, 0, len(partitions))
+	for _, id := range partitions {
+		low, high, err := proxy.QueryWatermarkOffsets(topic, id, requestTimeoutMs)
+		if err != nil {
+			return ReplayProgress{Topic: topic}, err
+		}
+		r := OffsetRange{Partition: id, From: low, To: high}
+		if !from.IsZero() {
+			if r.From, err = proxy.OffsetForTime(topic, id, from); err != nil {
+				return ReplayProgress{Topic: topic}, err
+			}
+		}
+		if !to.IsZero() {
+			if r.To, err = proxy.OffsetForTime(topic, id, to); err != nil {
+				return ReplayProgress{Topic: topic}, err
+			}
+		}
+		ranges = append(ranges, r)
+	}
+	return proxy.ReplayPartitions(ctx, topic, ranges, handler, opts...)
+}
+
+//ReplayPartitions 重放topic上指定offset范围的消息,每个分区读到To或分区末尾后停止,全部分区结束后返回
+//重放使用临时创建的消费者,以指定分区模式读取,不加入消费组也不提交offset,不影响代理本身的订阅
+//@params ctx context.Context 控制重放的时间,结束时返回ctx的错误
+//@params topic string topic名
+//@params ranges []OffsetRange 每个分区的offset范围
+//@params handler OnMsgWithCtxCallback 消息处理函数,返回错误时重放停止并返回该错误
+//@params opts ...optparams.Option[ReplayOptions] 重放的可选参数
+func (proxy *ConsumerProxy) ReplayPartitions(ctx context.Context, topic string, ranges []OffsetRange, handler OnMsgWithCtxCallback, opts ...optparams.Option[ReplayOptions]) (ReplayProgress, error) {
+	opt := DefaultReplayOptions
+	optparams.GetOption(&opt, opts...)
+	start := time.Now()
+	progress := ReplayProgress{Topic: topic}
+	ends := map[int32]int64{}
+	assignment := []kafka.TopicPartition{}
+	for _, r := range ranges {
+		progress.Partitions++
+		if r.From < 0 || r.To <= r.From {
+			progress.Finished++
+			continue
+		}
+		progress.Total += r.To - r.From
+		ends[r.Partition] = r.To
+		assignment = append(assignment, kafka.TopicPartition{Topic: &topic, Partition: r.Partition, Offset: kafka.Offset(r.From)})
+	}
+	report := func() {
+		if opt.Progress != nil {
+			progress.Elapsed = time.Since(start)
+			opt.Progress(progress)
+		}
+	}
+	if len(assignment) == 0 {
+		report()
+		return progress, nil
+	}
+	cli, err := proxy.newClientWith(proxy.replayConfig())
+	if err != nil {
+		return progress, err
+	}
+	defer cli.Close()
+	proxy.refreshOAuthBearerTokenFor(cli)
+	err = cli.Assign(assignment)
+	if err != nil {
+		return progress, err
+	}
+	proxy.logger().Info("replay start", log.Dict{"topic": topic, "partitions": len(assignment), "total": progress.Total})
+	defer func() {
+		proxy.logger().Info("replay stop", log.Dict{"topic": topic, "processed": progress.Processed, "finished": progress.Finished, "elapsed": time.Since(start)})
+	}()
+	finish := func(partition int32) {
+		if _, ok := ends[partition]; ok {
+			delete(ends, partition)
+			progress.Finished++
+		}
+	}
+	events := cli.Events()
+	ticker := time.NewTicker(opt.ProgressInterval)
+	defer ticker.Stop()
+	for len(ends) > 0 {
+		var ev kafka.Event
+		if events == nil {
+			ev = cli.Poll(100)
+		} else {
+			select {
+			case ev = <-events:
+			case <-time.After(100 * time.Millisecond):
+			}
+		}
+		select {
+		case <-ctx.Done():
+			report()
+			return progress, ctx.Err()
+		case <-ticker.C:
+			report()
+		default:
+		}
+		switch e := ev.(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Error != nil {
+				report()
+				return progress, e.TopicPartition.Error
+			}
+			partition := e.TopicPartition.Partition
+			end, ok := ends[partition]
+			if !ok {
+				continue
+			}
+			//offset有空洞(比如事务的控制记录)时可能跳过To-1,读到To之后的消息也说明范围已经读完
+			if int64(e.TopicPartition.Offset) >= end {
+				finish(partition)
+				continue
+			}
+			progress.Processed++
+			if err := handler(ctx, e); err != nil {
+				report()
+				return progress, err
+			}
+			if int64(e.TopicPartition.Offset)+1 >= end {
+				finish(partition)
+			}
+		case kafka.PartitionEOF:
+			finish(e.Partition)
+		case kafka.OAuthBearerTokenRefresh:
+			proxy.refreshOAuthBearerTokenFor(cli)
+		case kafka.Error:
+			if e.IsFatal() {
+				report()
+				return progress, e
+			}
+			proxy.logger().Error("replay get error", log.Dict{"error": e})
+		}
+	}
+	report()
+	return progress, nil
+}
+
+//replayConfig 重放使用的消费者设置,在代理的设置上关闭offset的记录和提交,开启PartitionEOF以便在分区末尾停止
+func (proxy *ConsumerProxy) replayConfig() *kafka.ConfigMap {
+	conf := kafka.ConfigMap{}
+	for k, v := range proxy.Opt.ConfigMap {
+		conf[k] = v
+	}
+	for _, k := range []string{"go.logs.channel.enable", "go.logs.channel", "go.application.rebalance.enable"} {
+		delete(conf, k)
+	}
+	if v, ok := conf["group.id"].(string); !ok || v == "" {
+		conf["group.id"] = proxy.Name() + "-replay"
+	}
+	conf["enable.auto.commit"] = false
+	conf["enable.auto.offset.store"] = false
+	conf["enable.partition.eof"] = true
+	return &conf
+}
@@ -0,0 +1,278 @@
+package consumerproxy_test
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+var replayBase = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+//replayBroker 创建topic,第p个分区写入counts[p]条消息,第i条的时间戳为replayBase+i秒
+func replayBroker(t *testing.T, topic string, counts ...int) (*kafkatest.Broker, *consumerproxy.ConsumerProxy) {
+	t.Helper()
+	b := kafkatest.NewBroker()
+	if err := b.CreateTopic(topic, len(counts)); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	for partition, n := range counts {
+		for i := 0; i < n; i++ {
+			appendMessage(t, b, topic, int32(partition), replayBase.Add(time.Duration(i)*time.Second))
+		}
+	}
+	proxy, err := b.NewConsumerProxy("replay-group", consumerproxy.WithComsumerSetting("go.events.channel.enable", true))
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	return b, proxy
+}
+
+//appendMessage 向分区写入一条消息并等待确认
+func appendMessage(t *testing.T, b *kafkatest.Broker, topic string, partition int32, ts time.Time) {
+	t.Helper()
+	p, err := b.NewProducer(nil)
+	if err != nil {
+		t.Errorf("NewProducer: %v", err)
+		return
+	}
+	defer p.Close()
+	reports := make(chan kafka.Event, 1)
+	if err := p.Produce(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: partition}, Value: []byte("v"), Timestamp: ts}, reports); err != nil {
+		t.Errorf("Produce: %v", err)
+		return
+	}
+	if report := (<-reports).(*kafka.Message); report.TopicPartition.Error != nil {
+		t.Errorf("Produce: %v", report.TopicPartition.Error)
+	}
+}
+
+//replayed 记录重放的消息,格式为`分区@offset`
+type replayed struct {
+	lock sync.Mutex
+	msgs []string
+}
+
+func (r *replayed) handle(ctx context.Context, msg *kafka.Message) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.msgs = append(r.msgs, fmt.Sprintf("%d@%d", msg.TopicPartition.Partition, msg.TopicPartition.Offset))
+	return nil
+}
+
+func (r *replayed) sorted() []string {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	result := append([]string{}, r.msgs...)
+	sort.Strings(result)
+	return result
+}
+
+func TestReplayRange(t *testing.T) {
+	topic := "replay"
+	cases := []struct {
+		name     string
+		from, to time.Time
+		want     []string
+	}{
+		{name: "time range", from: replayBase.Add(time.Second), to: replayBase.Add(3 * time.Second), want: []string{"0@1", "0@2", "1@1", "1@2"}},
+		{name: "from only", from: replayBase.Add(2500 * time.Millisecond), want: []string{"0@3", "0@4", "1@3"}},
+		//to晚于最后一条消息时OffsetForTime取分区末尾
+		{name: "to after the last message", from: replayBase.Add(3 * time.Second), to: replayBase.Add(time.Hour), want: []string{"0@3", "0@4", "1@3"}},
+		{name: "whole topic", want: []string{"0@0", "0@1", "0@2", "0@3", "0@4", "1@0", "1@1", "1@2", "1@3"}},
+		{name: "empty range", from: replayBase.Add(time.Hour), to: replayBase.Add(2 * time.Hour), want: []string{}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			//分区2没有消息
+			_, proxy := replayBroker(t, topic, 5, 4, 0)
+			r := &replayed{}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			progress, err := proxy.ReplayRange(ctx, topic, c.from, c.to, r.handle)
+			if err != nil {
+				t.Fatalf("ReplayRange: %v", err)
+			}
+			if got := r.sorted(); !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("expect %v, got %v", c.want, got)
+			}
+			want := consumerproxy.ReplayProgress{Topic: topic, Partitions: 3, Finished: 3, Processed: int64(len(c.want)), Total: int64(len(c.want))}
+			progress.Elapsed = 0
+			if progress != want {
+				t.Fatalf("expect progress %+v, got %+v", want, progress)
+			}
+		})
+	}
+}
+
+func TestReplayRangeStopsAtComputedEnd(t *testing.T) {
+	topic := "replay"
+	b, proxy := replayBroker(t, topic, 3)
+	r := &replayed{}
+	var once sync.Once
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	//重放开始后写入的消息不在范围内
+	progress, err := proxy.ReplayRange(ctx, topic, time.Time{}, time.Time{}, func(ctx context.Context, msg *kafka.Message) error {
+		once.Do(func() {
+			for i := 0; i < 3; i++ {
+				appendMessage(t, b, topic, 0, time.Now())
+			}
+		})
+		return r.handle(ctx, msg)
+	})
+	if err != nil {
+		t.Fatalf("ReplayRange: %v", err)
+	}
+	if got := r.sorted(); !reflect.DeepEqual(got, []string{"0@0", "0@1", "0@2"}) {
+		t.Fatalf("replay should stop at the end computed at start, got %v", got)
+	}
+	if progress.Processed != 3 || progress.Total != 3 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+	if n := b.HighWatermark(topic, 0); n != 6 {
+		t.Fatalf("expect 6 messages in the partition, got %d", n)
+	}
+}
+
+func TestReplayPartitions(t *testing.T) {
+	topic := "replay"
+	_, proxy := replayBroker(t, topic, 5, 5, 5)
+	r := &replayed{}
+	ranges := []consumerproxy.OffsetRange{
+		{Partition: 0, From: 1, To: 3},
+		//To超过末尾时读到末尾为止
+		{Partition: 1, From: 3, To: 100},
+		//空的范围直接结束
+		{Partition: 2, From: 2, To: 2},
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	progress, err := proxy.ReplayPartitions(ctx, topic, ranges, r.handle)
+	if err != nil {
+		t.Fatalf("ReplayPartitions: %v", err)
+	}
+	if got := r.sorted(); !reflect.DeepEqual(got, []string{"0@1", "0@2", "1@3", "1@4"}) {
+		t.Fatalf("unexpected replayed messages %v", got)
+	}
+	if progress.Partitions != 3 || progress.Finished != 3 || progress.Processed != 4 || progress.Total != 99 {
+		t.Fatalf("unexpected progress %+v", progress)
+	}
+	r = &replayed{}
+	progress, err = proxy.ReplayRange(ctx, topic, time.Time{}, replayBase.Add(time.Second), r.handle, consumerproxy.WithReplayPartitions(2))
+	if err != nil {
+		t.Fatalf("ReplayRange: %v", err)
+	}
+	if got := r.sorted(); !reflect.DeepEqual(got, []string{"2@0"}) || progress.Partitions != 1 {
+		t.Fatalf("expect only partition 2 replayed, got %v, %+v", got, progress)
+	}
+}
+
+func TestReplayProgress(t *testing.T) {
+	topic := "replay"
+	_, proxy := replayBroker(t, topic, 5, 0)
+	var reports []consumerproxy.ReplayProgress
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	final, err := proxy.ReplayRange(ctx, topic, time.Time{}, time.Time{}, func(ctx context.Context, msg *kafka.Message) error {
+		time.Sleep(30 * time.Millisecond)
+		return nil
+	}, consumerproxy.WithProgress(func(p consumerproxy.ReplayProgress) { reports = append(reports, p) }, 10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("ReplayRange: %v", err)
+	}
+	if len(reports) < 3 {
+		t.Fatalf("expect progress reported during the replay, got %v", reports)
+	}
+	for i := 1; i < len(reports); i++ {
+		if reports[i].Processed < reports[i-1].Processed || reports[i].Elapsed < reports[i-1].Elapsed {
+			t.Fatalf("progress should not go backwards, got %v", reports)
+		}
+	}
+	last := reports[len(reports)-1]
+	if last != final || last.Processed != 5 || last.Total != 5 || last.Finished != 2 {
+		t.Fatalf("last report should be the final progress, got %+v and %+v", last, final)
+	}
+	//没有需要重放的消息时也会汇报一次
+	reports = nil
+	if _, err := proxy.ReplayPartitions(ctx, topic, []consumerproxy.OffsetRange{{Partition: 1, From: 0, To: 0}}, func(context.Context, *kafka.Message) error { return nil },
+		consumerproxy.WithProgress(func(p consumerproxy.ReplayProgress) { reports = append(reports, p) }, 0)); err != nil {
+		t.Fatalf("ReplayPartitions: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Finished != 1 || reports[0].Total != 0 {
+		t.Fatalf("expect one final report, got %v", reports)
+	}
+}
+
+func TestReplayHandlerError(t *testing.T) {
+	topic := "replay"
+	_, proxy := replayBroker(t, topic, 5)
+	errHandle := fmt.Errorf("handle failed")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	progress, err := proxy.ReplayRange(ctx, topic, time.Time{}, time.Time{}, func(ctx context.Context, msg *kafka.Message) error {
+		if msg.TopicPartition.Offset == 2 {
+			return errHandle
+		}
+		return nil
+	})
+	if err != errHandle || progress.Processed != 3 || progress.Finished != 0 {
+		t.Fatalf("expect to stop at the failed message, got %+v, %v", progress, err)
+	}
+}
+
+func TestSeekToTimestamp(t *testing.T) {
+	topic := "replay"
+	b, proxy := replayBroker(t, topic, 5)
+	if err := proxy.Assign([]kafka.TopicPartition{{Topic: &topic, Partition: 0, Offset: kafka.OffsetEnd}}); err != nil {
+		t.Fatalf("Assign: %v", err)
+	}
+	next := func() int64 {
+		t.Helper()
+		timeout := time.After(5 * time.Second)
+		for {
+			select {
+			case ev := <-proxy.Events():
+				if msg, ok := ev.(*kafka.Message); ok {
+					return int64(msg.TopicPartition.Offset)
+				}
+			case <-timeout:
+				t.Fatal("no message after seek")
+			}
+		}
+	}
+	if err := proxy.SeekToTimestamp(topic, 0, replayBase.Add(2500*time.Millisecond)); err != nil {
+		t.Fatalf("SeekToTimestamp: %v", err)
+	}
+	if offset := next(); offset != 3 {
+		t.Fatalf("expect the first message not earlier than the timestamp at offset 3, got %d", offset)
+	}
+	//时间戳晚于全部消息时移动到末尾,下一条读到的是新写入的消息
+	if err := proxy.SeekToTimestamp(topic, 0, replayBase.Add(time.Hour)); err != nil {
+		t.Fatalf("SeekToTimestamp: %v", err)
+	}
+	drain := time.After(100 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case <-proxy.Events():
+		case <-drain:
+			done = true
+		}
+	}
+	appendMessage(t, b, topic, 0, time.Now())
+	if offset := next(); offset != 5 {
+		t.Fatalf("expect the new message at offset 5, got %d", offset)
+	}
+	other := "other"
+	if err := proxy.SeekToTimestamp(other, 0, replayBase); err == nil {
+		t.Fatal("seek on a partition not assigned should fail")
+	}
+}
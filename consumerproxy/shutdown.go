@@ -0,0 +1,62 @@
+package consumerproxy
+
+import (
+	"context"
+
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Shutdown 优雅关闭消费端
+//先停止监听并等待正在处理和背压控制中已缓冲的消息处理完,然后同步提交已记录的offset,最后关闭消费者离开消费组,静态成员不离开消费组.
+//ctx在消息处理完前结束时不再等待,仍会提交并关闭,返回ctx的错误
+//@params ctx context.Context 控制等待消息处理完的时间
+func (proxy *ConsumerProxy) Shutdown(ctx context.Context) error {
+	if !proxy.IsOk() {
+		return ErrProxyNotYetSettedClient
+	}
+	if proxy.closed {
+		return ErrProxyClosed
+	}
+	proxy.closed = true
+	var err error
+	if proxy.stopWatch != nil {
+		proxy.stopWatch()
+		select {
+		case <-proxy.watchDone:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	if werr := proxy.stopWorkers(ctx, nil); werr != nil && err == nil {
+		err = werr
+	}
+	if cerr := proxy.commitStored(); cerr != nil {
+		proxy.logger().Error("commit offsets on shutdown get error", log.Dict{"err": cerr})
+		if err == nil {
+			err = cerr
+		}
+	}
+	if proxy.staticMember() {
+		proxy.logger().Info("static member keeps its partitions until session timeout", log.Dict{"group.instance.id": proxy.Opt.ConfigMap["group.instance.id"]})
+	}
+	cerr := proxy.Close()
+	if cerr != nil && err == nil {
+		err = cerr
+	}
+	proxy.logger().Debug("consumer shutdown")
+	return err
+}
+
+//commitStored 同步提交已记录的offset,没有消费组或没有需要提交的offset时什么都不做
+func (proxy *ConsumerProxy) commitStored() error {
+	v, ok := proxy.Opt.ConfigMap["group.id"].(string)
+	if !ok || v == "" {
+		return nil
+	}
+	_, err := proxy.Commit()
+	if kerr, ok := err.(kafka.Error); ok && kerr.Code() == kafka.ErrNoOffset {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,64 @@
+package consumerproxy
+
+import (
+	"os"
+	"time"
+
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//DefaultStaticSessionTimeout 静态成员默认的会话超时,需要长于一次重启的时间,重启期间分区不会被分给其他成员
+const DefaultStaticSessionTimeout = 60 * time.Second
+
+//InstanceID 静态成员的默认实例id,依次使用环境变量`POD_NAME`和主机名,都取不到时为空
+//k8s中可以通过downward api将`metadata.name`设置为`POD_NAME`,StatefulSet的pod重启后名字不变
+func InstanceID() string {
+	if name := os.Getenv("POD_NAME"); name != "" {
+		return name
+	}
+	name, err := os.Hostname()
+	if err != nil {
+		return ""
+	}
+	return name
+}
+
+//WithStaticMembership 以静态成员加入消费组
+//静态成员关闭时不离开消费组,在`session.timeout.ms`内以相同的`group.instance.id`重新加入会直接取回原来的分区,滚动重启不会触发再平衡.
+//没有设置`session.timeout.ms`时使用DefaultStaticSessionTimeout,同一个消费组中每个实例的id必须不同,否则先加入的实例会被踢出
+//@params instanceID string 实例id,为空时使用InstanceID(),仍为空时不使用静态成员
+func WithStaticMembership(instanceID string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if instanceID == "" {
+			instanceID = InstanceID()
+		}
+		if instanceID == "" {
+			return
+		}
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		o.ConfigMap["group.instance.id"] = instanceID
+		if _, ok := o.ConfigMap["session.timeout.ms"]; !ok {
+			o.ConfigMap["session.timeout.ms"] = int(DefaultStaticSessionTimeout / time.Millisecond)
+		}
+	})
+}
+
+//WithSessionTimeout 设置消费者的会话超时,超过这个时间没有心跳时broker认为成员已经离开
+//@params timeout time.Duration 会话超时,精度为ms
+func WithSessionTimeout(timeout time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		o.ConfigMap["session.timeout.ms"] = int(timeout / time.Millisecond)
+	})
+}
+
+//staticMember 是否以静态成员加入消费组
+func (proxy *ConsumerProxy) staticMember() bool {
+	v, ok := proxy.Opt.ConfigMap["group.instance.id"].(string)
+	return ok && v != ""
+}
@@ -0,0 +1,146 @@
+package consumerproxy_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//这里只验证静态成员的设置被传给了客户端,并且客户端能以静态成员加入消费组消费.
+//librdkafka v1.9的mock集群在JoinGroup时忽略group.instance.id,kafkatest的内存broker也不模拟静态成员,
+//关闭后保留分区,在会话超时内重启取回原来的分区,相同id的成员被踢出这些broker端的行为没有测试,需要在真实的集群上验证
+
+//configOf 创建消费者代理并返回传给客户端的设置
+func configOf(t *testing.T, opts ...optparams.Option[consumerproxy.Options]) kafka.ConfigMap {
+	t.Helper()
+	b := kafkatest.NewBroker()
+	newConsumer := b.ConsumerFactory()
+	conf := kafka.ConfigMap{}
+	opts = append(opts, consumerproxy.WithClientFactory(func(c *kafka.ConfigMap) (consumerproxy.Consumer, error) {
+		for k, v := range *c {
+			conf[k] = v
+		}
+		return newConsumer(c)
+	}))
+	proxy, err := b.NewConsumerProxy("static-group", opts...)
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	proxy.Close()
+	return conf
+}
+
+func TestStaticMembershipConfig(t *testing.T) {
+	defaultTimeout := int(consumerproxy.DefaultStaticSessionTimeout / time.Millisecond)
+	cases := []struct {
+		name       string
+		opts       []optparams.Option[consumerproxy.Options]
+		instanceID interface{}
+		timeout    interface{}
+	}{
+		{
+			name:       "default session timeout",
+			opts:       []optparams.Option[consumerproxy.Options]{consumerproxy.WithStaticMembership("instance-a")},
+			instanceID: "instance-a",
+			timeout:    defaultTimeout,
+		},
+		{
+			name:       "session timeout set before",
+			opts:       []optparams.Option[consumerproxy.Options]{consumerproxy.WithSessionTimeout(10 * time.Second), consumerproxy.WithStaticMembership("instance-a")},
+			instanceID: "instance-a",
+			timeout:    10000,
+		},
+		{
+			name:       "session timeout set after",
+			opts:       []optparams.Option[consumerproxy.Options]{consumerproxy.WithStaticMembership("instance-a"), consumerproxy.WithSessionTimeout(10 * time.Second)},
+			instanceID: "instance-a",
+			timeout:    10000,
+		},
+		{
+			name:    "not static",
+			opts:    []optparams.Option[consumerproxy.Options]{consumerproxy.WithSessionTimeout(10 * time.Second)},
+			timeout: 10000,
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			conf := configOf(t, c.opts...)
+			if conf["group.instance.id"] != c.instanceID {
+				t.Fatalf("expect group.instance.id %v, got %v", c.instanceID, conf["group.instance.id"])
+			}
+			if conf["session.timeout.ms"] != c.timeout {
+				t.Fatalf("expect session.timeout.ms %v, got %v", c.timeout, conf["session.timeout.ms"])
+			}
+		})
+	}
+}
+
+func TestInstanceID(t *testing.T) {
+	t.Setenv("POD_NAME", "consumer-0")
+	if id := consumerproxy.InstanceID(); id != "consumer-0" {
+		t.Fatalf("expect POD_NAME, got %s", id)
+	}
+	if conf := configOf(t, consumerproxy.WithStaticMembership("")); conf["group.instance.id"] != "consumer-0" {
+		t.Fatalf("empty instance id should fall back to POD_NAME, got %v", conf["group.instance.id"])
+	}
+	t.Setenv("POD_NAME", "")
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("Hostname: %v", err)
+	}
+	if id := consumerproxy.InstanceID(); id != hostname {
+		t.Fatalf("expect hostname %s, got %s", hostname, id)
+	}
+}
+
+func TestStaticMemberConsumesFromCluster(t *testing.T) {
+	cluster, err := kafkatest.NewCluster(1)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	defer cluster.Close()
+	topic := "static"
+	if err := cluster.CreateTopic(topic, 2); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	producer, err := cluster.NewProducerProxy()
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := producer.SendAndWait(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: int32(i % 2)}, Value: []byte("v")}); err != nil {
+			t.Fatalf("SendAndWait: %v", err)
+		}
+	}
+	consumer, err := cluster.NewConsumerProxy("static-group",
+		consumerproxy.WithStaticMembership("instance-a"),
+		consumerproxy.WithSessionTimeout(10*time.Second),
+	)
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	got := make(chan *kafka.Message, 4)
+	consumer.OnMessage(func(msg *kafka.Message) { got <- msg })
+	if err := consumer.Subscribe(topic, nil); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	consumer.Watch()
+	for i := 0; i < 4; i++ {
+		select {
+		case <-got:
+		case <-time.After(10 * time.Second):
+			t.Fatalf("static member should consume, got %d of 4 messages", i)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := consumer.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+}
@@ -0,0 +1,98 @@
+package consumerproxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Golang-Tools/kafkahelper/consumerproxy"
+
+//MessagingKafkaOffsetKey 消息在分区中的offset
+const MessagingKafkaOffsetKey = attribute.Key("messaging.kafka.message.offset")
+
+//defaultPropagator 默认使用w3c的trace-context和baggage
+var defaultPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+func (proxy *ConsumerProxy) propagator() propagation.TextMapPropagator {
+	if proxy.Opt.Propagator != nil {
+		return proxy.Opt.Propagator
+	}
+	return defaultPropagator
+}
+
+func (proxy *ConsumerProxy) tracer() trace.Tracer {
+	tp := proxy.Opt.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+//groupID 获取设置的group.id
+func (proxy *ConsumerProxy) groupID() string {
+	if proxy.Opt.ConfigMap == nil {
+		return ""
+	}
+	v, ok := proxy.Opt.ConfigMap["group.id"]
+	if !ok {
+		return ""
+	}
+	return fmt.Sprint(v)
+}
+
+//startSpan 从消息的headers中提取上下文并创建consumer span
+//未设置WithTracing时返回ctx本身和nil
+func (proxy *ConsumerProxy) startSpan(ctx context.Context, msg *kafka.Message) (context.Context, trace.Span) {
+	if !proxy.Opt.Tracing {
+		return ctx, nil
+	}
+	topic := ""
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("kafka"),
+		semconv.MessagingDestinationKey.String(topic),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingOperationProcess,
+		semconv.MessagingKafkaPartitionKey.Int64(int64(msg.TopicPartition.Partition)),
+		MessagingKafkaOffsetKey.Int64(int64(msg.TopicPartition.Offset)),
+		semconv.MessagingMessagePayloadSizeBytesKey.Int(len(msg.Value)),
+	}
+	if group := proxy.groupID(); group != "" {
+		attrs = append(attrs, semconv.MessagingKafkaConsumerGroupKey.String(group))
+	}
+	if msg.Key != nil {
+		attrs = append(attrs, semconv.MessagingKafkaMessageKeyKey.String(string(msg.Key)))
+	}
+	if msg.Value == nil {
+		attrs = append(attrs, semconv.MessagingKafkaTombstoneKey.Bool(true))
+	}
+	carrier := msghelper.NewHeaderCarrier(msg)
+	if id := carrier.Get(msghelper.HeaderMessageID); id != "" {
+		attrs = append(attrs, semconv.MessagingMessageIDKey.String(id))
+	}
+	ctx = proxy.propagator().Extract(ctx, carrier)
+	return proxy.tracer().Start(ctx, topic+" process", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(attrs...))
+}
+
+//endSpan 结束consumer span,err不为nil时记录错误
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,79 @@
+package consumerproxy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracingConsumerSpanParentedToProducerSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	b := kafkatest.NewBroker()
+	topic := "traced"
+	producer, err := b.NewProducerProxy(producerproxy.WithTracing(tp))
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	defer producer.Close()
+	if err := producer.SendAndWait(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny}, Value: []byte("v")}); err != nil {
+		t.Fatalf("SendAndWait: %v", err)
+	}
+	consumer, err := b.NewConsumerProxy("traced-group",
+		consumerproxy.WithTracing(tp),
+		consumerproxy.WithAutoOffsetReset("earliest"),
+		consumerproxy.WithComsumerSetting("go.events.channel.enable", true),
+	)
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	handled := make(chan trace.SpanContext, 1)
+	consumer.OnMessageWithContext(func(ctx context.Context, msg *kafka.Message) error {
+		handled <- trace.SpanContextFromContext(ctx)
+		return nil
+	})
+	if err := consumer.Subscribe(topic, nil); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	consumer.Watch()
+	defer consumer.Shutdown(context.Background())
+	var sc trace.SpanContext
+	select {
+	case sc = <-handled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("message not consumed")
+	}
+	var producerSpan, consumerSpan sdktrace.ReadOnlySpan
+	deadline := time.Now().Add(5 * time.Second)
+	for consumerSpan == nil && time.Now().Before(deadline) {
+		for _, s := range recorder.Ended() {
+			switch s.SpanKind() {
+			case trace.SpanKindProducer:
+				producerSpan = s
+			case trace.SpanKindConsumer:
+				consumerSpan = s
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if producerSpan == nil || consumerSpan == nil {
+		t.Fatalf("expect producer and consumer spans, got %d spans", len(recorder.Ended()))
+	}
+	if consumerSpan.SpanContext().SpanID() != sc.SpanID() {
+		t.Fatal("handler ctx should carry the consumer span")
+	}
+	if consumerSpan.Parent().SpanID() != producerSpan.SpanContext().SpanID() || consumerSpan.SpanContext().TraceID() != producerSpan.SpanContext().TraceID() {
+		t.Fatalf("consumer span should be a child of the producer span, parent %v, producer %v", consumerSpan.Parent(), producerSpan.SpanContext())
+	}
+	if !consumerSpan.Parent().IsRemote() {
+		t.Fatal("parent of the consumer span should be extracted from the message headers")
+	}
+}
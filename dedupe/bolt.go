@@ -0,0 +1,107 @@
+package dedupe
+
+import (
+	"context"
+	"encoding/binary"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+//boltBucket 记录所在的bucket
+var boltBucket = []byte("dedupe")
+
+//BoltStore 使用bbolt单文件数据库的本地存储,进程重启后记录仍然有效
+//同一个文件同时只能被一个进程打开
+type BoltStore struct {
+	db *bolt.DB
+}
+
+//NewBoltStore 打开或创建本地存储文件
+//@params path string 数据库文件路径
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+//boltValue 记录的值,前8字节为过期时间,第9字节为1时表示已经处理完成
+func boltValue(ttl time.Duration, done bool) []byte {
+	v := make([]byte, 9)
+	binary.BigEndian.PutUint64(v, uint64(time.Now().Add(ttl).UnixNano()))
+	if done {
+		v[8] = 1
+	}
+	return v
+}
+
+func expired(v []byte, now time.Time) bool {
+	return len(v) != 9 || int64(binary.BigEndian.Uint64(v)) <= now.UnixNano()
+}
+
+//Claim 实现Store
+func (s *BoltStore) Claim(ctx context.Context, key string, ttl time.Duration) (ClaimState, error) {
+	state := Claimed
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(boltBucket)
+		if v := b.Get([]byte(key)); v != nil && !expired(v, time.Now()) {
+			state = InProgress
+			if v[8] == 1 {
+				state = Done
+			}
+			return nil
+		}
+		return b.Put([]byte(key), boltValue(ttl, false))
+	})
+	return state, err
+}
+
+//Complete 实现Store
+func (s *BoltStore) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put([]byte(key), boltValue(ttl, true))
+	})
+}
+
+//Release 实现Store
+func (s *BoltStore) Release(ctx context.Context, key string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete([]byte(key))
+	})
+}
+
+//Sweep 删除已经过期的记录,记录不会自动删除,需要定时调用
+//@returns int 删除的记录数
+func (s *BoltStore) Sweep() (int, error) {
+	n := 0
+	now := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket).Cursor()
+		for k, v := c.First(); k != nil; k, v = c.Next() {
+			if expired(v, now) {
+				err := c.Delete()
+				if err != nil {
+					return err
+				}
+				n += 1
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+//Close 实现Store
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
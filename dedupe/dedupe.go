@@ -0,0 +1,254 @@
+//Package dedupe 消费端去重中间件
+//至少一次的投递在重平衡后会重复收到消息,中间件按消息的唯一键在存储中记录处理情况,跳过已经处理过的消息
+package dedupe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Logger 模块的logger
+var Logger *log.Log
+
+func init() {
+	Logger = logging.New(log.Dict{"module": "kafka-dedupe"})
+}
+
+//ErrInProgress 相同唯一键的消息正在被其他处理者处理,等待超时后返回,消息的offset不会被记录
+var ErrInProgress = errors.New("duplicate message is still in progress")
+
+//ClaimState 占用key的结果
+type ClaimState int
+
+const (
+	//Claimed key不存在或已过期,已被本次调用占用
+	Claimed ClaimState = iota
+	//Done key已经处理完成
+	Done
+	//InProgress key正在被其他处理者占用
+	InProgress
+)
+
+//String 结果的名字
+func (s ClaimState) String() string {
+	switch s {
+	case Claimed:
+		return "claimed"
+	case Done:
+		return "done"
+	case InProgress:
+		return "in-progress"
+	default:
+		return fmt.Sprintf("ClaimState(%d)", int(s))
+	}
+}
+
+//Store 记录消息处理情况的存储
+type Store interface {
+	//Claim 占用key,key不存在或已过期时占用并返回Claimed,否则按记录返回Done或InProgress
+	Claim(ctx context.Context, key string, ttl time.Duration) (ClaimState, error)
+	//Complete 记录key已经处理完成,ttl后过期
+	Complete(ctx context.Context, key string, ttl time.Duration) error
+	//Release 释放key,之后可以再次占用
+	Release(ctx context.Context, key string) error
+	//Close 关闭存储
+	Close() error
+}
+
+//KeyFunc 从消息中获取去重用的唯一键,返回false表示这条消息不去重
+type KeyFunc func(msg *kafka.Message) (string, bool)
+
+//ByHeader 使用消息header中的值作为唯一键,没有这个header的消息不去重
+//@params name string header名,为空时使用msghelper.HeaderMessageID
+func ByHeader(name string) KeyFunc {
+	if name == "" {
+		name = msghelper.HeaderMessageID
+	}
+	return func(msg *kafka.Message) (string, bool) {
+		for _, h := range msg.Headers {
+			if h.Key == name && len(h.Value) > 0 {
+				return string(h.Value), true
+			}
+		}
+		return "", false
+	}
+}
+
+//ByOffset 使用topic,partition和offset作为唯一键,只能去掉同一条消息的重复投递
+func ByOffset() KeyFunc {
+	return func(msg *kafka.Message) (string, bool) {
+		if msg.TopicPartition.Topic == nil || msg.TopicPartition.Offset < 0 {
+			return "", false
+		}
+		return fmt.Sprintf("%s/%d/%d", *msg.TopicPartition.Topic, msg.TopicPartition.Partition, msg.TopicPartition.Offset), true
+	}
+}
+
+//FailurePolicy 消息处理失败时如何记录
+type FailurePolicy int
+
+const (
+	//FailureRelease 释放占用,重复投递时会再次处理
+	FailureRelease FailurePolicy = iota
+	//FailureComplete 视为已经处理,重复投递时跳过
+	FailureComplete
+	//FailureHold 失败后保留记录ProcessingTTL,过期前的重复投递会被跳过,之后会再次处理
+	FailureHold
+)
+
+//Options 中间件的设置
+type Options struct {
+	KeyFunc       KeyFunc
+	Prefix        string
+	TTL           time.Duration
+	ProcessingTTL time.Duration
+	OnFailure     FailurePolicy
+	WaitInterval  time.Duration
+	MaxWait       time.Duration
+}
+
+var DefaultOptions = Options{
+	TTL:           24 * time.Hour,
+	ProcessingTTL: 5 * time.Minute,
+	WaitInterval:  200 * time.Millisecond,
+}
+
+//WithKeyFunc 设置唯一键的获取方式,默认使用ByHeader(msghelper.HeaderMessageID)
+//@params fn KeyFunc 可以使用ByHeader,ByOffset或自定义函数
+func WithKeyFunc(fn KeyFunc) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.KeyFunc = fn
+	})
+}
+
+//WithPrefix 设置唯一键的前缀,多个消费组共用存储时用于区分,比如使用消费组id
+//@params prefix string 唯一键的前缀
+func WithPrefix(prefix string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Prefix = prefix
+	})
+}
+
+//WithTTL 设置处理完成的记录保留多久,默认24h
+//@params ttl time.Duration 记录保留时长
+func WithTTL(ttl time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.TTL = ttl
+	})
+}
+
+//WithProcessingTTL 设置处理中的占用保留多久,进程在处理中崩溃时占用过期后消息才能被再次处理,默认5min
+//@params ttl time.Duration 占用保留时长
+func WithProcessingTTL(ttl time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.ProcessingTTL = ttl
+	})
+}
+
+//WithFailurePolicy 设置消息处理失败时如何记录,默认为FailureRelease
+//@params policy FailurePolicy 处理失败时的策略
+func WithFailurePolicy(policy FailurePolicy) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.OnFailure = policy
+	})
+}
+
+//WithWait 设置遇到正在处理中的重复消息时如何等待
+//默认每200ms检查一次,一直等到原消息处理完成,被释放或占用过期(最长ProcessingTTL)
+//@params interval time.Duration 检查的间隔
+//@params max time.Duration 最长等待时间,超过后返回ErrInProgress,小于等于0表示不限制
+func WithWait(interval, max time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.WaitInterval = interval
+		o.MaxWait = max
+	})
+}
+
+//claim 占用key,key正在处理中时等待原消息的结果
+//原消息处理完成时返回Done,被释放或占用过期时由本次调用占用并返回Claimed,等待超时或ctx结束时返回ErrInProgress
+func claim(ctx context.Context, store Store, key string, opt Options) (ClaimState, error) {
+	interval := opt.WaitInterval
+	if interval <= 0 {
+		interval = DefaultOptions.WaitInterval
+	}
+	var deadline <-chan time.Time
+	if opt.MaxWait > 0 {
+		timer := time.NewTimer(opt.MaxWait)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		state, err := store.Claim(ctx, key, opt.ProcessingTTL)
+		if err != nil || state != InProgress {
+			return state, err
+		}
+		timer := time.NewTimer(interval)
+		select {
+		case <-timer.C:
+		case <-deadline:
+			timer.Stop()
+			return InProgress, ErrInProgress
+		case <-ctx.Done():
+			timer.Stop()
+			return InProgress, fmt.Errorf("%w: %v", ErrInProgress, ctx.Err())
+		}
+	}
+}
+
+//New 创建去重中间件,使用ConsumerProxy.Use注册
+//已经处理过的消息会被跳过,处理函数返回nil;正在被其他处理者处理的消息会等待原消息的结果,
+//原消息处理失败并被释放时由本次投递处理,等待超时返回ErrInProgress,不会把还未处理完的消息当作成功;
+//没有唯一键的消息直接处理;存储出错时不去重直接处理,保证消息不会因为存储故障而丢失
+//@params store Store 记录处理情况的存储
+//@params opts ...optparams.Option[Options] 中间件的设置
+func New(store Store, opts ...optparams.Option[Options]) consumerproxy.Middleware {
+	opt := DefaultOptions
+	optparams.GetOption(&opt, opts...)
+	if opt.KeyFunc == nil {
+		opt.KeyFunc = ByHeader("")
+	}
+	return func(next consumerproxy.OnMsgWithCtxCallback) consumerproxy.OnMsgWithCtxCallback {
+		return func(ctx context.Context, msg *kafka.Message) error {
+			key, ok := opt.KeyFunc(msg)
+			if !ok {
+				return next(ctx, msg)
+			}
+			key = opt.Prefix + key
+			state, err := claim(ctx, store, key, opt)
+			if errors.Is(err, ErrInProgress) {
+				return err
+			}
+			if err != nil {
+				Logger.Error("dedupe store claim get error, handle without dedupe", log.Dict{"err": err, "key": key})
+				return next(ctx, msg)
+			}
+			if state == Done {
+				Logger.Debug("skip duplicate message", log.Dict{"key": key, "partition": msg.TopicPartition.Partition, "offset": msg.TopicPartition.Offset})
+				return nil
+			}
+			herr := next(ctx, msg)
+			switch {
+			case herr == nil || opt.OnFailure == FailureComplete:
+				err = store.Complete(ctx, key, opt.TTL)
+			case opt.OnFailure == FailureRelease:
+				err = store.Release(ctx, key)
+			case opt.OnFailure == FailureHold:
+				//记为ProcessingTTL后过期的完成记录,过期前的重复投递直接跳过而不是等待
+				err = store.Complete(ctx, key, opt.ProcessingTTL)
+			}
+			if err != nil {
+				Logger.Error("dedupe store update get error", log.Dict{"err": err, "key": key})
+			}
+			return herr
+		}
+	}
+}
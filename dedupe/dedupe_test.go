@@ -0,0 +1,242 @@
+package dedupe
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//stores 三种存储的构造函数
+func stores() map[string]func(t *testing.T) Store {
+	return map[string]func(t *testing.T) Store{
+		"memory": func(t *testing.T) Store {
+			return NewMemoryStore(0)
+		},
+		"bolt": func(t *testing.T) Store {
+			s, err := NewBoltStore(filepath.Join(t.TempDir(), "dedupe.db"))
+			if err != nil {
+				t.Fatalf("NewBoltStore: %v", err)
+			}
+			t.Cleanup(func() { s.Close() })
+			return s
+		},
+		"sql": func(t *testing.T) Store {
+			db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "dedupe.db")+"?_busy_timeout=5000")
+			if err != nil {
+				t.Fatalf("open sqlite: %v", err)
+			}
+			t.Cleanup(func() { db.Close() })
+			s := NewSQLStore(db, "dedupe", QuestionMark)
+			if err := s.CreateTable(context.Background()); err != nil {
+				t.Fatalf("CreateTable: %v", err)
+			}
+			return s
+		},
+	}
+}
+
+func mustClaim(t *testing.T, s Store, key string, ttl time.Duration, want ClaimState) {
+	t.Helper()
+	got, err := s.Claim(context.Background(), key, ttl)
+	if err != nil {
+		t.Fatalf("Claim(%s): %v", key, err)
+	}
+	if got != want {
+		t.Fatalf("Claim(%s): expect %v, got %v", key, want, got)
+	}
+}
+
+func TestStoreClaimCompleteRelease(t *testing.T) {
+	for name, newStore := range stores() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			ctx := context.Background()
+			mustClaim(t, s, "a", time.Minute, Claimed)
+			mustClaim(t, s, "a", time.Minute, InProgress)
+			if err := s.Complete(ctx, "a", time.Minute); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			mustClaim(t, s, "a", time.Minute, Done)
+			if err := s.Release(ctx, "a"); err != nil {
+				t.Fatalf("Release: %v", err)
+			}
+			mustClaim(t, s, "a", time.Minute, Claimed)
+			//没有占用过的key也可以直接记为完成
+			if err := s.Complete(ctx, "b", time.Minute); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			mustClaim(t, s, "b", time.Minute, Done)
+		})
+	}
+}
+
+func TestStoreTTLExpiry(t *testing.T) {
+	for name, newStore := range stores() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			mustClaim(t, s, "processing", 50*time.Millisecond, Claimed)
+			if err := s.Complete(context.Background(), "done", 50*time.Millisecond); err != nil {
+				t.Fatalf("Complete: %v", err)
+			}
+			time.Sleep(100 * time.Millisecond)
+			mustClaim(t, s, "processing", time.Minute, Claimed)
+			mustClaim(t, s, "done", time.Minute, Claimed)
+			mustClaim(t, s, "done", time.Minute, InProgress)
+		})
+	}
+}
+
+func message(id string) *kafka.Message {
+	topic := "orders"
+	return &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic},
+		Headers:        []kafka.Header{{Key: msghelper.HeaderMessageID, Value: []byte(id)}},
+	}
+}
+
+func TestMiddlewareFailurePolicy(t *testing.T) {
+	errHandle := errors.New("handle failed")
+	cases := []struct {
+		policy FailurePolicy
+		//第二次投递是否会被处理
+		handled bool
+		//ProcessingTTL过期后第三次投递是否会被处理,第二次投递处理成功后会被记为完成
+		handledAfterTTL bool
+	}{
+		{policy: FailureRelease, handled: true, handledAfterTTL: false},
+		{policy: FailureComplete, handled: false, handledAfterTTL: false},
+		{policy: FailureHold, handled: false, handledAfterTTL: true},
+	}
+	for name, newStore := range stores() {
+		for _, c := range cases {
+			t.Run(fmt.Sprintf("%s/policy-%d", name, c.policy), func(t *testing.T) {
+				var calls int32
+				handler := New(newStore(t), WithFailurePolicy(c.policy), WithProcessingTTL(100*time.Millisecond))(func(ctx context.Context, msg *kafka.Message) error {
+					if atomic.AddInt32(&calls, 1) == 1 {
+						return errHandle
+					}
+					return nil
+				})
+				if err := handler(context.Background(), message("m1")); err != errHandle {
+					t.Fatalf("policy %d: expect the handler error, got %v", c.policy, err)
+				}
+				if err := handler(context.Background(), message("m1")); err != nil {
+					t.Fatalf("policy %d: %v", c.policy, err)
+				}
+				if got := atomic.LoadInt32(&calls) == 2; got != c.handled {
+					t.Fatalf("policy %d: expect handled %v on redelivery, got %v", c.policy, c.handled, got)
+				}
+				before := atomic.LoadInt32(&calls)
+				time.Sleep(150 * time.Millisecond)
+				if err := handler(context.Background(), message("m1")); err != nil {
+					t.Fatalf("policy %d: %v", c.policy, err)
+				}
+				if got := atomic.LoadInt32(&calls) > before; got != c.handledAfterTTL {
+					t.Fatalf("policy %d: expect handled %v after ProcessingTTL, got %v", c.policy, c.handledAfterTTL, got)
+				}
+			})
+		}
+	}
+}
+
+func TestMiddlewareSkipsCompleted(t *testing.T) {
+	for name, newStore := range stores() {
+		t.Run(name, func(t *testing.T) {
+			var calls int32
+			handler := New(newStore(t))(func(ctx context.Context, msg *kafka.Message) error {
+				atomic.AddInt32(&calls, 1)
+				return nil
+			})
+			for i := 0; i < 3; i++ {
+				if err := handler(context.Background(), message("m1")); err != nil {
+					t.Fatalf("handler: %v", err)
+				}
+			}
+			//没有唯一键的消息不去重
+			topic := "orders"
+			for i := 0; i < 2; i++ {
+				if err := handler(context.Background(), &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}}); err != nil {
+					t.Fatalf("handler: %v", err)
+				}
+			}
+			if n := atomic.LoadInt32(&calls); n != 3 {
+				t.Fatalf("expect 3 calls, got %d", n)
+			}
+		})
+	}
+}
+
+func TestMiddlewareWaitsForInProgress(t *testing.T) {
+	errHandle := errors.New("handle failed")
+	for name, newStore := range stores() {
+		t.Run(name, func(t *testing.T) {
+			started := make(chan struct{})
+			finish := make(chan struct{})
+			var calls int32
+			handler := New(newStore(t), WithWait(10*time.Millisecond, 0))(func(ctx context.Context, msg *kafka.Message) error {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					close(started)
+					<-finish
+					return errHandle
+				}
+				return nil
+			})
+			var wg sync.WaitGroup
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				handler(context.Background(), message("m1"))
+			}()
+			<-started
+			done := make(chan error, 1)
+			go func() {
+				done <- handler(context.Background(), message("m1"))
+			}()
+			select {
+			case err := <-done:
+				t.Fatalf("duplicate should wait while the original is in progress, returned %v", err)
+			case <-time.After(50 * time.Millisecond):
+			}
+			//原消息处理失败被释放后由重复投递处理
+			close(finish)
+			wg.Wait()
+			if err := <-done; err != nil {
+				t.Fatalf("duplicate should be handled after the original failed, got %v", err)
+			}
+			if n := atomic.LoadInt32(&calls); n != 2 {
+				t.Fatalf("expect 2 calls, got %d", n)
+			}
+		})
+	}
+}
+
+func TestMiddlewareInProgressTimeout(t *testing.T) {
+	for name, newStore := range stores() {
+		t.Run(name, func(t *testing.T) {
+			s := newStore(t)
+			mustClaim(t, s, "m1", time.Minute, Claimed)
+			var calls int32
+			handler := New(s, WithWait(10*time.Millisecond, 50*time.Millisecond))(func(ctx context.Context, msg *kafka.Message) error {
+				atomic.AddInt32(&calls, 1)
+				return nil
+			})
+			err := handler(context.Background(), message("m1"))
+			if !errors.Is(err, ErrInProgress) {
+				t.Fatalf("expect ErrInProgress, got %v", err)
+			}
+			if n := atomic.LoadInt32(&calls); n != 0 {
+				t.Fatalf("in-progress duplicate should not be handled, got %d calls", n)
+			}
+		})
+	}
+}
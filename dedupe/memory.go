@@ -0,0 +1,101 @@
+package dedupe
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+//memoryEntry 内存存储中的一条记录
+type memoryEntry struct {
+	key     string
+	expires time.Time
+	done    bool
+}
+
+//MemoryStore 内存中的LRU存储,记录数超过容量时淘汰最久未使用的记录,记录到期后失效
+//只在单个进程内有效,进程重启后记录丢失
+type MemoryStore struct {
+	lock     sync.Mutex
+	capacity int
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+//NewMemoryStore 创建内存存储
+//@params capacity int 最多保存的记录数,小于等于0表示不限制
+func NewMemoryStore(capacity int) *MemoryStore {
+	return &MemoryStore{
+		capacity: capacity,
+		items:    map[string]*list.Element{},
+		order:    list.New(),
+	}
+}
+
+//set 写入记录并移到最前,调用时需要持有锁
+func (s *MemoryStore) set(key string, ttl time.Duration, done bool) {
+	expires := time.Now().Add(ttl)
+	if e, ok := s.items[key]; ok {
+		entry := e.Value.(*memoryEntry)
+		entry.expires = expires
+		entry.done = done
+		s.order.MoveToFront(e)
+		return
+	}
+	s.items[key] = s.order.PushFront(&memoryEntry{key: key, expires: expires, done: done})
+	for s.capacity > 0 && s.order.Len() > s.capacity {
+		last := s.order.Back()
+		s.order.Remove(last)
+		delete(s.items, last.Value.(*memoryEntry).key)
+	}
+}
+
+//Claim 实现Store
+func (s *MemoryStore) Claim(ctx context.Context, key string, ttl time.Duration) (ClaimState, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.items[key]; ok {
+		entry := e.Value.(*memoryEntry)
+		if time.Now().Before(entry.expires) {
+			s.order.MoveToFront(e)
+			if entry.done {
+				return Done, nil
+			}
+			return InProgress, nil
+		}
+	}
+	s.set(key, ttl, false)
+	return Claimed, nil
+}
+
+//Complete 实现Store
+func (s *MemoryStore) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.set(key, ttl, true)
+	return nil
+}
+
+//Release 实现Store
+func (s *MemoryStore) Release(ctx context.Context, key string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if e, ok := s.items[key]; ok {
+		s.order.Remove(e)
+		delete(s.items, key)
+	}
+	return nil
+}
+
+//Len 当前保存的记录数,包括已经过期但还未被淘汰的记录
+func (s *MemoryStore) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.order.Len()
+}
+
+//Close 实现Store
+func (s *MemoryStore) Close() error {
+	return nil
+}
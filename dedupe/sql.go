@@ -0,0 +1,107 @@
+package dedupe
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/internal/sqldialect"
+)
+
+//SQLStore 使用数据库表的存储,多个进程可以共用
+//表结构为`dedupe_key VARCHAR(255) PRIMARY KEY, expires_at BIGINT, done SMALLINT`,只使用通用sql
+type SQLStore struct {
+	db          *sql.DB
+	table       string
+	placeholder sqldialect.Placeholder
+}
+
+//QuestionMark MySQL,SQLite使用的`?`占位符
+var QuestionMark = sqldialect.QuestionMark
+
+//Dollar PostgreSQL使用的`$1`形式的占位符
+var Dollar = sqldialect.Dollar
+
+//NewSQLStore 创建数据库存储
+//@params db *sql.DB 数据库连接
+//@params table string 表名
+//@params placeholder func(i int) string 第i个参数的占位符写法,可以使用QuestionMark或Dollar
+func NewSQLStore(db *sql.DB, table string, placeholder func(i int) string) *SQLStore {
+	return &SQLStore{db: db, table: table, placeholder: placeholder}
+}
+
+//sql 将语句中的表名和`?`占位符替换为方言的写法
+func (s *SQLStore) sql(query string) string {
+	return sqldialect.Rebind(strings.ReplaceAll(query, "{table}", s.table), s.placeholder)
+}
+
+//CreateTable 创建表,表已存在时不做修改
+func (s *SQLStore) CreateTable(ctx context.Context) error {
+	_, err := s.db.ExecContext(ctx, s.sql(`CREATE TABLE IF NOT EXISTS {table} (dedupe_key VARCHAR(255) NOT NULL PRIMARY KEY, expires_at BIGINT NOT NULL, done SMALLINT NOT NULL DEFAULT 0)`))
+	return err
+}
+
+//Claim 实现Store
+//先尝试接管已过期的记录,没有时插入新记录,插入失败且记录存在说明已被占用或已经处理完成
+func (s *SQLStore) Claim(ctx context.Context, key string, ttl time.Duration) (ClaimState, error) {
+	now := time.Now()
+	expires := now.Add(ttl).UnixMilli()
+	res, err := s.db.ExecContext(ctx, s.sql(`UPDATE {table} SET expires_at = ?, done = 0 WHERE dedupe_key = ? AND expires_at <= ?`), expires, key, now.UnixMilli())
+	if err != nil {
+		return InProgress, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return Claimed, nil
+	}
+	_, err = s.db.ExecContext(ctx, s.sql(`INSERT INTO {table} (dedupe_key, expires_at, done) VALUES (?, ?, 0)`), key, expires)
+	if err == nil {
+		return Claimed, nil
+	}
+	var done int
+	qerr := s.db.QueryRowContext(ctx, s.sql(`SELECT done FROM {table} WHERE dedupe_key = ?`), key).Scan(&done)
+	if qerr != nil {
+		return InProgress, fmt.Errorf("claim dedupe key: %w", err)
+	}
+	if done == 1 {
+		return Done, nil
+	}
+	//记录可能在查询前刚好过期,返回InProgress由调用方稍后重试
+	return InProgress, nil
+}
+
+//Complete 实现Store
+func (s *SQLStore) Complete(ctx context.Context, key string, ttl time.Duration) error {
+	expires := time.Now().Add(ttl).UnixMilli()
+	res, err := s.db.ExecContext(ctx, s.sql(`UPDATE {table} SET expires_at = ?, done = 1 WHERE dedupe_key = ?`), expires, key)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+	_, err = s.db.ExecContext(ctx, s.sql(`INSERT INTO {table} (dedupe_key, expires_at, done) VALUES (?, ?, 1)`), key, expires)
+	return err
+}
+
+//Release 实现Store
+func (s *SQLStore) Release(ctx context.Context, key string) error {
+	_, err := s.db.ExecContext(ctx, s.sql(`DELETE FROM {table} WHERE dedupe_key = ?`), key)
+	return err
+}
+
+//Sweep 删除已经过期的记录,需要定时调用
+//@returns int64 删除的记录数
+func (s *SQLStore) Sweep(ctx context.Context) (int64, error) {
+	res, err := s.db.ExecContext(ctx, s.sql(`DELETE FROM {table} WHERE expires_at <= ?`), time.Now().UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+//Close 实现Store,不会关闭数据库连接
+func (s *SQLStore) Close() error {
+	return nil
+}
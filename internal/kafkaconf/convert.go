@@ -0,0 +1,137 @@
+package kafkaconf
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+//ErrUnknownKey 未知的设置项
+var ErrUnknownKey = errors.New("unknown config key")
+
+//ErrInvalidValue 设置项的值类型或取值不对
+var ErrInvalidValue = errors.New("invalid config value")
+
+//Convert 将设置的值转换为设置项要求的类型,字符串形式的值(比如来自环境变量)会被解析
+//@params t Type 设置项值的类型
+//@params values []string 枚举类型的可选值
+//@params value any 设置的值
+func Convert(t Type, values []string, value any) (any, error) {
+	switch t {
+	case String:
+		if s, ok := value.(string); ok {
+			return s, nil
+		}
+	case Int:
+		switch v := value.(type) {
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case int32:
+			return int(v), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return int(v), nil
+			}
+		case string:
+			i, err := strconv.Atoi(strings.TrimSpace(v))
+			if err == nil {
+				return i, nil
+			}
+		}
+	case Float:
+		switch v := value.(type) {
+		case float64:
+			return v, nil
+		case float32:
+			return float64(v), nil
+		case int:
+			return v, nil
+		case int64:
+			return int(v), nil
+		case string:
+			f, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+			if err == nil {
+				return f, nil
+			}
+		}
+	case Bool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case string:
+			b, err := strconv.ParseBool(strings.TrimSpace(v))
+			if err == nil {
+				return b, nil
+			}
+		}
+	case Enum:
+		if s, ok := value.(string); ok {
+			for _, candidate := range values {
+				if strings.EqualFold(candidate, strings.TrimSpace(s)) {
+					return candidate, nil
+				}
+			}
+			return nil, fmt.Errorf("%w: expect one of %s, got %q", ErrInvalidValue, strings.Join(values, ","), s)
+		}
+	case Acks:
+		if s, ok := value.(string); ok && strings.EqualFold(strings.TrimSpace(s), "all") {
+			return "all", nil
+		}
+		i, err := Convert(Int, nil, value)
+		if err == nil {
+			return i, nil
+		}
+	default:
+		return value, nil
+	}
+	return nil, fmt.Errorf("%w: expect %s, got %T(%v)", ErrInvalidValue, t, value, value)
+}
+
+//ConvertProperty 检查key是否为已知的设置项并将值转换为对应的类型
+//@params key string 设置项,会先经过NormalizeKey匹配
+//@params value any 设置的值
+//@returns string 设置项在librdkafka中的名字
+func ConvertProperty(key string, value any) (string, any, error) {
+	prop, ok := Lookup(key)
+	if !ok {
+		return "", nil, fmt.Errorf("%w: %q", ErrUnknownKey, key)
+	}
+	v, err := Convert(prop.Type, prop.Values, value)
+	if err != nil {
+		return "", nil, fmt.Errorf("key %q: %w", key, err)
+	}
+	return prop.Name, v, nil
+}
+
+//MultiError 多个错误,用于一次报告所有有问题的设置项
+type MultiError []error
+
+func (m MultiError) Error() string {
+	msgs := make([]string, 0, len(m))
+	for _, err := range m {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+//Is 只要有一个错误匹配target就返回true
+func (m MultiError) Is(target error) bool {
+	for _, err := range m {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+//ErrOrNil 没有错误时返回nil
+func (m MultiError) ErrOrNil() error {
+	if len(m) == 0 {
+		return nil
+	}
+	return m
+}
@@ -0,0 +1,86 @@
+package kafkaconf_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestConvert(t *testing.T) {
+	cases := []struct {
+		name   string
+		t      kafkaconf.Type
+		values []string
+		value  any
+		want   any
+	}{
+		{name: "int from string", t: kafkaconf.Int, value: " 42 ", want: 42},
+		{name: "int from int64", t: kafkaconf.Int, value: int64(7), want: 7},
+		//json和yaml的数字可能解析为float64
+		{name: "int from whole float", t: kafkaconf.Int, value: float64(3), want: 3},
+		{name: "int from fraction", t: kafkaconf.Int, value: 3.5},
+		{name: "int from word", t: kafkaconf.Int, value: "many"},
+		{name: "float from string", t: kafkaconf.Float, value: "0.5", want: 0.5},
+		{name: "float from int", t: kafkaconf.Float, value: 5, want: 5},
+		{name: "bool from string", t: kafkaconf.Bool, value: "true", want: true},
+		{name: "bool from digit", t: kafkaconf.Bool, value: "0", want: false},
+		{name: "bool from word", t: kafkaconf.Bool, value: "yes"},
+		{name: "string", t: kafkaconf.String, value: "a", want: "a"},
+		{name: "string from int", t: kafkaconf.String, value: 1},
+		{name: "enum ignores case", t: kafkaconf.Enum, values: []string{"none", "gzip"}, value: " GZIP", want: "gzip"},
+		{name: "enum not listed", t: kafkaconf.Enum, values: []string{"none", "gzip"}, value: "brotli"},
+		{name: "acks all", t: kafkaconf.Acks, value: "ALL", want: "all"},
+		{name: "acks from string", t: kafkaconf.Acks, value: "-1", want: -1},
+		{name: "acks from int", t: kafkaconf.Acks, value: 1, want: 1},
+		{name: "acks from word", t: kafkaconf.Acks, value: "some"},
+		{name: "any", t: kafkaconf.Any, value: []int{1}, want: []int{1}},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := kafkaconf.Convert(c.t, c.values, c.value)
+			if c.want == nil {
+				if !errors.Is(err, kafkaconf.ErrInvalidValue) {
+					t.Fatalf("expect ErrInvalidValue, got %v, %v", got, err)
+				}
+				return
+			}
+			if err != nil || !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("expect %T(%v), got %T(%v), %v", c.want, c.want, got, got, err)
+			}
+		})
+	}
+}
+
+func TestParse(t *testing.T) {
+	goKeys := map[string]kafkaconf.Type{"parallel.callback": kafkaconf.Bool}
+	//环境变量的写法会被统一为librdkafka的设置项名
+	cm, flags, err := kafkaconf.Parse(map[string]any{
+		"BOOTSTRAP_SERVERS":  "localhost:9092",
+		"linger.ms":          "5",
+		"PARALLEL_CALLBACK":  "true",
+		"compression.type":   "LZ4",
+		"enable.idempotence": true,
+	}, goKeys)
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	want := kafka.ConfigMap{"bootstrap.servers": "localhost:9092", "linger.ms": float64(5), "compression.type": "lz4", "enable.idempotence": true}
+	if !reflect.DeepEqual(cm, want) {
+		t.Fatalf("expect %v, got %v", want, cm)
+	}
+	if !reflect.DeepEqual(flags, map[string]any{"parallel.callback": true}) {
+		t.Fatalf("unexpected go settings %v", flags)
+	}
+	//所有有问题的设置项一起报告
+	_, _, err = kafkaconf.Parse(map[string]any{"bootstrap.server": "localhost:9092", "linger.ms": "soon", "parallel.callback": "maybe"}, goKeys)
+	var errs kafkaconf.MultiError
+	if !errors.As(err, &errs) || len(errs) != 3 {
+		t.Fatalf("expect 3 errors, got %v", err)
+	}
+	if !errors.Is(err, kafkaconf.ErrUnknownKey) || !errors.Is(err, kafkaconf.ErrInvalidValue) {
+		t.Fatalf("expect unknown key and invalid value errors, got %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package kafkaconf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"gopkg.in/yaml.v3"
+)
+
+//DefaultEnvPrefix 默认的环境变量前缀
+const DefaultEnvPrefix = "KAFKA_"
+
+//ReadFile 读取配置文件,根据扩展名支持yaml,yml,json和toml,嵌套的表会用`.`展开
+//@params path string 配置文件路径
+func ReadFile(path string) (map[string]any, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	raw := map[string]any{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &raw)
+	case ".json":
+		err = json.Unmarshal(content, &raw)
+	case ".toml":
+		err = toml.Unmarshal(content, &raw)
+	default:
+		return nil, fmt.Errorf("unsupported config file type %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	result := map[string]any{}
+	flatten("", raw, result)
+	return result, nil
+}
+
+func flatten(prefix string, raw map[string]any, result map[string]any) {
+	for k, v := range raw {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		if sub, ok := v.(map[string]any); ok {
+			flatten(key, sub, result)
+		} else {
+			result[key] = v
+		}
+	}
+}
+
+//ReadEnv 读取带有前缀的环境变量,去掉前缀后作为设置项,值均为字符串
+//@params prefix string 环境变量前缀,为空时使用`KAFKA_`
+func ReadEnv(prefix string) map[string]any {
+	if prefix == "" {
+		prefix = DefaultEnvPrefix
+	}
+	if !strings.HasSuffix(prefix, "_") {
+		prefix += "_"
+	}
+	result := map[string]any{}
+	for _, kv := range os.Environ() {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok || !strings.HasPrefix(k, prefix) || len(k) == len(prefix) {
+			continue
+		}
+		result[NormalizeKey(strings.TrimPrefix(k, prefix))] = v
+	}
+	return result
+}
+
+//DropUnknown 去掉既不是librdkafka设置项也不在goKeys中的设置项,返回去掉的key
+//环境变量中常有同前缀但与客户端无关的变量,如k8s注入的`KAFKA_PORT`,`KAFKA_SERVICE_HOST`和kafka脚本使用的`KAFKA_OPTS`,`KAFKA_HEAP_OPTS`,
+//读取环境变量时用它跳过这些变量,配置文件仍由Parse拒绝未知的设置项
+//@params raw map[string]any 读取到的设置,会被直接修改
+//@params goKeys map[string]Type go端的设置项及其类型
+func DropUnknown(raw map[string]any, goKeys map[string]Type) []string {
+	dropped := []string{}
+	for k := range raw {
+		if _, ok := goKeys[NormalizeKey(k)]; ok {
+			continue
+		}
+		if _, ok := Lookup(k); ok {
+			continue
+		}
+		delete(raw, k)
+		dropped = append(dropped, k)
+	}
+	sort.Strings(dropped)
+	return dropped
+}
+
+//Parse 将读取到的设置分为librdkafka的设置和go端的设置,并检查未知的设置项和值的类型
+//@params raw map[string]any 读取到的设置
+//@params goKeys map[string]Type go端的设置项及其类型,key使用NormalizeKey后的形式
+//@returns kafka.ConfigMap librdkafka的设置
+//@returns map[string]any go端的设置
+func Parse(raw map[string]any, goKeys map[string]Type) (kafka.ConfigMap, map[string]any, error) {
+	keys := make([]string, 0, len(raw))
+	for k := range raw {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	cm := kafka.ConfigMap{}
+	flags := map[string]any{}
+	errs := MultiError{}
+	for _, k := range keys {
+		if t, ok := goKeys[NormalizeKey(k)]; ok {
+			v, err := Convert(t, nil, raw[k])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("key %q: %w", k, err))
+				continue
+			}
+			flags[NormalizeKey(k)] = v
+			continue
+		}
+		name, v, err := ConvertProperty(k, raw[k])
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		cm[name] = v
+	}
+	if err := errs.ErrOrNil(); err != nil {
+		return nil, nil, err
+	}
+	return cm, flags, nil
+}
@@ -0,0 +1,42 @@
+package kafkaconf
+
+import "github.com/confluentinc/confluent-kafka-go/kafka"
+
+//aliasesOf 找出key的所有别名
+func aliasesOf(key string) []string {
+	result := []string{}
+	for _, pair := range aliases {
+		if pair[0] == key {
+			result = append(result, pair[1])
+		} else if pair[1] == key {
+			result = append(result, pair[0])
+		}
+	}
+	return result
+}
+
+//Merge 以base为底,用override覆盖生成新的设置,override中设置了某项的别名时base中的该项也会被忽略
+//@params base kafka.ConfigMap 底层设置,比如预设的profile
+//@params override kafka.ConfigMap 显式的设置
+func Merge(base, override kafka.ConfigMap) kafka.ConfigMap {
+	result := kafka.ConfigMap{}
+	for k, v := range base {
+		if _, ok := override[k]; ok {
+			continue
+		}
+		overridden := false
+		for _, alias := range aliasesOf(k) {
+			if _, ok := override[alias]; ok {
+				overridden = true
+				break
+			}
+		}
+		if !overridden {
+			result[k] = v
+		}
+	}
+	for k, v := range override {
+		result[k] = v
+	}
+	return result
+}
@@ -0,0 +1,255 @@
+//kafkaconf 生产者代理和消费者代理共用的librdkafka设置项说明,以及设置的读取和校验工具
+package kafkaconf
+
+import "strings"
+
+//Type 设置项值的类型
+type Type int
+
+const (
+	String Type = iota
+	Int
+	Bool
+	Float
+	Enum
+	//Acks 可以是整数,也可以是all
+	Acks
+	//Any 只能在代码中设置的值,比如go.logs.channel
+	Any
+)
+
+func (t Type) String() string {
+	switch t {
+	case String:
+		return "string"
+	case Int:
+		return "int"
+	case Bool:
+		return "bool"
+	case Float:
+		return "float"
+	case Enum:
+		return "enum"
+	case Acks:
+		return "int or all"
+	default:
+		return "any"
+	}
+}
+
+//Role 设置项适用的客户端
+type Role int
+
+const (
+	//Both 生产者和消费者都可以使用
+	Both Role = iota
+	Producer
+	Consumer
+)
+
+func (r Role) String() string {
+	switch r {
+	case Producer:
+		return "producer"
+	case Consumer:
+		return "consumer"
+	default:
+		return "both"
+	}
+}
+
+//Property librdkafka或confluent-kafka-go的设置项
+type Property struct {
+	Name   string
+	Type   Type
+	Role   Role
+	Values []string
+}
+
+func p(name string, t Type, r Role, values ...string) Property {
+	return Property{Name: name, Type: t, Role: r, Values: values}
+}
+
+//Properties 支持的设置项,参考<https://github.com/edenhill/librdkafka/blob/master/CONFIGURATION.md>
+//回调函数类的设置项无法在go中设置,因此没有列出
+var Properties = []Property{
+	p("builtin.features", String, Both),
+	p("client.id", String, Both),
+	p("client.software.name", String, Both),
+	p("client.software.version", String, Both),
+	p("metadata.broker.list", String, Both),
+	p("bootstrap.servers", String, Both),
+	p("message.max.bytes", Int, Both),
+	p("message.copy.max.bytes", Int, Both),
+	p("receive.message.max.bytes", Int, Both),
+	p("max.in.flight.requests.per.connection", Int, Both),
+	p("max.in.flight", Int, Both),
+	p("topic.metadata.refresh.interval.ms", Int, Both),
+	p("metadata.max.age.ms", Int, Both),
+	p("topic.metadata.refresh.fast.interval.ms", Int, Both),
+	p("topic.metadata.refresh.fast.cnt", Int, Both),
+	p("topic.metadata.refresh.sparse", Bool, Both),
+	p("topic.metadata.propagation.max.ms", Int, Both),
+	p("topic.blacklist", String, Both),
+	p("debug", String, Both),
+	p("socket.timeout.ms", Int, Both),
+	p("socket.blocking.max.ms", Int, Both),
+	p("socket.send.buffer.bytes", Int, Both),
+	p("socket.receive.buffer.bytes", Int, Both),
+	p("socket.keepalive.enable", Bool, Both),
+	p("socket.nagle.disable", Bool, Both),
+	p("socket.max.fails", Int, Both),
+	p("broker.address.ttl", Int, Both),
+	p("broker.address.family", Enum, Both, "any", "v4", "v6"),
+	p("client.dns.lookup", Enum, Both, "use_all_dns_ips", "resolve_canonical_bootstrap_servers_only"),
+	p("enable.sparse.connections", Bool, Both),
+	p("socket.connection.setup.timeout.ms", Int, Both),
+	p("connections.max.idle.ms", Int, Both),
+	p("reconnect.backoff.jitter.ms", Int, Both),
+	p("reconnect.backoff.ms", Int, Both),
+	p("reconnect.backoff.max.ms", Int, Both),
+	p("retry.backoff.ms", Int, Both),
+	p("retry.backoff.max.ms", Int, Both),
+	p("metadata.recovery.strategy", Enum, Both, "none", "rebootstrap"),
+	p("statistics.interval.ms", Int, Both),
+	p("enable.metrics.push", Bool, Both),
+	p("enabled_events", Int, Both),
+	p("log_level", Int, Both),
+	p("log.queue", Bool, Both),
+	p("log.thread.name", Bool, Both),
+	p("enable.random.seed", Bool, Both),
+	p("log.connection.close", Bool, Both),
+	p("internal.termination.signal", Int, Both),
+	p("api.version.request", Bool, Both),
+	p("api.version.request.timeout.ms", Int, Both),
+	p("api.version.fallback.ms", Int, Both),
+	p("broker.version.fallback", String, Both),
+	p("allow.auto.create.topics", Bool, Both),
+	p("security.protocol", Enum, Both, "plaintext", "ssl", "sasl_plaintext", "sasl_ssl"),
+	p("ssl.cipher.suites", String, Both),
+	p("ssl.curves.list", String, Both),
+	p("ssl.sigalgs.list", String, Both),
+	p("ssl.key.location", String, Both),
+	p("ssl.key.password", String, Both),
+	p("ssl.key.pem", String, Both),
+	p("ssl.certificate.location", String, Both),
+	p("ssl.certificate.pem", String, Both),
+	p("ssl.ca.location", String, Both),
+	p("ssl.ca.pem", String, Both),
+	p("ssl.ca.certificate.stores", String, Both),
+	p("ssl.crl.location", String, Both),
+	p("ssl.keystore.location", String, Both),
+	p("ssl.keystore.password", String, Both),
+	p("ssl.engine.location", String, Both),
+	p("ssl.engine.id", String, Both),
+	p("ssl.providers", String, Both),
+	p("enable.ssl.certificate.verification", Bool, Both),
+	p("ssl.endpoint.identification.algorithm", Enum, Both, "none", "https"),
+	p("sasl.mechanisms", Enum, Both, "GSSAPI", "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512", "OAUTHBEARER"),
+	p("sasl.mechanism", Enum, Both, "GSSAPI", "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512", "OAUTHBEARER"),
+	p("sasl.kerberos.service.name", String, Both),
+	p("sasl.kerberos.principal", String, Both),
+	p("sasl.kerberos.kinit.cmd", String, Both),
+	p("sasl.kerberos.keytab", String, Both),
+	p("sasl.kerberos.min.time.before.relogin", Int, Both),
+	p("sasl.username", String, Both),
+	p("sasl.password", String, Both),
+	p("sasl.oauthbearer.config", String, Both),
+	p("enable.sasl.oauthbearer.unsecure.jwt", Bool, Both),
+	p("sasl.oauthbearer.method", Enum, Both, "default", "oidc"),
+	p("sasl.oauthbearer.client.id", String, Both),
+	p("sasl.oauthbearer.client.secret", String, Both),
+	p("sasl.oauthbearer.scope", String, Both),
+	p("sasl.oauthbearer.extensions", String, Both),
+	p("sasl.oauthbearer.token.endpoint.url", String, Both),
+	p("plugin.library.paths", String, Both),
+	p("client.rack", String, Both),
+	p("test.mock.num.brokers", Int, Both),
+	p("test.mock.broker.rtt", Int, Both),
+	p("group.id", String, Consumer),
+	p("group.instance.id", String, Consumer),
+	p("group.protocol", Enum, Consumer, "classic", "consumer"),
+	p("group.remote.assignor", String, Consumer),
+	p("partition.assignment.strategy", String, Consumer),
+	p("session.timeout.ms", Int, Consumer),
+	p("heartbeat.interval.ms", Int, Consumer),
+	p("group.protocol.type", String, Consumer),
+	p("coordinator.query.interval.ms", Int, Consumer),
+	p("max.poll.interval.ms", Int, Consumer),
+	p("enable.auto.commit", Bool, Consumer),
+	p("auto.commit.interval.ms", Int, Consumer),
+	p("enable.auto.offset.store", Bool, Consumer),
+	p("queued.min.messages", Int, Consumer),
+	p("queued.max.messages.kbytes", Int, Consumer),
+	p("fetch.wait.max.ms", Int, Consumer),
+	p("fetch.message.max.bytes", Int, Consumer),
+	p("max.partition.fetch.bytes", Int, Consumer),
+	p("fetch.max.bytes", Int, Consumer),
+	p("fetch.min.bytes", Int, Consumer),
+	p("fetch.error.backoff.ms", Int, Consumer),
+	p("fetch.queue.backoff.ms", Int, Consumer),
+	p("offset.store.method", Enum, Consumer, "none", "file", "broker"),
+	p("isolation.level", Enum, Consumer, "read_uncommitted", "read_committed"),
+	p("enable.partition.eof", Bool, Consumer),
+	p("check.crcs", Bool, Consumer),
+	p("auto.commit.enable", Bool, Consumer),
+	p("auto.offset.reset", Enum, Consumer, "smallest", "earliest", "beginning", "largest", "latest", "end", "error"),
+	p("offset.store.path", String, Consumer),
+	p("offset.store.sync.interval.ms", Int, Consumer),
+	p("consume.callback.max.messages", Int, Consumer),
+	p("transactional.id", String, Producer),
+	p("transaction.timeout.ms", Int, Producer),
+	p("enable.idempotence", Bool, Producer),
+	p("enable.gapless.guarantee", Bool, Producer),
+	p("queue.buffering.max.messages", Int, Producer),
+	p("queue.buffering.max.kbytes", Int, Producer),
+	p("queue.buffering.max.ms", Float, Producer),
+	p("linger.ms", Float, Producer),
+	p("message.send.max.retries", Int, Producer),
+	p("retries", Int, Producer),
+	p("queue.buffering.backpressure.threshold", Int, Producer),
+	p("compression.codec", Enum, Producer, "none", "gzip", "snappy", "lz4", "zstd", "inherit"),
+	p("compression.type", Enum, Producer, "none", "gzip", "snappy", "lz4", "zstd"),
+	p("batch.num.messages", Int, Producer),
+	p("batch.size", Int, Producer),
+	p("delivery.report.only.error", Bool, Producer),
+	p("sticky.partitioning.linger.ms", Int, Producer),
+	p("request.required.acks", Acks, Producer),
+	p("acks", Acks, Producer),
+	p("request.timeout.ms", Int, Producer),
+	p("message.timeout.ms", Int, Producer),
+	p("delivery.timeout.ms", Int, Producer),
+	p("queuing.strategy", Enum, Producer, "fifo", "lifo"),
+	p("produce.offset.report", Bool, Producer),
+	p("partitioner", Enum, Producer, "random", "consistent", "consistent_random", "murmur2", "murmur2_random", "fnv1a", "fnv1a_random"),
+	p("compression.level", Int, Producer),
+	p("go.batch.producer", Bool, Producer),
+	p("go.delivery.reports", Bool, Producer),
+	p("go.delivery.report.fields", String, Producer),
+	p("go.produce.channel.size", Int, Producer),
+	p("go.events.channel.size", Int, Both),
+	p("go.logs.channel.enable", Bool, Both),
+	p("go.logs.channel", Any, Both),
+	p("go.events.channel.enable", Bool, Consumer),
+	p("go.application.rebalance.enable", Bool, Consumer),
+	p("default.topic.config", Any, Both),
+}
+
+var index = map[string]Property{}
+
+func init() {
+	for _, prop := range Properties {
+		index[NormalizeKey(prop.Name)] = prop
+	}
+}
+
+//NormalizeKey 统一设置项的写法,转为小写并将`_`替换为`.`,用于匹配环境变量和配置文件中的写法
+func NormalizeKey(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", ".")
+}
+
+//Lookup 查找设置项,key会先经过NormalizeKey
+func Lookup(key string) (Property, bool) {
+	prop, ok := index[NormalizeKey(key)]
+	return prop, ok
+}
@@ -0,0 +1,53 @@
+package kafkaconf
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Mask 敏感设置项被替换成的值
+const Mask = "******"
+
+//sensitive 除了名字中带有password和secret的设置项外,其他需要隐藏的设置项
+var sensitive = map[string]bool{
+	"sasl.oauthbearer.config":     true,
+	"sasl.oauthbearer.extensions": true,
+	"ssl.key.pem":                 true,
+}
+
+//IsSensitive 判断设置项是否包含密码或密钥
+func IsSensitive(key string) bool {
+	k := strings.ToLower(key)
+	return strings.Contains(k, "password") || strings.Contains(k, "secret") || sensitive[k]
+}
+
+//Redact 复制一份设置,其中的敏感设置项会被替换为Mask
+func Redact(cm kafka.ConfigMap) kafka.ConfigMap {
+	result := make(kafka.ConfigMap, len(cm))
+	for k, v := range cm {
+		if IsSensitive(k) {
+			result[k] = Mask
+		} else {
+			result[k] = v
+		}
+	}
+	return result
+}
+
+//Format 将设置按key排序格式化为`k=v`的形式,敏感设置项会被替换为Mask
+func Format(cm kafka.ConfigMap) string {
+	redacted := Redact(cm)
+	keys := make([]string, 0, len(redacted))
+	for k := range redacted {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, fmt.Sprintf("%s=%v", k, redacted[k]))
+	}
+	return strings.Join(pairs, " ")
+}
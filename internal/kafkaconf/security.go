@@ -0,0 +1,72 @@
+package kafkaconf
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func protocolOf(cm kafka.ConfigMap) string {
+	v, ok := cm["security.protocol"]
+	if !ok {
+		return "plaintext"
+	}
+	return strings.ToLower(fmt.Sprint(v))
+}
+
+//EnableTLS 在现有security.protocol的基础上开启tls,已经开启sasl时设为sasl_ssl,否则设为ssl
+func EnableTLS(cm kafka.ConfigMap) {
+	switch protocolOf(cm) {
+	case "sasl_plaintext", "sasl_ssl":
+		cm["security.protocol"] = "sasl_ssl"
+	default:
+		cm["security.protocol"] = "ssl"
+	}
+}
+
+//EnableSASL 在现有security.protocol的基础上开启sasl,已经开启tls时设为sasl_ssl,否则设为sasl_plaintext
+func EnableSASL(cm kafka.ConfigMap, mechanism string) {
+	switch protocolOf(cm) {
+	case "ssl", "sasl_ssl":
+		cm["security.protocol"] = "sasl_ssl"
+	default:
+		cm["security.protocol"] = "sasl_plaintext"
+	}
+	cm["sasl.mechanisms"] = mechanism
+}
+
+//TLSConfig tls连接的设置,为空的字段不会被设置
+type TLSConfig struct {
+	//CALocation 用于校验broker证书的CA证书路径
+	CALocation string
+	//CertificateLocation 客户端证书路径,用于双向认证
+	CertificateLocation string
+	//KeyLocation 客户端私钥路径,用于双向认证
+	KeyLocation string
+	//KeyPassword 客户端私钥的密码
+	KeyPassword string
+	//SkipVerify 不校验broker的证书,仅用于测试
+	SkipVerify bool
+}
+
+//ApplyTLS 将tls设置写入cm
+func ApplyTLS(cm kafka.ConfigMap, conf TLSConfig) {
+	EnableTLS(cm)
+	if conf.CALocation != "" {
+		cm["ssl.ca.location"] = conf.CALocation
+	}
+	if conf.CertificateLocation != "" {
+		cm["ssl.certificate.location"] = conf.CertificateLocation
+	}
+	if conf.KeyLocation != "" {
+		cm["ssl.key.location"] = conf.KeyLocation
+	}
+	if conf.KeyPassword != "" {
+		cm["ssl.key.password"] = conf.KeyPassword
+	}
+	if conf.SkipVerify {
+		cm["enable.ssl.certificate.verification"] = false
+		cm["ssl.endpoint.identification.algorithm"] = "none"
+	}
+}
@@ -0,0 +1,159 @@
+package kafkaconf
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//ErrWrongRole 设置项不适用于这类客户端
+var ErrWrongRole = errors.New("config key not applicable")
+
+//ErrMissingKey 缺少必须的设置项
+var ErrMissingKey = errors.New("missing config key")
+
+//ErrConflict 设置项之间相互冲突
+var ErrConflict = errors.New("conflicting config")
+
+//Validate 校验设置,检查不适用于role的设置项,值的类型以及相互冲突的设置
+//不在Properties中的设置项不做检查,交给librdkafka在创建客户端时判断,可以用Unknown列出它们并打印警告
+//@params cm kafka.ConfigMap 要校验的设置
+//@params role Role 客户端类型,Producer或Consumer
+func Validate(cm kafka.ConfigMap, role Role) error {
+	keys := make([]string, 0, len(cm))
+	for k := range cm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	errs := MultiError{}
+	typed := map[string]any{}
+	for _, k := range keys {
+		prop, ok := index[NormalizeKey(k)]
+		if !ok || prop.Name != k {
+			continue
+		}
+		if prop.Role != Both && prop.Role != role {
+			errs = append(errs, fmt.Errorf("%w: %q is a %s property", ErrWrongRole, k, prop.Role))
+			continue
+		}
+		v, err := Convert(prop.Type, prop.Values, cm[k])
+		if err != nil {
+			errs = append(errs, fmt.Errorf("key %q: %w", k, err))
+			continue
+		}
+		typed[k] = v
+	}
+	errs = append(errs, conflicts(typed, role)...)
+	return errs.ErrOrNil()
+}
+
+//Unknown 列出不在Properties中的设置项,通常是拼写错误或者较新版本librdkafka的设置项
+//@params cm kafka.ConfigMap 要检查的设置
+func Unknown(cm kafka.ConfigMap) []string {
+	unknown := []string{}
+	for k := range cm {
+		if prop, ok := index[NormalizeKey(k)]; !ok || prop.Name != k {
+			unknown = append(unknown, k)
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+func has(cm map[string]any, key string) bool {
+	_, ok := cm[key]
+	return ok
+}
+
+func isTrue(cm map[string]any, key string) bool {
+	b, ok := cm[key].(bool)
+	return ok && b
+}
+
+func isFalse(cm map[string]any, key string) bool {
+	b, ok := cm[key].(bool)
+	return ok && !b
+}
+
+func intOf(cm map[string]any, key string) (int, bool) {
+	i, ok := cm[key].(int)
+	return i, ok
+}
+
+//aliases 互为别名的设置项,同时设置时值必须一致
+var aliases = [][2]string{
+	{"acks", "request.required.acks"},
+	{"linger.ms", "queue.buffering.max.ms"},
+	{"retries", "message.send.max.retries"},
+	{"compression.type", "compression.codec"},
+	{"max.in.flight", "max.in.flight.requests.per.connection"},
+	{"sasl.mechanism", "sasl.mechanisms"},
+	{"bootstrap.servers", "metadata.broker.list"},
+}
+
+//first 取别名中第一个被设置的值
+func first(cm map[string]any, keys ...string) (any, bool) {
+	for _, k := range keys {
+		if v, ok := cm[k]; ok {
+			return v, true
+		}
+	}
+	return nil, false
+}
+
+func conflicts(cm map[string]any, role Role) []error {
+	errs := []error{}
+	for _, pair := range aliases {
+		a, aok := cm[pair[0]]
+		b, bok := cm[pair[1]]
+		if aok && bok && fmt.Sprint(a) != fmt.Sprint(b) {
+			errs = append(errs, fmt.Errorf("%w: %q=%v and its alias %q=%v", ErrConflict, pair[0], a, pair[1], b))
+		}
+	}
+	if _, ok := first(cm, "bootstrap.servers", "metadata.broker.list"); !ok {
+		errs = append(errs, fmt.Errorf("%w: %q", ErrMissingKey, "bootstrap.servers"))
+	}
+	protocol := strings.ToLower(fmt.Sprint(cm["security.protocol"]))
+	sasl := protocol == "sasl_plaintext" || protocol == "sasl_ssl"
+	if mechanism, ok := first(cm, "sasl.mechanisms", "sasl.mechanism"); ok {
+		if !sasl {
+			errs = append(errs, fmt.Errorf("%w: sasl.mechanisms=%v requires security.protocol sasl_plaintext or sasl_ssl", ErrConflict, mechanism))
+		}
+		switch mechanism {
+		case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+			if !has(cm, "sasl.username") || !has(cm, "sasl.password") {
+				errs = append(errs, fmt.Errorf("%w: sasl.mechanisms=%v requires sasl.username and sasl.password", ErrMissingKey, mechanism))
+			}
+		}
+	}
+	switch role {
+	case Producer:
+		if isTrue(cm, "enable.idempotence") {
+			if acks, ok := first(cm, "acks", "request.required.acks"); ok && acks != "all" && acks != -1 {
+				errs = append(errs, fmt.Errorf("%w: enable.idempotence=true requires acks=all, got %v", ErrConflict, acks))
+			}
+			if n, ok := intOf(cm, "max.in.flight.requests.per.connection"); ok && n > 5 {
+				errs = append(errs, fmt.Errorf("%w: enable.idempotence=true requires max.in.flight.requests.per.connection<=5, got %d", ErrConflict, n))
+			}
+			if n, ok := intOf(cm, "max.in.flight"); ok && n > 5 {
+				errs = append(errs, fmt.Errorf("%w: enable.idempotence=true requires max.in.flight<=5, got %d", ErrConflict, n))
+			}
+			if retries, ok := first(cm, "retries", "message.send.max.retries"); ok && retries == 0 {
+				errs = append(errs, fmt.Errorf("%w: enable.idempotence=true requires retries>0", ErrConflict))
+			}
+		}
+		if has(cm, "transactional.id") && isFalse(cm, "enable.idempotence") {
+			errs = append(errs, fmt.Errorf("%w: transactional.id requires enable.idempotence=true", ErrConflict))
+		}
+	case Consumer:
+		session, sok := intOf(cm, "session.timeout.ms")
+		heartbeat, hok := intOf(cm, "heartbeat.interval.ms")
+		if sok && hok && heartbeat >= session {
+			errs = append(errs, fmt.Errorf("%w: heartbeat.interval.ms=%d must be lower than session.timeout.ms=%d", ErrConflict, heartbeat, session))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,115 @@
+package kafkaconf_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func TestValidateRole(t *testing.T) {
+	cases := []struct {
+		name string
+		cm   kafka.ConfigMap
+		role kafkaconf.Role
+		err  error
+	}{
+		{name: "producer key on consumer", cm: kafka.ConfigMap{"acks": "all"}, role: kafkaconf.Consumer, err: kafkaconf.ErrWrongRole},
+		{name: "consumer key on producer", cm: kafka.ConfigMap{"group.id": "g"}, role: kafkaconf.Producer, err: kafkaconf.ErrWrongRole},
+		{name: "producer key on producer", cm: kafka.ConfigMap{"acks": "all"}, role: kafkaconf.Producer},
+		{name: "consumer key on consumer", cm: kafka.ConfigMap{"group.id": "g"}, role: kafkaconf.Consumer},
+		{name: "shared key", cm: kafka.ConfigMap{"client.id": "c"}, role: kafkaconf.Producer},
+		{name: "wrong type", cm: kafka.ConfigMap{"session.timeout.ms": "long"}, role: kafkaconf.Consumer, err: kafkaconf.ErrInvalidValue},
+		//未知的设置项交给librdkafka检查
+		{name: "unknown key", cm: kafka.ConfigMap{"lingerms": 5}, role: kafkaconf.Producer},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.cm["bootstrap.servers"] = "localhost:9092"
+			err := kafkaconf.Validate(c.cm, c.role)
+			if c.err == nil && err != nil {
+				t.Fatalf("expect valid, got %v", err)
+			}
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expect %v, got %v", c.err, err)
+			}
+		})
+	}
+	if got := kafkaconf.Unknown(kafka.ConfigMap{"lingerms": 5, "linger.ms": 5, "LINGER_MS": 5}); !reflect.DeepEqual(got, []string{"LINGER_MS", "lingerms"}) {
+		t.Fatalf("unexpected unknown keys %v", got)
+	}
+}
+
+func TestValidateConflicts(t *testing.T) {
+	cases := []struct {
+		name string
+		cm   kafka.ConfigMap
+		role kafkaconf.Role
+		err  error
+	}{
+		{name: "missing bootstrap servers", cm: kafka.ConfigMap{}, role: kafkaconf.Producer, err: kafkaconf.ErrMissingKey},
+		{name: "broker list alias", cm: kafka.ConfigMap{"metadata.broker.list": "b:9092"}, role: kafkaconf.Producer},
+		{name: "aliases disagree", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "linger.ms": 5, "queue.buffering.max.ms": 10}, role: kafkaconf.Producer, err: kafkaconf.ErrConflict},
+		{name: "aliases agree", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "linger.ms": "5", "queue.buffering.max.ms": 5}, role: kafkaconf.Producer},
+		{name: "sasl without sasl protocol", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "sasl.mechanisms": "PLAIN", "sasl.username": "u", "sasl.password": "p"}, role: kafkaconf.Producer, err: kafkaconf.ErrConflict},
+		{name: "sasl plain without password", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "security.protocol": "sasl_ssl", "sasl.mechanism": "PLAIN", "sasl.username": "u"}, role: kafkaconf.Producer, err: kafkaconf.ErrMissingKey},
+		{name: "sasl plain", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "security.protocol": "SASL_SSL", "sasl.mechanisms": "PLAIN", "sasl.username": "u", "sasl.password": "p"}, role: kafkaconf.Producer},
+		{name: "idempotence with acks 1", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "enable.idempotence": true, "acks": 1}, role: kafkaconf.Producer, err: kafkaconf.ErrConflict},
+		{name: "idempotence with acks -1", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "enable.idempotence": "true", "request.required.acks": "-1"}, role: kafkaconf.Producer},
+		{name: "idempotence with too many in flight", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "enable.idempotence": true, "max.in.flight": 6}, role: kafkaconf.Producer, err: kafkaconf.ErrConflict},
+		{name: "idempotence without retries", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "enable.idempotence": true, "retries": 0}, role: kafkaconf.Producer, err: kafkaconf.ErrConflict},
+		{name: "transactions without idempotence", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "transactional.id": "tx", "enable.idempotence": false}, role: kafkaconf.Producer, err: kafkaconf.ErrConflict},
+		{name: "heartbeat not lower than session", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "session.timeout.ms": 3000, "heartbeat.interval.ms": "3000"}, role: kafkaconf.Consumer, err: kafkaconf.ErrConflict},
+		{name: "heartbeat lower than session", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "session.timeout.ms": 3000, "heartbeat.interval.ms": 1000}, role: kafkaconf.Consumer},
+		//不开启事件channel时再平衡事件由Poll返回
+		{name: "application rebalance with poll", cm: kafka.ConfigMap{"bootstrap.servers": "a:9092", "go.application.rebalance.enable": true, "go.events.channel.enable": false}, role: kafkaconf.Consumer},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := kafkaconf.Validate(c.cm, c.role)
+			if c.err == nil && err != nil {
+				t.Fatalf("expect valid, got %v", err)
+			}
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expect %v, got %v", c.err, err)
+			}
+		})
+	}
+}
+
+func TestRedact(t *testing.T) {
+	cm := kafka.ConfigMap{
+		"bootstrap.servers":              "a:9092",
+		"sasl.username":                  "u",
+		"sasl.password":                  "p",
+		"ssl.key.password":               "kp",
+		"ssl.key.pem":                    "pem",
+		"sasl.oauthbearer.client.secret": "s",
+		"sasl.oauthbearer.config":        "principal=u",
+	}
+	redacted := kafkaconf.Redact(cm)
+	for _, k := range []string{"sasl.password", "ssl.key.password", "ssl.key.pem", "sasl.oauthbearer.client.secret", "sasl.oauthbearer.config"} {
+		if redacted[k] != kafkaconf.Mask {
+			t.Fatalf("expect %s masked, got %v", k, redacted[k])
+		}
+	}
+	if redacted["sasl.username"] != "u" || redacted["bootstrap.servers"] != "a:9092" {
+		t.Fatalf("non sensitive keys should be kept, got %v", redacted)
+	}
+	if cm["sasl.password"] != "p" {
+		t.Fatal("Redact should not modify the original config")
+	}
+	if !kafkaconf.IsSensitive("SASL.PASSWORD") {
+		t.Fatal("IsSensitive should ignore case")
+	}
+	got := kafkaconf.Format(kafka.ConfigMap{"sasl.password": "p", "client.id": "c", "acks": "all"})
+	if want := "acks=all client.id=c sasl.password=" + kafkaconf.Mask; got != want {
+		t.Fatalf("expect %q, got %q", want, got)
+	}
+	if strings.Contains(kafkaconf.Format(cm), "kp") {
+		t.Fatal("Format should not print secrets")
+	}
+}
@@ -0,0 +1,30 @@
+//Package logging 创建带有固定字段的logger
+//loggerhelper只能通过修改全局设置再导出的方式创建带字段的logger,各模块共用这里的锁,避免并发创建时拿到其他模块的字段
+package logging
+
+import (
+	"sync"
+
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/sirupsen/logrus"
+)
+
+var lock sync.Mutex
+
+//New 创建带有固定字段的logger,创建后全局logger的附加字段会被清空
+//log.Set每次调用都会重新添加设置中的hook,这里在创建后还原hook,避免重复触发
+//@params fields log.Dict logger固定带有的字段
+func New(fields log.Dict) *log.Log {
+	lock.Lock()
+	defer lock.Unlock()
+	base := log.GetLogger()
+	hooks := logrus.LevelHooks{}
+	for level, hs := range base.Hooks {
+		hooks[level] = append([]logrus.Hook{}, hs...)
+	}
+	log.Set(log.WithExtFields(fields))
+	l := log.Export()
+	log.Set(log.WithExtFields(log.Dict{}))
+	base.ReplaceHooks(hooks)
+	return l
+}
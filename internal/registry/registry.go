@@ -0,0 +1,108 @@
+//Package registry 按名字保存代理的注册表,供producerproxy和consumerproxy共用
+package registry
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+//ErrAlreadyRegistered 注册表中已经有同名的代理
+var ErrAlreadyRegistered = errors.New("proxy name already registered")
+
+//ErrNotRegistered 注册表中没有这个名字的代理
+var ErrNotRegistered = errors.New("proxy not registered")
+
+//Proxy 注册的代理需要实现的方法
+type Proxy interface {
+	IsOk() bool
+}
+
+//entry 注册表中的一项,init不为nil时在第一次Get时才执行
+type entry[P Proxy] struct {
+	sync.Mutex
+	proxy P
+	init  func() error
+}
+
+//Registry 并发安全的代理注册表
+type Registry[P Proxy] struct {
+	lock    sync.RWMutex
+	entries map[string]*entry[P]
+	named   func(proxy P, name string)
+}
+
+//New 创建注册表
+//@params named func(proxy P, name string) 注册成功前调用,用于设置代理的名字
+func New[P Proxy](named func(proxy P, name string)) *Registry[P] {
+	return &Registry[P]{entries: map[string]*entry[P]{}, named: named}
+}
+
+//Register 将代理以name注册到注册表
+//@params name string 代理的名字
+//@params proxy P 要注册的代理
+//@params init func() error 延迟初始化的函数,为nil时代理已经初始化
+func (r *Registry[P]) Register(name string, proxy P, init func() error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.entries[name]; ok {
+		return ErrAlreadyRegistered
+	}
+	if r.named != nil {
+		r.named(proxy, name)
+	}
+	r.entries[name] = &entry[P]{proxy: proxy, init: init}
+	return nil
+}
+
+//Unregister 从注册表中移除代理
+//@params name string 代理的名字
+func (r *Registry[P]) Unregister(name string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	delete(r.entries, name)
+}
+
+//Get 获取注册的代理,延迟初始化的代理会在这里初始化,初始化失败时下次Get会重试
+//@params name string 代理的名字
+func (r *Registry[P]) Get(name string) (P, error) {
+	r.lock.RLock()
+	e, ok := r.entries[name]
+	r.lock.RUnlock()
+	if !ok {
+		var zero P
+		return zero, ErrNotRegistered
+	}
+	if e.init == nil {
+		return e.proxy, nil
+	}
+	e.Lock()
+	defer e.Unlock()
+	if !e.proxy.IsOk() {
+		err := e.init()
+		if err != nil {
+			var zero P
+			return zero, err
+		}
+	}
+	return e.proxy, nil
+}
+
+//Range 按名字顺序遍历注册表中的代理,fn返回false时停止遍历
+//@params fn func(name string, proxy P) bool 遍历使用的函数
+func (r *Registry[P]) Range(fn func(name string, proxy P) bool) {
+	r.lock.RLock()
+	names := make([]string, 0, len(r.entries))
+	proxies := make(map[string]P, len(r.entries))
+	for name, e := range r.entries {
+		names = append(names, name)
+		proxies[name] = e.proxy
+	}
+	r.lock.RUnlock()
+	sort.Strings(names)
+	for _, name := range names {
+		if !fn(name, proxies[name]) {
+			return
+		}
+	}
+}
@@ -0,0 +1,669 @@
+//Package spool 本地磁盘上的先进先出日志,按段文件顺序追加记录,用于在集群不可用时暂存消息
+package spool
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+)
+
+//Logger 模块的logger
+var Logger *log.Log
+
+func init() {
+	Logger = logging.New(log.Dict{"module": "kafka-spool"})
+}
+
+//ErrFull 暂存区已经达到大小上限
+var ErrFull = errors.New("spool is full")
+
+//ErrClosed 暂存区已经关闭
+var ErrClosed = errors.New("spool closed")
+
+//errCorrupted 记录的长度或crc32不对
+var errCorrupted = errors.New("spool record corrupted")
+
+//SyncPolicy 写入后何时调用fsync
+type SyncPolicy int
+
+const (
+	//SyncNever 不主动fsync,由操作系统决定何时落盘,进程崩溃不丢数据但机器掉电可能丢失
+	SyncNever SyncPolicy = iota
+	//SyncAlways 每次写入后fsync
+	SyncAlways
+	//SyncInterval 按固定间隔fsync
+	SyncInterval
+)
+
+//String 策略的名字
+func (p SyncPolicy) String() string {
+	switch p {
+	case SyncAlways:
+		return "always"
+	case SyncInterval:
+		return "interval"
+	default:
+		return "never"
+	}
+}
+
+const (
+	//DefaultSegmentBytes 默认的段文件大小上限
+	DefaultSegmentBytes = 64 << 20
+	//DefaultSyncInterval 策略为SyncInterval时默认的fsync间隔
+	DefaultSyncInterval = time.Second
+)
+
+const (
+	segmentExt = ".seg"
+	cursorFile = "cursor"
+	headerSize = 8
+	//maxRecordSize 单条记录的最大长度,超过时认为长度字段已经损坏
+	maxRecordSize = 1 << 30
+)
+
+//Options 暂存区的设置
+type Options struct {
+	//Dir 段文件所在的文件夹,不存在时会创建
+	Dir string
+	//SegmentBytes 单个段文件的大小上限,超过后写入新的段文件
+	SegmentBytes int64
+	//MaxBytes 未读取记录的总大小上限,小于等于0表示不限制
+	MaxBytes int64
+	//Retention 记录最多保留多久,按段文件的最后写入时间过期,小于等于0表示不过期
+	Retention time.Duration
+	//Sync fsync策略
+	Sync SyncPolicy
+	//SyncInterval 策略为SyncInterval时的间隔
+	SyncInterval time.Duration
+}
+
+//segment 一个段文件
+type segment struct {
+	seq     uint64
+	size    int64
+	count   int
+	modTime time.Time
+}
+
+//position 读取位置
+type position struct {
+	seq    uint64
+	offset int64
+}
+
+//Spool 磁盘上的先进先出日志
+//记录格式为4字节长度,4字节crc32和记录内容,读取位置保存在cursor文件中
+type Spool struct {
+	lock     sync.Mutex
+	opts     Options
+	segments []*segment
+	writer   *os.File
+	cursor   position
+	peeked   []position
+	count    int
+	size     int64
+	dirty    bool
+	closed   bool
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+//Open 打开或创建暂存区,会校验已有的段文件并截掉末尾写了一半的记录
+//@params opts Options 暂存区的设置
+func Open(opts Options) (*Spool, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("spool dir is empty")
+	}
+	if opts.SegmentBytes <= 0 {
+		opts.SegmentBytes = DefaultSegmentBytes
+	}
+	if opts.SyncInterval <= 0 {
+		opts.SyncInterval = DefaultSyncInterval
+	}
+	err := os.MkdirAll(opts.Dir, 0o755)
+	if err != nil {
+		return nil, err
+	}
+	s := &Spool{opts: opts}
+	err = s.load()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Sync == SyncInterval {
+		s.stop = make(chan struct{})
+		s.done = make(chan struct{})
+		go s.syncLoop()
+	}
+	return s, nil
+}
+
+func (s *Spool) segmentPath(seq uint64) string {
+	return filepath.Join(s.opts.Dir, fmt.Sprintf("%020d%s", seq, segmentExt))
+}
+
+//load 读取已有的段文件和读取位置
+func (s *Spool) load() error {
+	entries, err := os.ReadDir(s.opts.Dir)
+	if err != nil {
+		return err
+	}
+	seqs := []uint64{}
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, segmentExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, segmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		seqs = append(seqs, seq)
+	}
+	sort.Slice(seqs, func(i, j int) bool { return seqs[i] < seqs[j] })
+	s.cursor = s.readCursor()
+	for i, seq := range seqs {
+		if seq < s.cursor.seq {
+			os.Remove(s.segmentPath(seq))
+			continue
+		}
+		from := int64(0)
+		if seq == s.cursor.seq {
+			from = s.cursor.offset
+		}
+		seg, err := s.scan(seq, from, i == len(seqs)-1)
+		if err != nil {
+			return err
+		}
+		s.segments = append(s.segments, seg)
+		s.count += seg.count
+		s.size += seg.size - from
+	}
+	if len(s.segments) == 0 {
+		next := s.cursor.seq
+		if next == 0 {
+			next = 1
+		}
+		s.cursor = position{seq: next}
+		return s.rotate()
+	}
+	if s.cursor.seq < s.segments[0].seq || s.cursor.offset > s.segments[0].size {
+		s.cursor = position{seq: s.segments[0].seq}
+	}
+	last := s.segments[len(s.segments)-1]
+	s.writer, err = os.OpenFile(s.segmentPath(last.seq), os.O_WRONLY|os.O_APPEND, 0o644)
+	return err
+}
+
+//scan 校验段文件中的记录,统计from之后的记录数
+//最后一个段文件末尾损坏的部分会被截掉;其他段文件中损坏的记录及其后的部分无法读取,会被跳过并记录丢失的字节数,后面段文件中的记录不受影响
+func (s *Spool) scan(seq uint64, from int64, last bool) (*segment, error) {
+	path := s.segmentPath(seq)
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	seg := &segment{seq: seq, modTime: info.ModTime()}
+	var offset int64
+	for {
+		n, err := readRecord(f, nil)
+		if err != nil {
+			break
+		}
+		if offset >= from {
+			seg.count += 1
+		}
+		offset += n
+	}
+	seg.size = offset
+	if offset < info.Size() {
+		if !last {
+			Logger.Warn("skip corrupted spool segment tail", log.Dict{"segment": seq, "offset": offset, "lost_bytes": info.Size() - offset})
+			return seg, nil
+		}
+		Logger.Warn("truncate spool segment tail", log.Dict{"segment": seq, "offset": offset, "lost_bytes": info.Size() - offset})
+		err = os.Truncate(path, offset)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return seg, nil
+}
+
+//readCursor 读取保存的读取位置,没有时从头开始
+func (s *Spool) readCursor() position {
+	data, err := os.ReadFile(filepath.Join(s.opts.Dir, cursorFile))
+	if err != nil {
+		return position{}
+	}
+	parts := strings.Fields(string(data))
+	if len(parts) != 2 {
+		return position{}
+	}
+	seq, err1 := strconv.ParseUint(parts[0], 10, 64)
+	offset, err2 := strconv.ParseInt(parts[1], 10, 64)
+	if err1 != nil || err2 != nil {
+		return position{}
+	}
+	return position{seq: seq, offset: offset}
+}
+
+//writeCursor 原子地保存读取位置
+func (s *Spool) writeCursor() error {
+	path := filepath.Join(s.opts.Dir, cursorFile)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "%d %d\n", s.cursor.seq, s.cursor.offset)
+	if err == nil && s.opts.Sync == SyncAlways {
+		err = f.Sync()
+	}
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+//rotate 关闭当前的段文件,新建下一个段文件用于写入
+func (s *Spool) rotate() error {
+	seq := s.cursor.seq
+	if len(s.segments) > 0 {
+		seq = s.segments[len(s.segments)-1].seq + 1
+	}
+	if s.writer != nil {
+		if s.dirty {
+			s.writer.Sync()
+			s.dirty = false
+		}
+		s.writer.Close()
+		s.writer = nil
+	}
+	f, err := os.OpenFile(s.segmentPath(seq), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	s.writer = f
+	s.segments = append(s.segments, &segment{seq: seq, modTime: time.Now()})
+	return nil
+}
+
+//Append 在末尾追加一条记录
+//@params data []byte 记录的内容
+func (s *Spool) Append(data []byte) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	n := int64(len(data) + headerSize)
+	if s.opts.MaxBytes > 0 && s.size+n > s.opts.MaxBytes {
+		return ErrFull
+	}
+	last := s.segments[len(s.segments)-1]
+	if last.size > 0 && last.size+n > s.opts.SegmentBytes {
+		err := s.rotate()
+		if err != nil {
+			return err
+		}
+		last = s.segments[len(s.segments)-1]
+	}
+	buf := make([]byte, n)
+	binary.BigEndian.PutUint32(buf[0:4], uint32(len(data)))
+	binary.BigEndian.PutUint32(buf[4:8], crc32.ChecksumIEEE(data))
+	copy(buf[headerSize:], data)
+	_, err := s.writer.Write(buf)
+	if err != nil {
+		return err
+	}
+	last.size += n
+	last.count += 1
+	last.modTime = time.Now()
+	s.count += 1
+	s.size += n
+	if s.opts.Sync == SyncAlways {
+		return s.writer.Sync()
+	}
+	s.dirty = true
+	return nil
+}
+
+//Peek 从读取位置开始读取最多n条记录,不移动读取位置,读取后用Commit确认
+//@params n int 最多读取的记录数
+func (s *Spool) Peek(n int) ([][]byte, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return nil, ErrClosed
+	}
+	s.peeked = s.peeked[:0]
+	records := [][]byte{}
+	pos := s.cursor
+	for _, seg := range s.segments {
+		if len(records) >= n {
+			break
+		}
+		if seg.seq < pos.seq {
+			continue
+		}
+		if seg.seq > pos.seq {
+			pos = position{seq: seg.seq}
+		}
+		if pos.offset >= seg.size {
+			continue
+		}
+		f, err := os.Open(s.segmentPath(seg.seq))
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Seek(pos.offset, io.SeekStart)
+		for err == nil && len(records) < n && pos.offset < seg.size {
+			var data []byte
+			var size int64
+			size, err = readRecord(f, &data)
+			if err != nil {
+				break
+			}
+			pos.offset += size
+			records = append(records, data)
+			s.peeked = append(s.peeked, pos)
+		}
+		f.Close()
+		if errors.Is(err, errCorrupted) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			err = s.skipCorrupted(seg, pos.offset)
+		}
+		if err != nil {
+			return records, fmt.Errorf("read spool segment %d: %w", seg.seq, err)
+		}
+	}
+	return records, nil
+}
+
+//skipCorrupted 段文件在offset处的记录损坏,丢弃该段文件在offset之后的部分,读取从下一个段文件继续,调用时需要持有锁
+//损坏的是正在写入的段文件时先切换到新的段文件,避免新记录追加在损坏的部分之后
+func (s *Spool) skipCorrupted(seg *segment, offset int64) error {
+	Logger.Warn("skip corrupted spool records", log.Dict{"segment": seg.seq, "offset": offset, "lost_bytes": seg.size - offset})
+	if seg == s.segments[len(s.segments)-1] {
+		err := s.rotate()
+		if err != nil {
+			return err
+		}
+	}
+	seg.size = offset
+	return s.recount()
+}
+
+//recount 重新统计未读取的记录数和字节数,调用时需要持有锁
+func (s *Spool) recount() error {
+	count := 0
+	size := int64(0)
+	for _, seg := range s.segments {
+		from := int64(0)
+		if seg.seq == s.cursor.seq {
+			from = s.cursor.offset
+		}
+		if seg.seq < s.cursor.seq || from >= seg.size {
+			continue
+		}
+		f, err := os.Open(s.segmentPath(seg.seq))
+		if err != nil {
+			return err
+		}
+		_, err = f.Seek(from, io.SeekStart)
+		for offset := from; err == nil && offset < seg.size; {
+			var n int64
+			n, err = readRecord(f, nil)
+			if err == nil {
+				offset += n
+				count++
+			}
+		}
+		f.Close()
+		size += seg.size - from
+	}
+	dropped := s.count - count
+	s.count = count
+	s.size = size
+	if dropped > 0 {
+		Logger.Warn("spool records lost", log.Dict{"dropped": dropped})
+	}
+	return nil
+}
+
+//Commit 确认上一次Peek读取的前n条记录已经处理,移动读取位置并删除读完的段文件
+//@params n int 已经处理的记录数
+func (s *Spool) Commit(n int) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return ErrClosed
+	}
+	if n <= 0 || len(s.peeked) == 0 {
+		return nil
+	}
+	if n > len(s.peeked) {
+		n = len(s.peeked)
+	}
+	to := s.peeked[n-1]
+	for _, seg := range s.segments {
+		if seg.seq > to.seq {
+			break
+		}
+		from := int64(0)
+		if seg.seq == s.cursor.seq {
+			from = s.cursor.offset
+		}
+		end := seg.size
+		if seg.seq == to.seq {
+			end = to.offset
+		}
+		s.size -= end - from
+	}
+	s.count -= n
+	s.cursor = to
+	s.peeked = s.peeked[:0]
+	return s.compact()
+}
+
+//compact 删除已经读完的段文件,保存读取位置,调用时需要持有锁
+func (s *Spool) compact() error {
+	for len(s.segments) > 1 {
+		first := s.segments[0]
+		if first.seq > s.cursor.seq || (first.seq == s.cursor.seq && s.cursor.offset < first.size) {
+			break
+		}
+		err := os.Remove(s.segmentPath(first.seq))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		s.segments = s.segments[1:]
+		if s.cursor.seq <= first.seq {
+			s.cursor = position{seq: s.segments[0].seq}
+		}
+	}
+	return s.writeCursor()
+}
+
+//Expire 删除最后写入时间超过保留时间的段文件
+//@returns int 被删除的未读记录数
+func (s *Spool) Expire() (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.closed {
+		return 0, ErrClosed
+	}
+	if s.opts.Retention <= 0 {
+		return 0, nil
+	}
+	deadline := time.Now().Add(-s.opts.Retention)
+	last := s.segments[len(s.segments)-1]
+	if last.size > 0 && last.modTime.Before(deadline) {
+		err := s.rotate()
+		if err != nil {
+			return 0, err
+		}
+	}
+	dropped := 0
+	for len(s.segments) > 1 && s.segments[0].modTime.Before(deadline) {
+		first := s.segments[0]
+		from := int64(0)
+		if first.seq == s.cursor.seq {
+			from = s.cursor.offset
+		}
+		remain, err := s.countFrom(first, from)
+		if err != nil {
+			return dropped, err
+		}
+		dropped += remain
+		s.count -= remain
+		s.size -= first.size - from
+		s.cursor = position{seq: first.seq, offset: first.size}
+		s.peeked = s.peeked[:0]
+		err = s.compact()
+		if err != nil {
+			return dropped, err
+		}
+	}
+	return dropped, nil
+}
+
+//countFrom 统计段文件中from之后的记录数
+func (s *Spool) countFrom(seg *segment, from int64) (int, error) {
+	if from == 0 {
+		return seg.count, nil
+	}
+	if from >= seg.size {
+		return 0, nil
+	}
+	f, err := os.Open(s.segmentPath(seg.seq))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	_, err = f.Seek(from, io.SeekStart)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for offset := from; offset < seg.size; count++ {
+		n, err := readRecord(f, nil)
+		if err != nil {
+			break
+		}
+		offset += n
+	}
+	return count, nil
+}
+
+//Len 未读取的记录数
+func (s *Spool) Len() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.count
+}
+
+//Size 未读取记录占用的字节数
+func (s *Spool) Size() int64 {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.size
+}
+
+//Sync 将写入的记录落盘
+func (s *Spool) Sync() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.sync()
+}
+
+func (s *Spool) sync() error {
+	if s.closed || !s.dirty {
+		return nil
+	}
+	s.dirty = false
+	return s.writer.Sync()
+}
+
+func (s *Spool) syncLoop() {
+	defer close(s.done)
+	ticker := time.NewTicker(s.opts.SyncInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.Sync()
+		}
+	}
+}
+
+//Close 落盘并关闭暂存区
+func (s *Spool) Close() error {
+	s.lock.Lock()
+	if s.closed {
+		s.lock.Unlock()
+		return nil
+	}
+	err := s.sync()
+	cerr := s.writer.Close()
+	if err == nil {
+		err = cerr
+	}
+	s.closed = true
+	s.lock.Unlock()
+	if s.stop != nil {
+		close(s.stop)
+		<-s.done
+	}
+	return err
+}
+
+//readRecord 读取一条记录,校验长度和crc32,data为nil时只跳过记录
+//@returns int64 记录在文件中占用的字节数
+func readRecord(r io.Reader, data *[]byte) (int64, error) {
+	var header [headerSize]byte
+	_, err := io.ReadFull(r, header[:])
+	if err != nil {
+		return 0, err
+	}
+	size := binary.BigEndian.Uint32(header[0:4])
+	sum := binary.BigEndian.Uint32(header[4:8])
+	if size > maxRecordSize {
+		return 0, errCorrupted
+	}
+	buf := make([]byte, size)
+	_, err = io.ReadFull(r, buf)
+	if err != nil {
+		return 0, err
+	}
+	if crc32.ChecksumIEEE(buf) != sum {
+		return 0, errCorrupted
+	}
+	if data != nil {
+		*data = buf
+	}
+	return int64(size) + headerSize, nil
+}
@@ -0,0 +1,261 @@
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+	"time"
+)
+
+func openSpool(t *testing.T, opts Options) *Spool {
+	t.Helper()
+	if opts.Dir == "" {
+		opts.Dir = t.TempDir()
+	}
+	s, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func appendAll(t *testing.T, s *Spool, records ...string) {
+	t.Helper()
+	for _, r := range records {
+		if err := s.Append([]byte(r)); err != nil {
+			t.Fatalf("Append(%s): %v", r, err)
+		}
+	}
+}
+
+func peek(t *testing.T, s *Spool, n int) []string {
+	t.Helper()
+	records, err := s.Peek(n)
+	if err != nil {
+		t.Fatalf("Peek: %v", err)
+	}
+	result := []string{}
+	for _, r := range records {
+		result = append(result, string(r))
+	}
+	return result
+}
+
+func expectRecords(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("expect %v, got %v", want, got)
+	}
+}
+
+func segments(t *testing.T, dir string) []string {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*"+segmentExt))
+	if err != nil {
+		t.Fatalf("Glob: %v", err)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func TestAppendPeekCommit(t *testing.T) {
+	for _, policy := range []SyncPolicy{SyncNever, SyncAlways, SyncInterval} {
+		t.Run(policy.String(), func(t *testing.T) {
+			s := openSpool(t, Options{Sync: policy, SyncInterval: 10 * time.Millisecond})
+			appendAll(t, s, "a", "b", "c", "d", "e")
+			if s.Len() != 5 || s.Size() != int64(5*(1+headerSize)) {
+				t.Fatalf("expect 5 records of %d bytes, got %d records of %d bytes", 5*(1+headerSize), s.Len(), s.Size())
+			}
+			expectRecords(t, peek(t, s, 3), "a", "b", "c")
+			//Peek不移动读取位置
+			expectRecords(t, peek(t, s, 3), "a", "b", "c")
+			if err := s.Commit(2); err != nil {
+				t.Fatalf("Commit: %v", err)
+			}
+			if s.Len() != 3 || s.Size() != int64(3*(1+headerSize)) {
+				t.Fatalf("expect 3 records left, got %d records of %d bytes", s.Len(), s.Size())
+			}
+			expectRecords(t, peek(t, s, 10), "c", "d", "e")
+			if err := s.Commit(3); err != nil {
+				t.Fatalf("Commit: %v", err)
+			}
+			if s.Len() != 0 || s.Size() != 0 {
+				t.Fatalf("expect empty spool, got %d records of %d bytes", s.Len(), s.Size())
+			}
+			expectRecords(t, peek(t, s, 10))
+		})
+	}
+}
+
+func TestSegmentRotation(t *testing.T) {
+	dir := t.TempDir()
+	//每个段文件放两条记录
+	s := openSpool(t, Options{Dir: dir, SegmentBytes: 2 * (2 + headerSize)})
+	appendAll(t, s, "r1", "r2", "r3", "r4", "r5")
+	if n := len(segments(t, dir)); n != 3 {
+		t.Fatalf("expect 3 segments, got %d", n)
+	}
+	expectRecords(t, peek(t, s, 3), "r1", "r2", "r3")
+	if err := s.Commit(3); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	//读完的段文件被删除
+	if n := len(segments(t, dir)); n != 2 {
+		t.Fatalf("expect 2 segments after commit, got %d", n)
+	}
+	expectRecords(t, peek(t, s, 10), "r4", "r5")
+}
+
+func TestReopen(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Options{Dir: dir, SegmentBytes: 2 * (2 + headerSize)})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	appendAll(t, s, "r1", "r2", "r3", "r4", "r5")
+	expectRecords(t, peek(t, s, 3), "r1", "r2", "r3")
+	if err := s.Commit(3); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := s.Append([]byte("closed")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("expect ErrClosed, got %v", err)
+	}
+	s = openSpool(t, Options{Dir: dir, SegmentBytes: 2 * (2 + headerSize)})
+	if s.Len() != 2 {
+		t.Fatalf("expect 2 records after reopen, got %d", s.Len())
+	}
+	appendAll(t, s, "r6")
+	expectRecords(t, peek(t, s, 10), "r4", "r5", "r6")
+}
+
+func TestTornTail(t *testing.T) {
+	dir := t.TempDir()
+	s, err := Open(Options{Dir: dir})
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	appendAll(t, s, "a", "b", "c")
+	s.Close()
+	names := segments(t, dir)
+	last := names[len(names)-1]
+	info, err := os.Stat(last)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+	//模拟写了一半的记录:长度字段说明有10字节,实际只写了3字节
+	f, err := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		t.Fatalf("OpenFile: %v", err)
+	}
+	f.Write([]byte{0, 0, 0, 10, 1, 2, 3, 4, 'x', 'y', 'z'})
+	f.Close()
+	s = openSpool(t, Options{Dir: dir})
+	if s.Len() != 3 {
+		t.Fatalf("expect 3 records, got %d", s.Len())
+	}
+	if info2, _ := os.Stat(last); info2.Size() != info.Size() {
+		t.Fatalf("torn tail should be truncated to %d bytes, got %d", info.Size(), info2.Size())
+	}
+	appendAll(t, s, "d")
+	expectRecords(t, peek(t, s, 10), "a", "b", "c", "d")
+}
+
+//corrupt 修改段文件中第index条单字节记录的内容,使crc校验失败
+func corrupt(t *testing.T, path string, index int) {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+	data[index*(1+headerSize)+headerSize] ^= 0xff
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+}
+
+func TestCorruptSegmentOnOpen(t *testing.T) {
+	dir := t.TempDir()
+	opts := Options{Dir: dir, SegmentBytes: 3 * (1 + headerSize)}
+	s, err := Open(opts)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	appendAll(t, s, "a", "b", "c", "d", "e", "f")
+	s.Close()
+	names := segments(t, dir)
+	if len(names) != 2 {
+		t.Fatalf("expect 2 segments, got %d", len(names))
+	}
+	//第一个段文件中b损坏,b和c无法读取,后面段文件中的记录不受影响
+	corrupt(t, names[0], 1)
+	s = openSpool(t, opts)
+	if s.Len() != 4 {
+		t.Fatalf("expect 4 readable records, got %d", s.Len())
+	}
+	expectRecords(t, peek(t, s, 10), "a", "d", "e", "f")
+}
+
+func TestCorruptRecordWhileOpen(t *testing.T) {
+	dir := t.TempDir()
+	s := openSpool(t, Options{Dir: dir})
+	appendAll(t, s, "a", "b", "c")
+	corrupt(t, segments(t, dir)[0], 1)
+	//读取到损坏的记录时跳过该段文件剩余的部分,不会一直卡住
+	expectRecords(t, peek(t, s, 10), "a")
+	if s.Len() != 1 {
+		t.Fatalf("expect 1 record left after skipping the corrupted tail, got %d", s.Len())
+	}
+	//新记录写入新的段文件,不会追加在损坏的部分之后
+	appendAll(t, s, "d")
+	expectRecords(t, peek(t, s, 10), "a", "d")
+	if err := s.Commit(2); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	if s.Len() != 0 {
+		t.Fatalf("expect empty spool, got %d", s.Len())
+	}
+}
+
+func TestMaxBytes(t *testing.T) {
+	s := openSpool(t, Options{MaxBytes: 3 * (1 + headerSize)})
+	appendAll(t, s, "a", "b", "c")
+	if err := s.Append([]byte("d")); !errors.Is(err, ErrFull) {
+		t.Fatalf("expect ErrFull, got %v", err)
+	}
+	peek(t, s, 1)
+	if err := s.Commit(1); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	appendAll(t, s, "d")
+	expectRecords(t, peek(t, s, 10), "b", "c", "d")
+}
+
+func TestRetention(t *testing.T) {
+	s := openSpool(t, Options{Retention: 50 * time.Millisecond})
+	appendAll(t, s, "a", "b", "c")
+	dropped, err := s.Expire()
+	if err != nil || dropped != 0 {
+		t.Fatalf("nothing should expire yet, got %d, %v", dropped, err)
+	}
+	peek(t, s, 1)
+	if err := s.Commit(1); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	dropped, err = s.Expire()
+	if err != nil {
+		t.Fatalf("Expire: %v", err)
+	}
+	if dropped != 2 || s.Len() != 0 || s.Size() != 0 {
+		t.Fatalf("expect 2 unread records expired, got %d, %d left of %d bytes", dropped, s.Len(), s.Size())
+	}
+	appendAll(t, s, "d")
+	expectRecords(t, peek(t, s, 10), "d")
+}
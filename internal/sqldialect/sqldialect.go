@@ -0,0 +1,36 @@
+//Package sqldialect 不同数据库sql占位符写法的差异,供outbox和dedupe共用
+package sqldialect
+
+import (
+	"strconv"
+	"strings"
+)
+
+//Placeholder 第i个参数的占位符,i从1开始
+type Placeholder func(i int) string
+
+//QuestionMark MySQL,SQLite使用的`?`占位符
+func QuestionMark(int) string { return "?" }
+
+//Dollar PostgreSQL使用的`$1`形式的占位符
+func Dollar(i int) string { return "$" + strconv.Itoa(i) }
+
+//Rebind 将语句中的`?`占位符替换为指定的写法
+//@params query string 使用`?`占位符的语句
+//@params placeholder Placeholder 占位符写法,为nil时不替换
+func Rebind(query string, placeholder Placeholder) string {
+	if placeholder == nil {
+		return query
+	}
+	var b strings.Builder
+	i := 0
+	for _, r := range query {
+		if r == '?' {
+			i += 1
+			b.WriteString(placeholder(i))
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
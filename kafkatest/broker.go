@@ -0,0 +1,361 @@
+//Package kafkatest 纯go实现的内存kafka,用于在单元测试中代替librdkafka和真实集群
+//支持topic,分区,offset,消费组的简单分配以及发送报告,生产者和消费者分别满足producerproxy.Producer和consumerproxy.Consumer接口
+package kafkatest
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Options 内存broker的设置
+type Options struct {
+	DefaultPartitions  int
+	NotAutoCreateTopic bool
+}
+
+var DefaultOptions = Options{
+	DefaultPartitions: 1,
+}
+
+//WithDefaultPartitions 设置自动创建的topic的分区数,默认1
+//@params n int 分区数
+func WithDefaultPartitions(n int) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.DefaultPartitions = n
+	})
+}
+
+//WithoutAutoCreateTopic 发送到不存在的topic时不自动创建,发送报告中返回ErrUnknownTopicOrPart
+func WithoutAutoCreateTopic() optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.NotAutoCreateTopic = true
+	})
+}
+
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+type topic struct {
+	partitions [][]*kafka.Message
+	next       int
+}
+
+type group struct {
+	members    []*Consumer
+	committed  map[partitionKey]int64
+	generation int
+}
+
+//Broker 内存中的kafka,所有状态由一把锁保护
+type Broker struct {
+	Opt     Options
+	lock    sync.Mutex
+	topics  map[string]*topic
+	groups  map[string]*group
+	changed chan struct{}
+}
+
+//NewBroker 创建内存broker
+func NewBroker(opts ...optparams.Option[Options]) *Broker {
+	b := &Broker{
+		Opt:     DefaultOptions,
+		topics:  map[string]*topic{},
+		groups:  map[string]*group{},
+		changed: make(chan struct{}),
+	}
+	optparams.GetOption(&b.Opt, opts...)
+	if b.Opt.DefaultPartitions <= 0 {
+		b.Opt.DefaultPartitions = 1
+	}
+	return b
+}
+
+//notify 唤醒等待新消息或新事件的消费者,需要持有锁
+func (b *Broker) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+//CreateTopic 创建topic,已经订阅该topic的消费组会重新分配分区
+//@params name string topic名
+//@params partitions int 分区数
+func (b *Broker) CreateTopic(name string, partitions int) error {
+	if partitions <= 0 {
+		return kafka.NewError(kafka.ErrInvalidArg, "partitions should be positive", false)
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if _, ok := b.topics[name]; ok {
+		return kafka.NewError(kafka.ErrTopicAlreadyExists, fmt.Sprintf("Topic '%s' already exists.", name), false)
+	}
+	b.createTopic(name, partitions)
+	return nil
+}
+
+//createTopic 创建topic并重新分配订阅了它的消费组,需要持有锁
+func (b *Broker) createTopic(name string, partitions int) *topic {
+	t := &topic{partitions: make([][]*kafka.Message, partitions)}
+	b.topics[name] = t
+	for _, g := range b.groups {
+		for _, m := range g.members {
+			if m.subscribed(name) {
+				b.rebalance(g)
+				break
+			}
+		}
+	}
+	b.notify()
+	return t
+}
+
+//Topics 所有topic的名字,按名字排序
+func (b *Broker) Topics() []string {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	names := make([]string, 0, len(b.topics))
+	for name := range b.topics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//Partitions topic的分区数,topic不存在时为0
+func (b *Broker) Partitions(topic string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t, ok := b.topics[topic]
+	if !ok {
+		return 0
+	}
+	return len(t.partitions)
+}
+
+//HighWatermark 分区中下一条消息的offset,分区不存在时为0
+func (b *Broker) HighWatermark(topic string, partition int32) int64 {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return int64(len(b.log(partitionKey{topic, partition})))
+}
+
+//Messages topic中的全部消息,按分区和offset排序,返回的是副本
+func (b *Broker) Messages(topic string) []*kafka.Message {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t, ok := b.topics[topic]
+	if !ok {
+		return nil
+	}
+	msgs := []*kafka.Message{}
+	for _, p := range t.partitions {
+		for _, m := range p {
+			msgs = append(msgs, copyMessage(m))
+		}
+	}
+	return msgs
+}
+
+//Committed 消费组在分区上已提交的offset,没有提交过时为kafka.OffsetInvalid
+func (b *Broker) Committed(groupID string, topic string, partition int32) kafka.Offset {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	g, ok := b.groups[groupID]
+	if !ok {
+		return kafka.OffsetInvalid
+	}
+	off, ok := g.committed[partitionKey{topic, partition}]
+	if !ok {
+		return kafka.OffsetInvalid
+	}
+	return kafka.Offset(off)
+}
+
+//Generation 消费组重新分配的次数
+func (b *Broker) Generation(groupID string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	g, ok := b.groups[groupID]
+	if !ok {
+		return 0
+	}
+	return g.generation
+}
+
+//Members 消费组的成员数
+func (b *Broker) Members(groupID string) int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	g, ok := b.groups[groupID]
+	if !ok {
+		return 0
+	}
+	return len(g.members)
+}
+
+//log 分区中的消息,分区不存在时为nil,需要持有锁
+func (b *Broker) log(k partitionKey) []*kafka.Message {
+	t, ok := b.topics[k.topic]
+	if !ok || k.partition < 0 || int(k.partition) >= len(t.partitions) {
+		return nil
+	}
+	return t.partitions[k.partition]
+}
+
+//append 写入一条消息,返回带有分区和offset的发送报告
+//指定了分区时写入该分区,有key时按key的crc32选择分区,否则轮流写入各个分区
+func (b *Broker) append(msg *kafka.Message) *kafka.Message {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	report := copyMessage(msg)
+	name := *msg.TopicPartition.Topic
+	t, ok := b.topics[name]
+	if !ok {
+		if b.Opt.NotAutoCreateTopic {
+			report.TopicPartition.Error = kafka.NewError(kafka.ErrUnknownTopicOrPart, "Broker: Unknown topic or partition", false)
+			return report
+		}
+		t = b.createTopic(name, b.Opt.DefaultPartitions)
+	}
+	partition := msg.TopicPartition.Partition
+	switch {
+	case partition == kafka.PartitionAny && msg.Key != nil:
+		partition = int32(crc32.ChecksumIEEE(msg.Key) % uint32(len(t.partitions)))
+	case partition == kafka.PartitionAny:
+		partition = int32(t.next % len(t.partitions))
+		t.next++
+	case partition < 0 || int(partition) >= len(t.partitions):
+		report.TopicPartition.Error = kafka.NewError(kafka.ErrUnknownPartition, "Local: Unknown partition", false)
+		return report
+	}
+	if report.Timestamp.IsZero() {
+		report.Timestamp = time.Now()
+	}
+	report.TimestampType = kafka.TimestampCreateTime
+	report.TopicPartition.Partition = partition
+	report.TopicPartition.Offset = kafka.Offset(len(t.partitions[partition]))
+	stored := copyMessage(report)
+	stored.Opaque = nil
+	t.partitions[partition] = append(t.partitions[partition], stored)
+	b.notify()
+	return report
+}
+
+//join 消费者加入消费组并重新分配,需要持有锁
+func (b *Broker) join(c *Consumer) {
+	g, ok := b.groups[c.groupID]
+	if !ok {
+		g = &group{committed: map[partitionKey]int64{}}
+		b.groups[c.groupID] = g
+	}
+	found := false
+	for _, m := range g.members {
+		if m == c {
+			found = true
+			break
+		}
+	}
+	if !found {
+		g.members = append(g.members, c)
+	}
+	b.rebalance(g)
+}
+
+//leave 消费者离开消费组并重新分配,不在消费组中时只清空分配,需要持有锁
+func (b *Broker) leave(c *Consumer) {
+	g, ok := b.groups[c.groupID]
+	if !ok {
+		return
+	}
+	found := false
+	for i, m := range g.members {
+		if m == c {
+			g.members = append(g.members[:i:i], g.members[i+1:]...)
+			found = true
+			break
+		}
+	}
+	c.rebalanced(nil)
+	if found {
+		b.rebalance(g)
+	}
+}
+
+//rebalance 按加入顺序将每个topic的分区轮流分给订阅了它的成员,需要持有锁
+func (b *Broker) rebalance(g *group) {
+	names := []string{}
+	seen := map[string]bool{}
+	for _, m := range g.members {
+		for _, name := range m.subscription {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+	assignments := map[*Consumer][]kafka.TopicPartition{}
+	for _, name := range names {
+		t, ok := b.topics[name]
+		if !ok {
+			continue
+		}
+		subs := []*Consumer{}
+		for _, m := range g.members {
+			if m.subscribed(name) {
+				subs = append(subs, m)
+			}
+		}
+		for p := range t.partitions {
+			m := subs[p%len(subs)]
+			topicName := name
+			assignments[m] = append(assignments[m], kafka.TopicPartition{Topic: &topicName, Partition: int32(p), Offset: kafka.OffsetInvalid})
+		}
+	}
+	for _, m := range g.members {
+		m.rebalanced(assignments[m])
+	}
+	g.generation++
+	b.notify()
+}
+
+//commit 提交消费组的offset,需要持有锁
+func (b *Broker) commit(groupID string, offsets map[partitionKey]int64) {
+	g, ok := b.groups[groupID]
+	if !ok {
+		g = &group{committed: map[partitionKey]int64{}}
+		b.groups[groupID] = g
+	}
+	for k, off := range offsets {
+		g.committed[k] = off
+	}
+}
+
+//copyMessage 复制消息,headers和key/value使用新的切片
+func copyMessage(msg *kafka.Message) *kafka.Message {
+	m := *msg
+	if msg.TopicPartition.Topic != nil {
+		topic := *msg.TopicPartition.Topic
+		m.TopicPartition.Topic = &topic
+	}
+	if msg.Key != nil {
+		m.Key = append([]byte{}, msg.Key...)
+	}
+	if msg.Value != nil {
+		m.Value = append([]byte{}, msg.Value...)
+	}
+	if msg.Headers != nil {
+		m.Headers = make([]kafka.Header, len(msg.Headers))
+		for i, h := range msg.Headers {
+			m.Headers[i] = kafka.Header{Key: h.Key, Value: append([]byte(nil), h.Value...)}
+		}
+	}
+	return &m
+}
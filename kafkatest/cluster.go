@@ -0,0 +1,213 @@
+package kafkatest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+)
+
+//kafka协议中常用的请求类型,用于PushRequestErrors
+const (
+	ApiKeyProduce         int16 = 0
+	ApiKeyFetch           int16 = 1
+	ApiKeyListOffsets     int16 = 2
+	ApiKeyMetadata        int16 = 3
+	ApiKeyOffsetCommit    int16 = 8
+	ApiKeyOffsetFetch     int16 = 9
+	ApiKeyFindCoordinator int16 = 10
+	ApiKeyJoinGroup       int16 = 11
+	ApiKeyHeartbeat       int16 = 12
+	ApiKeyLeaveGroup      int16 = 13
+	ApiKeySyncGroup       int16 = 14
+	ApiKeyInitProducerId  int16 = 22
+)
+
+//Cluster 基于librdkafka内置mock集群的集成测试环境,代理使用真实的librdkafka客户端连接它
+//可以模拟broker下线,网络延迟和请求返回错误
+type Cluster struct {
+	mock      *mockCluster
+	lock      sync.Mutex
+	producers []*producerproxy.ProducerProxy
+	consumers []*consumerproxy.ConsumerProxy
+}
+
+//NewCluster 启动mock集群
+//@params brokers int broker的数量,broker的id从1开始
+func NewCluster(brokers int) (*Cluster, error) {
+	mock, err := newMockCluster(brokers)
+	if err != nil {
+		return nil, err
+	}
+	return &Cluster{mock: mock}, nil
+}
+
+//BootstrapServers 集群的连接地址
+func (c *Cluster) BootstrapServers() string {
+	return c.mock.bootstrapServers()
+}
+
+//CreateTopic 创建topic,副本数为1
+//@params topic string topic名
+//@params partitions int 分区数
+func (c *Cluster) CreateTopic(topic string, partitions int) error {
+	return c.mock.createTopic(topic, partitions, 1)
+}
+
+//SetBrokerDown 断开broker的连接并拒绝新连接,不会触发leader切换
+//@params brokerID int broker的id,-1表示全部broker
+func (c *Cluster) SetBrokerDown(brokerID int) error {
+	return c.mock.setBrokerDown(brokerID)
+}
+
+//SetBrokerUp 恢复broker的连接
+//@params brokerID int broker的id,-1表示全部broker
+func (c *Cluster) SetBrokerUp(brokerID int) error {
+	return c.mock.setBrokerUp(brokerID)
+}
+
+//SetRoundtripDuration 设置broker的请求延迟,用于模拟慢网络
+//@params brokerID int broker的id,-1表示全部broker
+//@params d time.Duration 每个请求的延迟
+func (c *Cluster) SetRoundtripDuration(brokerID int, d time.Duration) error {
+	return c.mock.setRoundtripDuration(brokerID, d)
+}
+
+//PushRequestErrors 让集群对接下来的apiKey请求依次返回errs中的错误,每个错误只用一次
+//可以用来模拟可重试的错误,如ApiKeyProduce配合kafka.ErrNotLeaderForPartition或ApiKeyJoinGroup配合kafka.ErrNotCoordinator
+//@params apiKey int16 kafka协议的请求类型,如ApiKeyProduce
+//@params errs ...kafka.ErrorCode 依次返回的错误
+func (c *Cluster) PushRequestErrors(apiKey int16, errs ...kafka.ErrorCode) {
+	c.mock.pushRequestErrors(apiKey, errs)
+}
+
+//NewProducerProxy 创建已经Init的生产者代理,连接到mock集群,Close时会被关闭
+//@params opts ...optparams.Option[producerproxy.Options] 代理的其他设置
+func (c *Cluster) NewProducerProxy(opts ...optparams.Option[producerproxy.Options]) (*producerproxy.ProducerProxy, error) {
+	proxy := producerproxy.New()
+	err := proxy.Init(c.BootstrapServers(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	c.producers = append(c.producers, proxy)
+	c.lock.Unlock()
+	return proxy, nil
+}
+
+//NewConsumerProxy 创建已经Init的消费者代理,连接到mock集群,Close时会被关闭
+//默认开启`go.events.channel.enable`和`go.application.rebalance.enable`并从最早的offset开始消费,可以用opts覆盖
+//@params groupID string 消费组
+//@params opts ...optparams.Option[consumerproxy.Options] 代理的其他设置
+func (c *Cluster) NewConsumerProxy(groupID string, opts ...optparams.Option[consumerproxy.Options]) (*consumerproxy.ConsumerProxy, error) {
+	proxy := consumerproxy.New()
+	opts = append([]optparams.Option[consumerproxy.Options]{
+		consumerproxy.WithGroupID(groupID),
+		consumerproxy.WithAutoOffsetReset("earliest"),
+		consumerproxy.WithComsumerSetting("go.events.channel.enable", true),
+		consumerproxy.WithComsumerSetting("go.application.rebalance.enable", true),
+	}, opts...)
+	err := proxy.Init(c.BootstrapServers(), opts...)
+	if err != nil {
+		return nil, err
+	}
+	c.lock.Lock()
+	c.consumers = append(c.consumers, proxy)
+	c.lock.Unlock()
+	return proxy, nil
+}
+
+//ExpectMessages 从头读取topic的全部分区,直到读到n条消息或超时
+//使用独立的临时消费者,不影响其他消费组的offset
+//@params topic string topic名
+//@params n int 期望的消息数
+//@params timeout time.Duration 超时时间
+//@returns []*kafka.Message 读到的消息,超时时返回已读到的消息和错误
+func (c *Cluster) ExpectMessages(topic string, n int, timeout time.Duration) ([]*kafka.Message, error) {
+	consumer, err := kafka.NewConsumer(&kafka.ConfigMap{
+		"bootstrap.servers":  c.BootstrapServers(),
+		"group.id":           "kafkatest-expect-" + uuid.New().String(),
+		"enable.auto.commit": false,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer consumer.Close()
+	deadline := time.Now().Add(timeout)
+	meta, err := consumer.GetMetadata(&topic, false, int(timeout/time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	t, ok := meta.Topics[topic]
+	if !ok || t.Error.Code() != kafka.ErrNoError {
+		return nil, fmt.Errorf("topic %s not found: %v", topic, t.Error)
+	}
+	partitions := make([]kafka.TopicPartition, 0, len(t.Partitions))
+	for _, p := range t.Partitions {
+		partitions = append(partitions, kafka.TopicPartition{Topic: &topic, Partition: p.ID, Offset: kafka.OffsetBeginning})
+	}
+	err = consumer.Assign(partitions)
+	if err != nil {
+		return nil, err
+	}
+	msgs := []*kafka.Message{}
+	for len(msgs) < n {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return msgs, fmt.Errorf("expect %d messages in %s, got %d", n, topic, len(msgs))
+		}
+		msg, err := consumer.ReadMessage(left)
+		if err != nil {
+			if kerr, ok := err.(kafka.Error); ok && kerr.Code() == kafka.ErrTimedOut {
+				continue
+			}
+			return msgs, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+//ExpectDelivered 等待生产者代理收到n条发送成功的报告,代理需要监听发送报告
+//@params proxy *producerproxy.ProducerProxy 生产者代理
+//@params n int64 期望的发送成功数
+//@params timeout time.Duration 超时时间
+func ExpectDelivered(proxy *producerproxy.ProducerProxy, n int64, timeout time.Duration) error {
+	if !proxy.IsWatchingDeliver() {
+		return fmt.Errorf("producer proxy %s is not watching delivery reports", proxy.Name())
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		got := proxy.DeliveredRecords()
+		if got >= n {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("expect %d delivered messages, got %d", n, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//Close 关闭由集群创建的代理,然后关闭mock集群,已经关闭的代理会被跳过
+func (c *Cluster) Close() {
+	c.lock.Lock()
+	producers, consumers := c.producers, c.consumers
+	c.producers, c.consumers = nil, nil
+	c.lock.Unlock()
+	for _, proxy := range consumers {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		proxy.Shutdown(ctx)
+		cancel()
+	}
+	for _, proxy := range producers {
+		proxy.Close()
+	}
+	c.mock.close()
+}
@@ -0,0 +1,132 @@
+package kafkatest
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func newTestCluster(t *testing.T, brokers int, topic string, partitions int) *Cluster {
+	t.Helper()
+	c, err := NewCluster(brokers)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	t.Cleanup(c.Close)
+	if err := c.CreateTopic(topic, partitions); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	return c
+}
+
+func TestClusterProduceAndExpect(t *testing.T) {
+	topic := "orders"
+	c := newTestCluster(t, 1, topic, 3)
+	producer, err := c.NewProducerProxy()
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		err := producer.Send(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny}, Value: []byte(fmt.Sprint(i))})
+		if err != nil {
+			t.Fatalf("Send: %v", err)
+		}
+	}
+	if err := ExpectDelivered(producer, 10, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := c.ExpectMessages(topic, 10, 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 10 {
+		t.Fatalf("expect 10 messages, got %d", len(msgs))
+	}
+}
+
+func TestClusterConsumerProxy(t *testing.T) {
+	topic := "payments"
+	c := newTestCluster(t, 1, topic, 2)
+	producer, err := c.NewProducerProxy()
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	for i := 0; i < 5; i++ {
+		err := producer.SendAndWait(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny}, Value: []byte(fmt.Sprint(i))})
+		if err != nil {
+			t.Fatalf("SendAndWait: %v", err)
+		}
+	}
+	consumer, err := c.NewConsumerProxy("payments-group")
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	got := make(chan *kafka.Message, 5)
+	if err := consumer.OnMessage(func(msg *kafka.Message) { got <- msg }); err != nil {
+		t.Fatalf("OnMessage: %v", err)
+	}
+	if err := consumer.Subscribe(topic, nil); err != nil {
+		t.Fatalf("Subscribe: %v", err)
+	}
+	consumer.Watch()
+	timeout := time.After(20 * time.Second)
+	for i := 0; i < 5; i++ {
+		select {
+		case <-got:
+		case <-timeout:
+			t.Fatalf("expect 5 messages, got %d", i)
+		}
+	}
+}
+
+func TestClusterPushRequestErrors(t *testing.T) {
+	topic := "retries"
+	c := newTestCluster(t, 1, topic, 1)
+	producer, err := c.NewProducerProxy()
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	c.PushRequestErrors(ApiKeyProduce, kafka.ErrNotLeaderForPartition, kafka.ErrNotEnoughReplicas)
+	err = producer.SendAndWait(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0}, Value: []byte("x")})
+	if err != nil {
+		t.Fatalf("retriable errors should be retried, got %v", err)
+	}
+	c.PushRequestErrors(ApiKeyProduce, kafka.ErrMsgSizeTooLarge)
+	err = producer.SendAndWait(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0}, Value: []byte("y")})
+	kerr, ok := err.(kafka.Error)
+	if !ok || kerr.Code() != kafka.ErrMsgSizeTooLarge {
+		t.Fatalf("expect %v, got %v", kafka.ErrMsgSizeTooLarge, err)
+	}
+}
+
+func TestClusterBrokerDown(t *testing.T) {
+	topic := "outage"
+	c := newTestCluster(t, 1, topic, 1)
+	producer, err := c.NewProducerProxy()
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	if err := producer.SendAndWait(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0}, Value: []byte("before")}); err != nil {
+		t.Fatalf("SendAndWait: %v", err)
+	}
+	if err := c.SetBrokerDown(-1); err != nil {
+		t.Fatalf("SetBrokerDown: %v", err)
+	}
+	if err := producer.Send(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0}, Value: []byte("during")}); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	if err := ExpectDelivered(producer, 2, 500*time.Millisecond); err == nil {
+		t.Fatal("message should not be delivered while the broker is down")
+	}
+	if err := c.SetBrokerUp(-1); err != nil {
+		t.Fatalf("SetBrokerUp: %v", err)
+	}
+	if err := ExpectDelivered(producer, 2, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.ExpectMessages(topic, 2, 10*time.Second); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,698 @@
+package kafkatest
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+var _ consumerproxy.Consumer = (*Consumer)(nil)
+
+//Consumer 从内存broker读取消息的消费者,满足consumerproxy.Consumer接口
+//消息,分区分配事件和PartitionEOF都由后台goroutine投递到Events(),Poll也从Events()读取
+//设置`go.application.rebalance.enable=true`时分配变化会以AssignedPartitions/RevokedPartitions事件通知,需要应用调用Assign/Unassign,否则自动分配
+type Consumer struct {
+	broker       *Broker
+	groupID      string
+	offsetReset  string
+	autoStore    bool
+	autoCommit   bool
+	appRebalance bool
+	cooperative  bool
+	partitionEOF bool
+	subscription []string
+	member       []kafka.TopicPartition
+	assigned     []partitionKey
+	positions    map[partitionKey]int64
+	eofSent      map[partitionKey]bool
+	paused       map[partitionKey]bool
+	stored       map[partitionKey]int64
+	pending      []kafka.Event
+	cursor       int
+	events       chan kafka.Event
+	closed       bool
+	done         chan struct{}
+}
+
+//NewConsumer 创建消费者,`group.id`必须设置
+//支持的设置有`auto.offset.reset`(默认latest),`enable.auto.offset.store`,`enable.auto.commit`(自动提交时记录offset后立即提交),
+//`go.application.rebalance.enable`,`partition.assignment.strategy`(包含cooperative-sticky时使用增量分配),`enable.partition.eof`和`go.events.channel.size`(默认10000),其他设置会被忽略
+//@params conf *kafka.ConfigMap 消费者的设置
+func (b *Broker) NewConsumer(conf *kafka.ConfigMap) (*Consumer, error) {
+	if conf == nil {
+		conf = &kafka.ConfigMap{}
+	}
+	c := &Consumer{
+		broker:      b,
+		groupID:     confString(*conf, "group.id", ""),
+		offsetReset: confString(*conf, "auto.offset.reset", "latest"),
+		positions:   map[partitionKey]int64{},
+		eofSent:     map[partitionKey]bool{},
+		paused:      map[partitionKey]bool{},
+		stored:      map[partitionKey]int64{},
+		done:        make(chan struct{}),
+	}
+	if c.groupID == "" {
+		return nil, kafka.NewError(kafka.ErrInvalidArg, "Required property group.id not set", false)
+	}
+	var err error
+	if c.autoStore, err = confBool(*conf, "enable.auto.offset.store", true); err != nil {
+		return nil, err
+	}
+	if c.autoCommit, err = confBool(*conf, "enable.auto.commit", true); err != nil {
+		return nil, err
+	}
+	if c.appRebalance, err = confBool(*conf, "go.application.rebalance.enable", false); err != nil {
+		return nil, err
+	}
+	c.cooperative = strings.Contains(confString(*conf, "partition.assignment.strategy", ""), "cooperative-sticky")
+	if c.partitionEOF, err = confBool(*conf, "enable.partition.eof", false); err != nil {
+		return nil, err
+	}
+	size, err := confInt(*conf, "go.events.channel.size", 10000)
+	if err != nil {
+		return nil, err
+	}
+	c.events = make(chan kafka.Event, size)
+	go c.pump()
+	return c, nil
+}
+
+//ConsumerFactory 用于consumerproxy.WithClientFactory,让代理Init时连接到内存broker
+func (b *Broker) ConsumerFactory() consumerproxy.ClientFactory {
+	return func(conf *kafka.ConfigMap) (consumerproxy.Consumer, error) {
+		c, err := b.NewConsumer(conf)
+		if err != nil {
+			return nil, err
+		}
+		return c, nil
+	}
+}
+
+//pump 将待投递的事件和已分配分区中的新消息依次投递到Events()
+func (c *Consumer) pump() {
+	b := c.broker
+	for {
+		b.lock.Lock()
+		if c.closed {
+			b.lock.Unlock()
+			return
+		}
+		ev := c.next()
+		wait := b.changed
+		b.lock.Unlock()
+		if ev == nil {
+			select {
+			case <-wait:
+			case <-c.done:
+				return
+			}
+			continue
+		}
+		select {
+		case c.events <- ev:
+		case <-c.done:
+			return
+		}
+	}
+}
+
+//next 取出下一个要投递的事件,先投递分配事件,再轮流从已分配的分区中取消息,需要持有broker的锁
+func (c *Consumer) next() kafka.Event {
+	if len(c.pending) > 0 {
+		ev := c.pending[0]
+		c.pending = c.pending[1:]
+		return ev
+	}
+	n := len(c.assigned)
+	for i := 0; i < n; i++ {
+		k := c.assigned[(c.cursor+i)%n]
+		if c.paused[k] {
+			continue
+		}
+		pos := c.positions[k]
+		msgs := c.broker.log(k)
+		if pos < int64(len(msgs)) {
+			c.cursor = (c.cursor + i + 1) % n
+			c.positions[k] = pos + 1
+			c.eofSent[k] = false
+			if c.autoStore {
+				c.store(k, pos+1)
+			}
+			return copyMessage(msgs[pos])
+		}
+		if c.partitionEOF && !c.eofSent[k] {
+			c.eofSent[k] = true
+			topic := k.topic
+			return kafka.PartitionEOF{Topic: &topic, Partition: k.partition, Offset: kafka.Offset(pos)}
+		}
+	}
+	return nil
+}
+
+//store 记录offset,开启自动提交时立即提交,需要持有broker的锁
+func (c *Consumer) store(k partitionKey, off int64) {
+	c.stored[k] = off
+	if c.autoCommit {
+		c.broker.commit(c.groupID, map[partitionKey]int64{k: off})
+	}
+}
+
+//subscribed 是否订阅了topic
+func (c *Consumer) subscribed(topic string) bool {
+	for _, t := range c.subscription {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+//rebalanced 消费组重新分配后更新分配,需要持有broker的锁
+//增量分配时只通知变化的分区,保留的分区继续读取
+func (c *Consumer) rebalanced(partitions []kafka.TopicPartition) {
+	if samePartitions(c.member, partitions) {
+		return
+	}
+	old := c.member
+	c.member = partitions
+	if c.cooperative {
+		revoked, added := diffPartitions(old, partitions), diffPartitions(partitions, old)
+		if !c.appRebalance {
+			c.unassign(revoked)
+			c.add(added)
+			return
+		}
+		if len(revoked) > 0 {
+			c.pending = append(c.pending, kafka.RevokedPartitions{Partitions: revoked})
+		}
+		if len(added) > 0 {
+			c.pending = append(c.pending, kafka.AssignedPartitions{Partitions: added})
+		}
+		return
+	}
+	if !c.appRebalance {
+		c.assign(partitions)
+		return
+	}
+	c.assign(nil)
+	if len(old) > 0 {
+		c.pending = append(c.pending, kafka.RevokedPartitions{Partitions: old})
+	}
+	if len(partitions) > 0 {
+		c.pending = append(c.pending, kafka.AssignedPartitions{Partitions: partitions})
+	}
+}
+
+//assign 设置读取的分区和起始位置,需要持有broker的锁
+func (c *Consumer) assign(partitions []kafka.TopicPartition) {
+	c.assigned = nil
+	c.positions = map[partitionKey]int64{}
+	c.eofSent = map[partitionKey]bool{}
+	c.paused = map[partitionKey]bool{}
+	c.cursor = 0
+	for _, tp := range partitions {
+		if tp.Topic == nil {
+			continue
+		}
+		k := partitionKey{*tp.Topic, tp.Partition}
+		c.assigned = append(c.assigned, k)
+		c.positions[k] = c.startOffset(k, tp.Offset)
+	}
+	c.broker.notify()
+}
+
+//add 增加读取的分区,已经在读取的分区不变,需要持有broker的锁
+func (c *Consumer) add(partitions []kafka.TopicPartition) {
+	for _, tp := range partitions {
+		if tp.Topic == nil {
+			continue
+		}
+		k := partitionKey{*tp.Topic, tp.Partition}
+		if _, ok := c.positions[k]; ok {
+			continue
+		}
+		c.assigned = append(c.assigned, k)
+		c.positions[k] = c.startOffset(k, tp.Offset)
+	}
+	c.broker.notify()
+}
+
+//unassign 停止读取指定的分区,同时丢弃这些分区上已记录未提交的offset,需要持有broker的锁
+func (c *Consumer) unassign(partitions []kafka.TopicPartition) {
+	for _, tp := range partitions {
+		if tp.Topic == nil {
+			continue
+		}
+		k := partitionKey{*tp.Topic, tp.Partition}
+		if _, ok := c.positions[k]; !ok {
+			continue
+		}
+		delete(c.positions, k)
+		delete(c.eofSent, k)
+		delete(c.paused, k)
+		delete(c.stored, k)
+		for i, a := range c.assigned {
+			if a == k {
+				c.assigned = append(c.assigned[:i:i], c.assigned[i+1:]...)
+				break
+			}
+		}
+	}
+	c.cursor = 0
+}
+
+//startOffset 计算分区的起始位置,未指定offset时使用已提交的offset,没有时按auto.offset.reset处理,需要持有broker的锁
+func (c *Consumer) startOffset(k partitionKey, off kafka.Offset) int64 {
+	high := int64(len(c.broker.log(k)))
+	switch {
+	case off >= 0:
+		return int64(off)
+	case off == kafka.OffsetBeginning:
+		return 0
+	case off == kafka.OffsetEnd:
+		return high
+	}
+	if g, ok := c.broker.groups[c.groupID]; ok {
+		if committed, ok := g.committed[k]; ok {
+			return committed
+		}
+	}
+	switch c.offsetReset {
+	case "earliest", "smallest", "beginning":
+		return 0
+	default:
+		return high
+	}
+}
+
+//Subscribe 订阅单个topic
+func (c *Consumer) Subscribe(topic string, rebalanceCb kafka.RebalanceCb) error {
+	return c.SubscribeTopics([]string{topic}, rebalanceCb)
+}
+
+//SubscribeTopics 订阅topic并加入消费组,替换之前的订阅;不支持rebalanceCb,分配变化通过Events()通知
+func (c *Consumer) SubscribeTopics(topics []string, rebalanceCb kafka.RebalanceCb) error {
+	if rebalanceCb != nil {
+		return kafka.NewError(kafka.ErrNotImplemented, "kafkatest: rebalanceCb is not supported, use go.application.rebalance.enable", false)
+	}
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if c.closed {
+		return kafka.NewError(kafka.ErrState, "Consumer is closed", false)
+	}
+	c.subscription = append([]string{}, topics...)
+	b.join(c)
+	return nil
+}
+
+//Unsubscribe 取消订阅并离开消费组
+func (c *Consumer) Unsubscribe() error {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c.subscription = nil
+	b.leave(c)
+	return nil
+}
+
+//Assign 设置读取的分区,offset为kafka.OffsetStored或kafka.OffsetInvalid时从已提交的offset开始
+func (c *Consumer) Assign(partitions []kafka.TopicPartition) error {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c.assign(partitions)
+	return nil
+}
+
+//Unassign 清空读取的分区
+func (c *Consumer) Unassign() error {
+	return c.Assign(nil)
+}
+
+//IncrementalAssign 增加读取的分区,已经在读取的分区不变
+func (c *Consumer) IncrementalAssign(partitions []kafka.TopicPartition) error {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c.add(partitions)
+	return nil
+}
+
+//IncrementalUnassign 停止读取指定的分区
+func (c *Consumer) IncrementalUnassign(partitions []kafka.TopicPartition) error {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	c.unassign(partitions)
+	return nil
+}
+
+//Pause 暂停读取已分配的分区,重新分配后暂停状态会被清除,已经投递到Events()中的消息不会被撤回
+func (c *Consumer) Pause(partitions []kafka.TopicPartition) error {
+	return c.setPaused(partitions, true)
+}
+
+//Resume 恢复读取暂停的分区
+func (c *Consumer) Resume(partitions []kafka.TopicPartition) error {
+	return c.setPaused(partitions, false)
+}
+
+//setPaused 设置分区的暂停状态,未分配的分区会被忽略
+func (c *Consumer) setPaused(partitions []kafka.TopicPartition, paused bool) error {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, tp := range partitions {
+		if tp.Topic == nil {
+			continue
+		}
+		k := partitionKey{*tp.Topic, tp.Partition}
+		if _, ok := c.positions[k]; !ok {
+			continue
+		}
+		if paused {
+			c.paused[k] = true
+		} else {
+			delete(c.paused, k)
+		}
+	}
+	b.notify()
+	return nil
+}
+
+//GetRebalanceProtocol 消费组使用的分配协议,设置了cooperative-sticky时为COOPERATIVE,否则为EAGER,未加入消费组时为NONE
+func (c *Consumer) GetRebalanceProtocol() string {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	switch {
+	case len(c.subscription) == 0:
+		return "NONE"
+	case c.cooperative:
+		return "COOPERATIVE"
+	default:
+		return "EAGER"
+	}
+}
+
+//AssignmentLost 内存broker不会有会话超时,分配不会丢失,总是返回false
+func (c *Consumer) AssignmentLost() bool {
+	return false
+}
+
+//Assignment 当前读取的分区
+func (c *Consumer) Assignment() ([]kafka.TopicPartition, error) {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	partitions := make([]kafka.TopicPartition, 0, len(c.assigned))
+	for _, k := range c.assigned {
+		topic := k.topic
+		partitions = append(partitions, kafka.TopicPartition{Topic: &topic, Partition: k.partition, Offset: kafka.Offset(c.positions[k])})
+	}
+	return partitions, nil
+}
+
+//Seek 移动已分配分区的读取位置,已经投递到Events()中的消息不会被撤回
+//@params partition kafka.TopicPartition 分区和新的offset,可以使用kafka.OffsetBeginning和kafka.OffsetEnd
+//@params ignoredTimeoutMs int 与kafka.Consumer保持一致,不使用
+func (c *Consumer) Seek(partition kafka.TopicPartition, ignoredTimeoutMs int) error {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if partition.Topic == nil {
+		return kafka.NewError(kafka.ErrInvalidArg, "Local: Invalid argument or configuration", false)
+	}
+	k := partitionKey{*partition.Topic, partition.Partition}
+	if _, ok := c.positions[k]; !ok {
+		return kafka.NewError(kafka.ErrUnknownPartition, "Local: Unknown partition", false)
+	}
+	c.positions[k] = c.startOffset(k, partition.Offset)
+	c.eofSent[k] = false
+	b.notify()
+	return nil
+}
+
+//Position 查询分区的读取位置,即下一条要投递的消息的offset,未分配的分区为kafka.OffsetInvalid
+//@params partitions []kafka.TopicPartition 要查询的分区
+func (c *Consumer) Position(partitions []kafka.TopicPartition) (offsets []kafka.TopicPartition, err error) {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	offsets = make([]kafka.TopicPartition, 0, len(partitions))
+	for _, tp := range partitions {
+		tp.Offset = kafka.OffsetInvalid
+		if tp.Topic != nil {
+			if off, ok := c.positions[partitionKey{*tp.Topic, tp.Partition}]; ok {
+				tp.Offset = kafka.Offset(off)
+			}
+		}
+		offsets = append(offsets, tp)
+	}
+	return offsets, nil
+}
+
+//GetMetadata 查询topic和分区,内存broker只有一个id为1的节点
+//@params topic *string 要查询的topic,不为nil时只返回该topic
+//@params allTopics bool topic为nil时是否返回全部topic
+//@params timeoutMs int 与kafka.Consumer保持一致,不使用
+func (c *Consumer) GetMetadata(topic *string, allTopics bool, timeoutMs int) (*kafka.Metadata, error) {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	node := kafka.BrokerMetadata{ID: 1, Host: "kafkatest", Port: 9092}
+	md := &kafka.Metadata{Brokers: []kafka.BrokerMetadata{node}, Topics: map[string]kafka.TopicMetadata{}, OriginatingBroker: node}
+	names := []string{}
+	switch {
+	case topic != nil:
+		names = append(names, *topic)
+	case allTopics:
+		for name := range b.topics {
+			names = append(names, name)
+		}
+	}
+	for _, name := range names {
+		t, ok := b.topics[name]
+		if !ok {
+			md.Topics[name] = kafka.TopicMetadata{Topic: name, Error: kafka.NewError(kafka.ErrUnknownTopicOrPart, "Broker: Unknown topic or partition", false)}
+			continue
+		}
+		tm := kafka.TopicMetadata{Topic: name, Partitions: make([]kafka.PartitionMetadata, len(t.partitions))}
+		for i := range t.partitions {
+			tm.Partitions[i] = kafka.PartitionMetadata{ID: int32(i), Leader: 1, Replicas: []int32{1}, Isrs: []int32{1}}
+		}
+		md.Topics[name] = tm
+	}
+	return md, nil
+}
+
+//QueryWatermarkOffsets 查询分区的最早和下一条消息的offset,内存broker不会删除消息,最早的offset总是0
+//@params topic string topic名
+//@params partition int32 分区
+//@params timeoutMs int 与kafka.Consumer保持一致,不使用
+func (c *Consumer) QueryWatermarkOffsets(topic string, partition int32, timeoutMs int) (low, high int64, err error) {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	t, ok := b.topics[topic]
+	if !ok || partition < 0 || int(partition) >= len(t.partitions) {
+		return 0, 0, kafka.NewError(kafka.ErrUnknownPartition, "Local: Unknown partition", false)
+	}
+	return 0, int64(len(t.partitions[partition])), nil
+}
+
+//OffsetsForTimes 查询每个分区上时间戳不早于指定时间的第一条消息的offset,没有这样的消息时为kafka.OffsetEnd
+//@params times []kafka.TopicPartition 分区和毫秒时间戳,时间戳放在Offset中
+//@params timeoutMs int 与kafka.Consumer保持一致,不使用
+func (c *Consumer) OffsetsForTimes(times []kafka.TopicPartition, timeoutMs int) (offsets []kafka.TopicPartition, err error) {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	offsets = make([]kafka.TopicPartition, len(times))
+	for i, tp := range times {
+		offsets[i] = tp
+		if tp.Topic == nil {
+			offsets[i].Error = kafka.NewError(kafka.ErrUnknownPartition, "Local: Unknown partition", false)
+			continue
+		}
+		t, ok := b.topics[*tp.Topic]
+		if !ok || tp.Partition < 0 || int(tp.Partition) >= len(t.partitions) {
+			offsets[i].Error = kafka.NewError(kafka.ErrUnknownPartition, "Local: Unknown partition", false)
+			continue
+		}
+		msgs := t.partitions[tp.Partition]
+		at := int64(tp.Offset)
+		n := sort.Search(len(msgs), func(j int) bool { return msgs[j].Timestamp.UnixMilli() >= at })
+		if n < len(msgs) {
+			offsets[i].Offset = kafka.Offset(n)
+		} else {
+			offsets[i].Offset = kafka.OffsetEnd
+		}
+	}
+	return offsets, nil
+}
+
+//Events 消息和事件的channel,Close后不会被关闭
+func (c *Consumer) Events() chan kafka.Event {
+	return c.events
+}
+
+//Logs 内存实现没有librdkafka日志,总是返回nil
+func (c *Consumer) Logs() chan kafka.LogEvent {
+	return nil
+}
+
+//Poll 从Events()读取一个事件,超时返回nil
+//@params timeoutMs int 超时时间,单位ms
+func (c *Consumer) Poll(timeoutMs int) kafka.Event {
+	timer := time.NewTimer(time.Duration(timeoutMs) * time.Millisecond)
+	defer timer.Stop()
+	select {
+	case ev := <-c.events:
+		return ev
+	case <-timer.C:
+		return nil
+	}
+}
+
+//ReadMessage 读取一条消息,跳过其他事件,超时返回ErrTimedOut;timeout为负数时一直等待
+//@params timeout time.Duration 超时时间
+func (c *Consumer) ReadMessage(timeout time.Duration) (*kafka.Message, error) {
+	var deadline <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	for {
+		select {
+		case ev := <-c.events:
+			switch e := ev.(type) {
+			case *kafka.Message:
+				return e, nil
+			case kafka.Error:
+				return nil, e
+			}
+		case <-deadline:
+			return nil, kafka.NewError(kafka.ErrTimedOut, "Local: Timed out", false)
+		}
+	}
+}
+
+//StoreMessage 记录消息的下一个offset,开启自动提交时立即提交
+func (c *Consumer) StoreMessage(m *kafka.Message) ([]kafka.TopicPartition, error) {
+	if m.TopicPartition.Topic == nil {
+		return nil, kafka.NewError(kafka.ErrInvalidArg, "Local: Invalid argument or configuration", false)
+	}
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	off := int64(m.TopicPartition.Offset) + 1
+	c.store(partitionKey{*m.TopicPartition.Topic, m.TopicPartition.Partition}, off)
+	tp := m.TopicPartition
+	tp.Offset = kafka.Offset(off)
+	return []kafka.TopicPartition{tp}, nil
+}
+
+//Commit 提交已记录但还未提交的offset,没有时返回ErrNoOffset
+func (c *Consumer) Commit() ([]kafka.TopicPartition, error) {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	committed := map[partitionKey]int64{}
+	if g, ok := b.groups[c.groupID]; ok {
+		committed = g.committed
+	}
+	offsets := map[partitionKey]int64{}
+	for k, off := range c.stored {
+		if current, ok := committed[k]; !ok || current != off {
+			offsets[k] = off
+		}
+	}
+	if len(offsets) == 0 {
+		return nil, kafka.NewError(kafka.ErrNoOffset, "Local: No offset stored", false)
+	}
+	b.commit(c.groupID, offsets)
+	partitions := make([]kafka.TopicPartition, 0, len(offsets))
+	for k, off := range offsets {
+		topic := k.topic
+		partitions = append(partitions, kafka.TopicPartition{Topic: &topic, Partition: k.partition, Offset: kafka.Offset(off)})
+	}
+	sortPartitions(partitions)
+	return partitions, nil
+}
+
+//Close 离开消费组并停止投递,开启自动提交时已记录的offset已经提交
+//不支持静态成员,设置了`group.instance.id`的消费者关闭时也会立即离开消费组
+func (c *Consumer) Close() error {
+	b := c.broker
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.subscription = nil
+	b.leave(c)
+	c.closed = true
+	close(c.done)
+	return nil
+}
+
+//SetOAuthBearerToken 内存实现不需要认证,直接返回nil
+func (c *Consumer) SetOAuthBearerToken(oauthBearerToken kafka.OAuthBearerToken) error {
+	return nil
+}
+
+//SetOAuthBearerTokenFailure 内存实现不需要认证,直接返回nil
+func (c *Consumer) SetOAuthBearerTokenFailure(errstr string) error {
+	return nil
+}
+
+//diffPartitions 在a中但不在b中的分区
+func diffPartitions(a, b []kafka.TopicPartition) []kafka.TopicPartition {
+	in := map[partitionKey]bool{}
+	for _, tp := range b {
+		if tp.Topic != nil {
+			in[partitionKey{*tp.Topic, tp.Partition}] = true
+		}
+	}
+	diff := []kafka.TopicPartition{}
+	for _, tp := range a {
+		if tp.Topic != nil && !in[partitionKey{*tp.Topic, tp.Partition}] {
+			diff = append(diff, tp)
+		}
+	}
+	return diff
+}
+
+//samePartitions 两个分配是否包含相同的分区
+func samePartitions(a, b []kafka.TopicPartition) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := map[partitionKey]bool{}
+	for _, tp := range a {
+		set[partitionKey{*tp.Topic, tp.Partition}] = true
+	}
+	for _, tp := range b {
+		if !set[partitionKey{*tp.Topic, tp.Partition}] {
+			return false
+		}
+	}
+	return true
+}
+
+//sortPartitions 按topic和分区排序
+func sortPartitions(partitions []kafka.TopicPartition) {
+	sort.Slice(partitions, func(i, j int) bool {
+		if *partitions[i].Topic != *partitions[j].Topic {
+			return *partitions[i].Topic < *partitions[j].Topic
+		}
+		return partitions[i].Partition < partitions[j].Partition
+	})
+}
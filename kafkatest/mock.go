@@ -0,0 +1,119 @@
+package kafkatest
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef struct rd_kafka_s rd_kafka_t;
+typedef struct rd_kafka_conf_s rd_kafka_conf_t;
+typedef struct rd_kafka_mock_cluster_s rd_kafka_mock_cluster_t;
+
+rd_kafka_conf_t *rd_kafka_conf_new(void);
+void rd_kafka_conf_destroy(rd_kafka_conf_t *conf);
+rd_kafka_t *rd_kafka_new(int type, rd_kafka_conf_t *conf, char *errstr, size_t errstr_size);
+void rd_kafka_destroy(rd_kafka_t *rk);
+rd_kafka_mock_cluster_t *rd_kafka_mock_cluster_new(rd_kafka_t *rk, int broker_cnt);
+void rd_kafka_mock_cluster_destroy(rd_kafka_mock_cluster_t *mcluster);
+const char *rd_kafka_mock_cluster_bootstraps(const rd_kafka_mock_cluster_t *mcluster);
+int rd_kafka_mock_topic_create(rd_kafka_mock_cluster_t *mcluster, const char *topic, int partition_cnt, int replication_factor);
+int rd_kafka_mock_broker_set_down(rd_kafka_mock_cluster_t *mcluster, int32_t broker_id);
+int rd_kafka_mock_broker_set_up(rd_kafka_mock_cluster_t *mcluster, int32_t broker_id);
+int rd_kafka_mock_broker_set_rtt(rd_kafka_mock_cluster_t *mcluster, int32_t broker_id, int rtt_ms);
+void rd_kafka_mock_push_request_errors_array(rd_kafka_mock_cluster_t *mcluster, int16_t ApiKey, size_t cnt, const int *errors);
+*/
+import "C"
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//mockCluster 直接使用librdkafka的mock集群接口,confluent-kafka-go v1的kafka.MockCluster只提供启动和关闭,
+//故障注入的函数需要通过cgo调用,符号来自confluent-kafka-go静态链接的librdkafka
+type mockCluster struct {
+	rk       *C.rd_kafka_t
+	mcluster *C.rd_kafka_mock_cluster_t
+	brokers  int
+}
+
+//newMockCluster 启动有brokers个broker的mock集群
+func newMockCluster(brokers int) (*mockCluster, error) {
+	errstr := (*C.char)(C.malloc(512))
+	defer C.free(unsafe.Pointer(errstr))
+	conf := C.rd_kafka_conf_new()
+	rk := C.rd_kafka_new(0, conf, errstr, 512)
+	if rk == nil {
+		C.rd_kafka_conf_destroy(conf)
+		return nil, kafka.NewError(kafka.ErrInvalidArg, C.GoString(errstr), false)
+	}
+	mcluster := C.rd_kafka_mock_cluster_new(rk, C.int(brokers))
+	if mcluster == nil {
+		C.rd_kafka_destroy(rk)
+		return nil, kafka.NewError(kafka.ErrInvalidArg, "create mock cluster failed", false)
+	}
+	return &mockCluster{rk: rk, mcluster: mcluster, brokers: brokers}, nil
+}
+
+//result 将librdkafka的错误码转换为error
+func result(code C.int, op string) error {
+	if code == 0 {
+		return nil
+	}
+	return kafka.NewError(kafka.ErrorCode(code), fmt.Sprintf("mock cluster %s failed", op), false)
+}
+
+func (m *mockCluster) bootstrapServers() string {
+	return C.GoString(C.rd_kafka_mock_cluster_bootstraps(m.mcluster))
+}
+
+func (m *mockCluster) createTopic(topic string, partitions int, replicationFactor int) error {
+	ctopic := C.CString(topic)
+	defer C.free(unsafe.Pointer(ctopic))
+	return result(C.rd_kafka_mock_topic_create(m.mcluster, ctopic, C.int(partitions), C.int(replicationFactor)), "create topic")
+}
+
+//eachBroker 对指定的broker执行操作,brokerID为-1时对全部broker执行
+func (m *mockCluster) eachBroker(brokerID int, fn func(id C.int32_t) C.int, op string) error {
+	if brokerID != -1 {
+		return result(fn(C.int32_t(brokerID)), op)
+	}
+	for id := 1; id <= m.brokers; id++ {
+		if err := result(fn(C.int32_t(id)), op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *mockCluster) setBrokerDown(brokerID int) error {
+	return m.eachBroker(brokerID, func(id C.int32_t) C.int { return C.rd_kafka_mock_broker_set_down(m.mcluster, id) }, "set broker down")
+}
+
+func (m *mockCluster) setBrokerUp(brokerID int) error {
+	return m.eachBroker(brokerID, func(id C.int32_t) C.int { return C.rd_kafka_mock_broker_set_up(m.mcluster, id) }, "set broker up")
+}
+
+func (m *mockCluster) setRoundtripDuration(brokerID int, d time.Duration) error {
+	return m.eachBroker(brokerID, func(id C.int32_t) C.int {
+		return C.rd_kafka_mock_broker_set_rtt(m.mcluster, id, C.int(d/time.Millisecond))
+	}, "set broker rtt")
+}
+
+func (m *mockCluster) pushRequestErrors(apiKey int16, errs []kafka.ErrorCode) {
+	if len(errs) == 0 {
+		return
+	}
+	codes := make([]C.int, len(errs))
+	for i, e := range errs {
+		codes[i] = C.int(e)
+	}
+	C.rd_kafka_mock_push_request_errors_array(m.mcluster, C.int16_t(apiKey), C.size_t(len(codes)), &codes[0])
+}
+
+func (m *mockCluster) close() {
+	C.rd_kafka_mock_cluster_destroy(m.mcluster)
+	C.rd_kafka_destroy(m.rk)
+}
@@ -0,0 +1,236 @@
+package kafkatest
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+var _ producerproxy.Producer = (*Producer)(nil)
+
+type delivery struct {
+	report *kafka.Message
+	ch     chan kafka.Event
+}
+
+//Producer 写入内存broker的生产者,满足producerproxy.Producer接口
+//消息在Produce时就写入broker,发送报告由后台goroutine按顺序投递到Events()或指定的deliveryChan
+type Producer struct {
+	broker          *Broker
+	events          chan kafka.Event
+	deliveryReports bool
+	lock            sync.Mutex
+	queue           []delivery
+	sending         int
+	wake            chan struct{}
+	closed          bool
+	done            chan struct{}
+	pumpDone        chan struct{}
+}
+
+//NewProducer 创建生产者,支持的设置有`go.delivery.reports`和`go.events.channel.size`(默认10000),其他设置会被忽略
+//@params conf *kafka.ConfigMap 生产者的设置
+func (b *Broker) NewProducer(conf *kafka.ConfigMap) (*Producer, error) {
+	if conf == nil {
+		conf = &kafka.ConfigMap{}
+	}
+	reports, err := confBool(*conf, "go.delivery.reports", true)
+	if err != nil {
+		return nil, err
+	}
+	size, err := confInt(*conf, "go.events.channel.size", 10000)
+	if err != nil {
+		return nil, err
+	}
+	p := &Producer{
+		broker:          b,
+		events:          make(chan kafka.Event, size),
+		deliveryReports: reports,
+		wake:            make(chan struct{}, 1),
+		done:            make(chan struct{}),
+		pumpDone:        make(chan struct{}),
+	}
+	go p.pump()
+	return p, nil
+}
+
+//ProducerFactory 用于producerproxy.WithClientFactory,让代理Init时连接到内存broker
+func (b *Broker) ProducerFactory() producerproxy.ClientFactory {
+	return func(conf *kafka.ConfigMap) (producerproxy.Producer, error) {
+		p, err := b.NewProducer(conf)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+}
+
+//Produce 写入消息,发送报告中的消息带有分区和offset
+//@params msg *kafka.Message 要写入的消息,Topic不能为空
+//@params deliveryChan chan kafka.Event 发送报告投递的channel,为nil时投递到Events()
+func (p *Producer) Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error {
+	if msg == nil || msg.TopicPartition.Topic == nil {
+		return kafka.NewError(kafka.ErrInvalidArg, "Local: Invalid argument or configuration", false)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.closed {
+		return kafka.NewError(kafka.ErrState, "Producer is closed", false)
+	}
+	report := p.broker.append(msg)
+	if deliveryChan == nil && !p.deliveryReports {
+		return nil
+	}
+	p.queue = append(p.queue, delivery{report: report, ch: deliveryChan})
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+//pump 按顺序投递发送报告
+func (p *Producer) pump() {
+	defer close(p.pumpDone)
+	for {
+		p.lock.Lock()
+		if len(p.queue) == 0 {
+			p.lock.Unlock()
+			select {
+			case <-p.wake:
+				continue
+			case <-p.done:
+				return
+			}
+		}
+		d := p.queue[0]
+		p.queue = p.queue[1:]
+		p.sending++
+		p.lock.Unlock()
+		ch := d.ch
+		if ch == nil {
+			ch = p.events
+		}
+		select {
+		case ch <- d.report:
+		case <-p.done:
+			return
+		}
+		p.lock.Lock()
+		p.sending--
+		p.lock.Unlock()
+	}
+}
+
+//Events 发送报告的channel,Close后会被关闭
+func (p *Producer) Events() chan kafka.Event {
+	return p.events
+}
+
+//Logs 内存实现没有librdkafka日志,总是返回nil
+func (p *Producer) Logs() chan kafka.LogEvent {
+	return nil
+}
+
+//Len 还未被读取的发送报告数
+func (p *Producer) Len() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	return len(p.queue) + p.sending + len(p.events)
+}
+
+//Flush 等待发送报告全部被读取,直到超时
+//@params timeoutMs int 超时时间,单位ms
+//@returns int 超时时还未被读取的发送报告数
+func (p *Producer) Flush(timeoutMs int) int {
+	deadline := time.Now().Add(time.Duration(timeoutMs) * time.Millisecond)
+	for {
+		n := p.Len()
+		if n == 0 || !time.Now().Before(deadline) {
+			return n
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+//Close 关闭生产者,未投递的发送报告会被丢弃,Events()会被关闭
+func (p *Producer) Close() {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.done)
+	p.lock.Unlock()
+	<-p.pumpDone
+	close(p.events)
+}
+
+//GetFatalError 内存实现不会发生致命错误,总是返回nil
+func (p *Producer) GetFatalError() error {
+	return nil
+}
+
+//SetOAuthBearerToken 内存实现不需要认证,直接返回nil
+func (p *Producer) SetOAuthBearerToken(oauthBearerToken kafka.OAuthBearerToken) error {
+	return nil
+}
+
+//SetOAuthBearerTokenFailure 内存实现不需要认证,直接返回nil
+func (p *Producer) SetOAuthBearerTokenFailure(errstr string) error {
+	return nil
+}
+
+//confBool 读取布尔类型的设置,兼容字符串写法
+func confBool(conf kafka.ConfigMap, key string, def bool) (bool, error) {
+	v, ok := conf[key]
+	if !ok {
+		return def, nil
+	}
+	switch x := v.(type) {
+	case bool:
+		return x, nil
+	case string:
+		b, err := strconv.ParseBool(x)
+		if err != nil {
+			return def, kafka.NewError(kafka.ErrInvalidArg, fmt.Sprintf("%s expects a boolean value", key), false)
+		}
+		return b, nil
+	default:
+		return def, kafka.NewError(kafka.ErrInvalidArg, fmt.Sprintf("%s expects a boolean value", key), false)
+	}
+}
+
+//confInt 读取整数类型的设置,兼容字符串写法
+func confInt(conf kafka.ConfigMap, key string, def int) (int, error) {
+	v, ok := conf[key]
+	if !ok {
+		return def, nil
+	}
+	switch x := v.(type) {
+	case int:
+		return x, nil
+	case string:
+		n, err := strconv.Atoi(x)
+		if err != nil {
+			return def, kafka.NewError(kafka.ErrInvalidArg, fmt.Sprintf("%s expects an integer value", key), false)
+		}
+		return n, nil
+	default:
+		return def, kafka.NewError(kafka.ErrInvalidArg, fmt.Sprintf("%s expects an integer value", key), false)
+	}
+}
+
+//confString 读取字符串类型的设置
+func confString(conf kafka.ConfigMap, key string, def string) string {
+	v, ok := conf[key]
+	if !ok {
+		return def
+	}
+	return fmt.Sprint(v)
+}
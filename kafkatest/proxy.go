@@ -0,0 +1,35 @@
+package kafkatest
+
+import (
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	"github.com/Golang-Tools/optparams"
+)
+
+//Endpoint 代理连接内存broker时使用的bootstrap.servers,只用于通过设置校验
+const Endpoint = "kafkatest:9092"
+
+//NewProducerProxy 创建已经Init的生产者代理,连接到内存broker,opts中的WithClientFactory可以包装b.ProducerFactory()
+//@params opts ...optparams.Option[producerproxy.Options] 代理的其他设置
+func (b *Broker) NewProducerProxy(opts ...optparams.Option[producerproxy.Options]) (*producerproxy.ProducerProxy, error) {
+	proxy := producerproxy.New()
+	opts = append([]optparams.Option[producerproxy.Options]{producerproxy.WithClientFactory(b.ProducerFactory())}, opts...)
+	err := proxy.Init(Endpoint, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
+
+//NewConsumerProxy 创建已经Init的消费者代理,连接到内存broker,opts中的WithClientFactory可以包装b.ConsumerFactory()
+//@params groupID string 消费组
+//@params opts ...optparams.Option[consumerproxy.Options] 代理的其他设置
+func (b *Broker) NewConsumerProxy(groupID string, opts ...optparams.Option[consumerproxy.Options]) (*consumerproxy.ConsumerProxy, error) {
+	proxy := consumerproxy.New()
+	opts = append([]optparams.Option[consumerproxy.Options]{consumerproxy.WithGroupID(groupID), consumerproxy.WithClientFactory(b.ConsumerFactory())}, opts...)
+	err := proxy.Init(Endpoint, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return proxy, nil
+}
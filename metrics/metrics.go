@@ -0,0 +1,276 @@
+//metrics 为生产者和消费者代理提供prometheus指标
+//使用`producerproxy.WithMetrics`和`consumerproxy.WithMetrics`将Collector设置到代理上即可
+package metrics
+
+import (
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var Logger *log.Log
+
+func init() {
+	Logger = logging.New(log.Dict{"module": "kafka-metrics"})
+}
+
+//Collector 同时实现了`producerproxy.MetricsRecorder`和`consumerproxy.MetricsRecorder`的prometheus指标收集器
+type Collector struct {
+	Opt Options
+
+	messagesSent      *prometheus.CounterVec
+	messagesDelivered *prometheus.CounterVec
+	messagesDropped   *prometheus.CounterVec
+	spoolMessages     *prometheus.GaugeVec
+	spoolBytes        *prometheus.GaugeVec
+	messagesSpooled   *prometheus.CounterVec
+	deliveryFailures  *prometheus.CounterVec
+	producerInFlight  *prometheus.GaugeVec
+	messagesConsumed  *prometheus.CounterVec
+	handlerDuration   *prometheus.HistogramVec
+	consumerInFlight  *prometheus.GaugeVec
+	rebalances        *prometheus.CounterVec
+	consumerLag       *prometheus.GaugeVec
+	clientQueueMsgs   *prometheus.GaugeVec
+	clientQueueBytes  *prometheus.GaugeVec
+	clientReplyQueue  *prometheus.GaugeVec
+	clientTxRequests  *prometheus.Desc
+	clientRxResponses *prometheus.Desc
+	clientTxMessages  *prometheus.Desc
+	clientRxMessages  *prometheus.Desc
+
+	totalsLock   sync.Mutex
+	clientTotals map[clientKey]clientTotals
+}
+
+//clientKey 客户端累计值的标签
+type clientKey struct {
+	proxy      string
+	clientType string
+}
+
+//clientTotals librdkafka统计信息中从客户端创建起的累计值,按counter导出
+type clientTotals struct {
+	tx, rx, txMsgs, rxMsgs int64
+}
+
+//New 创建一个指标收集器
+//@params opts ...optparams.Option[Options] 指标收集器的设置
+func New(opts ...optparams.Option[Options]) *Collector {
+	c := new(Collector)
+	c.Opt = DefaultOptions
+	optparams.GetOption(&c.Opt, opts...)
+	ns := c.Opt.Namespace
+	cl := c.Opt.ConstLabels
+	c.messagesSent = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns, Subsystem: "producer", Name: "messages_sent_total",
+		Help: "Number of messages handed to the producer.", ConstLabels: cl,
+	}, []string{"proxy", "topic"})
+	c.messagesDelivered = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns, Subsystem: "producer", Name: "messages_produced_total",
+		Help: "Number of messages acknowledged by the brokers.", ConstLabels: cl,
+	}, []string{"proxy", "topic", "partition"})
+	c.messagesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns, Subsystem: "producer", Name: "messages_dropped_total",
+		Help: "Number of messages dropped because the send queue or the spool was full.", ConstLabels: cl,
+	}, []string{"proxy", "topic"})
+	c.spoolMessages = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns, Subsystem: "producer", Name: "spool_messages",
+		Help: "Number of messages waiting in the local disk spool.", ConstLabels: cl,
+	}, []string{"proxy"})
+	c.spoolBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns, Subsystem: "producer", Name: "spool_bytes",
+		Help: "Bytes of messages waiting in the local disk spool.", ConstLabels: cl,
+	}, []string{"proxy"})
+	c.messagesSpooled = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns, Subsystem: "producer", Name: "messages_spooled_total",
+		Help: "Number of messages written to the local disk spool.", ConstLabels: cl,
+	}, []string{"proxy", "topic"})
+	c.deliveryFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns, Subsystem: "producer", Name: "delivery_failures_total",
+		Help: "Number of messages whose delivery report carried an error.", ConstLabels: cl,
+	}, []string{"proxy", "topic", "partition"})
+	c.producerInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns, Subsystem: "producer", Name: "in_flight_messages",
+		Help: "Number of messages sent but not yet confirmed, partition is the one requested when sending (-1 for any).", ConstLabels: cl,
+	}, []string{"proxy", "topic", "partition"})
+	c.messagesConsumed = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns, Subsystem: "consumer", Name: "messages_consumed_total",
+		Help: "Number of messages received by the consumer.", ConstLabels: cl,
+	}, []string{"proxy", "topic", "partition"})
+	c.handlerDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: ns, Subsystem: "consumer", Name: "handler_duration_seconds",
+		Help: "Time spent in the message handler.", ConstLabels: cl, Buckets: c.Opt.Buckets,
+	}, []string{"proxy", "topic", "partition"})
+	c.consumerInFlight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns, Subsystem: "consumer", Name: "in_flight_messages",
+		Help: "Number of messages being handled.", ConstLabels: cl,
+	}, []string{"proxy", "topic", "partition"})
+	c.rebalances = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: ns, Subsystem: "consumer", Name: "rebalances_total",
+		Help: "Number of rebalance events.", ConstLabels: cl,
+	}, []string{"proxy", "event"})
+	c.consumerLag = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: ns, Subsystem: "consumer", Name: "lag",
+		Help: "Consumer lag reported by librdkafka statistics.", ConstLabels: cl,
+	}, []string{"proxy", "topic", "partition"})
+	c.clientQueueMsgs = c.newClientGauge("queue_messages", "Number of messages in the client queues (msg_cnt).")
+	c.clientQueueBytes = c.newClientGauge("queue_bytes", "Size of messages in the client queues (msg_size).")
+	c.clientReplyQueue = c.newClientGauge("reply_queue_ops", "Number of ops waiting to be served by poll (replyq).")
+	c.clientTxRequests = c.newClientCounterDesc("tx_requests_total", "Total number of requests sent to brokers (tx).")
+	c.clientRxResponses = c.newClientCounterDesc("rx_responses_total", "Total number of responses received from brokers (rx).")
+	c.clientTxMessages = c.newClientCounterDesc("tx_messages_total", "Total number of messages transmitted (txmsgs).")
+	c.clientRxMessages = c.newClientCounterDesc("rx_messages_total", "Total number of messages consumed (rxmsgs).")
+	c.clientTotals = map[clientKey]clientTotals{}
+	return c
+}
+
+func (c *Collector) newClientGauge(name, help string) *prometheus.GaugeVec {
+	return prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: c.Opt.Namespace, Subsystem: "client", Name: name,
+		Help: help, ConstLabels: c.Opt.ConstLabels,
+	}, []string{"proxy", "type"})
+}
+
+//newClientCounterDesc librdkafka的累计值在每次统计时整体给出,只能在收集时按const counter导出
+func (c *Collector) newClientCounterDesc(name, help string) *prometheus.Desc {
+	return prometheus.NewDesc(prometheus.BuildFQName(c.Opt.Namespace, "client", name), help, []string{"proxy", "type"}, c.Opt.ConstLabels)
+}
+
+func (c *Collector) clientCounterDescs() []*prometheus.Desc {
+	return []*prometheus.Desc{c.clientTxRequests, c.clientRxResponses, c.clientTxMessages, c.clientRxMessages}
+}
+
+func (c *Collector) collectors() []prometheus.Collector {
+	return []prometheus.Collector{
+		c.messagesSent, c.messagesDelivered, c.messagesDropped, c.deliveryFailures, c.producerInFlight,
+		c.spoolMessages, c.spoolBytes, c.messagesSpooled,
+		c.messagesConsumed, c.handlerDuration, c.consumerInFlight, c.rebalances, c.consumerLag,
+		c.clientQueueMsgs, c.clientQueueBytes, c.clientReplyQueue,
+	}
+}
+
+//Describe 实现prometheus.Collector接口
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, col := range c.collectors() {
+		col.Describe(ch)
+	}
+	for _, desc := range c.clientCounterDescs() {
+		ch <- desc
+	}
+}
+
+//Collect 实现prometheus.Collector接口
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	for _, col := range c.collectors() {
+		col.Collect(ch)
+	}
+	c.totalsLock.Lock()
+	defer c.totalsLock.Unlock()
+	for key, t := range c.clientTotals {
+		values := []int64{t.tx, t.rx, t.txMsgs, t.rxMsgs}
+		for i, desc := range c.clientCounterDescs() {
+			ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(values[i]), key.proxy, key.clientType)
+		}
+	}
+}
+
+//Register 将收集器注册到registerer
+//@params reg prometheus.Registerer 注册器,为nil时使用prometheus.DefaultRegisterer
+func (c *Collector) Register(reg prometheus.Registerer) error {
+	if reg == nil {
+		reg = prometheus.DefaultRegisterer
+	}
+	return reg.Register(c)
+}
+
+//MessageSent 实现`producerproxy.MetricsRecorder`
+func (c *Collector) MessageSent(proxy, topic string) {
+	c.messagesSent.WithLabelValues(proxy, topic).Inc()
+}
+
+//MessageDelivered 实现`producerproxy.MetricsRecorder`
+func (c *Collector) MessageDelivered(proxy, topic string, partition int32) {
+	c.messagesDelivered.WithLabelValues(proxy, topic, partitionLabel(partition)).Inc()
+}
+
+//MessageDropped 实现`producerproxy.MetricsRecorder`
+func (c *Collector) MessageDropped(proxy, topic string) {
+	c.messagesDropped.WithLabelValues(proxy, topic).Inc()
+}
+
+//MessageSpooled 实现`producerproxy.MetricsRecorder`
+func (c *Collector) MessageSpooled(proxy, topic string) {
+	c.messagesSpooled.WithLabelValues(proxy, topic).Inc()
+}
+
+//SpoolDepth 实现`producerproxy.MetricsRecorder`
+func (c *Collector) SpoolDepth(proxy string, messages int, bytes int64) {
+	c.spoolMessages.WithLabelValues(proxy).Set(float64(messages))
+	c.spoolBytes.WithLabelValues(proxy).Set(float64(bytes))
+}
+
+//DeliveryFailed 实现`producerproxy.MetricsRecorder`
+func (c *Collector) DeliveryFailed(proxy, topic string, partition int32) {
+	c.deliveryFailures.WithLabelValues(proxy, topic, partitionLabel(partition)).Inc()
+}
+
+//ProducerInFlight 实现`producerproxy.MetricsRecorder`
+func (c *Collector) ProducerInFlight(proxy, topic string, partition int32, delta int) {
+	c.producerInFlight.WithLabelValues(proxy, topic, partitionLabel(partition)).Add(float64(delta))
+}
+
+//MessageConsumed 实现`consumerproxy.MetricsRecorder`
+func (c *Collector) MessageConsumed(proxy, topic string, partition int32) {
+	c.messagesConsumed.WithLabelValues(proxy, topic, partitionLabel(partition)).Inc()
+}
+
+//HandlerDuration 实现`consumerproxy.MetricsRecorder`
+func (c *Collector) HandlerDuration(proxy, topic string, partition int32, duration time.Duration) {
+	c.handlerDuration.WithLabelValues(proxy, topic, partitionLabel(partition)).Observe(duration.Seconds())
+}
+
+//ConsumerInFlight 实现`consumerproxy.MetricsRecorder`
+func (c *Collector) ConsumerInFlight(proxy, topic string, partition int32, delta int) {
+	c.consumerInFlight.WithLabelValues(proxy, topic, partitionLabel(partition)).Add(float64(delta))
+}
+
+//Rebalance 实现`consumerproxy.MetricsRecorder`
+func (c *Collector) Rebalance(proxy string, event string) {
+	c.rebalances.WithLabelValues(proxy, event).Inc()
+}
+
+//Stats 同时实现`producerproxy.MetricsRecorder`和`consumerproxy.MetricsRecorder`
+//解析librdkafka的统计信息,更新客户端队列和消费延迟指标
+func (c *Collector) Stats(proxy string, stats string) {
+	s, err := parseStats(stats)
+	if err != nil {
+		Logger.Error("parse librdkafka stats get error", log.Dict{"err": err, "proxy": proxy})
+		return
+	}
+	c.clientQueueMsgs.WithLabelValues(proxy, s.Type).Set(float64(s.MsgCnt))
+	c.clientQueueBytes.WithLabelValues(proxy, s.Type).Set(float64(s.MsgSize))
+	c.clientReplyQueue.WithLabelValues(proxy, s.Type).Set(float64(s.ReplyQ))
+	c.totalsLock.Lock()
+	c.clientTotals[clientKey{proxy: proxy, clientType: s.Type}] = clientTotals{tx: s.Tx, rx: s.Rx, txMsgs: s.TxMsgs, rxMsgs: s.RxMsgs}
+	c.totalsLock.Unlock()
+	if s.Type != "consumer" {
+		return
+	}
+	for topic, t := range s.Topics {
+		for _, p := range t.Partitions {
+			//-1是librdkafka内部的未分配分区
+			if p.Partition < 0 || p.ConsumerLag < 0 {
+				continue
+			}
+			c.consumerLag.WithLabelValues(proxy, topic, partitionLabel(p.Partition)).Set(float64(p.ConsumerLag))
+		}
+	}
+}
+
+//Default 默认的指标收集器
+var Default = New()
@@ -0,0 +1,66 @@
+package metrics_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/Golang-Tools/kafkahelper/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestProducerInFlightLabels(t *testing.T) {
+	c := metrics.New(metrics.WithConstLabels(prometheus.Labels{"app": "test"}))
+	c.ProducerInFlight("p1", "orders", 2, 3)
+	c.ProducerInFlight("p1", "orders", 2, -1)
+	//未指定分区发送时分区为-1
+	c.ProducerInFlight("p1", "orders", -1, 1)
+	c.ProducerInFlight("p1", "users", 0, 1)
+	expected := `
+# HELP kafka_producer_in_flight_messages Number of messages sent but not yet confirmed, partition is the one requested when sending (-1 for any).
+# TYPE kafka_producer_in_flight_messages gauge
+kafka_producer_in_flight_messages{app="test",partition="-1",proxy="p1",topic="orders"} 1
+kafka_producer_in_flight_messages{app="test",partition="2",proxy="p1",topic="orders"} 2
+kafka_producer_in_flight_messages{app="test",partition="0",proxy="p1",topic="users"} 1
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected), "kafka_producer_in_flight_messages"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestClientTotalsAreCounters(t *testing.T) {
+	c := metrics.New()
+	c.Stats("p1", `{"name":"rdkafka#producer-1","type":"producer","replyq":1,"msg_cnt":2,"msg_size":30,"tx":10,"rx":9,"txmsgs":100,"rxmsgs":0}`)
+	c.Stats("c1", `{"name":"rdkafka#consumer-1","type":"consumer","tx":5,"rx":5,"txmsgs":0,"rxmsgs":40}`)
+	//librdkafka给出的是累计值,后一次统计覆盖前一次而不是累加
+	c.Stats("p1", `{"name":"rdkafka#producer-1","type":"producer","replyq":0,"msg_cnt":0,"msg_size":0,"tx":12,"rx":11,"txmsgs":120,"rxmsgs":0}`)
+	expected := `
+# HELP kafka_client_tx_requests_total Total number of requests sent to brokers (tx).
+# TYPE kafka_client_tx_requests_total counter
+kafka_client_tx_requests_total{proxy="c1",type="consumer"} 5
+kafka_client_tx_requests_total{proxy="p1",type="producer"} 12
+# HELP kafka_client_rx_responses_total Total number of responses received from brokers (rx).
+# TYPE kafka_client_rx_responses_total counter
+kafka_client_rx_responses_total{proxy="c1",type="consumer"} 5
+kafka_client_rx_responses_total{proxy="p1",type="producer"} 11
+# HELP kafka_client_tx_messages_total Total number of messages transmitted (txmsgs).
+# TYPE kafka_client_tx_messages_total counter
+kafka_client_tx_messages_total{proxy="c1",type="consumer"} 0
+kafka_client_tx_messages_total{proxy="p1",type="producer"} 120
+# HELP kafka_client_rx_messages_total Total number of messages consumed (rxmsgs).
+# TYPE kafka_client_rx_messages_total counter
+kafka_client_rx_messages_total{proxy="c1",type="consumer"} 40
+kafka_client_rx_messages_total{proxy="p1",type="producer"} 0
+# HELP kafka_client_queue_messages Number of messages in the client queues (msg_cnt).
+# TYPE kafka_client_queue_messages gauge
+kafka_client_queue_messages{proxy="c1",type="consumer"} 0
+kafka_client_queue_messages{proxy="p1",type="producer"} 0
+`
+	if err := testutil.CollectAndCompare(c, strings.NewReader(expected),
+		"kafka_client_tx_requests_total", "kafka_client_rx_responses_total",
+		"kafka_client_tx_messages_total", "kafka_client_rx_messages_total",
+		"kafka_client_queue_messages",
+	); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"github.com/Golang-Tools/optparams"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+//Options 设置指标收集器的可选参数
+type Options struct {
+	Namespace   string
+	ConstLabels prometheus.Labels
+	Buckets     []float64
+}
+
+var DefaultOptions = Options{
+	Namespace: "kafka",
+	Buckets:   prometheus.DefBuckets,
+}
+
+//WithNamespace 设置指标的命名空间,默认为kafka
+//@params namespace string 指标名的前缀
+func WithNamespace(namespace string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Namespace = namespace
+	})
+}
+
+//WithConstLabels 设置所有指标都带上的固定标签
+//@params labels prometheus.Labels 固定标签
+func WithConstLabels(labels prometheus.Labels) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.ConstLabels = labels
+	})
+}
+
+//WithBuckets 设置消息处理耗时直方图的分桶,单位s
+//@params buckets ...float64 分桶的上界
+func WithBuckets(buckets ...float64) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Buckets = buckets
+	})
+}
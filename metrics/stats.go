@@ -0,0 +1,42 @@
+package metrics
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+//rdkafkaStats librdkafka统计信息中用到的部分,完整结构见<https://github.com/edenhill/librdkafka/blob/master/STATISTICS.md>
+type rdkafkaStats struct {
+	Name    string                  `json:"name"`
+	Type    string                  `json:"type"`
+	ReplyQ  int64                   `json:"replyq"`
+	MsgCnt  int64                   `json:"msg_cnt"`
+	MsgSize int64                   `json:"msg_size"`
+	Tx      int64                   `json:"tx"`
+	Rx      int64                   `json:"rx"`
+	TxMsgs  int64                   `json:"txmsgs"`
+	RxMsgs  int64                   `json:"rxmsgs"`
+	Topics  map[string]rdkafkaTopic `json:"topics"`
+}
+
+type rdkafkaTopic struct {
+	Partitions map[string]rdkafkaPartition `json:"partitions"`
+}
+
+type rdkafkaPartition struct {
+	Partition   int32 `json:"partition"`
+	ConsumerLag int64 `json:"consumer_lag"`
+}
+
+func parseStats(stats string) (*rdkafkaStats, error) {
+	result := rdkafkaStats{}
+	err := json.Unmarshal([]byte(stats), &result)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+func partitionLabel(partition int32) string {
+	return strconv.FormatInt(int64(partition), 10)
+}
@@ -0,0 +1,172 @@
+package mirror
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//CheckpointStore 检查点存储,检查点是源分区上下一条需要复制的消息的offset
+type CheckpointStore interface {
+	//Load 读取任务的检查点
+	Load(ctx context.Context, name string) ([]kafka.TopicPartition, error)
+	//Save 保存任务的检查点,只包含有变化的分区
+	Save(ctx context.Context, name string, offsets []kafka.TopicPartition) error
+}
+
+//TopicCheckpoints 将检查点保存在目标集群的topic中,建议使用`cleanup.policy=compact`的topic
+//每个分区的检查点是一条消息,key为`任务名/源topic/分区`,value为十进制的offset,读取时以最后一条为准
+type TopicCheckpoints struct {
+	topic    string
+	producer *producerproxy.ProducerProxy
+	consumer *consumerproxy.ConsumerProxy
+}
+
+var _ CheckpointStore = (*TopicCheckpoints)(nil)
+
+//NewTopicCheckpoints 创建保存在topic中的检查点存储
+//@params topic string 保存检查点的topic
+//@params producer *producerproxy.ProducerProxy 目标集群的生产者代理,可以与复制使用同一个
+//@params consumer *consumerproxy.ConsumerProxy 目标集群的消费者代理,只用于启动时读取检查点,不要调用它的Watch
+func NewTopicCheckpoints(topic string, producer *producerproxy.ProducerProxy, consumer *consumerproxy.ConsumerProxy) *TopicCheckpoints {
+	return &TopicCheckpoints{topic: topic, producer: producer, consumer: consumer}
+}
+
+//checkpointKey 检查点消息的key
+func checkpointKey(name string, topic string, partition int32) string {
+	return fmt.Sprintf("%s/%s/%d", name, topic, partition)
+}
+
+//parseCheckpointKey 解析检查点消息的key,topic中不会有`/`,因此从右边拆分
+func parseCheckpointKey(key string) (name string, topic string, partition int32, ok bool) {
+	i := strings.LastIndexByte(key, '/')
+	if i < 0 {
+		return "", "", 0, false
+	}
+	p, err := strconv.ParseInt(key[i+1:], 10, 32)
+	if err != nil {
+		return "", "", 0, false
+	}
+	j := strings.LastIndexByte(key[:i], '/')
+	if j < 0 {
+		return "", "", 0, false
+	}
+	return key[:j], key[j+1 : i], int32(p), true
+}
+
+//Save 发送检查点消息并等待全部确认
+func (s *TopicCheckpoints) Save(ctx context.Context, name string, offsets []kafka.TopicPartition) error {
+	results := make([]<-chan error, 0, len(offsets))
+	for _, tp := range offsets {
+		msg := msghelper.NewMsg(s.topic, []byte(strconv.FormatInt(int64(tp.Offset), 10)),
+			msghelper.WithKey([]byte(checkpointKey(name, *tp.Topic, tp.Partition))))
+		results = append(results, s.producer.SendAsync(ctx, msg))
+	}
+	for _, result := range results {
+		select {
+		case err := <-result:
+			if err != nil {
+				return err
+			}
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+//Load 从头读取检查点topic直到开始时的末尾,topic不存在时没有检查点
+func (s *TopicCheckpoints) Load(ctx context.Context, name string) ([]kafka.TopicPartition, error) {
+	partitions, err := s.consumer.Partitions(s.topic)
+	if err != nil {
+		if kerr, ok := err.(kafka.Error); ok && kerr.Code() == kafka.ErrUnknownTopicOrPart {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ends := map[int32]int64{}
+	assignment := []kafka.TopicPartition{}
+	for _, id := range partitions {
+		low, high, err := s.consumer.QueryWatermarkOffsets(s.topic, id, metadataTimeoutMs)
+		if err != nil {
+			return nil, err
+		}
+		if high <= low {
+			continue
+		}
+		ends[id] = high
+		assignment = append(assignment, kafka.TopicPartition{Topic: &s.topic, Partition: id, Offset: kafka.Offset(low)})
+	}
+	if len(assignment) == 0 {
+		return nil, nil
+	}
+	err = s.consumer.Assign(assignment)
+	if err != nil {
+		return nil, err
+	}
+	defer s.consumer.Unassign()
+	latest := map[partitionKey]int64{}
+	for len(ends) > 0 {
+		ev, err := s.next(ctx)
+		if err != nil {
+			return nil, err
+		}
+		switch e := ev.(type) {
+		case *kafka.Message:
+			if e.TopicPartition.Error != nil {
+				return nil, e.TopicPartition.Error
+			}
+			if n, topic, partition, ok := parseCheckpointKey(string(e.Key)); ok && n == name {
+				if offset, err := strconv.ParseInt(string(e.Value), 10, 64); err == nil {
+					latest[partitionKey{topic, partition}] = offset
+				}
+			}
+			id := e.TopicPartition.Partition
+			if end, ok := ends[id]; ok && int64(e.TopicPartition.Offset)+1 >= end {
+				delete(ends, id)
+			}
+		case kafka.Error:
+			if e.IsFatal() {
+				return nil, e
+			}
+		}
+	}
+	offsets := make([]kafka.TopicPartition, 0, len(latest))
+	for k, offset := range latest {
+		topic := k.topic
+		offsets = append(offsets, kafka.TopicPartition{Topic: &topic, Partition: k.partition, Offset: kafka.Offset(offset)})
+	}
+	return offsets, nil
+}
+
+//next 读取下一个事件,开启了events channel时从channel读取,否则Poll
+func (s *TopicCheckpoints) next(ctx context.Context) (kafka.Event, error) {
+	events := s.consumer.Events()
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		if events == nil {
+			if ev := s.consumer.Poll(100); ev != nil {
+				return ev, nil
+			}
+			continue
+		}
+		timer := time.NewTimer(100 * time.Millisecond)
+		select {
+		case ev := <-events:
+			timer.Stop()
+			return ev, nil
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package mirror
+
+import "testing"
+
+func TestParseCheckpointKey(t *testing.T) {
+	cases := []struct {
+		key       string
+		name      string
+		topic     string
+		partition int32
+		ok        bool
+	}{
+		{key: checkpointKey("mirror", "orders", 3), name: "mirror", topic: "orders", partition: 3, ok: true},
+		//任务名中可以有`/`,topic中不会有
+		{key: checkpointKey("team/a/mirror", "orders.v1", 12), name: "team/a/mirror", topic: "orders.v1", partition: 12, ok: true},
+		{key: checkpointKey("", "orders", 0), name: "", topic: "orders", partition: 0, ok: true},
+		{key: "mirror/orders", ok: false},
+		{key: "orders", ok: false},
+		{key: "mirror/orders/x", ok: false},
+		{key: "mirror/orders/99999999999", ok: false},
+		{key: "", ok: false},
+	}
+	for _, c := range cases {
+		name, topic, partition, ok := parseCheckpointKey(c.key)
+		if ok != c.ok {
+			t.Fatalf("%q: expect ok %v, got %v", c.key, c.ok, ok)
+		}
+		if ok && (name != c.name || topic != c.topic || partition != c.partition) {
+			t.Fatalf("%q: expect %q %q %d, got %q %q %d", c.key, c.name, c.topic, c.partition, name, topic, partition)
+		}
+	}
+}
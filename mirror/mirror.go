@@ -0,0 +1,546 @@
+//Package mirror 在topic之间或集群之间复制消息
+//源端使用指定分区模式的消费者代理,不加入消费组;目标端使用生产者代理,保留消息的key,headers和时间戳,可以保留分区.
+//复制支持过滤和改写,可以按offset或时间指定起止位置,已经确认写入目标的位置可以作为检查点保存在目标集群的topic中用于断点续传
+package mirror
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Logger 模块的logger
+var Logger *log.Log
+
+func init() {
+	Logger = logging.New(log.Dict{"module": "kafka-mirror"})
+}
+
+//ErrAlreadyRunning 复制任务已经运行过,每个任务只能运行一次
+var ErrAlreadyRunning = errors.New("mirror already running")
+
+//metadataTimeoutMs 查询offset的超时时间
+const metadataTimeoutMs = 10000
+
+//positionInterval 检查分区是否已经读到结束位置的间隔
+const positionInterval = time.Second
+
+//partitionKey 分区的标识
+type partitionKey struct {
+	topic     string
+	partition int32
+}
+
+func keyOf(msg *kafka.Message) partitionKey {
+	k := partitionKey{partition: msg.TopicPartition.Partition}
+	if msg.TopicPartition.Topic != nil {
+		k.topic = *msg.TopicPartition.Topic
+	}
+	return k
+}
+
+//inflight 已经发往目标还未确认的消息,result为nil表示消息被过滤
+type inflight struct {
+	next   int64
+	result <-chan error
+}
+
+//partitionState 源分区的复制进度
+//seen为最后交给handle的消息的下一个offset,checked为上一次检查读取位置时的seen
+type partitionState struct {
+	key     partitionKey
+	end     int64
+	done    int64
+	saved   int64
+	seen    int64
+	checked int64
+	reached bool
+	pending []inflight
+}
+
+//finished 分区是否已经复制到结束位置且全部确认
+func (st *partitionState) finished() bool {
+	return st.reached && len(st.pending) == 0
+}
+
+//Stats 复制的统计
+type Stats struct {
+	//Consumed 从源端读到的消息数
+	Consumed int64
+	//Filtered 被过滤或被改写函数丢弃的消息数
+	Filtered int64
+	//Produced 已经确认写入目标的消息数
+	Produced int64
+	//Partitions 复制的分区数
+	Partitions int
+	//Finished 已经复制到结束位置的分区数
+	Finished int
+	//Remaining 设置了结束位置时还需要复制的消息数,按offset计算
+	Remaining int64
+}
+
+//Mirror 复制任务
+type Mirror struct {
+	source  *consumerproxy.ConsumerProxy
+	target  *producerproxy.ProducerProxy
+	topics  []string
+	opt     Options
+	lock    sync.Mutex
+	ctx     context.Context
+	parts   map[partitionKey]*partitionState
+	stats   Stats
+	started bool
+	stopped bool
+	err     error
+	failed  chan struct{}
+}
+
+//New 创建复制任务
+//源端消费者代理由任务独占,任务会注册消息处理函数和分区末尾的回调并分配分区,不要再调用它的OnMessage,OnPartitionEOF,Subscribe和Watch;
+//设置了结束位置时建议开启`enable.partition.eof`,结束位置前是事务的控制记录或被压缩掉的offset时可以立即确认分区已经读完,
+//没有开启时通过定期检查读取位置确认
+//@params source *consumerproxy.ConsumerProxy 源端已经Init的消费者代理,需要开启`go.events.channel.enable`
+//@params target *producerproxy.ProducerProxy 目标端已经Init的生产者代理,需要监听发送报告
+//@params topics []string 要复制的源topic
+func New(source *consumerproxy.ConsumerProxy, target *producerproxy.ProducerProxy, topics []string, opts ...optparams.Option[Options]) *Mirror {
+	m := &Mirror{
+		source: source,
+		target: target,
+		topics: append([]string{}, topics...),
+		opt:    DefaultOptions,
+		parts:  map[partitionKey]*partitionState{},
+		failed: make(chan struct{}),
+	}
+	optparams.GetOption(&m.opt, opts...)
+	return m
+}
+
+//Stats 复制的统计
+func (m *Mirror) Stats() Stats {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	s := m.stats
+	s.Partitions = len(m.parts)
+	for _, st := range m.parts {
+		if st.finished() {
+			s.Finished++
+		}
+		if st.end >= 0 && st.done < st.end {
+			s.Remaining += st.end - st.done
+		}
+	}
+	return s
+}
+
+//resolve 计算位置对应的offset
+func (m *Mirror) resolve(key partitionKey, b Bound) (int64, error) {
+	if b.kind == byTime {
+		return m.source.OffsetForTime(key.topic, key.partition, b.time)
+	}
+	return b.offset, nil
+}
+
+//prepare 读取检查点,计算每个分区的起止offset
+//@returns []kafka.TopicPartition 需要分配的分区和起始offset
+func (m *Mirror) prepare(ctx context.Context) ([]kafka.TopicPartition, error) {
+	if !m.source.IsOk() {
+		return nil, consumerproxy.ErrProxyNotYetSettedClient
+	}
+	saved := map[partitionKey]int64{}
+	if m.opt.Checkpoints != nil {
+		tps, err := m.opt.Checkpoints.Load(ctx, m.opt.Name)
+		if err != nil {
+			return nil, fmt.Errorf("load checkpoints: %w", err)
+		}
+		for _, tp := range tps {
+			if tp.Topic != nil {
+				saved[partitionKey{*tp.Topic, tp.Partition}] = int64(tp.Offset)
+			}
+		}
+	}
+	assignment := []kafka.TopicPartition{}
+	for _, topic := range m.topics {
+		partitions, err := m.source.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("topic %q: %w", topic, err)
+		}
+		for _, id := range partitions {
+			key := partitionKey{topic, id}
+			low, _, err := m.source.QueryWatermarkOffsets(topic, id, metadataTimeoutMs)
+			if err != nil {
+				return nil, fmt.Errorf("query watermarks of %s[%d]: %w", topic, id, err)
+			}
+			st := &partitionState{key: key, end: -1, done: low, saved: -1, checked: -1}
+			if off, ok := saved[key]; ok {
+				st.done, st.saved = off, off
+			} else if !m.opt.Start.IsZero() {
+				if st.done, err = m.resolve(key, m.opt.Start); err != nil {
+					return nil, fmt.Errorf("resolve start of %s[%d]: %w", topic, id, err)
+				}
+			}
+			st.seen = st.done
+			if !m.opt.End.IsZero() {
+				if st.end, err = m.resolve(key, m.opt.End); err != nil {
+					return nil, fmt.Errorf("resolve end of %s[%d]: %w", topic, id, err)
+				}
+			}
+			m.parts[key] = st
+			if st.end >= 0 && st.done >= st.end {
+				st.reached = true
+				continue
+			}
+			t := topic
+			assignment = append(assignment, kafka.TopicPartition{Topic: &t, Partition: id, Offset: kafka.Offset(st.done)})
+		}
+	}
+	return assignment, nil
+}
+
+//Run 开始复制,设置了结束位置时所有分区都复制完成后返回nil,否则一直复制直到ctx结束并返回ctx的错误
+//结束时会停止读取,等待已发送消息的发送报告并保存检查点;消息发送失败或改写函数返回错误时停止并返回该错误
+//@params ctx context.Context 控制复制的运行
+func (m *Mirror) Run(ctx context.Context) error {
+	m.lock.Lock()
+	if m.started {
+		m.lock.Unlock()
+		return ErrAlreadyRunning
+	}
+	m.started = true
+	m.lock.Unlock()
+	assignment, err := m.prepare(ctx)
+	if err != nil {
+		return err
+	}
+	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	m.ctx = runCtx
+	if len(assignment) == 0 {
+		return m.checkpoint(ctx)
+	}
+	err = m.source.OnMessageWithContext(m.handle)
+	if err != nil {
+		return err
+	}
+	err = m.source.OnPartitionEOF(m.onEOF)
+	if err != nil {
+		return err
+	}
+	err = m.source.Assign(assignment)
+	if err != nil {
+		return err
+	}
+	Logger.Info("mirror started", log.Dict{"name": m.opt.Name, "topics": m.topics, "partitions": len(assignment)})
+	stop := m.source.Watch()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	lastCheckpoint := time.Now()
+	lastPosition := time.Now()
+	run := true
+	for run {
+		select {
+		case <-runCtx.Done():
+			run = false
+		case <-m.failed:
+			run = false
+		case <-ticker.C:
+			if m.progress() {
+				run = false
+				break
+			}
+			if time.Since(lastPosition) >= positionInterval {
+				lastPosition = time.Now()
+				m.checkPositions()
+			}
+			if m.opt.Checkpoints != nil && time.Since(lastCheckpoint) >= m.opt.CheckpointInterval {
+				lastCheckpoint = time.Now()
+				if err := m.checkpoint(runCtx); err != nil {
+					Logger.Error("save checkpoints get error", log.Dict{"name": m.opt.Name, "err": err})
+				}
+			}
+		}
+	}
+	stop()
+	m.lock.Lock()
+	m.stopped = true
+	m.lock.Unlock()
+	cancel()
+	if err := m.source.Unassign(); err != nil {
+		Logger.Warn("unassign source get error", log.Dict{"name": m.opt.Name, "err": err})
+	}
+	m.drain()
+	cctx, ccancel := context.WithTimeout(context.Background(), m.opt.DrainTimeout)
+	defer ccancel()
+	cerr := m.checkpoint(cctx)
+	stats := m.Stats()
+	Logger.Info("mirror stopped", log.Dict{"name": m.opt.Name, "consumed": stats.Consumed, "produced": stats.Produced, "filtered": stats.Filtered})
+	m.lock.Lock()
+	err = m.err
+	m.lock.Unlock()
+	if err == nil {
+		err = cerr
+	}
+	if err == nil && stats.Finished < stats.Partitions {
+		err = ctx.Err()
+	}
+	return err
+}
+
+//progress 收集已经确认的消息
+//@returns bool 是否所有分区都已经复制完成
+func (m *Mirror) progress() bool {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	all := true
+	for _, st := range m.parts {
+		m.collect(st)
+		if !st.finished() {
+			all = false
+		}
+	}
+	return all
+}
+
+//handle 交给源端消费者代理的消息处理函数
+//改写和发送不持有锁,同一分区的消息由消费者代理按顺序交给handle,因此pending仍然按offset排列
+func (m *Mirror) handle(_ context.Context, msg *kafka.Message) error {
+	offset := int64(msg.TopicPartition.Offset)
+	m.lock.Lock()
+	if m.stopped || m.err != nil {
+		m.lock.Unlock()
+		return nil
+	}
+	st, ok := m.parts[keyOf(msg)]
+	if !ok || st.reached {
+		m.lock.Unlock()
+		return nil
+	}
+	st.seen = offset + 1
+	if st.end >= 0 && offset >= st.end {
+		st.reached = true
+		m.lock.Unlock()
+		return nil
+	}
+	m.stats.Consumed++
+	m.lock.Unlock()
+	out, err := m.convert(msg)
+	if err != nil {
+		err = fmt.Errorf("transform %s[%d]@%d: %w", st.key.topic, st.key.partition, offset, err)
+		m.lock.Lock()
+		m.fail(err)
+		m.lock.Unlock()
+		return err
+	}
+	var result <-chan error
+	if out != nil {
+		result = m.target.SendAsync(m.ctx, out)
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if out == nil {
+		m.stats.Filtered++
+	}
+	st.pending = append(st.pending, inflight{next: offset + 1, result: result})
+	if st.end >= 0 && offset+1 >= st.end {
+		st.reached = true
+	}
+	m.collect(st)
+	return nil
+}
+
+//onEOF 读到分区末尾时,末尾不早于结束位置的分区已经读完
+func (m *Mirror) onEOF(tp kafka.TopicPartition) {
+	if tp.Topic == nil {
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	st, ok := m.parts[partitionKey{*tp.Topic, tp.Partition}]
+	if !ok || st.reached || st.end < 0 {
+		return
+	}
+	if int64(tp.Offset) >= st.end {
+		st.reached = true
+	}
+}
+
+//checkPositions 没有开启PartitionEOF时确认分区是否已经读完
+//分区的读取位置已经越过结束位置,且两次检查之间没有新的消息交给handle(越过结束位置之前的消息都已经处理)时认为分区已经读完
+func (m *Mirror) checkPositions() {
+	m.lock.Lock()
+	tps := []kafka.TopicPartition{}
+	for _, st := range m.parts {
+		if st.reached || st.end < 0 {
+			continue
+		}
+		topic := st.key.topic
+		tps = append(tps, kafka.TopicPartition{Topic: &topic, Partition: st.key.partition})
+	}
+	m.lock.Unlock()
+	if len(tps) == 0 {
+		return
+	}
+	positions, err := m.source.Position(tps)
+	if err != nil {
+		Logger.Warn("query source positions get error", log.Dict{"name": m.opt.Name, "err": err})
+		return
+	}
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, tp := range positions {
+		if tp.Topic == nil {
+			continue
+		}
+		st, ok := m.parts[partitionKey{*tp.Topic, tp.Partition}]
+		if !ok || st.reached {
+			continue
+		}
+		if tp.Offset >= 0 && int64(tp.Offset) >= st.end && st.seen == st.checked {
+			st.reached = true
+		}
+		st.checked = st.seen
+	}
+}
+
+//convert 由源消息构造发往目标的消息,经过过滤和改写,返回nil表示不复制
+func (m *Mirror) convert(msg *kafka.Message) (*kafka.Message, error) {
+	for _, f := range m.opt.Filters {
+		if !f(msg) {
+			return nil, nil
+		}
+	}
+	topic := ""
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+	if m.opt.TopicMap != nil {
+		topic = m.opt.TopicMap(topic)
+	}
+	out := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Value:          msg.Value,
+		Key:            msg.Key,
+		Timestamp:      msg.Timestamp,
+	}
+	if m.opt.KeepPartition {
+		out.TopicPartition.Partition = msg.TopicPartition.Partition
+	}
+	if len(msg.Headers) > 0 {
+		out.Headers = append([]kafka.Header{}, msg.Headers...)
+	}
+	for _, t := range m.opt.Transforms {
+		var err error
+		out, err = t(out)
+		if err != nil || out == nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+//collect 按顺序收集分区上已经确认的消息,推进已完成的位置,需要持有锁
+func (m *Mirror) collect(st *partitionState) {
+	for len(st.pending) > 0 {
+		p := st.pending[0]
+		if p.result != nil {
+			select {
+			case err := <-p.result:
+				if err != nil {
+					m.deliveryFailed(st, p, err)
+					return
+				}
+				m.stats.Produced++
+			default:
+				return
+			}
+		}
+		st.done = p.next
+		st.pending = st.pending[1:]
+	}
+}
+
+//deliveryFailed 处理发送失败,停止复制时的ctx错误不算失败,需要持有锁
+func (m *Mirror) deliveryFailed(st *partitionState, p inflight, err error) {
+	if m.ctx.Err() != nil && errors.Is(err, m.ctx.Err()) {
+		//没有发出的消息之后的位置都不能确认
+		st.pending = nil
+		return
+	}
+	m.fail(fmt.Errorf("deliver %s[%d]@%d: %w", st.key.topic, st.key.partition, p.next-1, err))
+	st.pending = nil
+}
+
+//fail 记录第一个错误并通知Run停止,需要持有锁
+func (m *Mirror) fail(err error) {
+	if m.err != nil {
+		return
+	}
+	m.err = err
+	Logger.Error("mirror failed", log.Dict{"name": m.opt.Name, "err": err})
+	close(m.failed)
+}
+
+//drain 等待已发送消息的发送报告,最多等待DrainTimeout
+func (m *Mirror) drain() {
+	ctx, cancel := context.WithTimeout(context.Background(), m.opt.DrainTimeout)
+	defer cancel()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	for _, st := range m.parts {
+		for len(st.pending) > 0 {
+			p := st.pending[0]
+			if p.result != nil {
+				select {
+				case err := <-p.result:
+					if err != nil {
+						m.deliveryFailed(st, p, err)
+						continue
+					}
+					m.stats.Produced++
+				case <-ctx.Done():
+					Logger.Warn("drain timeout, some positions are not confirmed", log.Dict{"name": m.opt.Name})
+					return
+				}
+			}
+			st.done = p.next
+			st.pending = st.pending[1:]
+		}
+	}
+}
+
+//checkpoint 保存有变化的检查点
+func (m *Mirror) checkpoint(ctx context.Context) error {
+	if m.opt.Checkpoints == nil {
+		return nil
+	}
+	m.lock.Lock()
+	changed := []kafka.TopicPartition{}
+	for _, st := range m.parts {
+		if st.done != st.saved {
+			topic := st.key.topic
+			changed = append(changed, kafka.TopicPartition{Topic: &topic, Partition: st.key.partition, Offset: kafka.Offset(st.done)})
+		}
+	}
+	m.lock.Unlock()
+	if len(changed) == 0 {
+		return nil
+	}
+	err := m.opt.Checkpoints.Save(ctx, m.opt.Name, changed)
+	if err != nil {
+		return err
+	}
+	m.lock.Lock()
+	for _, tp := range changed {
+		m.parts[partitionKey{*tp.Topic, tp.Partition}].saved = int64(tp.Offset)
+	}
+	m.lock.Unlock()
+	return nil
+}
@@ -0,0 +1,305 @@
+package mirror_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/Golang-Tools/kafkahelper/mirror"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+var base = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+//fill 向源topic的每个分区写入n条消息,第i条的时间戳为base+i秒,key为`k<分区>-<i>`,value为`v<分区>-<i>`
+func fill(t *testing.T, b *kafkatest.Broker, topic string, partitions int, n int) {
+	t.Helper()
+	if err := b.CreateTopic(topic, partitions); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	p, err := b.NewProducer(nil)
+	if err != nil {
+		t.Fatalf("NewProducer: %v", err)
+	}
+	defer p.Close()
+	reports := make(chan kafka.Event, partitions*n)
+	for partition := 0; partition < partitions; partition++ {
+		for i := 0; i < n; i++ {
+			msg := &kafka.Message{
+				TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: int32(partition)},
+				Key:            []byte(fmt.Sprintf("k%d-%d", partition, i)),
+				Value:          []byte(fmt.Sprintf("v%d-%d", partition, i)),
+				Headers:        []kafka.Header{{Key: "seq", Value: []byte(fmt.Sprint(i))}, {Key: "seq", Value: []byte("dup")}},
+				Timestamp:      base.Add(time.Duration(i) * time.Second),
+			}
+			if err := p.Produce(msg, reports); err != nil {
+				t.Fatalf("Produce: %v", err)
+			}
+		}
+	}
+	for i := 0; i < partitions*n; i++ {
+		if report := (<-reports).(*kafka.Message); report.TopicPartition.Error != nil {
+			t.Fatalf("Produce: %v", report.TopicPartition.Error)
+		}
+	}
+}
+
+//source 源端的消费者代理
+func source(t *testing.T, b *kafkatest.Broker, opts ...optparams.Option[consumerproxy.Options]) *consumerproxy.ConsumerProxy {
+	t.Helper()
+	opts = append([]optparams.Option[consumerproxy.Options]{consumerproxy.WithComsumerSetting("go.events.channel.enable", true)}, opts...)
+	proxy, err := b.NewConsumerProxy("mirror", opts...)
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+//target 目标端的生产者代理
+func target(t *testing.T, b *kafkatest.Broker) *producerproxy.ProducerProxy {
+	t.Helper()
+	proxy, err := b.NewProducerProxy()
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	t.Cleanup(func() { proxy.Close() })
+	return proxy
+}
+
+//run 运行到所有分区复制完成
+func run(t *testing.T, m *mirror.Mirror) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.Run(ctx); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+}
+
+func values(msgs []*kafka.Message) []string {
+	result := []string{}
+	for _, msg := range msgs {
+		result = append(result, string(msg.Value))
+	}
+	return result
+}
+
+func TestMirrorOffsetRange(t *testing.T) {
+	for _, eof := range []bool{false, true} {
+		t.Run(fmt.Sprintf("partition-eof-%v", eof), func(t *testing.T) {
+			src, dst := kafkatest.NewBroker(), kafkatest.NewBroker()
+			fill(t, src, "orders", 2, 5)
+			if err := dst.CreateTopic("orders", 2); err != nil {
+				t.Fatalf("CreateTopic: %v", err)
+			}
+			m := mirror.New(source(t, src, consumerproxy.WithComsumerSetting("enable.partition.eof", eof)), target(t, dst), []string{"orders"},
+				mirror.WithStart(mirror.AtOffset(1)),
+				mirror.WithEnd(mirror.AtOffset(4)),
+				mirror.WithKeepPartition(),
+			)
+			run(t, m)
+			copied := dst.Messages("orders")
+			if len(copied) != 6 {
+				t.Fatalf("expect offsets [1,4) of 2 partitions, got %v", values(copied))
+			}
+			sources := map[string]*kafka.Message{}
+			for _, msg := range src.Messages("orders") {
+				sources[string(msg.Value)] = msg
+			}
+			for _, msg := range copied {
+				orig := sources[string(msg.Value)]
+				if orig.TopicPartition.Offset < 1 || orig.TopicPartition.Offset >= 4 {
+					t.Fatalf("message at offset %d is out of range", orig.TopicPartition.Offset)
+				}
+				if msg.TopicPartition.Partition != orig.TopicPartition.Partition {
+					t.Fatalf("%s: expect partition %d, got %d", msg.Value, orig.TopicPartition.Partition, msg.TopicPartition.Partition)
+				}
+				if !bytes.Equal(msg.Key, orig.Key) || !msg.Timestamp.Equal(orig.Timestamp) || !reflect.DeepEqual(msg.Headers, orig.Headers) {
+					t.Fatalf("%s: key, headers and timestamp should be kept, expect %v %v %v, got %v %v %v",
+						msg.Value, string(orig.Key), orig.Headers, orig.Timestamp, string(msg.Key), msg.Headers, msg.Timestamp)
+				}
+			}
+			stats := m.Stats()
+			if stats.Consumed != 6 || stats.Produced != 6 || stats.Filtered != 0 || stats.Partitions != 2 || stats.Finished != 2 || stats.Remaining != 0 {
+				t.Fatalf("unexpected stats %+v", stats)
+			}
+		})
+	}
+}
+
+func TestMirrorTimeRange(t *testing.T) {
+	src, dst := kafkatest.NewBroker(), kafkatest.NewBroker()
+	fill(t, src, "orders", 1, 5)
+	m := mirror.New(source(t, src), target(t, dst), []string{"orders"},
+		mirror.WithStart(mirror.AtTime(base.Add(500*time.Millisecond))),
+		mirror.WithEnd(mirror.AtTime(base.Add(3*time.Second))),
+		mirror.WithTopicMap(func(topic string) string { return topic + ".copy" }),
+	)
+	run(t, m)
+	if got := values(dst.Messages("orders.copy")); !reflect.DeepEqual(got, []string{"v0-1", "v0-2"}) {
+		t.Fatalf("expect messages in [base+0.5s, base+3s), got %v", got)
+	}
+	if n := len(dst.Messages("orders")); n != 0 {
+		t.Fatalf("messages should go to the mapped topic, got %d in the source topic name", n)
+	}
+}
+
+func TestMirrorFilterAndTransform(t *testing.T) {
+	src, dst := kafkatest.NewBroker(), kafkatest.NewBroker()
+	fill(t, src, "orders", 1, 6)
+	m := mirror.New(source(t, src), target(t, dst), []string{"orders"},
+		mirror.WithEnd(mirror.AtOffset(6)),
+		mirror.WithFilter(func(msg *kafka.Message) bool { return msg.TopicPartition.Offset != 1 }),
+		mirror.WithTransform(
+			func(msg *kafka.Message) (*kafka.Message, error) {
+				if string(msg.Value) == "v0-3" {
+					return nil, nil
+				}
+				msg.Value = append([]byte("copy-"), msg.Value...)
+				return msg, nil
+			},
+			func(msg *kafka.Message) (*kafka.Message, error) {
+				msg.Headers = append(msg.Headers, kafka.Header{Key: "mirrored", Value: []byte("1")})
+				return msg, nil
+			},
+		),
+	)
+	run(t, m)
+	copied := dst.Messages("orders")
+	if got := values(copied); !reflect.DeepEqual(got, []string{"copy-v0-0", "copy-v0-2", "copy-v0-4", "copy-v0-5"}) {
+		t.Fatalf("unexpected copied messages %v", got)
+	}
+	for _, msg := range copied {
+		if last := msg.Headers[len(msg.Headers)-1]; last.Key != "mirrored" {
+			t.Fatalf("transforms should run in order, got headers %v", msg.Headers)
+		}
+	}
+	if stats := m.Stats(); stats.Consumed != 6 || stats.Filtered != 2 || stats.Produced != 4 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
+
+func TestMirrorTransformError(t *testing.T) {
+	src, dst := kafkatest.NewBroker(), kafkatest.NewBroker()
+	fill(t, src, "orders", 1, 3)
+	errTransform := errors.New("bad message")
+	m := mirror.New(source(t, src), target(t, dst), []string{"orders"},
+		mirror.WithTransform(func(msg *kafka.Message) (*kafka.Message, error) {
+			if string(msg.Value) == "v0-1" {
+				return nil, errTransform
+			}
+			return msg, nil
+		}),
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := m.Run(ctx); !errors.Is(err, errTransform) {
+		t.Fatalf("expect the transform error, got %v", err)
+	}
+	if err := m.Run(ctx); err != mirror.ErrAlreadyRunning {
+		t.Fatalf("expect ErrAlreadyRunning, got %v", err)
+	}
+}
+
+//checkpoints 保存在目标集群topic中的检查点存储,每次使用新的消费者代理,模拟重启
+func checkpoints(t *testing.T, dst *kafkatest.Broker, producer *producerproxy.ProducerProxy) *mirror.TopicCheckpoints {
+	t.Helper()
+	consumer, err := dst.NewConsumerProxy("checkpoints", consumerproxy.WithComsumerSetting("go.events.channel.enable", true))
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	t.Cleanup(func() { consumer.Close() })
+	return mirror.NewTopicCheckpoints("mirror-checkpoints", producer, consumer)
+}
+
+func TestMirrorResumeFromCheckpoints(t *testing.T) {
+	src, dst := kafkatest.NewBroker(), kafkatest.NewBroker()
+	fill(t, src, "orders", 2, 6)
+	producer := target(t, dst)
+	//检查点topic不存在时从头开始
+	if saved, err := checkpoints(t, dst, producer).Load(context.Background(), "team/orders"); err != nil || len(saved) != 0 {
+		t.Fatalf("expect no checkpoints before the first run, got %v, %v", saved, err)
+	}
+	jobs := []struct {
+		name string
+		to   string
+		end  int64
+	}{
+		{name: "team/orders", to: "orders.a", end: 3},
+		{name: "team/orders-b", to: "orders.b", end: 5},
+	}
+	for _, job := range jobs {
+		job := job
+		if err := dst.CreateTopic(job.to, 2); err != nil {
+			t.Fatalf("CreateTopic: %v", err)
+		}
+		run(t, mirror.New(source(t, src), producer, []string{"orders"},
+			mirror.WithName(job.name),
+			mirror.WithTopicMap(func(string) string { return job.to }),
+			mirror.WithEnd(mirror.AtOffset(job.end)),
+			mirror.WithKeepPartition(),
+			mirror.WithCheckpoints(checkpoints(t, dst, producer)),
+		))
+	}
+	//重启后从检查点继续,不同名字的任务的检查点互不影响
+	store := checkpoints(t, dst, producer)
+	saved, err := store.Load(context.Background(), "team/orders")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	got := map[string]kafka.Offset{}
+	for _, tp := range saved {
+		got[fmt.Sprintf("%s[%d]", *tp.Topic, tp.Partition)] = tp.Offset
+	}
+	if want := map[string]kafka.Offset{"orders[0]": 3, "orders[1]": 3}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expect checkpoints %v, got %v", want, got)
+	}
+	m := mirror.New(source(t, src), producer, []string{"orders"},
+		mirror.WithName("team/orders"),
+		mirror.WithTopicMap(func(string) string { return "orders.a" }),
+		mirror.WithStart(mirror.AtOffset(0)),
+		mirror.WithEnd(mirror.AtOffset(6)),
+		mirror.WithKeepPartition(),
+		mirror.WithCheckpoints(store),
+	)
+	run(t, m)
+	if stats := m.Stats(); stats.Consumed != 6 || stats.Produced != 6 {
+		t.Fatalf("resumed mirror should copy only the rest, got %+v", stats)
+	}
+	for partition := 0; partition < 2; partition++ {
+		want := []string{}
+		for i := 0; i < 6; i++ {
+			want = append(want, fmt.Sprintf("v%d-%d", partition, i))
+		}
+		got := []string{}
+		for _, msg := range dst.Messages("orders.a") {
+			if msg.TopicPartition.Partition == int32(partition) {
+				got = append(got, string(msg.Value))
+			}
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("partition %d: expect %v without gaps or duplicates, got %v", partition, want, got)
+		}
+	}
+	saved, err = checkpoints(t, dst, producer).Load(context.Background(), "team/orders")
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if len(saved) != 2 {
+		t.Fatalf("expect checkpoints of 2 partitions, got %v", saved)
+	}
+	for _, tp := range saved {
+		if tp.Offset != 6 {
+			t.Fatalf("expect checkpoints at the end offset 6, got %v", saved)
+		}
+	}
+}
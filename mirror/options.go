@@ -0,0 +1,147 @@
+package mirror
+
+import (
+	"time"
+
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//boundKind 起止位置的类型
+type boundKind int
+
+const (
+	unbounded boundKind = iota
+	byOffset
+	byTime
+)
+
+//Bound 复制的起止位置,可以按offset或时间指定,零值表示不限制
+type Bound struct {
+	kind   boundKind
+	offset int64
+	time   time.Time
+}
+
+//AtOffset 在每个分区的offset处开始或结束,结束位置不包含该offset
+//@params offset int64 分区上的offset
+func AtOffset(offset int64) Bound {
+	return Bound{kind: byOffset, offset: offset}
+}
+
+//AtTime 在每个分区上时间戳不早于t的第一条消息处开始或结束,结束位置不包含该消息
+//@params t time.Time 消息的时间戳
+func AtTime(t time.Time) Bound {
+	return Bound{kind: byTime, time: t}
+}
+
+//IsZero 是否没有设置位置
+func (b Bound) IsZero() bool {
+	return b.kind == unbounded
+}
+
+//Filter 消息过滤函数,返回false的消息不会被复制
+type Filter func(msg *kafka.Message) bool
+
+//Transform 复制前改写消息,返回nil时消息不会被复制,返回错误时复制停止
+//传入的消息已经是复制到目标的消息,topic已经按TopicMap改写,可以直接修改
+type Transform func(msg *kafka.Message) (*kafka.Message, error)
+
+//Options 复制的设置
+type Options struct {
+	Name               string
+	TopicMap           func(topic string) string
+	KeepPartition      bool
+	Filters            []Filter
+	Transforms         []Transform
+	Start              Bound
+	End                Bound
+	Checkpoints        CheckpointStore
+	CheckpointInterval time.Duration
+	DrainTimeout       time.Duration
+}
+
+var DefaultOptions = Options{
+	Name:               "mirror",
+	CheckpointInterval: 5 * time.Second,
+	DrainTimeout:       30 * time.Second,
+}
+
+//WithName 设置复制任务的名字,用于区分检查点,同一个检查点存储中的不同任务需要使用不同的名字
+//@params name string 任务的名字
+func WithName(name string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Name = name
+	})
+}
+
+//WithTopicMap 设置目标topic,默认与源topic相同
+//@params fn func(topic string) string 由源topic得到目标topic
+func WithTopicMap(fn func(topic string) string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.TopicMap = fn
+	})
+}
+
+//WithKeepPartition 发往与源消息相同的分区,默认由目标的分区器按key选择
+func WithKeepPartition() optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.KeepPartition = true
+	})
+}
+
+//WithFilter 添加消息过滤函数,多个过滤函数都返回true的消息才会被复制
+//@params filters ...Filter 消息过滤函数
+func WithFilter(filters ...Filter) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Filters = append(o.Filters, filters...)
+	})
+}
+
+//WithTransform 添加改写函数,按添加的顺序执行
+//@params transforms ...Transform 改写函数
+func WithTransform(transforms ...Transform) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Transforms = append(o.Transforms, transforms...)
+	})
+}
+
+//WithStart 设置开始位置,没有检查点时使用,默认从最早的offset开始
+//@params b Bound 开始位置
+func WithStart(b Bound) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Start = b
+	})
+}
+
+//WithEnd 设置结束位置,所有分区都复制到结束位置后Run返回,默认一直复制
+//@params b Bound 结束位置
+func WithEnd(b Bound) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.End = b
+	})
+}
+
+//WithCheckpoints 设置检查点存储,启动时从检查点继续,运行中定期和结束时保存已经复制完成的位置
+//@params store CheckpointStore 检查点存储
+func WithCheckpoints(store CheckpointStore) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Checkpoints = store
+	})
+}
+
+//WithCheckpointInterval 设置保存检查点的间隔
+//@params interval time.Duration 保存检查点的间隔
+func WithCheckpointInterval(interval time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.CheckpointInterval = interval
+	})
+}
+
+//WithDrainTimeout 设置结束时等待已发送消息的发送报告的最长时间
+//@params timeout time.Duration 等待的最长时间
+func WithDrainTimeout(timeout time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.DrainTimeout = timeout
+	})
+}
@@ -0,0 +1,48 @@
+package msghelper
+
+import "github.com/confluentinc/confluent-kafka-go/kafka"
+
+//HeaderMessageID 约定的消息id所在的header
+const HeaderMessageID = "message-id"
+
+//HeaderCarrier 将kafka消息的headers适配为opentelemetry的`propagation.TextMapCarrier`
+//用于在消息中注入和提取`traceparent`/`tracestate`
+type HeaderCarrier struct {
+	Msg *kafka.Message
+}
+
+//NewHeaderCarrier 创建消息headers的适配器
+//@params msg *kafka.Message 消息指针
+func NewHeaderCarrier(msg *kafka.Message) *HeaderCarrier {
+	return &HeaderCarrier{Msg: msg}
+}
+
+//Get 获取key对应的header值,有多个时取最后一个
+func (c *HeaderCarrier) Get(key string) string {
+	for i := len(c.Msg.Headers) - 1; i >= 0; i-- {
+		if c.Msg.Headers[i].Key == key {
+			return string(c.Msg.Headers[i].Value)
+		}
+	}
+	return ""
+}
+
+//Set 设置header,已有同名header时会被替换
+func (c *HeaderCarrier) Set(key string, value string) {
+	hs := make([]kafka.Header, 0, len(c.Msg.Headers)+1)
+	for _, h := range c.Msg.Headers {
+		if h.Key != key {
+			hs = append(hs, h)
+		}
+	}
+	c.Msg.Headers = append(hs, kafka.Header{Key: key, Value: []byte(value)})
+}
+
+//Keys 列出所有header的key
+func (c *HeaderCarrier) Keys() []string {
+	result := make([]string, 0, len(c.Msg.Headers))
+	for _, h := range c.Msg.Headers {
+		result = append(result, h.Key)
+	}
+	return result
+}
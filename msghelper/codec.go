@@ -0,0 +1,163 @@
+package msghelper
+
+import (
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//ErrInvalidRecord 二进制数据不是有效的消息编码
+var ErrInvalidRecord = errors.New("invalid message record")
+
+//recordVersion 消息二进制编码的版本
+const recordVersion byte = 1
+
+//MarshalMessage 将消息编码为紧凑的二进制格式,
+//包含topic,partition,offset,timestamp,key,value和headers,不包含Opaque和发送结果
+//@params msg *kafka.Message 要编码的消息
+func MarshalMessage(msg *kafka.Message) []byte {
+	buf := make([]byte, 0, 32+len(msg.Key)+len(msg.Value))
+	buf = append(buf, recordVersion)
+	topic := ""
+	if msg.TopicPartition.Topic != nil {
+		topic = *msg.TopicPartition.Topic
+	}
+	buf = appendBytes(buf, []byte(topic))
+	buf = appendVarint(buf, int64(msg.TopicPartition.Partition))
+	buf = appendVarint(buf, int64(msg.TopicPartition.Offset))
+	var ts int64
+	if !msg.Timestamp.IsZero() {
+		ts = msg.Timestamp.UnixNano()
+	}
+	buf = appendVarint(buf, ts)
+	buf = append(buf, byte(msg.TimestampType))
+	buf = appendBytes(buf, msg.Key)
+	buf = appendBytes(buf, msg.Value)
+	buf = appendUvarint(buf, uint64(len(msg.Headers)))
+	for _, h := range msg.Headers {
+		buf = appendBytes(buf, []byte(h.Key))
+		buf = appendBytes(buf, h.Value)
+	}
+	return buf
+}
+
+//UnmarshalMessage 从MarshalMessage的结果中还原消息
+//@params data []byte 消息的二进制编码
+func UnmarshalMessage(data []byte) (*kafka.Message, error) {
+	r := recordReader{data: data}
+	if len(data) == 0 || data[0] != recordVersion {
+		return nil, ErrInvalidRecord
+	}
+	r.pos = 1
+	topic := string(r.bytes())
+	partition := r.varint()
+	offset := r.varint()
+	ts := r.varint()
+	tsType := r.byte()
+	msg := kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: int32(partition), Offset: kafka.Offset(offset)},
+		TimestampType:  kafka.TimestampType(tsType),
+	}
+	if ts != 0 {
+		msg.Timestamp = time.Unix(0, ts)
+	}
+	msg.Key = r.bytes()
+	msg.Value = r.bytes()
+	n := r.uvarint()
+	if r.err == nil && n > uint64(len(data)) {
+		return nil, ErrInvalidRecord
+	}
+	for i := uint64(0); i < n && r.err == nil; i++ {
+		key := string(r.bytes())
+		msg.Headers = append(msg.Headers, kafka.Header{Key: key, Value: r.bytes()})
+	}
+	if r.err != nil || r.pos != len(data) {
+		return nil, ErrInvalidRecord
+	}
+	return &msg, nil
+}
+
+//appendBytes 写入一段可以为nil的字节,长度加1后写入,0表示nil
+func appendBytes(buf []byte, b []byte) []byte {
+	if b == nil {
+		return appendUvarint(buf, 0)
+	}
+	buf = appendUvarint(buf, uint64(len(b))+1)
+	return append(buf, b...)
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+//recordReader 按顺序读取二进制编码,出错后的读取都返回零值
+type recordReader struct {
+	data []byte
+	pos  int
+	err  error
+}
+
+func (r *recordReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.data[r.pos:])
+	if n <= 0 {
+		r.err = ErrInvalidRecord
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *recordReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.data[r.pos:])
+	if n <= 0 {
+		r.err = ErrInvalidRecord
+		return 0
+	}
+	r.pos += n
+	return v
+}
+
+func (r *recordReader) byte() byte {
+	if r.err != nil {
+		return 0
+	}
+	if r.pos >= len(r.data) {
+		r.err = ErrInvalidRecord
+		return 0
+	}
+	b := r.data[r.pos]
+	r.pos += 1
+	return b
+}
+
+func (r *recordReader) bytes() []byte {
+	n := r.uvarint()
+	if r.err != nil || n == 0 {
+		return nil
+	}
+	n -= 1
+	if n > uint64(len(r.data)-r.pos) {
+		r.err = ErrInvalidRecord
+		return nil
+	}
+	b := make([]byte, n)
+	copy(b, r.data[r.pos:])
+	r.pos += int(n)
+	return b
+}
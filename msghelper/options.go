@@ -53,3 +53,9 @@ func WithPartition(partition int32) optparams.Option[kafka.Message] {
 		o.TopicPartition.Partition = partition
 	})
 }
+
+//WithMessageID 设置消息id,会放在header`message-id`中
+//@params id string 消息id
+func WithMessageID(id string) optparams.Option[kafka.Message] {
+	return AddHeader(HeaderMessageID, []byte(id))
+}
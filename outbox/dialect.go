@@ -0,0 +1,109 @@
+package outbox
+
+import (
+	"fmt"
+
+	"github.com/Golang-Tools/kafkahelper/internal/sqldialect"
+)
+
+//Dialect 不同数据库的sql差异
+type Dialect struct {
+	//Name 方言的名字
+	Name string
+	//Placeholder 第i个参数的占位符,i从1开始
+	Placeholder func(i int) string
+	//SkipLocked 是否支持`SELECT ... FOR UPDATE SKIP LOCKED`,支持时领取租约前用它跳过其他转发进程正在领取的行
+	SkipLocked bool
+	//Schema 建表语句
+	Schema func(table string) []string
+}
+
+//Rebind 将语句中的`?`占位符替换为方言的写法
+//@params query string 使用`?`占位符的语句
+func (d Dialect) Rebind(query string) string {
+	return sqldialect.Rebind(query, d.Placeholder)
+}
+
+//Postgres PostgreSQL 9.5+,使用`FOR UPDATE SKIP LOCKED`选出要领取的行
+var Postgres = Dialect{
+	Name:        "postgres",
+	Placeholder: sqldialect.Dollar,
+	SkipLocked:  true,
+	Schema: func(table string) []string {
+		return []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	id BIGSERIAL PRIMARY KEY,
+	topic VARCHAR(255) NOT NULL,
+	aggregate_key VARCHAR(255) NOT NULL DEFAULT '',
+	msg_key BYTEA,
+	payload BYTEA,
+	headers TEXT,
+	state SMALLINT NOT NULL DEFAULT 0,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at BIGINT NOT NULL DEFAULT 0,
+	claimed_by VARCHAR(64) NOT NULL DEFAULT '',
+	claimed_until BIGINT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at BIGINT NOT NULL,
+	sent_at BIGINT NOT NULL DEFAULT 0
+)`, table),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_pending_idx ON %[1]s (state, aggregate_key, id)`, table),
+		}
+	},
+}
+
+//MySQL MySQL 8.0+,使用`FOR UPDATE SKIP LOCKED`选出要领取的行
+var MySQL = Dialect{
+	Name:        "mysql",
+	Placeholder: sqldialect.QuestionMark,
+	SkipLocked:  true,
+	Schema: func(table string) []string {
+		return []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	id BIGINT AUTO_INCREMENT PRIMARY KEY,
+	topic VARCHAR(255) NOT NULL,
+	aggregate_key VARCHAR(255) NOT NULL DEFAULT '',
+	msg_key LONGBLOB,
+	payload LONGBLOB,
+	headers TEXT,
+	state SMALLINT NOT NULL DEFAULT 0,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at BIGINT NOT NULL DEFAULT 0,
+	claimed_by VARCHAR(64) NOT NULL DEFAULT '',
+	claimed_until BIGINT NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at BIGINT NOT NULL,
+	sent_at BIGINT NOT NULL DEFAULT 0,
+	INDEX %[1]s_pending_idx (state, aggregate_key, id)
+)`, table),
+		}
+	},
+}
+
+//SQLite SQLite 3.8.3+,不支持行锁,直接用UPDATE写入租约
+var SQLite = Dialect{
+	Name:        "sqlite",
+	Placeholder: sqldialect.QuestionMark,
+	SkipLocked:  false,
+	Schema: func(table string) []string {
+		return []string{
+			fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %[1]s (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	topic TEXT NOT NULL,
+	aggregate_key TEXT NOT NULL DEFAULT '',
+	msg_key BLOB,
+	payload BLOB,
+	headers TEXT,
+	state INTEGER NOT NULL DEFAULT 0,
+	attempts INTEGER NOT NULL DEFAULT 0,
+	next_attempt_at INTEGER NOT NULL DEFAULT 0,
+	claimed_by TEXT NOT NULL DEFAULT '',
+	claimed_until INTEGER NOT NULL DEFAULT 0,
+	last_error TEXT,
+	created_at INTEGER NOT NULL,
+	sent_at INTEGER NOT NULL DEFAULT 0
+)`, table),
+			fmt.Sprintf(`CREATE INDEX IF NOT EXISTS %[1]s_pending_idx ON %[1]s (state, aggregate_key, id)`, table),
+		}
+	},
+}
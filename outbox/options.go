@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"time"
+
+	"github.com/Golang-Tools/optparams"
+)
+
+//Options outbox的设置
+type Options struct {
+	Table           string
+	Dialect         Dialect
+	Sender          Sender
+	RelayID         string
+	BatchSize       int
+	PollInterval    time.Duration
+	LeaseDuration   time.Duration
+	RetryBackoff    time.Duration
+	MaxRetryBackoff time.Duration
+	MaxAttempts     int
+	Retention       time.Duration
+	CleanupInterval time.Duration
+}
+
+var DefaultOptions = Options{
+	Table:           "kafka_outbox",
+	Dialect:         Postgres,
+	BatchSize:       100,
+	PollInterval:    time.Second,
+	LeaseDuration:   30 * time.Second,
+	RetryBackoff:    time.Second,
+	MaxRetryBackoff: time.Minute,
+	Retention:       7 * 24 * time.Hour,
+	CleanupInterval: time.Hour,
+}
+
+//WithTable 设置outbox表名,默认为kafka_outbox
+//@params table string 表名
+func WithTable(table string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Table = table
+	})
+}
+
+//WithDialect 设置数据库方言,默认为Postgres
+//@params dialect Dialect 可以使用Postgres,MySQL,SQLite
+func WithDialect(dialect Dialect) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Dialect = dialect
+	})
+}
+
+//WithSender 设置发送消息的对象,默认使用producerproxy.Default
+//@params sender Sender 发送消息并等待确认的对象,比如*producerproxy.ProducerProxy
+func WithSender(sender Sender) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Sender = sender
+	})
+}
+
+//WithRelayID 设置转发进程的标识,领取租约时用于区分领取者,默认为主机名和进程号
+//@params id string 转发进程的标识
+func WithRelayID(id string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.RelayID = id
+	})
+}
+
+//WithBatchSize 设置每次最多领取多少行
+//@params size int 每批的行数
+func WithBatchSize(size int) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.BatchSize = size
+	})
+}
+
+//WithPollInterval 设置没有待发送的行时多久查询一次
+//@params interval time.Duration 查询间隔
+func WithPollInterval(interval time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.PollInterval = interval
+	})
+}
+
+//WithLease 设置领取的租约时长,超过时长还未标记的行可以被其他转发进程重新领取
+//@params d time.Duration 租约时长
+func WithLease(d time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.LeaseDuration = d
+	})
+}
+
+//WithRetry 设置发送失败后的重试
+//@params backoff time.Duration 第一次重试的等待时间,之后每次翻倍
+//@params maxBackoff time.Duration 最长的等待时间
+//@params maxAttempts int 最多尝试次数,达到后该行标记为放弃,同一聚合键的后续行继续发送,小于等于0表示一直重试
+func WithRetry(backoff, maxBackoff time.Duration, maxAttempts int) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.RetryBackoff = backoff
+		o.MaxRetryBackoff = maxBackoff
+		o.MaxAttempts = maxAttempts
+	})
+}
+
+//WithRetention 设置已发送的行保留多久,Run会按interval定时清理
+//@params retention time.Duration 保留时长,小于等于0表示不清理
+//@params interval time.Duration 清理间隔
+func WithRetention(retention, interval time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Retention = retention
+		o.CleanupInterval = interval
+	})
+}
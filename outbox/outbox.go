@@ -0,0 +1,415 @@
+//Package outbox 事务性发件箱
+//业务在同一个数据库事务中写入数据和待发送的消息,转发进程再领取未发送的行,通过生产者代理发送并标记为已发送.
+//同一个聚合键(消息的key)的行按写入顺序发送,前一行发送成功前不会领取后一行.
+//发送是至少一次的,发送成功但标记失败,或者租约过期时消息可能被重复发送
+package outbox
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Logger 模块的logger
+var Logger *log.Log
+
+func init() {
+	Logger = logging.New(log.Dict{"module": "kafka-outbox"})
+}
+
+//ErrNoTopic 消息没有设置topic
+var ErrNoTopic = errors.New("outbox message has no topic")
+
+//行的状态
+const (
+	statePending = 0
+	stateSent    = 1
+	stateDead    = 2
+)
+
+//maxKeyLength aggregate_key列的长度上限
+const maxKeyLength = 255
+
+//Sender 发送消息并等待kafka确认的对象,*producerproxy.ProducerProxy满足这个接口
+type Sender interface {
+	SendAndWaitWithContext(ctx context.Context, msg *kafka.Message) error
+}
+
+//Execer 可以执行sql的对象,*sql.DB,*sql.Tx和*sql.Conn都满足这个接口
+type Execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+}
+
+//Outbox 事务性发件箱
+type Outbox struct {
+	db    *sql.DB
+	Opt   Options
+	claim uint64
+}
+
+//New 创建发件箱
+//@params db *sql.DB 数据库连接
+//@params opts ...optparams.Option[Options] 设置
+func New(db *sql.DB, opts ...optparams.Option[Options]) *Outbox {
+	o := &Outbox{db: db, Opt: DefaultOptions}
+	optparams.GetOption(&o.Opt, opts...)
+	if o.Opt.RelayID == "" {
+		host, _ := os.Hostname()
+		o.Opt.RelayID = fmt.Sprintf("%s-%d", host, os.Getpid())
+	}
+	return o
+}
+
+//sender 发送消息的对象,未设置时使用producerproxy.Default
+func (o *Outbox) sender() Sender {
+	if o.Opt.Sender != nil {
+		return o.Opt.Sender
+	}
+	return producerproxy.Default
+}
+
+//sql 将语句中的表名和`?`占位符替换为方言的写法
+func (o *Outbox) sql(query string) string {
+	return o.Opt.Dialect.Rebind(strings.ReplaceAll(query, "{table}", o.Opt.Table))
+}
+
+//CreateTable 创建outbox表和索引,表已存在时不做修改
+func (o *Outbox) CreateTable(ctx context.Context) error {
+	for _, stmt := range o.Opt.Dialect.Schema(o.Opt.Table) {
+		_, err := o.db.ExecContext(ctx, stmt)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//aggregateKey 由消息的key得到聚合键,过长或不是utf8的key使用sha256
+func aggregateKey(key []byte) string {
+	if len(key) <= maxKeyLength && utf8.Valid(key) {
+		return string(key)
+	}
+	sum := sha256.Sum256(key)
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+//Add 在业务的事务中写入一条待发送的消息,消息的key作为聚合键,同一个key的消息按写入顺序发送
+//@params ctx context.Context 请求的上下文
+//@params exec Execer 通常是业务使用的*sql.Tx
+//@params msg *kafka.Message 要发送的消息,partition会被忽略
+func (o *Outbox) Add(ctx context.Context, exec Execer, msg *kafka.Message) error {
+	if msg.TopicPartition.Topic == nil || *msg.TopicPartition.Topic == "" {
+		return ErrNoTopic
+	}
+	var headers []byte
+	if len(msg.Headers) > 0 {
+		var err error
+		headers, err = json.Marshal(msg.Headers)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := exec.ExecContext(ctx, o.sql(`INSERT INTO {table} (topic, aggregate_key, msg_key, payload, headers, created_at) VALUES (?, ?, ?, ?, ?, ?)`),
+		*msg.TopicPartition.Topic, aggregateKey(msg.Key), msg.Key, msg.Value, string(headers), time.Now().UnixMilli())
+	return err
+}
+
+//row 领取到的一行
+type row struct {
+	id           int64
+	topic        string
+	aggregateKey string
+	key          []byte
+	payload      []byte
+	headers      sql.NullString
+	attempts     int
+	createdAt    int64
+}
+
+//message 将行还原为消息
+func (r *row) message() (*kafka.Message, error) {
+	topic := r.topic
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny},
+		Key:            r.key,
+		Value:          r.payload,
+		Timestamp:      time.UnixMilli(r.createdAt),
+	}
+	if r.headers.Valid && r.headers.String != "" {
+		err := json.Unmarshal([]byte(r.headers.String), &msg.Headers)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return msg, nil
+}
+
+const selectColumns = `o.id, o.topic, o.aggregate_key, o.msg_key, o.payload, o.headers, o.attempts, o.created_at`
+
+//headCondition 只领取每个聚合键最早的未发送行,空聚合键的行互不影响
+const headCondition = `o.state = 0 AND o.next_attempt_at <= ? AND NOT EXISTS (
+	SELECT 1 FROM {table} p WHERE p.state = 0 AND o.aggregate_key <> '' AND p.aggregate_key = o.aggregate_key AND p.id < o.id)`
+
+func scanRows(rows *sql.Rows) ([]*row, error) {
+	defer rows.Close()
+	result := []*row{}
+	for rows.Next() {
+		r := new(row)
+		err := rows.Scan(&r.id, &r.topic, &r.aggregateKey, &r.key, &r.payload, &r.headers, &r.attempts, &r.createdAt)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, r)
+	}
+	return result, rows.Err()
+}
+
+//result 一行的发送结果
+type result struct {
+	attempted bool
+	err       error
+}
+
+//RelayOnce 领取一批未发送的行,发送后标记结果
+//领取时在短事务中写入租约,方言支持SKIP LOCKED时用它跳过其他转发进程正在领取的行;发送和标记都在事务之外,等待kafka确认时不占用数据库事务
+//@returns int 发送成功的行数
+func (o *Outbox) RelayOnce(ctx context.Context) (int, error) {
+	now := time.Now()
+	claimer := fmt.Sprintf("%s-%d", o.Opt.RelayID, atomic.AddUint64(&o.claim, 1))
+	if len(claimer) > 64 {
+		claimer = claimer[len(claimer)-64:]
+	}
+	var err error
+	if o.Opt.Dialect.SkipLocked {
+		err = o.claimLocked(ctx, claimer, now)
+	} else {
+		err = o.claimLeased(ctx, claimer, now)
+	}
+	if err != nil {
+		return 0, err
+	}
+	rows, err := o.db.QueryContext(ctx, o.sql(`SELECT `+selectColumns+` FROM {table} o WHERE o.claimed_by = ? AND o.state = 0 ORDER BY o.id`), claimer)
+	if err != nil {
+		return 0, err
+	}
+	claimed, err := scanRows(rows)
+	if err != nil || len(claimed) == 0 {
+		return 0, err
+	}
+	results := o.publish(ctx, claimed)
+	//发送结果已经确定,ctx结束也要标记,否则发送成功的行会在租约过期后被重复发送
+	tx, err := o.db.BeginTx(context.Background(), nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	sent, err := o.mark(context.Background(), tx, claimed, results, claimer)
+	if err != nil {
+		return 0, err
+	}
+	return sent, tx.Commit()
+}
+
+//claimLocked 在事务中使用SELECT ... FOR UPDATE SKIP LOCKED选出行并写入租约
+func (o *Outbox) claimLocked(ctx context.Context, claimer string, now time.Time) error {
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	rows, err := tx.QueryContext(ctx, o.sql(`SELECT o.id FROM {table} o WHERE `+headCondition+` AND o.claimed_until < ? ORDER BY o.id LIMIT ? FOR UPDATE SKIP LOCKED`),
+		now.UnixMilli(), now.UnixMilli(), o.Opt.BatchSize)
+	if err != nil {
+		return err
+	}
+	args := []any{claimer, now.Add(o.Opt.LeaseDuration).UnixMilli()}
+	marks := []string{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		args = append(args, id)
+		marks = append(marks, "?")
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(marks) == 0 {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, o.sql(`UPDATE {table} SET claimed_by = ?, claimed_until = ? WHERE id IN (`+strings.Join(marks, ", ")+`)`), args...)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+//claimLeased 不支持行锁的数据库直接用一条UPDATE写入租约
+func (o *Outbox) claimLeased(ctx context.Context, claimer string, now time.Time) error {
+	_, err := o.db.ExecContext(ctx, o.sql(`UPDATE {table} SET claimed_by = ?, claimed_until = ? WHERE id IN (
+	SELECT o.id FROM {table} o WHERE `+headCondition+` AND o.claimed_until < ? ORDER BY o.id LIMIT ?)`),
+		claimer, now.Add(o.Opt.LeaseDuration).UnixMilli(), now.UnixMilli(), now.UnixMilli(), o.Opt.BatchSize)
+	return err
+}
+
+//publish 发送领取的行,同一个聚合键的行按顺序依次发送,前一行失败时后面的行不再发送,不同聚合键并行发送
+func (o *Outbox) publish(ctx context.Context, claimed []*row) []result {
+	results := make([]result, len(claimed))
+	groups := map[string][]int{}
+	order := []string{}
+	for i, r := range claimed {
+		key := r.aggregateKey
+		if key == "" {
+			key = fmt.Sprintf("\x00%d", r.id)
+		}
+		if _, ok := groups[key]; !ok {
+			order = append(order, key)
+		}
+		groups[key] = append(groups[key], i)
+	}
+	sender := o.sender()
+	var wg sync.WaitGroup
+	for _, key := range order {
+		wg.Add(1)
+		go func(idx []int) {
+			defer wg.Done()
+			for _, i := range idx {
+				if ctx.Err() != nil {
+					return
+				}
+				msg, err := claimed[i].message()
+				if err == nil {
+					err = sender.SendAndWaitWithContext(ctx, msg)
+				}
+				if err != nil && ctx.Err() != nil {
+					return
+				}
+				results[i] = result{attempted: true, err: err}
+				if err != nil {
+					return
+				}
+			}
+		}(groups[key])
+	}
+	wg.Wait()
+	return results
+}
+
+//backoff 第attempts次失败后的等待时间
+func (o *Outbox) backoff(attempts int) time.Duration {
+	d := o.Opt.RetryBackoff
+	for i := 1; i < attempts && d < o.Opt.MaxRetryBackoff; i++ {
+		d *= 2
+	}
+	if o.Opt.MaxRetryBackoff > 0 && d > o.Opt.MaxRetryBackoff {
+		d = o.Opt.MaxRetryBackoff
+	}
+	return d
+}
+
+//mark 标记发送结果,发送失败的行增加尝试次数并推迟下次尝试,没有尝试的行释放租约
+func (o *Outbox) mark(ctx context.Context, tx *sql.Tx, claimed []*row, results []result, claimer string) (int, error) {
+	now := time.Now()
+	sent := 0
+	for i, r := range claimed {
+		res := results[i]
+		var err error
+		switch {
+		case !res.attempted:
+			_, err = tx.ExecContext(ctx, o.sql(`UPDATE {table} SET claimed_until = 0 WHERE id = ? AND claimed_by = ?`), r.id, claimer)
+		case res.err == nil:
+			sent += 1
+			_, err = tx.ExecContext(ctx, o.sql(`UPDATE {table} SET state = ?, sent_at = ?, claimed_until = 0 WHERE id = ?`), stateSent, now.UnixMilli(), r.id)
+		default:
+			attempts := r.attempts + 1
+			state := statePending
+			if o.Opt.MaxAttempts > 0 && attempts >= o.Opt.MaxAttempts {
+				state = stateDead
+				Logger.Error("outbox message give up", log.Dict{"id": r.id, "topic": r.topic, "attempts": attempts, "err": res.err})
+			} else {
+				Logger.Warn("outbox message send failed", log.Dict{"id": r.id, "topic": r.topic, "attempts": attempts, "err": res.err})
+			}
+			_, err = tx.ExecContext(ctx, o.sql(`UPDATE {table} SET state = ?, attempts = ?, next_attempt_at = ?, last_error = ?, claimed_until = 0 WHERE id = ?`),
+				state, attempts, now.Add(o.backoff(attempts)).UnixMilli(), res.err.Error(), r.id)
+		}
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+//Cleanup 删除发送时间早于保留时长的已发送行,放弃发送的行会保留用于排查
+//@returns int64 删除的行数
+func (o *Outbox) Cleanup(ctx context.Context) (int64, error) {
+	if o.Opt.Retention <= 0 {
+		return 0, nil
+	}
+	res, err := o.db.ExecContext(ctx, o.sql(`DELETE FROM {table} WHERE state = ? AND sent_at < ?`), stateSent, time.Now().Add(-o.Opt.Retention).UnixMilli())
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+//Pending 还未发送的行数,不包括放弃发送的行
+func (o *Outbox) Pending(ctx context.Context) (int64, error) {
+	var n int64
+	err := o.db.QueryRowContext(ctx, o.sql(`SELECT COUNT(*) FROM {table} WHERE state = ?`), statePending).Scan(&n)
+	return n, err
+}
+
+//Run 持续转发直到ctx结束,有待发送的行时连续领取,否则按PollInterval查询,并按CleanupInterval清理已发送的行
+//@params ctx context.Context 控制转发的生命周期,结束时返回nil
+func (o *Outbox) Run(ctx context.Context) error {
+	poll := time.NewTimer(0)
+	defer poll.Stop()
+	var cleanup <-chan time.Time
+	if o.Opt.Retention > 0 && o.Opt.CleanupInterval > 0 {
+		ticker := time.NewTicker(o.Opt.CleanupInterval)
+		defer ticker.Stop()
+		cleanup = ticker.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-cleanup:
+			n, err := o.Cleanup(ctx)
+			if err != nil {
+				Logger.Error("outbox cleanup get error", log.Dict{"err": err})
+			} else if n > 0 {
+				Logger.Debug("outbox cleanup", log.Dict{"deleted": n})
+			}
+		case <-poll.C:
+			n, err := o.RelayOnce(ctx)
+			if err != nil && ctx.Err() == nil {
+				Logger.Error("outbox relay get error", log.Dict{"err": err})
+			}
+			if n > 0 && err == nil {
+				poll.Reset(0)
+			} else {
+				poll.Reset(o.Opt.PollInterval)
+			}
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+//fakeSender 记录发送的消息,fail返回非nil时发送失败
+type fakeSender struct {
+	lock sync.Mutex
+	sent []string
+	fail func(msg *kafka.Message) error
+}
+
+func (s *fakeSender) SendAndWaitWithContext(ctx context.Context, msg *kafka.Message) error {
+	if s.fail != nil {
+		if err := s.fail(msg); err != nil {
+			return err
+		}
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.sent = append(s.sent, string(msg.Value))
+	return nil
+}
+
+func (s *fakeSender) values() []string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]string{}, s.sent...)
+}
+
+func newTestOutbox(t *testing.T, sender Sender) *Outbox {
+	t.Helper()
+	db, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "outbox.db")+"?_busy_timeout=5000")
+	if err != nil {
+		t.Fatalf("open sqlite: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+	o := New(db, WithDialect(SQLite), WithSender(sender))
+	if err := o.CreateTable(context.Background()); err != nil {
+		t.Fatalf("CreateTable: %v", err)
+	}
+	return o
+}
+
+//add 在一个事务中写入消息
+func add(t *testing.T, o *Outbox, key string, values ...string) {
+	t.Helper()
+	ctx := context.Background()
+	tx, err := o.db.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatalf("BeginTx: %v", err)
+	}
+	topic := "events"
+	for _, v := range values {
+		msg := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Value: []byte(v)}
+		if key != "" {
+			msg.Key = []byte(key)
+		}
+		if err := o.Add(ctx, tx, msg); err != nil {
+			t.Fatalf("Add: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+}
+
+func pending(t *testing.T, o *Outbox) int64 {
+	t.Helper()
+	n, err := o.Pending(context.Background())
+	if err != nil {
+		t.Fatalf("Pending: %v", err)
+	}
+	return n
+}
+
+func TestRelayOnceSendsAndMarks(t *testing.T) {
+	sender := &fakeSender{}
+	o := newTestOutbox(t, sender)
+	add(t, o, "order-1", "a1", "a2")
+	add(t, o, "", "b1")
+	sent, err := o.RelayOnce(context.Background())
+	if err != nil {
+		t.Fatalf("RelayOnce: %v", err)
+	}
+	//同一个聚合键每次只领取最早的一行
+	if sent != 2 {
+		t.Fatalf("expect 2 sent, got %d", sent)
+	}
+	sent, err = o.RelayOnce(context.Background())
+	if err != nil || sent != 1 {
+		t.Fatalf("expect 1 sent, got %d, %v", sent, err)
+	}
+	if n := pending(t, o); n != 0 {
+		t.Fatalf("expect no pending rows, got %d", n)
+	}
+	got := sender.values()
+	if len(got) != 3 {
+		t.Fatalf("expect 3 messages, got %v", got)
+	}
+	first := map[string]int{}
+	for i, v := range got {
+		first[v] = i
+	}
+	if first["a1"] > first["a2"] {
+		t.Fatalf("messages with the same key should keep order, got %v", got)
+	}
+}
+
+func TestRelayOnceRetriesFailed(t *testing.T) {
+	errDown := errors.New("kafka down")
+	sender := &fakeSender{fail: func(msg *kafka.Message) error {
+		if string(msg.Value) == "a1" {
+			return errDown
+		}
+		return nil
+	}}
+	o := newTestOutbox(t, sender)
+	o.Opt.RetryBackoff = 0
+	add(t, o, "order-1", "a1", "a2")
+	sent, err := o.RelayOnce(context.Background())
+	if err != nil || sent != 0 {
+		t.Fatalf("expect 0 sent, got %d, %v", sent, err)
+	}
+	var attempts int
+	var lastError string
+	err = o.db.QueryRow(o.sql(`SELECT attempts, last_error FROM {table} WHERE payload = ?`), []byte("a1")).Scan(&attempts, &lastError)
+	if err != nil {
+		t.Fatalf("query: %v", err)
+	}
+	if attempts != 1 || lastError != errDown.Error() {
+		t.Fatalf("expect 1 attempt with %q, got %d %q", errDown, attempts, lastError)
+	}
+	if got := sender.values(); len(got) != 0 {
+		t.Fatalf("a2 should wait for a1, got %v", got)
+	}
+	sender.fail = nil
+	for i := 0; i < 2; i++ {
+		if _, err := o.RelayOnce(context.Background()); err != nil {
+			t.Fatalf("RelayOnce: %v", err)
+		}
+	}
+	if got := sender.values(); len(got) != 2 || got[0] != "a1" || got[1] != "a2" {
+		t.Fatalf("expect [a1 a2], got %v", got)
+	}
+}
+
+func TestRelayOnceMarksAfterCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	//发送成功后ctx立即结束,发送结果仍要被标记
+	sender := &fakeSender{fail: func(msg *kafka.Message) error {
+		cancel()
+		return nil
+	}}
+	o := newTestOutbox(t, sender)
+	add(t, o, "", "c1")
+	sent, err := o.RelayOnce(ctx)
+	if err != nil || sent != 1 {
+		t.Fatalf("expect 1 sent, got %d, %v", sent, err)
+	}
+	if n := pending(t, o); n != 0 {
+		t.Fatalf("sent row should be marked after cancel, %d pending", n)
+	}
+}
+
+func TestRelayOnceDoesNotHoldTransaction(t *testing.T) {
+	var o *Outbox
+	//发送时写入新的行,转发在发送期间持有写事务时会因为数据库被锁而失败
+	sender := &fakeSender{fail: func(msg *kafka.Message) error {
+		if string(msg.Value) != "d1" {
+			return nil
+		}
+		topic := "events"
+		return o.Add(context.Background(), o.db, &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}, Value: []byte("d2")})
+	}}
+	o = newTestOutbox(t, sender)
+	add(t, o, "", "d1")
+	sent, err := o.RelayOnce(context.Background())
+	if err != nil || sent != 1 {
+		t.Fatalf("expect 1 sent, got %d, %v", sent, err)
+	}
+	if n := pending(t, o); n != 1 {
+		t.Fatalf("expect d2 pending, got %d", n)
+	}
+}
@@ -0,0 +1,45 @@
+package producerproxy
+
+import (
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Producer 代理使用到的生产者方法,*kafka.Producer满足该接口,单元测试中可以使用kafkatest模块中的内存实现
+//需要调用接口之外的方法时可以断言为*kafka.Producer
+type Producer interface {
+	Produce(msg *kafka.Message, deliveryChan chan kafka.Event) error
+	Events() chan kafka.Event
+	Logs() chan kafka.LogEvent
+	Flush(timeoutMs int) int
+	Len() int
+	Close()
+	GetFatalError() error
+	SetOAuthBearerToken(oauthBearerToken kafka.OAuthBearerToken) error
+	SetOAuthBearerTokenFailure(errstr string) error
+}
+
+var _ Producer = (*kafka.Producer)(nil)
+
+//ClientFactory 由设置创建生产者的函数
+type ClientFactory func(conf *kafka.ConfigMap) (Producer, error)
+
+//WithClientFactory 设置创建生产者的函数,Init和Recreate都会使用它,默认使用kafka.NewProducer
+//@params factory ClientFactory 创建生产者的函数
+func WithClientFactory(factory ClientFactory) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.ClientFactory = factory
+	})
+}
+
+//newClient 按设置创建生产者
+func (proxy *ProducerProxy) newClient() (Producer, error) {
+	if proxy.Opt.ClientFactory != nil {
+		return proxy.Opt.ClientFactory(&proxy.Opt.ConfigMap)
+	}
+	cli, err := kafka.NewProducer(&proxy.Opt.ConfigMap)
+	if err != nil {
+		return nil, err
+	}
+	return cli, nil
+}
@@ -1,6 +1,12 @@
 package producerproxy
 
-import "errors"
+import (
+	"errors"
+
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	"github.com/Golang-Tools/kafkahelper/internal/registry"
+	"github.com/Golang-Tools/kafkahelper/internal/spool"
+)
 
 //ErrProxyAllreadySettedClient 代理已经设置过redis客户端对象
 var ErrProxyAllreadySettedClient = errors.New("cannot reset producer")
@@ -10,3 +16,39 @@ var ErrProxyNotYetSettedClient = errors.New("not set producer yet")
 
 //ErrDeliverIsWatching 代理还未设置客户端对象
 var ErrDeliverIsWatching = errors.New("can not set callback when deliver is watching")
+
+//ErrProxyAllreadyRegistered 注册表中已经有同名的代理
+var ErrProxyAllreadyRegistered = registry.ErrAlreadyRegistered
+
+//ErrProxyNotRegistered 注册表中没有这个名字的代理
+var ErrProxyNotRegistered = registry.ErrNotRegistered
+
+//ErrProxyClosed 代理已经关闭
+var ErrProxyClosed = errors.New("producer proxy closed")
+
+//ErrProducerRecreated 生产者在收到发送报告前被重建,消息是否发送成功未知
+var ErrProducerRecreated = errors.New("producer recreated before delivery report")
+
+//ErrQueueFull 代理的发送队列已满
+var ErrQueueFull = errors.New("producer send queue is full")
+
+//ErrSpoolFull 本地暂存区已经达到大小上限
+var ErrSpoolFull = spool.ErrFull
+
+//ErrSpoolClosed 本地暂存区已经关闭
+var ErrSpoolClosed = spool.ErrClosed
+
+//ErrConfigUnknownKey 设置中有未知的设置项
+var ErrConfigUnknownKey = kafkaconf.ErrUnknownKey
+
+//ErrConfigInvalidValue 设置项的值类型或取值不对
+var ErrConfigInvalidValue = kafkaconf.ErrInvalidValue
+
+//ErrConfigWrongRole 设置项不适用于这类客户端
+var ErrConfigWrongRole = kafkaconf.ErrWrongRole
+
+//ErrConfigMissingKey 缺少必须的设置项
+var ErrConfigMissingKey = kafkaconf.ErrMissingKey
+
+//ErrConfigConflict 设置项之间相互冲突
+var ErrConfigConflict = kafkaconf.ErrConflict
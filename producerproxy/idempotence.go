@@ -0,0 +1,101 @@
+package producerproxy
+
+import (
+	"sync/atomic"
+
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//FatalErrorCallback 生产者发生致命错误时的回调,此时生产者已经不可用,可以调用proxy.Recreate重建
+type FatalErrorCallback func(proxy *ProducerProxy, err kafka.Error)
+
+//WithIdempotence 开启幂等生产者,会同时设置acks=all,max.in.flight.requests.per.connection=5和无限重试
+//幂等生产者出现致命错误后将不可用,可以使用OnFatalError或WithRecreateOnFatalError处理
+func WithIdempotence() optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		for _, alias := range []string{"request.required.acks", "max.in.flight", "message.send.max.retries"} {
+			delete(o.ConfigMap, alias)
+		}
+		o.ConfigMap["enable.idempotence"] = true
+		o.ConfigMap["acks"] = "all"
+		o.ConfigMap["max.in.flight.requests.per.connection"] = 5
+		o.ConfigMap["retries"] = 2147483647
+	})
+}
+
+//WithRecreateOnFatalError 生产者发生致命错误时自动重建
+func WithRecreateOnFatalError() optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.RecreateOnFatalError = true
+	})
+}
+
+//OnFatalError 注册生产者发生致命错误时执行的回调,回调会在新的goroutine中执行
+//@params cb ...FatalErrorCallback 发生致命错误时执行的回调
+func (proxy *ProducerProxy) OnFatalError(cb ...FatalErrorCallback) error {
+	if proxy.IsWatchingDeliver() {
+		return ErrDeliverIsWatching
+	}
+	proxy.fatalErrorCallback = append(proxy.fatalErrorCallback, cb...)
+	return nil
+}
+
+func (proxy *ProducerProxy) onFatalError(err kafka.Error) {
+	if fatal := proxy.GetFatalError(); fatal != nil {
+		proxy.logger().Error("producer get fatal error", log.Dict{"err": fatal})
+	} else {
+		proxy.logger().Error("producer get fatal error", log.Dict{"err": err})
+	}
+	for _, cb := range proxy.fatalErrorCallback {
+		go cb(proxy, err)
+	}
+	if proxy.Opt.RecreateOnFatalError {
+		go func() {
+			err := proxy.Recreate()
+			if err != nil {
+				proxy.logger().Error("recreate producer get error", log.Dict{"err": err})
+			} else {
+				proxy.logger().Info("producer recreated")
+			}
+		}()
+	}
+}
+
+//Recreate 关闭当前的生产者并使用相同的设置创建新的生产者,用于从致命错误中恢复
+//新的生产者创建成功后才会替换旧的,创建失败时代理保持原样;
+//未发送的消息会保留在代理的发送队列中,由新的生产者继续按顺序发送;已交给旧生产者的消息会丢失
+//连接回调会在替换后重新执行
+func (proxy *ProducerProxy) Recreate() error {
+	cli, err := proxy.newClient()
+	if err != nil {
+		return err
+	}
+	proxy.clientLock.Lock()
+	if !proxy.IsOk() || proxy.closed {
+		closed := proxy.closed
+		proxy.clientLock.Unlock()
+		cli.Close()
+		if closed {
+			return ErrProxyClosed
+		}
+		return ErrProxyNotYetSettedClient
+	}
+	done := proxy.watchDone
+	proxy.Producer.Close()
+	proxy.Producer = cli
+	last := proxy.lastToken()
+	//旧的监听退出到新的监听启动之间仍然记录发往新生产者的消息
+	atomic.AddInt32(&proxy.deliver_watching, 1)
+	proxy.clientLock.Unlock()
+	defer atomic.AddInt32(&proxy.deliver_watching, -1)
+	if done != nil {
+		<-done
+	}
+	proxy.abandonInflightUntil(last, ErrProducerRecreated)
+	return proxy.connected(cli)
+}
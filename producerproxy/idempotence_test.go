@@ -0,0 +1,76 @@
+package producerproxy_test
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//flakyFactory 在fail为1时创建生产者失败
+func flakyFactory(b *kafkatest.Broker, fail *int32) producerproxy.ClientFactory {
+	factory := b.ProducerFactory()
+	return func(conf *kafka.ConfigMap) (producerproxy.Producer, error) {
+		if atomic.LoadInt32(fail) == 1 {
+			return nil, errors.New("broker unreachable")
+		}
+		return factory(conf)
+	}
+}
+
+func TestRecreateKeepsSending(t *testing.T) {
+	b := kafkatest.NewBroker()
+	var connects int32
+	proxy := producerproxy.New()
+	proxy.Regist(func(cli producerproxy.Producer) error {
+		atomic.AddInt32(&connects, 1)
+		return nil
+	})
+	if err := proxy.Init(kafkatest.Endpoint, producerproxy.WithClientFactory(b.ProducerFactory())); err != nil {
+		t.Fatalf("Init: %v", err)
+	}
+	defer proxy.Close()
+	topic := "recreated"
+	msg := func() *kafka.Message {
+		return &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny}, Value: []byte("v")}
+	}
+	if err := proxy.SendAndWait(msg()); err != nil {
+		t.Fatalf("SendAndWait: %v", err)
+	}
+	if err := proxy.Recreate(); err != nil {
+		t.Fatalf("Recreate: %v", err)
+	}
+	if !proxy.IsWatchingDeliver() {
+		t.Fatal("recreated producer should watch delivery reports")
+	}
+	if err := proxy.SendAndWait(msg()); err != nil {
+		t.Fatalf("SendAndWait after Recreate: %v", err)
+	}
+	if n := atomic.LoadInt32(&connects); n != 2 {
+		t.Fatalf("connect callbacks should run again after Recreate, ran %d times", n)
+	}
+	if n := proxy.DeliveredRecords(); n != 2 {
+		t.Fatalf("expect 2 delivered records, got %d", n)
+	}
+}
+
+func TestRecreateFailureKeepsClient(t *testing.T) {
+	b := kafkatest.NewBroker()
+	var fail int32
+	proxy, err := b.NewProducerProxy(producerproxy.WithClientFactory(flakyFactory(b, &fail)))
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	defer proxy.Close()
+	atomic.StoreInt32(&fail, 1)
+	if err := proxy.Recreate(); err == nil {
+		t.Fatal("Recreate should return the error of the client factory")
+	}
+	topic := "kept"
+	if err := proxy.SendAndWait(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny}, Value: []byte("v")}); err != nil {
+		t.Fatalf("old producer should keep working after a failed Recreate: %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package producerproxy
+
+import (
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//goKeys go端设置项,在配置文件中可以写作`parallel_callback`,环境变量中写作`KAFKA_PARALLEL_CALLBACK`
+var goKeys = map[string]kafkaconf.Type{
+	"name":                    kafkaconf.String,
+	"parallel.callback":       kafkaconf.Bool,
+	"not.confirm.delivery":    kafkaconf.Bool,
+	"send.queue.size":         kafkaconf.Int,
+	"queue.full.policy":       kafkaconf.String,
+	"queue.full.timeout.ms":   kafkaconf.Int,
+	"spool.dir":               kafkaconf.String,
+	"spool.max.bytes":         kafkaconf.Int,
+	"spool.segment.bytes":     kafkaconf.Int,
+	"spool.retention.ms":      kafkaconf.Int,
+	"spool.sync":              kafkaconf.String,
+	"spool.sync.interval.ms":  kafkaconf.Int,
+	"spool.retry.interval.ms": kafkaconf.Int,
+}
+
+func optionsFrom(raw map[string]any) (optparams.Option[Options], error) {
+	cm, flags, err := kafkaconf.Parse(raw, goKeys)
+	if err != nil {
+		return nil, err
+	}
+	var policy QueueFullPolicy
+	if v, ok := flags["queue.full.policy"]; ok {
+		policy, err = ParseQueueFullPolicy(v.(string))
+		if err != nil {
+			return nil, err
+		}
+	}
+	var syncPolicy SpoolSyncPolicy
+	if v, ok := flags["spool.sync"]; ok {
+		syncPolicy, err = ParseSpoolSyncPolicy(v.(string))
+		if err != nil {
+			return nil, err
+		}
+	}
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		for k, v := range cm {
+			o.ConfigMap[k] = v
+		}
+		if v, ok := flags["name"]; ok {
+			o.Name = v.(string)
+		}
+		if v, ok := flags["parallel.callback"]; ok {
+			o.ParallelCallback = v.(bool)
+		}
+		if v, ok := flags["send.queue.size"]; ok {
+			o.SendQueueSize = v.(int)
+		}
+		if _, ok := flags["queue.full.policy"]; ok {
+			o.QueueFullPolicy = policy
+		}
+		if v, ok := flags["queue.full.timeout.ms"]; ok {
+			o.QueueFullTimeout = time.Duration(v.(int)) * time.Millisecond
+		}
+		if v, ok := flags["spool.dir"]; ok {
+			o.SpoolDir = v.(string)
+		}
+		if v, ok := flags["spool.max.bytes"]; ok {
+			o.SpoolMaxBytes = int64(v.(int))
+		}
+		if v, ok := flags["spool.segment.bytes"]; ok {
+			o.SpoolSegmentBytes = int64(v.(int))
+		}
+		if v, ok := flags["spool.retention.ms"]; ok {
+			o.SpoolRetention = time.Duration(v.(int)) * time.Millisecond
+		}
+		if _, ok := flags["spool.sync"]; ok {
+			o.SpoolSync = syncPolicy
+		}
+		if v, ok := flags["spool.sync.interval.ms"]; ok {
+			o.SpoolSyncInterval = time.Duration(v.(int)) * time.Millisecond
+		}
+		if v, ok := flags["spool.retry.interval.ms"]; ok {
+			o.SpoolRetryInterval = time.Duration(v.(int)) * time.Millisecond
+		}
+		if v, ok := flags["not.confirm.delivery"]; ok {
+			o.NotConfirmDelivery = v.(bool)
+			if o.NotConfirmDelivery {
+				o.ConfigMap["go.delivery.reports"] = false
+			}
+		}
+	}), nil
+}
+
+//OptionsFromFile 从配置文件中读取代理的设置,根据扩展名支持yaml,yml,json和toml
+//librdkafka的设置项可以平铺也可以按`.`嵌套,go端的设置使用`name`,`parallel_callback`,`not_confirm_delivery`,
+//`send_queue_size`,`queue_full_policy`,`queue_full_timeout_ms`和`spool_`开头的暂存区设置
+//未知的设置项和类型不对的值会返回错误
+//@params path string 配置文件路径
+func OptionsFromFile(path string) (optparams.Option[Options], error) {
+	raw, err := kafkaconf.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return optionsFrom(raw)
+}
+
+//OptionsFromEnv 从环境变量中读取代理的设置,比如`KAFKA_BOOTSTRAP_SERVERS`对应`bootstrap.servers`,`KAFKA_NOT_CONFIRM_DELIVERY`对应`NotConfirmDelivery`
+//与前缀相同但不是设置项的环境变量(如`KAFKA_PORT`,`KAFKA_OPTS`)会被跳过并打印警告,类型不对的值会返回错误
+//@params prefix string 环境变量前缀,为空时使用`KAFKA_`
+func OptionsFromEnv(prefix string) (optparams.Option[Options], error) {
+	raw := kafkaconf.ReadEnv(prefix)
+	for _, k := range kafkaconf.DropUnknown(raw, goKeys) {
+		Logger.Warn("ignore unknown setting from environment", log.Dict{"key": k})
+	}
+	return optionsFrom(raw)
+}
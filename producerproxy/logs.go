@@ -0,0 +1,61 @@
+package producerproxy
+
+import (
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//logger 代理使用的logger,未设置WithLogger时使用模块的Logger,代理有名字时会带上proxy字段
+func (proxy *ProducerProxy) logger() *log.Log {
+	if proxy.Opt.Logger != nil {
+		return proxy.Opt.Logger
+	}
+	if proxy.namedLogger != nil {
+		return proxy.namedLogger
+	}
+	return Logger
+}
+
+//setName 设置代理的名字,之后代理打印的日志都会带上proxy字段
+func (proxy *ProducerProxy) setName(name string) {
+	proxy.Opt.Name = name
+	proxy.refreshLogger()
+}
+
+//refreshLogger 代理名字变化时重新创建带有代理名字段的logger
+func (proxy *ProducerProxy) refreshLogger() {
+	if proxy.Opt.Name == "" || proxy.loggerName == proxy.Opt.Name {
+		return
+	}
+	proxy.namedLogger = newLogger(proxy.Opt.Name)
+	proxy.loggerName = proxy.Opt.Name
+}
+
+//pumpLogs 将librdkafka的日志转发到代理的logger中,需要设置WithLibrdkafkaLogs
+func (proxy *ProducerProxy) pumpLogs(logs chan kafka.LogEvent) {
+	for ev := range logs {
+		fields := log.Dict{
+			"name":      ev.Name,
+			"tag":       ev.Tag,
+			"timestamp": ev.Timestamp,
+			"level":     ev.Level,
+		}
+		//librdkafka使用syslog的日志等级,数值越小越严重
+		switch {
+		case ev.Level <= 3:
+			proxy.logger().Error(ev.Message, fields)
+		case ev.Level == 4:
+			proxy.logger().Warn(ev.Message, fields)
+		case ev.Level <= 6:
+			proxy.logger().Info(ev.Message, fields)
+		default:
+			proxy.logger().Debug(ev.Message, fields)
+		}
+	}
+}
+
+//newLogger 创建带有代理名字段的logger
+func newLogger(name string) *log.Log {
+	return logging.New(log.Dict{"module": "kafka-producer-proxy", "proxy": name})
+}
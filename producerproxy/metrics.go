@@ -0,0 +1,21 @@
+package producerproxy
+
+//MetricsRecorder 生产者代理的指标记录器,可以直接使用子模块`metrics`中的`Collector`
+type MetricsRecorder interface {
+	//MessageSent 消息已经交给生产者发送
+	MessageSent(proxy, topic string)
+	//MessageDelivered 消息已经被kafka确认收到
+	MessageDelivered(proxy, topic string, partition int32)
+	//MessageDropped 发送队列满时按策略丢弃了消息
+	MessageDropped(proxy, topic string)
+	//MessageSpooled 消息被写入本地暂存区
+	MessageSpooled(proxy, topic string)
+	//SpoolDepth 本地暂存区中等待重放的消息数和字节数
+	SpoolDepth(proxy string, messages int, bytes int64)
+	//DeliveryFailed 消息发送失败
+	DeliveryFailed(proxy, topic string, partition int32)
+	//ProducerInFlight 已发送但还未收到确认的消息数量变化,partition为发送时指定的分区,未指定时为`kafka.PartitionAny`
+	ProducerInFlight(proxy, topic string, partition int32, delta int)
+	//Stats librdkafka的统计信息,需要设置`statistics.interval.ms`才会有
+	Stats(proxy string, stats string)
+}
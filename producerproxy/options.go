@@ -1,19 +1,128 @@
 package producerproxy
 
 import (
+	"time"
+
+	log "github.com/Golang-Tools/loggerhelper/v2"
 	"github.com/Golang-Tools/optparams"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //Option 设置key行为的选项
 type Options struct {
 	kafka.ConfigMap
-	ParallelCallback   bool
-	NotConfirmDelivery bool
+	Name                     string
+	ParallelCallback         bool
+	NotConfirmDelivery       bool
+	SendQueueSize            int
+	QueueFullPolicy          QueueFullPolicy
+	QueueFullTimeout         time.Duration
+	SpoolDir                 string
+	SpoolSegmentBytes        int64
+	SpoolMaxBytes            int64
+	SpoolRetention           time.Duration
+	SpoolSync                SpoolSyncPolicy
+	SpoolSyncInterval        time.Duration
+	SpoolRetryInterval       time.Duration
+	RecreateOnFatalError     bool
+	Metrics                  MetricsRecorder
+	Tracing                  bool
+	TracerProvider           trace.TracerProvider
+	OAuthBearerTokenProvider OAuthBearerTokenProvider
+	Profile                  string
+	ProfileConfig            kafka.ConfigMap
+	SkipValidation           bool
+	Logger                   *log.Log
+	Propagator               propagation.TextMapPropagator
+	ClientFactory            ClientFactory
 }
 
 var DefaultOptions = Options{
-	ConfigMap: kafka.ConfigMap{},
+	ConfigMap:     kafka.ConfigMap{},
+	SendQueueSize: 100000,
+}
+
+//WithName 设置代理的名字,会作为指标的proxy标签
+//@params name string 代理的名字
+func WithName(name string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Name = name
+	})
+}
+
+//WithMetrics 设置指标记录器
+//@params m MetricsRecorder 指标记录器,可以使用子模块metrics中的Collector
+func WithMetrics(m MetricsRecorder) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Metrics = m
+	})
+}
+
+//WithStatisticsInterval 设置librdkafka统计信息的发送间隔,统计信息会交给指标记录器
+//@params interval int 统计信息的发送间隔,单位ms,0表示不发送
+func WithStatisticsInterval(interval int) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		o.ConfigMap["statistics.interval.ms"] = interval
+	})
+}
+
+//WithTracing 开启opentelemetry链路追踪,发送消息时会创建producer span并将上下文注入到消息的headers中
+//@params tp trace.TracerProvider 使用的TracerProvider,为nil时使用otel的全局TracerProvider
+func WithTracing(tp trace.TracerProvider) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Tracing = true
+		o.TracerProvider = tp
+	})
+}
+
+//WithPropagator 设置链路追踪上下文的传播方式,默认使用w3c的trace-context和baggage
+//@params p propagation.TextMapPropagator 上下文的传播方式
+func WithPropagator(p propagation.TextMapPropagator) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Propagator = p
+	})
+}
+
+//WithLogger 设置代理使用的logger,默认使用模块的Logger
+//@params logger *log.Log 代理使用的logger
+func WithLogger(logger *log.Log) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Logger = logger
+	})
+}
+
+//WithLibrdkafkaLogs 将librdkafka的日志转发到代理的logger中,而不是直接打印到stderr
+func WithLibrdkafkaLogs() optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		o.ConfigMap["go.logs.channel.enable"] = true
+	})
+}
+
+//WithSendQueue 设置代理发送队列的容量和队列满时的策略,默认容量为100000,队列满时阻塞
+//@params size int 队列的容量,小于等于0表示不限制
+//@params policy QueueFullPolicy 队列满时的处理策略
+func WithSendQueue(size int, policy QueueFullPolicy) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.SendQueueSize = size
+		o.QueueFullPolicy = policy
+	})
+}
+
+//WithSendQueueTimeout 设置发送队列满时最多阻塞多久,超时返回ErrQueueFull
+//@params timeout time.Duration 最长阻塞时间,小于等于0时使用DefaultQueueFullTimeout
+func WithSendQueueTimeout(timeout time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.QueueFullPolicy = QueueFullBlockWithTimeout
+		o.QueueFullTimeout = timeout
+	})
 }
 
 //WithParallelCallback 设置callback并行执行
@@ -1,44 +1,66 @@
 package producerproxy
 
 import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
 	log "github.com/Golang-Tools/loggerhelper/v2"
 	"github.com/Golang-Tools/optparams"
 	"github.com/confluentinc/confluent-kafka-go/kafka"
 )
 
+//Logger 模块默认的logger,代理未设置WithLogger时使用
 var Logger *log.Log
 
 func init() {
-	log.Set(log.WithExtFields(log.Dict{"module": "kafka-producer-proxy"}))
-	Logger = log.Export()
-	log.Set(log.WithExtFields(log.Dict{}))
+	Logger = logging.New(log.Dict{"module": "kafka-producer-proxy"})
 }
 
 //SetConnectCallback
-type SetConnectCallback func(cli *kafka.Producer) error
+type SetConnectCallback func(cli Producer) error
 
 type DeliveryCallback func(evt *kafka.Message)
 type DeliveryUnknownEventCallback func(evt kafka.Event)
 
 //ProducerProxy redis客户端的代理
 type ProducerProxy struct {
-	*kafka.Producer
+	Producer
 	Opt                          Options
-	delivered_records            int64
-	deliver_watching             bool
+	delivered_records            int64 //原子操作
+	deliver_watching             int32 //正在监听发送报告的goroutine数,原子操作
 	callBacks                    []SetConnectCallback
 	deliveryCallback             []DeliveryCallback
 	deliveryErrorCallback        []DeliveryCallback
 	deliveryIgnoredEventCallback []DeliveryUnknownEventCallback
+	fatalErrorCallback           []FatalErrorCallback
+	namedLogger                  *log.Log
+	loggerName                   string
+	queue                        *sendQueue
+	senderOnce                   sync.Once
+	clientLock                   sync.RWMutex
+	closed                       bool
+	watchDone                    chan struct{}
+	senderDone                   chan struct{}
+	inflightLock                 sync.Mutex
+	inflight                     map[uint64]*deliveryToken
+	nextToken                    uint64
+	unsent                       []*kafka.Message
+	spool                        *producerSpool
 }
 
 //New 创建一个新的kafka Producer客户端代理
 func New() *ProducerProxy {
 	proxy := new(ProducerProxy)
 	proxy.Opt = DefaultOptions
+	proxy.Opt.ConfigMap = kafka.ConfigMap{}
+	for k, v := range DefaultOptions.ConfigMap {
+		proxy.Opt.ConfigMap[k] = v
+	}
 	proxy.callBacks = []SetConnectCallback{}
-	proxy.delivered_records = 0
-	proxy.deliver_watching = false
+	proxy.queue = newSendQueue(proxy.Opt.SendQueueSize, proxy.Opt.QueueFullPolicy, proxy.Opt.QueueFullTimeout)
 	return proxy
 }
 
@@ -47,70 +69,127 @@ func (proxy *ProducerProxy) IsOk() bool {
 	return proxy.Producer != nil
 }
 
+//Name 代理的名字,未设置时为default
+func (proxy *ProducerProxy) Name() string {
+	if proxy.Opt.Name == "" {
+		return "default"
+	}
+	return proxy.Opt.Name
+}
+
+//QueueLen 代理发送队列中还未交给生产者的消息数
+func (proxy *ProducerProxy) QueueLen() int {
+	return proxy.queue.len()
+}
+
 //IsWatchingDeliver( 检查代理是否正在监听发送情况
 func (proxy *ProducerProxy) IsWatchingDeliver() bool {
-	return proxy.deliver_watching
+	return atomic.LoadInt32(&proxy.deliver_watching) > 0
 }
 
 //DeliveredRecords 查看已经发送了几条信息
 func (proxy *ProducerProxy) DeliveredRecords() int64 {
-	return proxy.delivered_records
+	return atomic.LoadInt64(&proxy.delivered_records)
 }
 
-//Close 关闭发送端
+//Close 关闭发送端,不会等待flush,发送队列和生产者中还未发出的消息会被丢弃,需要等待发送完成时使用Shutdown
+//代理已经关闭时不做任何事
 func (proxy *ProducerProxy) Close() {
-	proxy.Producer.Close()
-	if proxy.IsWatchingDeliver() {
-		proxy.deliver_watching = false
+	proxy.clientLock.RLock()
+	closed := proxy.closed
+	proxy.clientLock.RUnlock()
+	if closed {
+		return
 	}
+	proxy.queue.close()
+	proxy.stopSpool()
+	proxy.clientLock.Lock()
+	defer proxy.clientLock.Unlock()
+	proxy.closed = true
+	proxy.Producer.Close()
+	atomic.StoreInt32(&proxy.deliver_watching, 0)
+	proxy.closeSpool()
+	proxy.abandonInflight(ErrProxyClosed)
 }
 
 //SetConnect 设置连接的客户端
-//@params cli *kafka.Producer 被代理
-func (proxy *ProducerProxy) SetConnect(cli *kafka.Producer) error {
+//@params cli Producer 被代理的生产者,可以是*kafka.Producer或其他满足Producer接口的实现
+func (proxy *ProducerProxy) SetConnect(cli Producer) error {
 	if proxy.IsOk() {
 		return ErrProxyAllreadySettedClient
 	}
 	proxy.Producer = cli
+	return proxy.connected(cli)
+}
+
+//connected 设置客户端后转发日志,刷新令牌,执行连接回调并开始监听发送报告,调用时不能持有clientLock
+func (proxy *ProducerProxy) connected(cli Producer) error {
+	if logs := cli.Logs(); logs != nil {
+		go proxy.pumpLogs(logs)
+	}
+	proxy.refreshOAuthBearerToken()
+	proxy.queue.configure(proxy.Opt.SendQueueSize, proxy.Opt.QueueFullPolicy, proxy.Opt.QueueFullTimeout)
+	err := proxy.openSpool()
+	if err != nil {
+		return err
+	}
+	proxy.senderOnce.Do(func() {
+		proxy.senderDone = make(chan struct{})
+		go proxy.sendLoop()
+	})
 	if proxy.Opt.ParallelCallback {
 		for _, cb := range proxy.callBacks {
 			go func(cb SetConnectCallback) {
-				err := cb(proxy.Producer)
+				err := cb(cli)
 				if err != nil {
-					Logger.Error("regist callback get error", log.Dict{"err": err})
+					proxy.logger().Error("regist callback get error", log.Dict{"err": err})
 				} else {
-					Logger.Debug("regist callback done")
+					proxy.logger().Debug("regist callback done")
 				}
 			}(cb)
 		}
 	} else {
 		for _, cb := range proxy.callBacks {
-			err := cb(proxy.Producer)
+			err := cb(cli)
 			if err != nil {
-				Logger.Error("regist callback get error", log.Dict{"err": err})
+				proxy.logger().Error("regist callback get error", log.Dict{"err": err})
 			} else {
-				Logger.Debug("regist callback done")
+				proxy.logger().Debug("regist callback done")
 			}
 		}
 	}
 
-	if !proxy.Opt.NotConfirmDelivery {
-		proxy.StartConfirmDelivery()
-		proxy.deliver_watching = true
+	//使用OAUTHBEARER时需要监听事件来刷新令牌,使用暂存区时需要监听发送失败和集群不可用
+	if !proxy.Opt.NotConfirmDelivery || proxy.Opt.OAuthBearerTokenProvider != nil || proxy.spool != nil {
+		return proxy.StartConfirmDelivery()
 	}
 	return nil
 }
 
 //Init 从配置条件初始化代理对象
-//@params endpoints string 设置etcd连接的地址端点,以`,`分隔
+//@params endpoints string 设置kafka连接的地址端点,以`,`分隔,为空时使用设置中的`bootstrap.servers`
 //@params opts ...optparams.Option[Options]
 func (proxy *ProducerProxy) Init(endpoints string, opts ...optparams.Option[Options]) error {
 	optparams.GetOption(&proxy.Opt, opts...)
+	proxy.refreshLogger()
 	if proxy.Opt.ConfigMap == nil {
 		proxy.Opt.ConfigMap = kafka.ConfigMap{}
 	}
-	proxy.Opt.ConfigMap["bootstrap.servers"] = endpoints
-	cli, err := kafka.NewProducer(&proxy.Opt.ConfigMap)
+	if endpoints != "" {
+		proxy.Opt.ConfigMap["bootstrap.servers"] = endpoints
+	}
+	proxy.Opt.ConfigMap = proxy.Opt.Effective()
+	if !proxy.Opt.SkipValidation {
+		err := proxy.Opt.Validate()
+		if err != nil {
+			return err
+		}
+		for _, k := range kafkaconf.Unknown(proxy.Opt.ConfigMap) {
+			proxy.logger().Warn("unknown config key, left to librdkafka", log.Dict{"key": k})
+		}
+	}
+	proxy.logger().Debug("init client", proxy.Opt.LogValue())
+	cli, err := proxy.newClient()
 	if err != nil {
 		return err
 	}
@@ -163,58 +242,289 @@ func (proxy *ProducerProxy) StartConfirmDelivery() error {
 	if !proxy.IsOk() {
 		return ErrProxyNotYetSettedClient
 	}
-	func() {
-		for e := range proxy.Events() {
+	atomic.AddInt32(&proxy.deliver_watching, 1)
+	done := make(chan struct{})
+	proxy.clientLock.Lock()
+	events := proxy.Events()
+	proxy.watchDone = done
+	proxy.clientLock.Unlock()
+	go func() {
+		defer close(done)
+		defer atomic.AddInt32(&proxy.deliver_watching, -1)
+		for e := range events {
 			switch ev := e.(type) {
 			case *kafka.Message:
 				{
+					token := proxy.untrack(ev)
+					if token != nil && proxy.Opt.Metrics != nil {
+						proxy.Opt.Metrics.ProducerInFlight(proxy.Name(), token.topic, token.partition, -1)
+					}
 					if ev.TopicPartition.Error != nil {
+						if proxy.Opt.Metrics != nil {
+							proxy.Opt.Metrics.DeliveryFailed(proxy.Name(), topicOf(ev), ev.TopicPartition.Partition)
+						}
+						if proxy.spoolFailed(ev) {
+							if token != nil {
+								token.finish(nil)
+							}
+							continue
+						}
+						if token != nil {
+							token.finish(ev.TopicPartition.Error)
+						}
 						if len(proxy.deliveryErrorCallback) > 0 {
 							for _, cb := range proxy.deliveryErrorCallback {
 								cb(ev)
 							}
 						} else {
-							Logger.Error("Delivery failed", log.Dict{"TopicPartition": ev.TopicPartition})
+							proxy.logger().Error("Delivery failed", log.Dict{"TopicPartition": ev.TopicPartition})
 						}
 					} else {
-						proxy.delivered_records += 1
+						atomic.AddInt64(&proxy.delivered_records, 1)
+						proxy.setReachable(true)
+						if token != nil {
+							token.finish(nil)
+						}
+						if proxy.Opt.Metrics != nil {
+							proxy.Opt.Metrics.MessageDelivered(proxy.Name(), topicOf(ev), ev.TopicPartition.Partition)
+						}
 						if len(proxy.deliveryCallback) > 0 {
 							for _, cb := range proxy.deliveryCallback {
 								cb(ev)
 							}
 						} else {
-							Logger.Info("Delivered message", log.Dict{"TopicPartition": ev.TopicPartition})
+							proxy.logger().Info("Delivered message", log.Dict{"TopicPartition": ev.TopicPartition})
 						}
 					}
 				}
-			default:
+			case kafka.OAuthBearerTokenRefresh:
 				{
-					if len(proxy.deliveryIgnoredEventCallback) > 0 {
-						for _, cb := range proxy.deliveryIgnoredEventCallback {
-							cb(e)
-						}
+					proxy.refreshOAuthBearerToken()
+				}
+			case *kafka.Stats:
+				{
+					if proxy.Opt.Metrics != nil {
+						proxy.Opt.Metrics.Stats(proxy.Name(), ev.String())
+					}
+				}
+			case kafka.Error:
+				{
+					if ev.IsFatal() {
+						proxy.onFatalError(ev)
 					} else {
-						Logger.Error("kafka producer Ignored event", log.Dict{"ev": ev})
+						if ev.Code() == kafka.ErrAllBrokersDown {
+							proxy.setReachable(false)
+						}
+						proxy.onUnknownEvent(e)
 					}
 				}
+			default:
+				{
+					proxy.onUnknownEvent(e)
+				}
 			}
 		}
 	}()
 	return nil
 }
 
-func (proxy *ProducerProxy) sendAsync(msg *kafka.Message) {
-	proxy.ProduceChannel() <- msg
+//onUnknownEvent 处理监听到的未知类型事件
+func (proxy *ProducerProxy) onUnknownEvent(e kafka.Event) {
+	if len(proxy.deliveryIgnoredEventCallback) > 0 {
+		for _, cb := range proxy.deliveryIgnoredEventCallback {
+			cb(e)
+		}
+	} else {
+		proxy.logger().Error("kafka producer Ignored event", log.Dict{"ev": e})
+	}
 }
 
-func (proxy *ProducerProxy) Send(msg *kafka.Message) {
-	go proxy.sendAsync(msg)
+//topicOf 获取消息的topic,topic为空时返回空字符串
+func topicOf(msg *kafka.Message) string {
+	if msg.TopicPartition.Topic == nil {
+		return ""
+	}
+	return *msg.TopicPartition.Topic
 }
 
-func (proxy *ProducerProxy) SendAndWait(msg *kafka.Message) error {
+//sent 记录消息已交给生产者,token不为nil时消息会等待发送报告
+//在途消息按发送时的topic和分区计数,收到发送报告时用令牌中记录的标签减去
+func (proxy *ProducerProxy) sent(msg *kafka.Message, token *deliveryToken) {
+	if proxy.Opt.Metrics != nil {
+		proxy.Opt.Metrics.MessageSent(proxy.Name(), topicOf(msg))
+		if token != nil {
+			proxy.Opt.Metrics.ProducerInFlight(proxy.Name(), token.topic, token.partition, 1)
+		}
+	}
+}
+
+//sendLoop 按顺序将发送队列中的消息交给生产者
+func (proxy *ProducerProxy) sendLoop() {
+	defer close(proxy.senderDone)
+	for {
+		req, ok := proxy.queue.pop()
+		if !ok {
+			return
+		}
+		var err error
+		if proxy.spooling() {
+			err = proxy.spoolMessage(req.msg)
+			if err == ErrSpoolFull {
+				proxy.logger().Warn("spool is full, message dropped", log.Dict{"topic": topicOf(req.msg)})
+				if proxy.Opt.Metrics != nil {
+					proxy.Opt.Metrics.MessageDropped(proxy.Name(), topicOf(req.msg))
+				}
+			}
+		} else {
+			err = proxy.produceWithRetry(req)
+		}
+		if err == ErrProxyClosed || err == ErrSpoolClosed {
+			proxy.unsend(req.msg)
+		}
+		if err != nil || !req.pending {
+			endSpan(req.span, err)
+		}
+		if req.done != nil {
+			if err != nil || !req.pending {
+				req.done <- err
+			}
+		} else if err != nil {
+			proxy.logger().Error("send message get error", log.Dict{"err": err, "topic": topicOf(req.msg)})
+		}
+	}
+}
+
+//isQueueFull 判断是否为librdkafka的本地队列已满错误
+func isQueueFull(err error) bool {
+	kerr, ok := err.(kafka.Error)
+	return ok && kerr.Code() == kafka.ErrQueueFull
+}
+
+//produceWithRetry 将消息交给生产者,librdkafka的本地队列已满时先flush再重试,直到成功或代理关闭
+func (proxy *ProducerProxy) produceWithRetry(req *sendRequest) error {
+	msg := req.msg
+	for {
+		err := proxy.produce(req)
+		if !isQueueFull(err) {
+			return err
+		}
+		proxy.logger().Debug("librdkafka queue is full, flush and retry", log.Dict{"topic": topicOf(msg)})
+		proxy.flush(100)
+	}
+}
+
+//flush 等待librdkafka发送队列中的消息,代理关闭时直接返回
+func (proxy *ProducerProxy) flush(timeoutMs int) int {
+	proxy.clientLock.RLock()
+	defer proxy.clientLock.RUnlock()
+	if proxy.closed || !proxy.IsOk() {
+		return 0
+	}
+	return proxy.Flush(timeoutMs)
+}
+
+//produce 将消息交给生产者,监听发送报告时req.done会在收到发送报告后得到通知
+func (proxy *ProducerProxy) produce(req *sendRequest) error {
+	msg := req.msg
+	proxy.clientLock.RLock()
+	defer proxy.clientLock.RUnlock()
+	if proxy.closed {
+		return ErrProxyClosed
+	}
+	if !proxy.IsOk() {
+		return ErrProxyNotYetSettedClient
+	}
+	token := proxy.track(msg, req.done, req.span)
 	err := proxy.Produce(msg, nil)
+	proxy.restore(token, err)
+	req.pending = token != nil && err == nil
+	if err == nil {
+		proxy.sent(msg, token)
+	}
 	return err
 }
 
+//enqueue 将请求放入发送队列,处理按策略被丢弃的请求
+func (proxy *ProducerProxy) enqueue(ctx context.Context, req *sendRequest) error {
+	dropped, err := proxy.queue.push(ctx, req)
+	if err != nil {
+		return err
+	}
+	if dropped != nil {
+		proxy.logger().Warn("send queue is full, message dropped", log.Dict{"topic": topicOf(dropped.msg), "policy": proxy.Opt.QueueFullPolicy.String()})
+		if proxy.Opt.Metrics != nil {
+			proxy.Opt.Metrics.MessageDropped(proxy.Name(), topicOf(dropped.msg))
+		}
+		endSpan(dropped.span, ErrQueueFull)
+		if dropped.done != nil {
+			dropped.done <- ErrQueueFull
+		}
+	}
+	return nil
+}
+
+//Send 异步发送消息,同一个代理上的Send和SendAndWait会按调用顺序发送
+//发送队列满时按QueueFullPolicy处理,策略为QueueFullReturnError或QueueFullBlockWithTimeout超时时返回ErrQueueFull
+//@params msg *kafka.Message 要发送的消息
+func (proxy *ProducerProxy) Send(msg *kafka.Message) error {
+	return proxy.SendWithContext(context.Background(), msg)
+}
+
+//SendWithContext 异步发送消息,开启链路追踪时会使用ctx中的span作为父span,在发送队列满而阻塞时ctx结束会返回ctx的错误
+//producer span在收到发送报告时结束,未监听发送报告时在消息交给生产者后结束
+//@params ctx context.Context 请求的上下文
+//@params msg *kafka.Message 要发送的消息
+func (proxy *ProducerProxy) SendWithContext(ctx context.Context, msg *kafka.Message) error {
+	span := proxy.startSpan(ctx, msg)
+	err := proxy.enqueue(ctx, &sendRequest{msg: msg, span: span})
+	if err != nil {
+		endSpan(span, err)
+	}
+	return err
+}
+
+//SendAndWait 发送消息,等待kafka确认收到,返回发送报告中的错误
+//未监听发送报告(WithoutConfirmDelivery)时只等待消息交给生产者;开启暂存区时消息写入暂存区也视为成功
+//@params msg *kafka.Message 要发送的消息
+func (proxy *ProducerProxy) SendAndWait(msg *kafka.Message) error {
+	return proxy.SendAndWaitWithContext(context.Background(), msg)
+}
+
+//SendAndWaitWithContext 发送消息,等待kafka确认收到,开启链路追踪时会使用ctx中的span作为父span
+//ctx结束时不再等待,但已经进入代理发送队列的消息仍会被发送
+//@params ctx context.Context 请求的上下文
+//@params msg *kafka.Message 要发送的消息
+func (proxy *ProducerProxy) SendAndWaitWithContext(ctx context.Context, msg *kafka.Message) error {
+	span := proxy.startSpan(ctx, msg)
+	done := make(chan error, 1)
+	err := proxy.enqueue(ctx, &sendRequest{msg: msg, done: done, span: span})
+	if err != nil {
+		endSpan(span, err)
+		return err
+	}
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return err
+}
+
+//SendAsync 异步发送消息,返回的channel会收到与SendAndWait相同的发送结果,用于不阻塞地确认多条消息
+//发送结果只会写入一次,channel有缓冲,调用方不读取也不会阻塞代理
+//@params ctx context.Context 请求的上下文,在发送队列满而阻塞时ctx结束会得到ctx的错误
+//@params msg *kafka.Message 要发送的消息
+//@returns <-chan error 发送结果
+func (proxy *ProducerProxy) SendAsync(ctx context.Context, msg *kafka.Message) <-chan error {
+	span := proxy.startSpan(ctx, msg)
+	done := make(chan error, 1)
+	err := proxy.enqueue(ctx, &sendRequest{msg: msg, done: done, span: span})
+	if err != nil {
+		endSpan(span, err)
+		done <- err
+	}
+	return done
+}
+
 //Default 默认的kafka Producer代理对象
 var Default = New()
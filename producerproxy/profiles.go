@@ -0,0 +1,64 @@
+package producerproxy
+
+import (
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//withProfile 设置预设的调优参数,显式设置的同名设置项(包括别名)优先,后设置的profile会替换之前的
+func withProfile(name string, cm kafka.ConfigMap) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.Profile = name
+		o.ProfileConfig = cm
+	})
+}
+
+//ProfileLowLatency 低延迟的预设,消息立即发送,不压缩,只需leader确认
+func ProfileLowLatency() optparams.Option[Options] {
+	return withProfile("low-latency", kafka.ConfigMap{
+		"linger.ms":                     0,
+		"batch.num.messages":            1,
+		"compression.type":              "none",
+		"acks":                          1,
+		"socket.nagle.disable":          true,
+		"retry.backoff.ms":              10,
+		"sticky.partitioning.linger.ms": 0,
+	})
+}
+
+//ProfileHighThroughput 高吞吐的预设,积攒大批量消息并使用lz4压缩发送
+func ProfileHighThroughput() optparams.Option[Options] {
+	return withProfile("high-throughput", kafka.ConfigMap{
+		"linger.ms":                    50,
+		"batch.size":                   1048576,
+		"batch.num.messages":           100000,
+		"compression.type":             "lz4",
+		"acks":                         1,
+		"queue.buffering.max.kbytes":   2097151,
+		"queue.buffering.max.messages": 1000000,
+	})
+}
+
+//ProfileDurable 可靠的预设,开启幂等并需要全部副本确认,发送失败会一直重试直到超时
+func ProfileDurable() optparams.Option[Options] {
+	return withProfile("durable", kafka.ConfigMap{
+		"acks":                                  "all",
+		"enable.idempotence":                    true,
+		"max.in.flight.requests.per.connection": 5,
+		"retries":                               2147483647,
+		"message.timeout.ms":                    300000,
+		"linger.ms":                             5,
+		"compression.type":                      "zstd",
+	})
+}
+
+//Effective 实际生效的设置,即预设的profile被显式设置覆盖后的结果
+func (o Options) Effective() kafka.ConfigMap {
+	return kafkaconf.Merge(o.ProfileConfig, o.ConfigMap)
+}
+
+//EffectiveConfig 代理实际使用的设置,敏感设置项会被隐藏
+func (proxy *ProducerProxy) EffectiveConfig() kafka.ConfigMap {
+	return kafkaconf.Redact(proxy.Opt.Effective())
+}
@@ -0,0 +1,220 @@
+package producerproxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//DefaultQueueFullTimeout 策略为QueueFullBlockWithTimeout但没有设置超时时间时使用的超时时间
+const DefaultQueueFullTimeout = 5 * time.Second
+
+//QueueFullPolicy 发送队列满时的处理策略
+type QueueFullPolicy int
+
+const (
+	//QueueFullBlock 阻塞直到队列有空位
+	QueueFullBlock QueueFullPolicy = iota
+	//QueueFullBlockWithTimeout 阻塞直到队列有空位或超时,超时返回ErrQueueFull
+	QueueFullBlockWithTimeout
+	//QueueFullDropOldest 丢弃队列中最早的消息
+	QueueFullDropOldest
+	//QueueFullDropNewest 丢弃新发送的消息
+	QueueFullDropNewest
+	//QueueFullReturnError 直接返回ErrQueueFull
+	QueueFullReturnError
+)
+
+func (p QueueFullPolicy) String() string {
+	switch p {
+	case QueueFullBlock:
+		return "block"
+	case QueueFullBlockWithTimeout:
+		return "block-with-timeout"
+	case QueueFullDropOldest:
+		return "drop-oldest"
+	case QueueFullDropNewest:
+		return "drop-newest"
+	case QueueFullReturnError:
+		return "return-error"
+	default:
+		return "unknown"
+	}
+}
+
+//ParseQueueFullPolicy 从名字解析队列满时的处理策略,名字与String的结果一致
+//@params name string 策略名,可选的有block,block-with-timeout,drop-oldest,drop-newest,return-error
+func ParseQueueFullPolicy(name string) (QueueFullPolicy, error) {
+	for _, p := range []QueueFullPolicy{QueueFullBlock, QueueFullBlockWithTimeout, QueueFullDropOldest, QueueFullDropNewest, QueueFullReturnError} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return QueueFullBlock, fmt.Errorf("%w: unknown queue full policy %q", ErrConfigInvalidValue, name)
+}
+
+//sendRequest 发送队列中的一项,done不为nil时会收到发送结果
+type sendRequest struct {
+	msg     *kafka.Message
+	done    chan error
+	span    trace.Span
+	pending bool
+}
+
+//sendQueue 有界的先进先出发送队列,由一个goroutine按顺序交给生产者,用于保证同一个代理的发送顺序
+type sendQueue struct {
+	lock     sync.Mutex
+	notEmpty *sync.Cond
+	notFull  *sync.Cond
+	items    []*sendRequest
+	closed   bool
+	size     int
+	policy   QueueFullPolicy
+	timeout  time.Duration
+}
+
+//newSendQueue 创建发送队列
+//@params size int 队列的容量,小于等于0表示不限制
+//@params policy QueueFullPolicy 队列满时的处理策略
+//@params timeout time.Duration 策略为QueueFullBlockWithTimeout时的超时时间,小于等于0时使用DefaultQueueFullTimeout
+func newSendQueue(size int, policy QueueFullPolicy, timeout time.Duration) *sendQueue {
+	q := new(sendQueue)
+	q.notEmpty = sync.NewCond(&q.lock)
+	q.notFull = sync.NewCond(&q.lock)
+	q.size = size
+	q.policy = policy
+	q.timeout = blockTimeout(timeout)
+	return q
+}
+
+//blockTimeout 超时时间小于等于0时使用DefaultQueueFullTimeout,避免阻塞超时策略退化为直接返回ErrQueueFull
+func blockTimeout(timeout time.Duration) time.Duration {
+	if timeout <= 0 {
+		return DefaultQueueFullTimeout
+	}
+	return timeout
+}
+
+func (q *sendQueue) full() bool {
+	return q.size > 0 && len(q.items) >= q.size
+}
+
+//wait 等待队列有空位,ctx结束或超过deadline时返回错误,调用时需要持有锁
+func (q *sendQueue) wait(ctx context.Context, deadline time.Time) error {
+	wake := func() {
+		q.lock.Lock()
+		q.notFull.Broadcast()
+		q.lock.Unlock()
+	}
+	if !deadline.IsZero() {
+		timer := time.AfterFunc(time.Until(deadline), wake)
+		defer timer.Stop()
+	}
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				wake()
+			case <-stop:
+			}
+		}()
+	}
+	for q.full() && !q.closed {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return ErrQueueFull
+		}
+		q.notFull.Wait()
+	}
+	return nil
+}
+
+//push 将请求放入队尾,队列满时按策略处理
+//@returns *sendRequest 按策略被丢弃的请求,可能是req本身
+func (q *sendQueue) push(ctx context.Context, req *sendRequest) (*sendRequest, error) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	if q.closed {
+		return nil, ErrProxyClosed
+	}
+	var dropped *sendRequest
+	if q.full() {
+		switch q.policy {
+		case QueueFullBlock:
+			err := q.wait(ctx, time.Time{})
+			if err != nil {
+				return nil, err
+			}
+		case QueueFullBlockWithTimeout:
+			err := q.wait(ctx, time.Now().Add(q.timeout))
+			if err != nil {
+				return nil, err
+			}
+		case QueueFullDropOldest:
+			dropped = q.items[0]
+			q.items[0] = nil
+			q.items = q.items[1:]
+		case QueueFullDropNewest:
+			return req, nil
+		default:
+			return nil, ErrQueueFull
+		}
+		if q.closed {
+			return nil, ErrProxyClosed
+		}
+	}
+	q.items = append(q.items, req)
+	q.notEmpty.Signal()
+	return dropped, nil
+}
+
+//pop 取出队首的请求,队列为空时阻塞,队列关闭且为空时返回false
+func (q *sendQueue) pop() (*sendRequest, bool) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	for len(q.items) == 0 && !q.closed {
+		q.notEmpty.Wait()
+	}
+	if len(q.items) == 0 {
+		return nil, false
+	}
+	req := q.items[0]
+	q.items[0] = nil
+	q.items = q.items[1:]
+	q.notFull.Signal()
+	return req, true
+}
+
+//close 关闭队列,不再接收新的请求
+func (q *sendQueue) close() {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.closed = true
+	q.notEmpty.Broadcast()
+	q.notFull.Broadcast()
+}
+
+//len 队列中等待发送的请求数
+func (q *sendQueue) len() int {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	return len(q.items)
+}
+
+//configure 修改队列的容量和队列满时的策略
+func (q *sendQueue) configure(size int, policy QueueFullPolicy, timeout time.Duration) {
+	q.lock.Lock()
+	defer q.lock.Unlock()
+	q.size = size
+	q.policy = policy
+	q.timeout = blockTimeout(timeout)
+	q.notFull.Broadcast()
+}
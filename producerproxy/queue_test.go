@@ -0,0 +1,114 @@
+package producerproxy
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//request 以value标识的请求
+func request(value string) *sendRequest {
+	return &sendRequest{msg: &kafka.Message{Value: []byte(value)}}
+}
+
+//values 依次取出队列中请求的value
+func values(q *sendQueue) []string {
+	result := []string{}
+	for q.len() > 0 {
+		req, _ := q.pop()
+		result = append(result, string(req.msg.Value))
+	}
+	return result
+}
+
+func TestBlockWithZeroTimeoutWaits(t *testing.T) {
+	q := newSendQueue(1, QueueFullBlockWithTimeout, 0)
+	if _, err := q.push(context.Background(), &sendRequest{}); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		q.pop()
+	}()
+	//超时时间为0时使用默认超时,应等到队列有空位而不是立即返回ErrQueueFull
+	if _, err := q.push(context.Background(), &sendRequest{}); err != nil {
+		t.Fatalf("push should wait for a free slot, got %v", err)
+	}
+}
+
+func TestQueueFullPolicies(t *testing.T) {
+	cases := []struct {
+		policy  QueueFullPolicy
+		dropped string
+		err     error
+		queued  []string
+	}{
+		{policy: QueueFullDropOldest, dropped: "a", queued: []string{"b", "c"}},
+		{policy: QueueFullDropNewest, dropped: "c", queued: []string{"a", "b"}},
+		{policy: QueueFullReturnError, err: ErrQueueFull, queued: []string{"a", "b"}},
+	}
+	for _, c := range cases {
+		t.Run(c.policy.String(), func(t *testing.T) {
+			q := newSendQueue(2, c.policy, 0)
+			for _, v := range []string{"a", "b"} {
+				if dropped, err := q.push(context.Background(), request(v)); dropped != nil || err != nil {
+					t.Fatalf("push %s to a queue not full: %v, %v", v, dropped, err)
+				}
+			}
+			dropped, err := q.push(context.Background(), request("c"))
+			if !errors.Is(err, c.err) {
+				t.Fatalf("expect error %v, got %v", c.err, err)
+			}
+			got := ""
+			if dropped != nil {
+				got = string(dropped.msg.Value)
+			}
+			if got != c.dropped {
+				t.Fatalf("expect dropped %q, got %q", c.dropped, got)
+			}
+			if queued := values(q); !reflect.DeepEqual(queued, c.queued) {
+				t.Fatalf("expect queued %v, got %v", c.queued, queued)
+			}
+		})
+	}
+}
+
+func TestQueueFullTimeout(t *testing.T) {
+	for _, timeout := range []time.Duration{0, -time.Second} {
+		if q := newSendQueue(1, QueueFullBlockWithTimeout, timeout); q.timeout != DefaultQueueFullTimeout {
+			t.Fatalf("timeout %v should fall back to DefaultQueueFullTimeout, got %v", timeout, q.timeout)
+		}
+	}
+	q := newSendQueue(1, QueueFullBlockWithTimeout, 50*time.Millisecond)
+	if q.timeout != 50*time.Millisecond {
+		t.Fatalf("expect timeout 50ms, got %v", q.timeout)
+	}
+	if _, err := q.push(context.Background(), request("a")); err != nil {
+		t.Fatalf("push: %v", err)
+	}
+	start := time.Now()
+	if _, err := q.push(context.Background(), request("b")); !errors.Is(err, ErrQueueFull) {
+		t.Fatalf("expect ErrQueueFull after the timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("push should block for the timeout, took %v", elapsed)
+	}
+	//修改配置时同样使用默认超时
+	q.configure(1, QueueFullBlockWithTimeout, 0)
+	if q.timeout != DefaultQueueFullTimeout {
+		t.Fatalf("configure should fall back to DefaultQueueFullTimeout, got %v", q.timeout)
+	}
+	//ctx先结束时返回ctx的错误
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := q.push(ctx, request("b")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect ctx error, got %v", err)
+	}
+	if queued := values(q); !reflect.DeepEqual(queued, []string{"a"}) {
+		t.Fatalf("expect only a queued, got %v", queued)
+	}
+}
@@ -0,0 +1,61 @@
+package producerproxy
+
+import (
+	"github.com/Golang-Tools/kafkahelper/internal/registry"
+	"github.com/Golang-Tools/optparams"
+)
+
+var proxies = registry.New(func(proxy *ProducerProxy, name string) {
+	proxy.setName(name)
+})
+
+//Register 将代理以name注册到注册表,代理的名字会被设置为name
+//@params name string 代理的名字
+//@params proxy *ProducerProxy 要注册的代理
+func Register(name string, proxy *ProducerProxy) error {
+	return proxies.Register(name, proxy, nil)
+}
+
+//RegisterLazy 注册一个延迟初始化的代理,在第一次Get时才使用endpoints和opts执行Init
+//@params name string 代理的名字
+//@params endpoints string 设置kafka连接的地址端点,以`,`分隔
+//@params opts ...optparams.Option[Options] 初始化代理的参数
+func RegisterLazy(name string, endpoints string, opts ...optparams.Option[Options]) error {
+	proxy := New()
+	return proxies.Register(name, proxy, func() error {
+		return proxy.Init(endpoints, opts...)
+	})
+}
+
+//Unregister 从注册表中移除代理,不会关闭代理
+//@params name string 代理的名字
+func Unregister(name string) {
+	proxies.Unregister(name)
+}
+
+//Get 获取注册的代理,延迟初始化的代理会在这里执行Init,初始化失败时下次Get会重试
+//@params name string 代理的名字
+func Get(name string) (*ProducerProxy, error) {
+	return proxies.Get(name)
+}
+
+//Range 按名字顺序遍历注册表中的代理,fn返回false时停止遍历
+//延迟初始化且还未Get过的代理也会被遍历到,此时它的IsOk为false
+//@params fn func(name string, proxy *ProducerProxy) bool 遍历使用的函数
+func Range(fn func(name string, proxy *ProducerProxy) bool) {
+	proxies.Range(fn)
+}
+
+//CloseAll 关闭注册表中所有已经初始化的代理
+func CloseAll() {
+	Range(func(name string, proxy *ProducerProxy) bool {
+		if proxy.IsOk() {
+			proxy.Close()
+		}
+		return true
+	})
+}
+
+func init() {
+	Register("default", Default)
+}
@@ -0,0 +1,90 @@
+package producerproxy
+
+import (
+	"strings"
+
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//TLSConfig tls连接的设置,为空的字段不会被设置
+type TLSConfig = kafkaconf.TLSConfig
+
+//OAuthBearerTokenProvider 获取OAUTHBEARER令牌的函数,在代理连接时和librdkafka要求刷新令牌时调用
+type OAuthBearerTokenProvider func() (kafka.OAuthBearerToken, error)
+
+//WithTLS 使用tls连接kafka,已经设置了sasl时会使用sasl_ssl
+//@params conf TLSConfig tls连接的设置
+func WithTLS(conf TLSConfig) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		kafkaconf.ApplyTLS(o.ConfigMap, conf)
+	})
+}
+
+//WithSASLPlain 使用SASL/PLAIN认证,已经设置了tls时会使用sasl_ssl
+//@params username string 用户名
+//@params password string 密码
+func WithSASLPlain(username, password string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		kafkaconf.EnableSASL(o.ConfigMap, "PLAIN")
+		o.ConfigMap["sasl.username"] = username
+		o.ConfigMap["sasl.password"] = password
+	})
+}
+
+//WithSCRAM 使用SASL/SCRAM认证,已经设置了tls时会使用sasl_ssl
+//@params mechanism string 认证机制,可选的有SCRAM-SHA-256,SCRAM-SHA-512
+//@params username string 用户名
+//@params password string 密码
+func WithSCRAM(mechanism, username, password string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		kafkaconf.EnableSASL(o.ConfigMap, strings.ToUpper(mechanism))
+		o.ConfigMap["sasl.username"] = username
+		o.ConfigMap["sasl.password"] = password
+	})
+}
+
+//WithOAuthBearer 使用SASL/OAUTHBEARER认证,已经设置了tls时会使用sasl_ssl
+//代理会在连接时以及收到`kafka.OAuthBearerTokenRefresh`事件时调用provider刷新令牌
+//@params provider OAuthBearerTokenProvider 获取令牌的函数
+func WithOAuthBearer(provider OAuthBearerTokenProvider) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		if o.ConfigMap == nil {
+			o.ConfigMap = kafka.ConfigMap{}
+		}
+		kafkaconf.EnableSASL(o.ConfigMap, "OAUTHBEARER")
+		o.OAuthBearerTokenProvider = provider
+	})
+}
+
+//refreshOAuthBearerToken 调用OAuthBearerTokenProvider获取令牌并设置给客户端,失败时通知librdkafka稍后重试
+func (proxy *ProducerProxy) refreshOAuthBearerToken() {
+	provider := proxy.Opt.OAuthBearerTokenProvider
+	if provider == nil {
+		return
+	}
+	token, err := provider()
+	if err == nil {
+		err = proxy.SetOAuthBearerToken(token)
+	}
+	if err != nil {
+		proxy.logger().Error("refresh oauthbearer token get error", log.Dict{"err": err})
+		failErr := proxy.SetOAuthBearerTokenFailure(err.Error())
+		if failErr != nil {
+			proxy.logger().Error("set oauthbearer token failure get error", log.Dict{"err": failErr})
+		}
+		return
+	}
+	proxy.logger().Debug("oauthbearer token refreshed", log.Dict{"expiration": token.Expiration})
+}
@@ -0,0 +1,187 @@
+package producerproxy
+
+import (
+	"context"
+	"sort"
+	"sync/atomic"
+
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel/trace"
+)
+
+//deliveryToken 交给生产者时替换消息的Opaque,用于在发送报告中找回对应的消息,收到报告后会还原用户的Opaque
+type deliveryToken struct {
+	id        uint64
+	opaque    any
+	msg       *kafka.Message
+	topic     string
+	partition int32
+	done      chan error
+	span      trace.Span
+}
+
+//finish 通知SendAndWait消息的发送结果并结束producer span,只会通知一次
+func (token *deliveryToken) finish(err error) {
+	if token.done != nil {
+		token.done <- err
+		token.done = nil
+	}
+	if token.span != nil {
+		endSpan(token.span, err)
+		token.span = nil
+	}
+}
+
+//track 记录交给生产者但还未收到发送报告的消息,未监听发送报告时不记录
+//@params done chan error 收到发送报告时通知发送结果,可以为nil
+//@params span trace.Span 收到发送报告时结束的producer span,可以为nil
+//@returns *deliveryToken 记录消息的令牌,未监听发送报告时为nil
+func (proxy *ProducerProxy) track(msg *kafka.Message, done chan error, span trace.Span) *deliveryToken {
+	if !proxy.IsWatchingDeliver() {
+		return nil
+	}
+	proxy.inflightLock.Lock()
+	proxy.nextToken += 1
+	token := &deliveryToken{id: proxy.nextToken, opaque: msg.Opaque, msg: msg, topic: topicOf(msg), partition: msg.TopicPartition.Partition, done: done, span: span}
+	if proxy.inflight == nil {
+		proxy.inflight = map[uint64]*deliveryToken{}
+	}
+	proxy.inflight[token.id] = token
+	proxy.inflightLock.Unlock()
+	msg.Opaque = token
+	return token
+}
+
+//restore 消息交给生产者后还原消息的Opaque,交给生产者失败时不再记录该消息
+func (proxy *ProducerProxy) restore(token *deliveryToken, err error) {
+	if token == nil {
+		return
+	}
+	token.msg.Opaque = token.opaque
+	if err != nil {
+		proxy.inflightLock.Lock()
+		delete(proxy.inflight, token.id)
+		proxy.inflightLock.Unlock()
+	}
+}
+
+//untrack 收到发送报告时移除记录,并还原报告中消息的Opaque
+//@returns *deliveryToken 消息对应的令牌,不是代理记录的消息时为nil
+func (proxy *ProducerProxy) untrack(ev *kafka.Message) *deliveryToken {
+	token, ok := ev.Opaque.(*deliveryToken)
+	if !ok {
+		return nil
+	}
+	ev.Opaque = token.opaque
+	proxy.inflightLock.Lock()
+	delete(proxy.inflight, token.id)
+	proxy.inflightLock.Unlock()
+	return token
+}
+
+//abandonInflight 不会再收到发送报告时通知还在等待的SendAndWait,消息仍保留在记录中
+func (proxy *ProducerProxy) abandonInflight(err error) {
+	proxy.abandonInflightUntil(^uint64(0), err)
+}
+
+//abandonInflightUntil 与abandonInflight相同,只通知令牌id不大于last的消息
+func (proxy *ProducerProxy) abandonInflightUntil(last uint64, err error) {
+	proxy.inflightLock.Lock()
+	defer proxy.inflightLock.Unlock()
+	for id, token := range proxy.inflight {
+		if id <= last {
+			token.finish(err)
+		}
+	}
+}
+
+//lastToken 最后分配的令牌id
+func (proxy *ProducerProxy) lastToken() uint64 {
+	proxy.inflightLock.Lock()
+	defer proxy.inflightLock.Unlock()
+	return proxy.nextToken
+}
+
+//unsend 记录因代理关闭而没能交给生产者的消息
+func (proxy *ProducerProxy) unsend(msg *kafka.Message) {
+	proxy.inflightLock.Lock()
+	proxy.unsent = append(proxy.unsent, msg)
+	proxy.inflightLock.Unlock()
+}
+
+//undelivered 按发送顺序返回还未收到发送报告和没能交给生产者的消息
+func (proxy *ProducerProxy) undelivered() []*kafka.Message {
+	proxy.inflightLock.Lock()
+	defer proxy.inflightLock.Unlock()
+	ids := make([]uint64, 0, len(proxy.inflight))
+	for id := range proxy.inflight {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	msgs := make([]*kafka.Message, 0, len(ids)+len(proxy.unsent))
+	for _, id := range ids {
+		msgs = append(msgs, proxy.inflight[id].msg)
+	}
+	msgs = append(msgs, proxy.unsent...)
+	proxy.inflight = map[uint64]*deliveryToken{}
+	proxy.unsent = nil
+	return msgs
+}
+
+//Shutdown 优雅关闭发送端
+//先停止接收新消息,等待发送队列中的消息交给生产者,然后flush直到全部发送完成或ctx结束,
+//关闭生产者后等待发送报告处理完,返回还未发送成功的消息,调用方可以自行保存或记录这些消息.
+//发送报告中失败的消息仍由OnDeliveryError注册的回调处理,不会出现在返回值中;
+//未监听发送报告时(WithoutConfirmDelivery)无法知道哪些消息已经发出,只返回没能交给生产者的消息.
+//开启暂存区时,暂存区中的消息保留在磁盘上,下次启动后重放,不会出现在返回值中.
+//ctx在全部消息发送完成前结束时返回ctx的错误
+//@params ctx context.Context 控制等待flush的时间
+//@returns []*kafka.Message 没有发送成功的消息,按发送顺序排列
+func (proxy *ProducerProxy) Shutdown(ctx context.Context) ([]*kafka.Message, error) {
+	if !proxy.IsOk() {
+		return nil, ErrProxyNotYetSettedClient
+	}
+	proxy.clientLock.RLock()
+	closed := proxy.closed
+	proxy.clientLock.RUnlock()
+	if closed {
+		return nil, ErrProxyClosed
+	}
+	proxy.queue.close()
+	var err error
+	if proxy.senderDone != nil {
+		select {
+		case <-proxy.senderDone:
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+	}
+	proxy.stopSpool()
+	for err == nil && proxy.flush(100) > 0 {
+		err = ctx.Err()
+	}
+
+	proxy.clientLock.Lock()
+	proxy.closed = true
+	done := proxy.watchDone
+	proxy.Producer.Close()
+	proxy.clientLock.Unlock()
+	//生产者关闭后事件channel会被关闭,监听goroutine处理完剩下的发送报告后退出
+	if done != nil && proxy.IsWatchingDeliver() {
+		<-done
+	}
+	atomic.StoreInt32(&proxy.deliver_watching, 0)
+	if proxy.senderDone != nil {
+		<-proxy.senderDone
+	}
+	proxy.closeSpool()
+	proxy.abandonInflight(ErrProxyClosed)
+	msgs := proxy.undelivered()
+	if len(msgs) > 0 {
+		proxy.logger().Warn("producer shutdown with undelivered messages", log.Dict{"undelivered": len(msgs)})
+	} else {
+		proxy.logger().Debug("producer shutdown")
+	}
+	return msgs, err
+}
@@ -0,0 +1,421 @@
+package producerproxy
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	"github.com/Golang-Tools/kafkahelper/internal/spool"
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//SpoolSyncPolicy 暂存区写入后何时fsync
+type SpoolSyncPolicy = spool.SyncPolicy
+
+const (
+	//SpoolSyncNever 不主动fsync,由操作系统决定何时落盘
+	SpoolSyncNever = spool.SyncNever
+	//SpoolSyncAlways 每写入一条消息就fsync
+	SpoolSyncAlways = spool.SyncAlways
+	//SpoolSyncInterval 按固定间隔fsync
+	SpoolSyncInterval = spool.SyncInterval
+)
+
+//DefaultSpoolRetryInterval 暂存区默认的重放间隔
+const DefaultSpoolRetryInterval = 5 * time.Second
+
+//spoolBatchSize 每次从暂存区重放的消息数
+const spoolBatchSize = 500
+
+//ParseSpoolSyncPolicy 从名字解析fsync策略,可选的有never,always,interval
+//@params name string 策略名
+func ParseSpoolSyncPolicy(name string) (SpoolSyncPolicy, error) {
+	for _, p := range []SpoolSyncPolicy{SpoolSyncNever, SpoolSyncAlways, SpoolSyncInterval} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return SpoolSyncNever, fmt.Errorf("%w: unknown spool sync policy %q", ErrConfigInvalidValue, name)
+}
+
+//WithSpool 开启本地磁盘暂存区
+//集群不可用(收到ErrAllBrokersDown)时发送的消息,以及因超时,断连等可重试错误发送失败的消息会写入dir下的段文件,
+//连接恢复后按写入顺序重放.暂存区中有消息时新的消息也会先写入暂存区,以保证顺序.
+//重放是至少一次的,重放中途关闭可能导致重复发送;因可重试错误失败后写入的消息会排在它之后已经发出的消息后面
+//@params dir string 段文件所在的文件夹
+func WithSpool(dir string) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.SpoolDir = dir
+	})
+}
+
+//WithSpoolLimits 设置暂存区的大小和保留时间,超过大小时新的消息会被丢弃并返回ErrSpoolFull,超过保留时间的段文件会被删除
+//@params maxBytes int64 暂存区未重放消息的总大小上限,小于等于0表示不限制
+//@params retention time.Duration 消息最多保留多久,小于等于0表示不过期
+func WithSpoolLimits(maxBytes int64, retention time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.SpoolMaxBytes = maxBytes
+		o.SpoolRetention = retention
+	})
+}
+
+//WithSpoolSegmentSize 设置单个段文件的大小上限,默认64MiB
+//@params bytes int64 段文件的大小上限
+func WithSpoolSegmentSize(bytes int64) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.SpoolSegmentBytes = bytes
+	})
+}
+
+//WithSpoolSync 设置暂存区的fsync策略,默认为SpoolSyncNever
+//@params policy SpoolSyncPolicy fsync策略
+//@params interval time.Duration 策略为SpoolSyncInterval时的间隔,默认1s
+func WithSpoolSync(policy SpoolSyncPolicy, interval time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.SpoolSync = policy
+		o.SpoolSyncInterval = interval
+	})
+}
+
+//WithSpoolRetryInterval 设置重放失败后多久再试,默认5s
+//@params interval time.Duration 重放间隔
+func WithSpoolRetryInterval(interval time.Duration) optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.SpoolRetryInterval = interval
+	})
+}
+
+//producerSpool 代理使用的暂存区
+type producerSpool struct {
+	*spool.Spool
+	lock        sync.Mutex
+	unreachable bool
+	stop        chan struct{}
+	done        chan struct{}
+}
+
+//SpoolLen 暂存区中等待重放的消息数,未开启暂存区时为0
+func (proxy *ProducerProxy) SpoolLen() int {
+	if proxy.spool == nil {
+		return 0
+	}
+	return proxy.spool.Len()
+}
+
+//openSpool 按设置打开暂存区并启动重放
+func (proxy *ProducerProxy) openSpool() error {
+	if proxy.Opt.SpoolDir == "" || proxy.spool != nil {
+		return nil
+	}
+	s, err := spool.Open(spool.Options{
+		Dir:          proxy.Opt.SpoolDir,
+		SegmentBytes: proxy.Opt.SpoolSegmentBytes,
+		MaxBytes:     proxy.Opt.SpoolMaxBytes,
+		Retention:    proxy.Opt.SpoolRetention,
+		Sync:         proxy.Opt.SpoolSync,
+		SyncInterval: proxy.Opt.SpoolSyncInterval,
+	})
+	if err != nil {
+		return err
+	}
+	sp := &producerSpool{Spool: s, stop: make(chan struct{}), done: make(chan struct{})}
+	proxy.spool = sp
+	if n := s.Len(); n > 0 {
+		proxy.logger().Info("spool has messages to replay", log.Dict{"messages": n, "dir": proxy.Opt.SpoolDir})
+	}
+	proxy.spoolDepth()
+	go proxy.replayLoop(sp)
+	return nil
+}
+
+//stopSpool 停止重放,暂存区中的消息保留在磁盘上
+func (proxy *ProducerProxy) stopSpool() {
+	sp := proxy.spool
+	if sp == nil {
+		return
+	}
+	select {
+	case <-sp.stop:
+	default:
+		close(sp.stop)
+	}
+	<-sp.done
+}
+
+//closeSpool 停止重放并关闭暂存区
+func (proxy *ProducerProxy) closeSpool() {
+	sp := proxy.spool
+	if sp == nil {
+		return
+	}
+	proxy.stopSpool()
+	err := sp.Close()
+	if err != nil {
+		proxy.logger().Error("close spool get error", log.Dict{"err": err})
+	}
+}
+
+//spooling 集群不可用或暂存区中还有消息时,新消息需要先写入暂存区
+func (proxy *ProducerProxy) spooling() bool {
+	sp := proxy.spool
+	if sp == nil {
+		return false
+	}
+	sp.lock.Lock()
+	unreachable := sp.unreachable
+	sp.lock.Unlock()
+	return unreachable || sp.Len() > 0
+}
+
+//setReachable 记录集群是否可用
+func (proxy *ProducerProxy) setReachable(reachable bool) {
+	sp := proxy.spool
+	if sp == nil {
+		return
+	}
+	sp.lock.Lock()
+	changed := sp.unreachable == reachable
+	sp.unreachable = !reachable
+	sp.lock.Unlock()
+	if !changed {
+		return
+	}
+	if reachable {
+		proxy.logger().Info("cluster is reachable, stop spooling")
+	} else {
+		proxy.logger().Warn("cluster is unreachable, spool messages to disk", log.Dict{"dir": proxy.Opt.SpoolDir})
+	}
+}
+
+//spoolMessage 将消息写入暂存区
+func (proxy *ProducerProxy) spoolMessage(msg *kafka.Message) error {
+	sp := proxy.spool
+	if sp == nil {
+		return ErrSpoolClosed
+	}
+	err := sp.Append(msghelper.MarshalMessage(msg))
+	if err != nil {
+		return err
+	}
+	if proxy.Opt.Metrics != nil {
+		proxy.Opt.Metrics.MessageSpooled(proxy.Name(), topicOf(msg))
+	}
+	proxy.spoolDepth()
+	return nil
+}
+
+//spoolFailed 发送失败且错误可重试时将消息写入暂存区
+//@returns bool 消息是否已经写入暂存区
+func (proxy *ProducerProxy) spoolFailed(msg *kafka.Message) bool {
+	if proxy.spool == nil || !spoolable(msg.TopicPartition.Error) {
+		return false
+	}
+	err := proxy.spoolMessage(msg)
+	if err != nil {
+		proxy.logger().Error("spool failed message get error", log.Dict{"err": err, "topic": topicOf(msg)})
+		return false
+	}
+	proxy.setReachable(false)
+	proxy.logger().Debug("delivery failed, message spooled", log.Dict{"err": msg.TopicPartition.Error, "topic": topicOf(msg)})
+	return true
+}
+
+//spoolDepth 更新暂存区深度指标
+func (proxy *ProducerProxy) spoolDepth() {
+	if proxy.Opt.Metrics != nil && proxy.spool != nil {
+		proxy.Opt.Metrics.SpoolDepth(proxy.Name(), proxy.spool.Len(), proxy.spool.Size())
+	}
+}
+
+//spoolable 判断发送失败的错误是否值得写入暂存区稍后重放
+func spoolable(err error) bool {
+	kerr, ok := err.(kafka.Error)
+	if !ok {
+		return false
+	}
+	if kerr.IsRetriable() {
+		return true
+	}
+	switch kerr.Code() {
+	case kafka.ErrMsgTimedOut, kafka.ErrAllBrokersDown, kafka.ErrTransport, kafka.ErrTimedOut, kafka.ErrTimedOutQueue,
+		kafka.ErrNotLeaderForPartition, kafka.ErrLeaderNotAvailable, kafka.ErrRequestTimedOut, kafka.ErrBrokerNotAvailable,
+		kafka.ErrNetworkException, kafka.ErrNotEnoughReplicas, kafka.ErrNotEnoughReplicasAfterAppend:
+		return true
+	}
+	return false
+}
+
+//replayLoop 定时过期旧消息并重放暂存区中的消息
+func (proxy *ProducerProxy) replayLoop(sp *producerSpool) {
+	defer close(sp.done)
+	interval := proxy.Opt.SpoolRetryInterval
+	if interval <= 0 {
+		interval = DefaultSpoolRetryInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		dropped, err := sp.Expire()
+		if err != nil {
+			proxy.logger().Error("expire spool get error", log.Dict{"err": err})
+		} else if dropped > 0 {
+			proxy.logger().Warn("spooled messages expired", log.Dict{"dropped": dropped})
+			proxy.spoolDepth()
+		}
+		for sp.Len() > 0 && proxy.replayBatch(sp) {
+		}
+		select {
+		case <-sp.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+//replayResult 重放中一条消息的结果
+type replayResult int
+
+const (
+	replayPending replayResult = iota
+	replayDone
+	replayRetry
+)
+
+//replayBatch 按顺序重放一批消息,等待全部发送报告后确认发送成功的前缀
+//不可重试的错误交给OnDeliveryError注册的回调后跳过
+//@returns bool 整批都已确认,可以继续重放
+func (proxy *ProducerProxy) replayBatch(sp *producerSpool) bool {
+	records, err := sp.Peek(spoolBatchSize)
+	if err != nil {
+		proxy.logger().Error("read spool get error", log.Dict{"err": err})
+		if len(records) == 0 {
+			return false
+		}
+	}
+	results := make([]replayResult, len(records))
+	deliveries := make(chan kafka.Event, len(records))
+	produced := 0
+	for i, data := range records {
+		msg, err := msghelper.UnmarshalMessage(data)
+		if err != nil {
+			proxy.logger().Error("spooled message is invalid, skip it", log.Dict{"err": err})
+			results[i] = replayDone
+			continue
+		}
+		msg.Opaque = i
+		err = proxy.produceSpooled(sp, msg, deliveries)
+		if err != nil {
+			if err != ErrProxyClosed {
+				proxy.logger().Error("replay spooled message get error", log.Dict{"err": err, "topic": topicOf(msg)})
+			}
+			break
+		}
+		produced += 1
+	}
+	timeout := time.NewTimer(proxy.deliveryTimeout())
+	defer timeout.Stop()
+wait:
+	for ; produced > 0; produced-- {
+		select {
+		case <-sp.stop:
+			return false
+		case <-timeout.C:
+			proxy.logger().Warn("wait for replayed messages timeout")
+			break wait
+		case e := <-deliveries:
+			ev, ok := e.(*kafka.Message)
+			if !ok {
+				continue
+			}
+			i := ev.Opaque.(int)
+			ev.Opaque = nil
+			switch {
+			case ev.TopicPartition.Error == nil:
+				results[i] = replayDone
+				if proxy.Opt.Metrics != nil {
+					proxy.Opt.Metrics.MessageDelivered(proxy.Name(), topicOf(ev), ev.TopicPartition.Partition)
+				}
+			case spoolable(ev.TopicPartition.Error):
+				results[i] = replayRetry
+			default:
+				results[i] = replayDone
+				if proxy.Opt.Metrics != nil {
+					proxy.Opt.Metrics.DeliveryFailed(proxy.Name(), topicOf(ev), ev.TopicPartition.Partition)
+				}
+				if len(proxy.deliveryErrorCallback) > 0 {
+					for _, cb := range proxy.deliveryErrorCallback {
+						cb(ev)
+					}
+				} else {
+					proxy.logger().Error("Replay spooled message failed", log.Dict{"TopicPartition": ev.TopicPartition})
+				}
+			}
+		}
+	}
+	committed := 0
+	for committed < len(results) && results[committed] == replayDone {
+		committed += 1
+	}
+	if committed > 0 {
+		err = sp.Commit(committed)
+		if err != nil {
+			proxy.logger().Error("commit spool get error", log.Dict{"err": err})
+			return false
+		}
+		proxy.spoolDepth()
+	}
+	if committed < len(results) {
+		proxy.setReachable(false)
+		return false
+	}
+	proxy.setReachable(true)
+	return true
+}
+
+//produceSpooled 将暂存区中的消息交给生产者,发送报告发到deliveries而不是代理的事件监听
+func (proxy *ProducerProxy) produceSpooled(sp *producerSpool, msg *kafka.Message, deliveries chan kafka.Event) error {
+	for {
+		select {
+		case <-sp.stop:
+			return ErrProxyClosed
+		default:
+		}
+		proxy.clientLock.RLock()
+		if proxy.closed || !proxy.IsOk() {
+			proxy.clientLock.RUnlock()
+			return ErrProxyClosed
+		}
+		err := proxy.Produce(msg, deliveries)
+		proxy.clientLock.RUnlock()
+		if !isQueueFull(err) {
+			if err == nil && proxy.Opt.Metrics != nil {
+				proxy.Opt.Metrics.MessageSent(proxy.Name(), topicOf(msg))
+			}
+			return err
+		}
+		proxy.flush(100)
+	}
+}
+
+//deliveryTimeout 等待发送报告的最长时间,比librdkafka的message.timeout.ms多留一些余量
+//设置的值可能是从文件或环境变量中读到的字符串,按设置项的类型转换
+func (proxy *ProducerProxy) deliveryTimeout() time.Duration {
+	ms := 300000
+	for _, key := range []string{"message.timeout.ms", "delivery.timeout.ms"} {
+		v, ok := proxy.Opt.ConfigMap[key]
+		if !ok {
+			continue
+		}
+		i, err := kafkaconf.Convert(kafkaconf.Int, nil, v)
+		if err != nil {
+			proxy.logger().Warn("invalid delivery timeout setting, ignored", log.Dict{"key": key, "value": v})
+			continue
+		}
+		ms = i.(int)
+	}
+	return time.Duration(ms)*time.Millisecond + 10*time.Second
+}
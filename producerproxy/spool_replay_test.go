@@ -0,0 +1,111 @@
+package producerproxy_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+func newSpoolCluster(t *testing.T, topic string) *kafkatest.Cluster {
+	t.Helper()
+	c, err := kafkatest.NewCluster(1)
+	if err != nil {
+		t.Fatalf("NewCluster: %v", err)
+	}
+	t.Cleanup(c.Close)
+	if err := c.CreateTopic(topic, 1); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	return c
+}
+
+func newSpoolProducer(t *testing.T, c *kafkatest.Cluster, dir string) *producerproxy.ProducerProxy {
+	t.Helper()
+	proxy, err := c.NewProducerProxy(
+		producerproxy.WithSpool(dir),
+		producerproxy.WithSpoolRetryInterval(100*time.Millisecond),
+		//从配置文件读到的值是字符串
+		producerproxy.WithProducerSetting("message.timeout.ms", "300"),
+	)
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	return proxy
+}
+
+func sendValues(t *testing.T, proxy *producerproxy.ProducerProxy, topic string, values ...string) {
+	t.Helper()
+	for _, v := range values {
+		if err := proxy.Send(&kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 0}, Value: []byte(v)}); err != nil {
+			t.Fatalf("Send(%s): %v", v, err)
+		}
+	}
+}
+
+func waitSpoolLen(t *testing.T, proxy *producerproxy.ProducerProxy, n int) {
+	t.Helper()
+	deadline := time.Now().Add(10 * time.Second)
+	for proxy.SpoolLen() != n {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d spooled messages, got %d", n, proxy.SpoolLen())
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func expectValues(t *testing.T, c *kafkatest.Cluster, topic string, values ...string) {
+	t.Helper()
+	msgs, err := c.ExpectMessages(topic, len(values), 10*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := []string{}
+	for _, m := range msgs {
+		got = append(got, string(m.Value))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(values) {
+		t.Fatalf("expect %v in order, got %v", values, got)
+	}
+}
+
+func TestSpoolReplayAfterOutage(t *testing.T) {
+	topic := "spooled"
+	c := newSpoolCluster(t, topic)
+	if err := c.SetBrokerDown(-1); err != nil {
+		t.Fatalf("SetBrokerDown: %v", err)
+	}
+	proxy := newSpoolProducer(t, c, t.TempDir())
+	sendValues(t, proxy, topic, "m1", "m2", "m3")
+	//发送超时后消息写入暂存区
+	waitSpoolLen(t, proxy, 3)
+	if err := c.SetBrokerUp(-1); err != nil {
+		t.Fatalf("SetBrokerUp: %v", err)
+	}
+	waitSpoolLen(t, proxy, 0)
+	expectValues(t, c, topic, "m1", "m2", "m3")
+}
+
+func TestSpoolReplayAfterRestart(t *testing.T) {
+	topic := "spooled"
+	c := newSpoolCluster(t, topic)
+	dir := t.TempDir()
+	if err := c.SetBrokerDown(-1); err != nil {
+		t.Fatalf("SetBrokerDown: %v", err)
+	}
+	proxy := newSpoolProducer(t, c, dir)
+	sendValues(t, proxy, topic, "m1", "m2")
+	waitSpoolLen(t, proxy, 2)
+	proxy.Close()
+	if err := c.SetBrokerUp(-1); err != nil {
+		t.Fatalf("SetBrokerUp: %v", err)
+	}
+	//重启后打开已有的暂存区,先重放暂存的消息,新消息排在其后
+	proxy = newSpoolProducer(t, c, dir)
+	sendValues(t, proxy, topic, "m3")
+	waitSpoolLen(t, proxy, 0)
+	expectValues(t, c, topic, "m1", "m2", "m3")
+}
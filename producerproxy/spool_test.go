@@ -0,0 +1,28 @@
+package producerproxy
+
+import (
+	"testing"
+	"time"
+)
+
+func TestDeliveryTimeout(t *testing.T) {
+	cases := []struct {
+		settings map[string]any
+		want     time.Duration
+	}{
+		{settings: map[string]any{}, want: 310 * time.Second},
+		{settings: map[string]any{"message.timeout.ms": 30000}, want: 40 * time.Second},
+		{settings: map[string]any{"message.timeout.ms": "30000"}, want: 40 * time.Second},
+		{settings: map[string]any{"delivery.timeout.ms": int64(5000)}, want: 15 * time.Second},
+		{settings: map[string]any{"message.timeout.ms": "soon"}, want: 310 * time.Second},
+	}
+	for _, c := range cases {
+		proxy := New()
+		for k, v := range c.settings {
+			proxy.Opt.ConfigMap[k] = v
+		}
+		if got := proxy.deliveryTimeout(); got != c.want {
+			t.Fatalf("%v: expect %v, got %v", c.settings, c.want, got)
+		}
+	}
+}
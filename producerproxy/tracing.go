@@ -0,0 +1,77 @@
+package producerproxy
+
+import (
+	"context"
+
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Golang-Tools/kafkahelper/producerproxy"
+
+//defaultPropagator 默认使用w3c的trace-context和baggage
+var defaultPropagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+func (proxy *ProducerProxy) propagator() propagation.TextMapPropagator {
+	if proxy.Opt.Propagator != nil {
+		return proxy.Opt.Propagator
+	}
+	return defaultPropagator
+}
+
+func (proxy *ProducerProxy) tracer() trace.Tracer {
+	tp := proxy.Opt.TracerProvider
+	if tp == nil {
+		tp = otel.GetTracerProvider()
+	}
+	return tp.Tracer(tracerName)
+}
+
+//startSpan 为发送的消息创建producer span并将上下文注入到消息的headers中
+//未设置WithTracing时返回nil
+func (proxy *ProducerProxy) startSpan(ctx context.Context, msg *kafka.Message) trace.Span {
+	if !proxy.Opt.Tracing {
+		return nil
+	}
+	topic := topicOf(msg)
+	attrs := []attribute.KeyValue{
+		semconv.MessagingSystemKey.String("kafka"),
+		semconv.MessagingDestinationKey.String(topic),
+		semconv.MessagingDestinationKindTopic,
+		semconv.MessagingMessagePayloadSizeBytesKey.Int(len(msg.Value)),
+	}
+	if msg.TopicPartition.Partition != kafka.PartitionAny {
+		attrs = append(attrs, semconv.MessagingKafkaPartitionKey.Int64(int64(msg.TopicPartition.Partition)))
+	}
+	if msg.Key != nil {
+		attrs = append(attrs, semconv.MessagingKafkaMessageKeyKey.String(string(msg.Key)))
+	}
+	if msg.Value == nil {
+		attrs = append(attrs, semconv.MessagingKafkaTombstoneKey.Bool(true))
+	}
+	carrier := msghelper.NewHeaderCarrier(msg)
+	if id := carrier.Get(msghelper.HeaderMessageID); id != "" {
+		attrs = append(attrs, semconv.MessagingMessageIDKey.String(id))
+	}
+	ctx, span := proxy.tracer().Start(ctx, topic+" send", trace.WithSpanKind(trace.SpanKindProducer), trace.WithAttributes(attrs...))
+	proxy.propagator().Inject(ctx, carrier)
+	return span
+}
+
+//endSpan 结束producer span,err不为nil时记录错误
+func endSpan(span trace.Span, err error) {
+	if span == nil {
+		return
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,93 @@
+package producerproxy_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func newTracedProducer(t *testing.T, b *kafkatest.Broker) (*producerproxy.ProducerProxy, *tracetest.SpanRecorder) {
+	t.Helper()
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	proxy, err := b.NewProducerProxy(producerproxy.WithTracing(tp))
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	t.Cleanup(proxy.Close)
+	return proxy, recorder
+}
+
+//waitEnded 等待recorder中有n个已结束的span
+func waitEnded(t *testing.T, recorder *tracetest.SpanRecorder, n int) []sdktrace.ReadOnlySpan {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		ended := recorder.Ended()
+		if len(ended) >= n {
+			return ended
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expect %d ended spans, got %d", n, len(ended))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestTracingInjectsHeaders(t *testing.T) {
+	b := kafkatest.NewBroker()
+	proxy, recorder := newTracedProducer(t, b)
+	topic := "traced"
+	msg := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: kafka.PartitionAny}, Value: []byte("v")}
+	if err := proxy.SendAndWait(msg); err != nil {
+		t.Fatalf("SendAndWait: %v", err)
+	}
+	spans := waitEnded(t, recorder, 1)
+	span := spans[0]
+	if span.SpanKind() != trace.SpanKindProducer {
+		t.Fatalf("expect producer span, got %v", span.SpanKind())
+	}
+	stored := b.Messages(topic)
+	if len(stored) != 1 {
+		t.Fatalf("expect 1 message, got %d", len(stored))
+	}
+	ctx := propagation.TraceContext{}.Extract(context.Background(), msghelper.NewHeaderCarrier(stored[0]))
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.IsValid() {
+		t.Fatal("traceparent header should be injected")
+	}
+	if sc.TraceID() != span.SpanContext().TraceID() || sc.SpanID() != span.SpanContext().SpanID() {
+		t.Fatalf("extracted span context %v does not match the producer span %v", sc, span.SpanContext())
+	}
+}
+
+func TestTracingSpanEndsOnDeliveryReport(t *testing.T) {
+	b := kafkatest.NewBroker()
+	if err := b.CreateTopic("failing", 1); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	proxy, recorder := newTracedProducer(t, b)
+	topic := "failing"
+	msg := &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: 9}, Value: []byte("v")}
+	if err := proxy.Send(msg); err != nil {
+		t.Fatalf("Send: %v", err)
+	}
+	spans := waitEnded(t, recorder, 1)
+	status := spans[0].Status()
+	if status.Code != codes.Error {
+		t.Fatalf("span of a failed delivery should have error status, got %v", status)
+	}
+	if len(spans[0].Events()) == 0 {
+		t.Fatal("delivery error should be recorded on the span")
+	}
+}
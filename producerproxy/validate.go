@@ -0,0 +1,37 @@
+package producerproxy
+
+import (
+	"fmt"
+
+	"github.com/Golang-Tools/kafkahelper/internal/kafkaconf"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+)
+
+//WithoutValidation 初始化时不校验设置,用于使用本模块还不认识的librdkafka设置项
+func WithoutValidation() optparams.Option[Options] {
+	return optparams.NewFuncOption(func(o *Options) {
+		o.SkipValidation = true
+	})
+}
+
+//Validate 校验设置,包括只适用于消费者的设置项,值的类型以及相互冲突的设置,未知的设置项交给librdkafka检查
+func (o Options) Validate() error {
+	return kafkaconf.Validate(o.Effective(), kafkaconf.Producer)
+}
+
+//String 打印设置,其中的密码和密钥会被隐藏
+func (o Options) String() string {
+	return fmt.Sprintf("Options{name=%q profile=%q parallel_callback=%t not_confirm_delivery=%t config={%s}}", o.Name, o.Profile, o.ParallelCallback, o.NotConfirmDelivery, kafkaconf.Format(o.Effective()))
+}
+
+//LogValue 用于打印日志的设置字段,其中的密码和密钥会被隐藏
+func (o Options) LogValue() log.Dict {
+	return log.Dict{
+		"name":                 o.Name,
+		"profile":              o.Profile,
+		"parallel_callback":    o.ParallelCallback,
+		"not_confirm_delivery": o.NotConfirmDelivery,
+		"config":               kafkaconf.Redact(o.Effective()),
+	}
+}
@@ -0,0 +1,225 @@
+package recorder
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+)
+
+//binaryMagic 二进制录制文件的文件头
+var binaryMagic = []byte("KREC\x01")
+
+//maxRecordSize 单条二进制记录的最大长度,用于识别损坏的文件
+const maxRecordSize = 1 << 30
+
+//ErrInvalidFile 文件不是有效的录制文件
+var ErrInvalidFile = errors.New("invalid record file")
+
+//Writer 写入录制文件,可以并发调用
+type Writer struct {
+	format Format
+	lock   sync.Mutex
+	buf    *bufio.Writer
+	closer io.Closer
+	tmp    []byte
+}
+
+//NewWriter 创建写入器,二进制格式会先写入文件头
+//@params w io.Writer 写入的目标
+//@params format Format 文件格式
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	wr := &Writer{format: format, buf: bufio.NewWriter(w)}
+	if c, ok := w.(io.Closer); ok {
+		wr.closer = c
+	}
+	if format == Binary {
+		if _, err := wr.buf.Write(binaryMagic); err != nil {
+			return nil, err
+		}
+	}
+	return wr, nil
+}
+
+//Create 创建录制文件,文件已存在时会被覆盖
+//@params path string 文件路径
+//@params format Format 文件格式
+func Create(path string, format Format) (*Writer, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w, err := NewWriter(f, format)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+//Write 写入一条记录,记录先写入缓冲区,需要落盘时调用Flush
+//@params rec *Record 要写入的记录
+func (w *Writer) Write(rec *Record) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.format == Binary {
+		var recordedAt int64
+		if !rec.RecordedAt.IsZero() {
+			recordedAt = rec.RecordedAt.UnixNano()
+		}
+		payload := appendVarint(nil, recordedAt)
+		payload = append(payload, msghelper.MarshalMessage(rec.Message())...)
+		w.tmp = appendUvarint(w.tmp[:0], uint64(len(payload)))
+		if _, err := w.buf.Write(w.tmp); err != nil {
+			return err
+		}
+		_, err := w.buf.Write(payload)
+		return err
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	_, err = w.buf.Write(data)
+	return err
+}
+
+//Flush 将缓冲区写入目标
+func (w *Writer) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.buf.Flush()
+}
+
+//Close 写入缓冲区并关闭目标
+func (w *Writer) Close() error {
+	err := w.Flush()
+	if w.closer != nil {
+		if cerr := w.closer.Close(); cerr != nil && err == nil {
+			err = cerr
+		}
+	}
+	return err
+}
+
+//Reader 按顺序读取录制文件,根据文件头自动识别格式
+type Reader struct {
+	format Format
+	buf    *bufio.Reader
+	closer io.Closer
+}
+
+//NewReader 创建读取器
+//@params r io.Reader 读取的来源
+func NewReader(r io.Reader) (*Reader, error) {
+	rd := &Reader{format: NDJSON, buf: bufio.NewReader(r)}
+	if c, ok := r.(io.Closer); ok {
+		rd.closer = c
+	}
+	head, err := rd.buf.Peek(len(binaryMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if bytes.Equal(head, binaryMagic) {
+		rd.format = Binary
+		rd.buf.Discard(len(binaryMagic))
+	}
+	return rd, nil
+}
+
+//Open 打开录制文件
+//@params path string 文件路径
+func Open(path string) (*Reader, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+//Format 文件的格式
+func (r *Reader) Format() Format {
+	return r.format
+}
+
+//Next 读取下一条记录,没有更多记录时返回io.EOF
+func (r *Reader) Next() (*Record, error) {
+	if r.format == Binary {
+		size, err := binary.ReadUvarint(r.buf)
+		if err != nil {
+			if err == io.EOF {
+				return nil, io.EOF
+			}
+			return nil, ErrInvalidFile
+		}
+		if size > maxRecordSize {
+			return nil, ErrInvalidFile
+		}
+		payload := make([]byte, size)
+		if _, err := io.ReadFull(r.buf, payload); err != nil {
+			return nil, ErrInvalidFile
+		}
+		recordedAt, n := binary.Varint(payload)
+		if n <= 0 {
+			return nil, ErrInvalidFile
+		}
+		msg, err := msghelper.UnmarshalMessage(payload[n:])
+		if err != nil {
+			return nil, err
+		}
+		rec := FromMessage(msg)
+		rec.RecordedAt = time.Time{}
+		if recordedAt != 0 {
+			rec.RecordedAt = time.Unix(0, recordedAt)
+		}
+		return rec, nil
+	}
+	for {
+		line, err := r.buf.ReadBytes('\n')
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		rec := &Record{}
+		if jerr := json.Unmarshal(line, rec); jerr != nil {
+			return nil, jerr
+		}
+		return rec, nil
+	}
+}
+
+//Close 关闭来源
+func (r *Reader) Close() error {
+	if r.closer != nil {
+		return r.closer.Close()
+	}
+	return nil
+}
+
+func appendUvarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendVarint(buf []byte, v int64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutVarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
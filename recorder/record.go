@@ -0,0 +1,121 @@
+//Package recorder 录制消费到的消息并回放
+//录制中间件将消息连同分区,offset和时间戳写入NDJSON或带长度前缀的二进制文件,
+//回放时可以把记录交给消息处理函数或通过生产者代理重新发送,支持按原速,加速或尽快回放
+package recorder
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Logger 模块的logger
+var Logger *log.Log
+
+func init() {
+	Logger = logging.New(log.Dict{"module": "kafka-recorder"})
+}
+
+//Format 录制文件的格式
+type Format int
+
+const (
+	//NDJSON 每行一条json记录,便于查看和编辑
+	NDJSON Format = iota
+	//Binary 带长度前缀的二进制记录,体积更小
+	Binary
+)
+
+//String 格式的名字
+func (f Format) String() string {
+	switch f {
+	case NDJSON:
+		return "ndjson"
+	case Binary:
+		return "binary"
+	default:
+		return fmt.Sprintf("Format(%d)", int(f))
+	}
+}
+
+//ParseFormat 由名字得到格式,可选的有ndjson,binary
+//@params name string 格式的名字,不区分大小写
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(name) {
+	case "ndjson", "json", "jsonl":
+		return NDJSON, nil
+	case "binary", "bin":
+		return Binary, nil
+	default:
+		return NDJSON, fmt.Errorf("unknown record format %q", name)
+	}
+}
+
+//Header 录制的header,按原顺序保存,同一个key可以出现多次
+type Header struct {
+	Key   string `json:"key"`
+	Value []byte `json:"value"`
+}
+
+//Record 一条录制的消息,带有分区,offset,时间戳和录制时间
+//不复用msghelper.ConciseMsg,因为它的headers是map,会丢失header的顺序和重复的key
+type Record struct {
+	Topic         string    `json:"topic"`
+	Value         []byte    `json:"value"`
+	Key           []byte    `json:"key,omitempty"`
+	Headers       []Header  `json:"headers,omitempty"`
+	Partition     int32     `json:"partition"`
+	Offset        int64     `json:"offset"`
+	Timestamp     time.Time `json:"timestamp"`
+	TimestampType int       `json:"timestamp_type,omitempty"`
+	RecordedAt    time.Time `json:"recorded_at"`
+}
+
+//FromMessage 由消费到的消息构造记录,录制时间为当前时间
+//@params msg *kafka.Message 消费到的消息
+func FromMessage(msg *kafka.Message) *Record {
+	rec := &Record{
+		Value:         msg.Value,
+		Key:           msg.Key,
+		Partition:     msg.TopicPartition.Partition,
+		Offset:        int64(msg.TopicPartition.Offset),
+		Timestamp:     msg.Timestamp,
+		TimestampType: int(msg.TimestampType),
+		RecordedAt:    time.Now(),
+	}
+	if msg.TopicPartition.Topic != nil {
+		rec.Topic = *msg.TopicPartition.Topic
+	}
+	for _, h := range msg.Headers {
+		rec.Headers = append(rec.Headers, Header{Key: h.Key, Value: h.Value})
+	}
+	return rec
+}
+
+//Message 将记录还原为kafka消息,headers保持录制时的顺序
+func (rec *Record) Message() *kafka.Message {
+	topic := rec.Topic
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic, Partition: rec.Partition, Offset: kafka.Offset(rec.Offset)},
+		Value:          rec.Value,
+		Key:            rec.Key,
+		Timestamp:      rec.Timestamp,
+		TimestampType:  kafka.TimestampType(rec.TimestampType),
+	}
+	for _, h := range rec.Headers {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: h.Key, Value: h.Value})
+	}
+	return msg
+}
+
+//at 回放时使用的时间点,优先使用录制时间
+func (rec *Record) at() time.Time {
+	if !rec.RecordedAt.IsZero() {
+		return rec.RecordedAt
+	}
+	return rec.Timestamp
+}
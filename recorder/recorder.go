@@ -0,0 +1,250 @@
+package recorder
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//MiddlewareOptions 录制中间件的设置
+type MiddlewareOptions struct {
+	Topics        []string
+	FlushEvery    int
+	FlushInterval time.Duration
+}
+
+var DefaultMiddlewareOptions = MiddlewareOptions{
+	FlushEvery: 1,
+}
+
+//WithTopics 只录制这些topic的消息,默认录制全部
+//@params topics ...string 要录制的topic
+func WithTopics(topics ...string) optparams.Option[MiddlewareOptions] {
+	return optparams.NewFuncOption(func(o *MiddlewareOptions) {
+		o.Topics = topics
+	})
+}
+
+//WithFlushEvery 设置每录制多少条消息flush一次,默认为1即每条消息都在交给处理函数前落盘
+//@params n int 两次flush之间的消息数,小于等于0时不按条数flush
+func WithFlushEvery(n int) optparams.Option[MiddlewareOptions] {
+	return optparams.NewFuncOption(func(o *MiddlewareOptions) {
+		o.FlushEvery = n
+	})
+}
+
+//WithFlushInterval 设置录制消息时距上次flush超过多久就flush,可以和WithFlushEvery同时使用
+//@params interval time.Duration 两次flush之间的最长间隔,小于等于0时不按时间flush
+func WithFlushInterval(interval time.Duration) optparams.Option[MiddlewareOptions] {
+	return optparams.NewFuncOption(func(o *MiddlewareOptions) {
+		o.FlushInterval = interval
+	})
+}
+
+//Middleware 录制消息的中间件,消息在交给处理函数前写入,按设置flush
+//默认每条消息都flush,处理函数崩溃时导致崩溃的消息也已经录制;放宽flush条件可以减少写盘次数,未flush的记录在Writer.Close时写入
+//写入失败只记录日志,不影响消息处理
+//@params w *Writer 录制文件的写入器
+//@params opts ...optparams.Option[MiddlewareOptions] 中间件的设置
+func Middleware(w *Writer, opts ...optparams.Option[MiddlewareOptions]) consumerproxy.Middleware {
+	opt := DefaultMiddlewareOptions
+	optparams.GetOption(&opt, opts...)
+	filter := map[string]bool{}
+	for _, t := range opt.Topics {
+		filter[t] = true
+	}
+	var lock sync.Mutex
+	unflushed := 0
+	lastFlush := time.Now()
+	//flushDue 记录一条消息并判断是否需要flush
+	flushDue := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		unflushed += 1
+		due := (opt.FlushEvery > 0 && unflushed >= opt.FlushEvery) || (opt.FlushInterval > 0 && time.Since(lastFlush) >= opt.FlushInterval)
+		if due {
+			unflushed = 0
+			lastFlush = time.Now()
+		}
+		return due
+	}
+	return func(next consumerproxy.OnMsgWithCtxCallback) consumerproxy.OnMsgWithCtxCallback {
+		return func(ctx context.Context, msg *kafka.Message) error {
+			rec := FromMessage(msg)
+			if len(filter) == 0 || filter[rec.Topic] {
+				err := w.Write(rec)
+				if err == nil && flushDue() {
+					err = w.Flush()
+				}
+				if err != nil {
+					Logger.Error("record message get error", log.Dict{"err": err, "topic": rec.Topic, "partition": rec.Partition, "offset": rec.Offset})
+				}
+			}
+			return next(ctx, msg)
+		}
+	}
+}
+
+//Speed 回放速度
+type Speed float64
+
+const (
+	//AsFastAsPossible 不等待,尽快回放
+	AsFastAsPossible Speed = 0
+	//RealTime 按录制时的间隔回放
+	RealTime Speed = 1
+)
+
+//ReplayOptions 回放的设置
+type ReplayOptions struct {
+	Speed         Speed
+	Filter        func(rec *Record) bool
+	TopicMap      func(topic string) string
+	KeepPartition bool
+	WaitDelivery  bool
+	clock         clock
+}
+
+//clock 回放等待使用的时钟,测试时替换
+type clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time { return time.Now() }
+
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+var DefaultReplayOptions = ReplayOptions{
+	Speed: AsFastAsPossible,
+}
+
+//WithSpeed 设置回放速度,1为原速,大于1为加速,0为尽快回放
+//@params speed Speed 回放速度
+func WithSpeed(speed Speed) optparams.Option[ReplayOptions] {
+	return optparams.NewFuncOption(func(o *ReplayOptions) {
+		o.Speed = speed
+	})
+}
+
+//WithFilter 只回放filter返回true的记录
+//@params filter func(rec *Record) bool 记录过滤函数
+func WithFilter(filter func(rec *Record) bool) optparams.Option[ReplayOptions] {
+	return optparams.NewFuncOption(func(o *ReplayOptions) {
+		o.Filter = filter
+	})
+}
+
+//WithTopicMap 重新发送时改写topic,用于发往测试环境的topic
+//@params fn func(topic string) string 由录制的topic得到发送的topic
+func WithTopicMap(fn func(topic string) string) optparams.Option[ReplayOptions] {
+	return optparams.NewFuncOption(func(o *ReplayOptions) {
+		o.TopicMap = fn
+	})
+}
+
+//WithKeepPartition 重新发送时发往录制时的分区,默认由分区器重新选择
+func WithKeepPartition() optparams.Option[ReplayOptions] {
+	return optparams.NewFuncOption(func(o *ReplayOptions) {
+		o.KeepPartition = true
+	})
+}
+
+//WithWaitDelivery 重新发送时每条消息都等待发送报告,默认只保证按顺序交给生产者代理
+func WithWaitDelivery() optparams.Option[ReplayOptions] {
+	return optparams.NewFuncOption(func(o *ReplayOptions) {
+		o.WaitDelivery = true
+	})
+}
+
+//replay 按设置的速度依次处理记录
+func replay(ctx context.Context, r *Reader, opt ReplayOptions, fn func(rec *Record) error) (int, error) {
+	count := 0
+	var first time.Time
+	var start time.Time
+	clk := opt.clock
+	if clk == nil {
+		clk = realClock{}
+	}
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return count, nil
+		}
+		if err != nil {
+			return count, err
+		}
+		if opt.Filter != nil && !opt.Filter(rec) {
+			continue
+		}
+		if opt.Speed > 0 {
+			at := rec.at()
+			if first.IsZero() {
+				first, start = at, clk.Now()
+			} else if wait := start.Add(time.Duration(float64(at.Sub(first)) / float64(opt.Speed))).Sub(clk.Now()); wait > 0 {
+				select {
+				case <-clk.After(wait):
+				case <-ctx.Done():
+					return count, ctx.Err()
+				}
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return count, err
+		}
+		err = fn(rec)
+		if err != nil {
+			return count, err
+		}
+		count++
+	}
+}
+
+//Replay 将记录依次交给消息处理函数,处理函数返回错误时停止
+//@params ctx context.Context 控制回放,结束时停止
+//@params r *Reader 录制文件的读取器
+//@params handler consumerproxy.OnMsgWithCtxCallback 消息处理函数
+//@returns int 已处理的记录数
+func Replay(ctx context.Context, r *Reader, handler consumerproxy.OnMsgWithCtxCallback, opts ...optparams.Option[ReplayOptions]) (int, error) {
+	opt := DefaultReplayOptions
+	optparams.GetOption(&opt, opts...)
+	return replay(ctx, r, opt, func(rec *Record) error {
+		return handler(ctx, rec.Message())
+	})
+}
+
+//Republish 将记录通过生产者代理重新发送,发送失败时停止
+//@params ctx context.Context 控制回放,结束时停止
+//@params r *Reader 录制文件的读取器
+//@params producer *producerproxy.ProducerProxy 生产者代理
+//@returns int 已发送的记录数
+func Republish(ctx context.Context, r *Reader, producer *producerproxy.ProducerProxy, opts ...optparams.Option[ReplayOptions]) (int, error) {
+	opt := DefaultReplayOptions
+	optparams.GetOption(&opt, opts...)
+	return replay(ctx, r, opt, func(rec *Record) error {
+		msg := rec.Message()
+		if opt.TopicMap != nil {
+			topic := opt.TopicMap(rec.Topic)
+			msg.TopicPartition.Topic = &topic
+		}
+		if !opt.KeepPartition {
+			msg.TopicPartition.Partition = kafka.PartitionAny
+		}
+		msg.TopicPartition.Offset = kafka.OffsetInvalid
+		msg.Timestamp = time.Time{}
+		msg.TimestampType = kafka.TimestampNotAvailable
+		if opt.WaitDelivery {
+			return producer.SendAndWaitWithContext(ctx, msg)
+		}
+		return producer.SendWithContext(ctx, msg)
+	})
+}
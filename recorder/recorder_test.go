@@ -0,0 +1,249 @@
+package recorder
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//fakeClock 等待时直接前进时间并记录等待的时长
+type fakeClock struct {
+	now   time.Time
+	waits []time.Duration
+}
+
+func (c *fakeClock) Now() time.Time { return c.now }
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.waits = append(c.waits, d)
+	c.now = c.now.Add(d)
+	ch := make(chan time.Time, 1)
+	ch <- c.now
+	return ch
+}
+
+func withClock(c clock) optparams.Option[ReplayOptions] {
+	return optparams.NewFuncOption(func(o *ReplayOptions) {
+		o.clock = c
+	})
+}
+
+func testRecords() []*Record {
+	base := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	return []*Record{
+		{
+			Topic:         "orders",
+			Value:         []byte("v1"),
+			Key:           []byte("k1"),
+			Headers:       []Header{{Key: "trace", Value: []byte("a")}, {Key: "retry", Value: []byte("1")}, {Key: "trace", Value: []byte("b")}},
+			Partition:     2,
+			Offset:        10,
+			Timestamp:     base,
+			TimestampType: int(kafka.TimestampCreateTime),
+			RecordedAt:    base.Add(time.Second),
+		},
+		{
+			Topic:      "orders",
+			Value:      []byte("v2"),
+			Partition:  0,
+			Offset:     11,
+			Timestamp:  base.Add(time.Second),
+			RecordedAt: base.Add(3 * time.Second),
+		},
+	}
+}
+
+func write(t *testing.T, format Format, records []*Record) *bytes.Buffer {
+	t.Helper()
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, format)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	for _, rec := range records {
+		if err := w.Write(rec); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	return buf
+}
+
+func readAll(r *Reader) ([]*Record, error) {
+	result := []*Record{}
+	for {
+		rec, err := r.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return result, err
+		}
+		result = append(result, rec)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, format := range []Format{NDJSON, Binary} {
+		t.Run(format.String(), func(t *testing.T) {
+			want := testRecords()
+			r, err := NewReader(write(t, format, want))
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			if r.Format() != format {
+				t.Fatalf("expect format %v, got %v", format, r.Format())
+			}
+			got, err := readAll(r)
+			if err != nil {
+				t.Fatalf("read: %v", err)
+			}
+			if len(got) != len(want) {
+				t.Fatalf("expect %d records, got %d", len(want), len(got))
+			}
+			for i := range want {
+				w, g := want[i], got[i]
+				if g.Topic != w.Topic || !bytes.Equal(g.Value, w.Value) || !bytes.Equal(g.Key, w.Key) ||
+					g.Partition != w.Partition || g.Offset != w.Offset || g.TimestampType != w.TimestampType ||
+					!g.Timestamp.Equal(w.Timestamp) || !g.RecordedAt.Equal(w.RecordedAt) {
+					t.Fatalf("record %d: expect %+v, got %+v", i, w, g)
+				}
+				if len(g.Headers) != len(w.Headers) || (len(w.Headers) > 0 && !reflect.DeepEqual(g.Headers, w.Headers)) {
+					t.Fatalf("record %d: headers should keep order and duplicated keys, expect %v, got %v", i, w.Headers, g.Headers)
+				}
+			}
+		})
+	}
+}
+
+func TestMessageKeepsHeaderOrder(t *testing.T) {
+	topic := "orders"
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &topic},
+		Headers:        []kafka.Header{{Key: "b", Value: []byte("1")}, {Key: "a", Value: []byte("2")}, {Key: "b", Value: []byte("3")}},
+	}
+	got := FromMessage(msg).Message().Headers
+	if !reflect.DeepEqual(got, msg.Headers) {
+		t.Fatalf("expect %v, got %v", msg.Headers, got)
+	}
+}
+
+func TestReaderRejectsBrokenInput(t *testing.T) {
+	binary := write(t, Binary, testRecords()).Bytes()
+	cases := []struct {
+		name string
+		data []byte
+		read int
+	}{
+		{name: "binary truncated record", data: binary[:len(binary)-3], read: 1},
+		{name: "binary oversized length", data: append(append([]byte{}, binaryMagic...), 0xff, 0xff, 0xff, 0xff, 0x7f), read: 0},
+		{name: "ndjson corrupt line", data: []byte("{\"topic\":\"orders\",\"offset\":1}\n{\"topic\":\n"), read: 1},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(c.data))
+			if err != nil {
+				t.Fatalf("NewReader: %v", err)
+			}
+			got, err := readAll(r)
+			if err == nil {
+				t.Fatal("broken input should return an error")
+			}
+			if r.Format() == Binary && !errors.Is(err, ErrInvalidFile) {
+				t.Fatalf("expect ErrInvalidFile, got %v", err)
+			}
+			if len(got) != c.read {
+				t.Fatalf("expect %d records before the error, got %d", c.read, len(got))
+			}
+		})
+	}
+}
+
+func TestReplaySpeed(t *testing.T) {
+	base := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	records := []*Record{
+		{Topic: "t", Offset: 0, RecordedAt: base},
+		{Topic: "t", Offset: 1, RecordedAt: base.Add(2 * time.Second)},
+		{Topic: "t", Offset: 2, RecordedAt: base.Add(6 * time.Second)},
+	}
+	cases := []struct {
+		speed Speed
+		waits []time.Duration
+	}{
+		{speed: RealTime, waits: []time.Duration{2 * time.Second, 4 * time.Second}},
+		{speed: 2, waits: []time.Duration{time.Second, 2 * time.Second}},
+		{speed: AsFastAsPossible, waits: nil},
+	}
+	for _, c := range cases {
+		clk := &fakeClock{now: time.Unix(1000, 0)}
+		r, err := NewReader(write(t, NDJSON, records))
+		if err != nil {
+			t.Fatalf("NewReader: %v", err)
+		}
+		offsets := []int64{}
+		n, err := Replay(context.Background(), r, func(ctx context.Context, msg *kafka.Message) error {
+			offsets = append(offsets, int64(msg.TopicPartition.Offset))
+			return nil
+		}, WithSpeed(c.speed), withClock(clk))
+		if err != nil || n != 3 {
+			t.Fatalf("speed %v: expect 3 replayed, got %d, %v", c.speed, n, err)
+		}
+		if !reflect.DeepEqual(offsets, []int64{0, 1, 2}) {
+			t.Fatalf("speed %v: records should be replayed in order, got %v", c.speed, offsets)
+		}
+		if !reflect.DeepEqual(clk.waits, c.waits) {
+			t.Fatalf("speed %v: expect waits %v, got %v", c.speed, c.waits, clk.waits)
+		}
+	}
+}
+
+func TestReplayStopsOnCancel(t *testing.T) {
+	r, err := NewReader(write(t, NDJSON, testRecords()))
+	if err != nil {
+		t.Fatalf("NewReader: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	n, err := Replay(ctx, r, func(context.Context, *kafka.Message) error {
+		cancel()
+		return nil
+	})
+	if !errors.Is(err, context.Canceled) || n != 1 {
+		t.Fatalf("expect to stop after 1 record with context.Canceled, got %d, %v", n, err)
+	}
+}
+
+func TestMiddlewareFlushEvery(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := NewWriter(buf, NDJSON)
+	if err != nil {
+		t.Fatalf("NewWriter: %v", err)
+	}
+	handler := Middleware(w, WithTopics("a"), WithFlushEvery(2))(func(context.Context, *kafka.Message) error { return nil })
+	lines := []int{}
+	for _, topic := range []string{"a", "b", "a", "a"} {
+		topic := topic
+		if err := handler(context.Background(), &kafka.Message{TopicPartition: kafka.TopicPartition{Topic: &topic}}); err != nil {
+			t.Fatalf("handler: %v", err)
+		}
+		lines = append(lines, bytes.Count(buf.Bytes(), []byte("\n")))
+	}
+	//b不录制,第二条a之后flush,第三条a还在缓冲区中
+	if !reflect.DeepEqual(lines, []int{0, 0, 2, 2}) {
+		t.Fatalf("expect flushed lines [0 0 2 2], got %v", lines)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n := bytes.Count(buf.Bytes(), []byte("\n")); n != 3 {
+		t.Fatalf("Close should write the buffered record, got %d lines", n)
+	}
+}
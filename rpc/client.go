@@ -0,0 +1,188 @@
+package rpc
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"github.com/google/uuid"
+)
+
+//ClientOptions 客户端的设置
+type ClientOptions struct {
+	Timeout   time.Duration
+	TopicFunc TopicFunc
+}
+
+var DefaultClientOptions = ClientOptions{
+	Timeout: 30 * time.Second,
+}
+
+//WithTimeout 设置ctx没有截止时间时请求的超时时间,默认30s
+//@params timeout time.Duration 超时时间
+func WithTimeout(timeout time.Duration) optparams.Option[ClientOptions] {
+	return optparams.NewFuncOption(func(o *ClientOptions) {
+		o.Timeout = timeout
+	})
+}
+
+//WithClientTopicFunc 设置服务名到请求topic的映射,需要与服务端一致
+//@params fn TopicFunc 由服务名得到topic
+func WithClientTopicFunc(fn TopicFunc) optparams.Option[ClientOptions] {
+	return optparams.NewFuncOption(func(o *ClientOptions) {
+		o.TopicFunc = fn
+	})
+}
+
+//Client rpc客户端,可以并发发起请求
+type Client struct {
+	Opt        ClientOptions
+	producer   *producerproxy.ProducerProxy
+	consumer   *consumerproxy.ConsumerProxy
+	replyTopic string
+	lock       sync.Mutex
+	pending    map[string]chan *kafka.Message
+	stop       func()
+}
+
+//NewClient 创建rpc客户端
+//consumer用于接收响应,需要已经Init,客户端不订阅replyTopic而是直接分配它的全部分区,起始位置为创建时各分区的末尾,
+//因此NewClient返回后发出的请求的响应都不会因为消费组还未分到分区或offset重置而丢失;
+//replyTopic需要已经存在,创建后新增的分区不会被读取.多个客户端可以共用同一个响应topic,不属于自己的响应会被忽略
+//@params producer *producerproxy.ProducerProxy 发送请求的生产者代理
+//@params consumer *consumerproxy.ConsumerProxy 接收响应的消费者代理
+//@params replyTopic string 响应发往的topic
+func NewClient(producer *producerproxy.ProducerProxy, consumer *consumerproxy.ConsumerProxy, replyTopic string, opts ...optparams.Option[ClientOptions]) (*Client, error) {
+	c := &Client{
+		Opt:        DefaultClientOptions,
+		producer:   producer,
+		consumer:   consumer,
+		replyTopic: replyTopic,
+		pending:    map[string]chan *kafka.Message{},
+	}
+	optparams.GetOption(&c.Opt, opts...)
+	if c.Opt.TopicFunc == nil {
+		c.Opt.TopicFunc = DefaultTopicFunc
+	}
+	err := consumer.OnMessageWithContext(c.onReply)
+	if err != nil {
+		return nil, err
+	}
+	err = c.assign()
+	if err != nil {
+		return nil, err
+	}
+	c.stop = consumer.Watch()
+	return c, nil
+}
+
+//assign 分配响应topic的全部分区,从当前末尾开始读取
+func (c *Client) assign() error {
+	partitions, err := c.consumer.Partitions(c.replyTopic)
+	if err != nil {
+		return err
+	}
+	timeoutMs := int(c.Opt.Timeout.Milliseconds())
+	if timeoutMs <= 0 {
+		timeoutMs = int(DefaultClientOptions.Timeout.Milliseconds())
+	}
+	assignment := make([]kafka.TopicPartition, 0, len(partitions))
+	for _, p := range partitions {
+		_, high, err := c.consumer.QueryWatermarkOffsets(c.replyTopic, p, timeoutMs)
+		if err != nil {
+			return err
+		}
+		assignment = append(assignment, kafka.TopicPartition{Topic: &c.replyTopic, Partition: p, Offset: kafka.Offset(high)})
+	}
+	return c.consumer.Assign(assignment)
+}
+
+//onReply 将响应交给等待中的请求
+func (c *Client) onReply(ctx context.Context, msg *kafka.Message) error {
+	id := header(msg, HeaderCorrelationID)
+	c.lock.Lock()
+	ch, ok := c.pending[id]
+	if ok {
+		delete(c.pending, id)
+	}
+	c.lock.Unlock()
+	if !ok {
+		Logger.Debug("ignore reply", log.Dict{"correlation_id": id})
+		return nil
+	}
+	ch <- msg
+	return nil
+}
+
+//Invoke 调用远程方法并等待响应
+//ctx没有截止时间时使用客户端设置的超时时间,截止时间会通过header传给服务端,服务端不会处理已经超时的请求
+//@params ctx context.Context 请求的上下文
+//@params method string 方法全名,格式为`/服务名/方法名`
+//@params req []byte 请求的内容
+//@params opts ...optparams.Option[kafka.Message] 请求消息的其他设置,比如WithKey
+//@returns []byte 响应的内容,服务端返回错误时为*Error,请求发送失败时为投递的错误,等待超时时为ctx的错误
+func (c *Client) Invoke(ctx context.Context, method string, req []byte, opts ...optparams.Option[kafka.Message]) ([]byte, error) {
+	service, _, err := splitMethod(method)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := ctx.Deadline(); !ok && c.Opt.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.Opt.Timeout)
+		defer cancel()
+	}
+	id := uuid.New().String()
+	msg := msghelper.NewMsg(c.Opt.TopicFunc(service), req, opts...)
+	msg.Headers = append(msg.Headers,
+		kafka.Header{Key: HeaderMethod, Value: []byte(method)},
+		kafka.Header{Key: HeaderReplyTo, Value: []byte(c.replyTopic)},
+		kafka.Header{Key: HeaderCorrelationID, Value: []byte(id)},
+	)
+	if deadline, ok := ctx.Deadline(); ok {
+		msg.Headers = append(msg.Headers, kafka.Header{Key: HeaderDeadline, Value: []byte(strconv.FormatInt(deadline.UnixMilli(), 10))})
+	}
+	ch := make(chan *kafka.Message, 1)
+	c.lock.Lock()
+	c.pending[id] = ch
+	c.lock.Unlock()
+	defer func() {
+		c.lock.Lock()
+		delete(c.pending, id)
+		c.lock.Unlock()
+	}()
+	err = c.producer.SendAndWaitWithContext(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case reply := <-ch:
+		err = errorFromHeaders(reply)
+		if err != nil {
+			return nil, err
+		}
+		return reply.Value, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+//InFlight 正在等待响应的请求数
+func (c *Client) InFlight() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.pending)
+}
+
+//Close 停止接收响应,等待中的请求会在超时后返回,不会关闭生产者和消费者代理
+func (c *Client) Close() {
+	if c.stop != nil {
+		c.stop()
+	}
+}
@@ -0,0 +1,111 @@
+package rpc
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Code rpc的状态码,取值与grpc的状态码一致
+type Code int
+
+const (
+	OK               Code = 0
+	Canceled         Code = 1
+	Unknown          Code = 2
+	InvalidArgument  Code = 3
+	DeadlineExceeded Code = 4
+	NotFound         Code = 5
+	Unimplemented    Code = 12
+	Internal         Code = 13
+	Unavailable      Code = 14
+)
+
+//String 状态码的名字
+func (c Code) String() string {
+	switch c {
+	case OK:
+		return "OK"
+	case Canceled:
+		return "Canceled"
+	case Unknown:
+		return "Unknown"
+	case InvalidArgument:
+		return "InvalidArgument"
+	case DeadlineExceeded:
+		return "DeadlineExceeded"
+	case NotFound:
+		return "NotFound"
+	case Unimplemented:
+		return "Unimplemented"
+	case Internal:
+		return "Internal"
+	case Unavailable:
+		return "Unavailable"
+	default:
+		return "Code(" + strconv.Itoa(int(c)) + ")"
+	}
+}
+
+//Error 服务端返回的错误,通过响应的headers传给客户端
+type Error struct {
+	Code    Code
+	Message string
+}
+
+//Error 实现error接口
+func (e *Error) Error() string {
+	return fmt.Sprintf("rpc error: code = %s desc = %s", e.Code, e.Message)
+}
+
+//Errorf 构造带状态码的错误,服务端处理函数返回它时客户端会收到同样的状态码
+//@params code Code 状态码
+//@params format string 错误信息的格式
+func Errorf(code Code, format string, args ...any) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+//CodeOf 获取错误的状态码,nil为OK,不是*Error的错误为Unknown
+func CodeOf(err error) Code {
+	if err == nil {
+		return OK
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Code
+	}
+	return Unknown
+}
+
+//errorHeaders 将错误写入响应的headers
+func errorHeaders(err error) []kafka.Header {
+	code := CodeOf(err)
+	headers := []kafka.Header{{Key: HeaderStatus, Value: []byte(strconv.Itoa(int(code)))}}
+	if err != nil {
+		msg := err.Error()
+		var e *Error
+		if errors.As(err, &e) {
+			msg = e.Message
+		}
+		headers = append(headers, kafka.Header{Key: HeaderError, Value: []byte(msg)})
+	}
+	return headers
+}
+
+//errorFromHeaders 从响应的headers中还原错误,状态为OK时返回nil
+func errorFromHeaders(msg *kafka.Message) error {
+	status := header(msg, HeaderStatus)
+	if status == "" {
+		return nil
+	}
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return Errorf(Unknown, "invalid status %q", status)
+	}
+	if Code(code) == OK {
+		return nil
+	}
+	return &Error{Code: Code(code), Message: header(msg, HeaderError)}
+}
@@ -0,0 +1,72 @@
+//Package rpc 基于kafka的请求/响应模式,接口风格接近grpc
+//客户端将请求发往服务对应的topic,headers中带上方法名,响应topic和关联id,然后在私有的响应消费者上等待响应;
+//服务端在消费者代理上注册处理函数,处理完成后自动将结果发往请求指定的响应topic,错误通过headers传回
+package rpc
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/Golang-Tools/kafkahelper/internal/logging"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+)
+
+//Logger 模块的logger
+var Logger *log.Log
+
+func init() {
+	Logger = logging.New(log.Dict{"module": "kafka-rpc"})
+}
+
+const (
+	//HeaderMethod 方法全名,格式为`/服务名/方法名`
+	HeaderMethod = "rpc-method"
+	//HeaderReplyTo 响应发往的topic,没有时服务端不响应
+	HeaderReplyTo = "reply-to"
+	//HeaderCorrelationID 关联请求和响应的id
+	HeaderCorrelationID = "correlation-id"
+	//HeaderDeadline 请求的截止时间,unix毫秒
+	HeaderDeadline = "rpc-deadline"
+	//HeaderStatus 响应的状态码
+	HeaderStatus = "rpc-status"
+	//HeaderError 响应的错误信息
+	HeaderError = "rpc-error"
+)
+
+//ErrInvalidMethod 方法名格式不对
+var ErrInvalidMethod = errors.New("rpc method should be /service/method")
+
+//TopicFunc 由服务名得到请求的topic
+type TopicFunc func(service string) string
+
+//DefaultTopicFunc 直接使用服务名作为topic
+func DefaultTopicFunc(service string) string {
+	return service
+}
+
+//MethodName 拼接方法全名
+//@params service string 服务名,比如`helloworld.Greeter`
+//@params method string 方法名,比如`SayHello`
+func MethodName(service, method string) string {
+	return "/" + service + "/" + method
+}
+
+//splitMethod 将方法全名拆成服务名和方法名
+func splitMethod(fullMethod string) (string, string, error) {
+	service, method, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok || service == "" || method == "" || strings.Contains(method, "/") {
+		return "", "", ErrInvalidMethod
+	}
+	return service, method, nil
+}
+
+//header 获取消息中header的值,没有时为空字符串
+func header(msg *kafka.Message, key string) string {
+	for _, h := range msg.Headers {
+		if h.Key == key {
+			return string(h.Value)
+		}
+	}
+	return ""
+}
@@ -0,0 +1,239 @@
+package rpc_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/kafkatest"
+	"github.com/Golang-Tools/kafkahelper/msghelper"
+	"github.com/Golang-Tools/kafkahelper/rpc"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const replyTopic = "replies"
+
+func newClient(t *testing.T, b *kafkatest.Broker) *rpc.Client {
+	t.Helper()
+	producer, err := b.NewProducerProxy()
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	t.Cleanup(producer.Close)
+	consumer, err := b.NewConsumerProxy("rpc-client", consumerproxy.WithComsumerSetting("go.events.channel.enable", true))
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	client, err := rpc.NewClient(producer, consumer, replyTopic, rpc.WithTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("NewClient: %v", err)
+	}
+	t.Cleanup(client.Close)
+	return client
+}
+
+func newServer(t *testing.T, b *kafkatest.Broker, tp trace.TracerProvider, handler rpc.Handler) *rpc.Server {
+	t.Helper()
+	return serve(t, b, tp, map[string]rpc.Handler{"Say": handler})
+}
+
+//serve 启动注册了echo服务的这些方法的服务端
+func serve(t *testing.T, b *kafkatest.Broker, tp trace.TracerProvider, methods map[string]rpc.Handler) *rpc.Server {
+	t.Helper()
+	producer, err := b.NewProducerProxy()
+	if err != nil {
+		t.Fatalf("NewProducerProxy: %v", err)
+	}
+	t.Cleanup(producer.Close)
+	consumer, err := b.NewConsumerProxy("echo-server",
+		consumerproxy.WithTracing(tp),
+		consumerproxy.WithAutoOffsetReset("earliest"),
+		consumerproxy.WithComsumerSetting("go.events.channel.enable", true),
+	)
+	if err != nil {
+		t.Fatalf("NewConsumerProxy: %v", err)
+	}
+	server := rpc.NewServer(consumer, producer, rpc.WithConcurrency(4))
+	server.RegisterService("echo", methods)
+	if err := server.Serve(); err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+	t.Cleanup(server.Stop)
+	return server
+}
+
+func newBroker(t *testing.T) *kafkatest.Broker {
+	t.Helper()
+	b := kafkatest.NewBroker()
+	if err := b.CreateTopic(replyTopic, 2); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	if err := b.CreateTopic(rpc.DefaultTopicFunc("echo"), 1); err != nil {
+		t.Fatalf("CreateTopic: %v", err)
+	}
+	return b
+}
+
+func TestInvokeConcurrentHandlerSpan(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	b := newBroker(t)
+	recording := make(chan bool, 1)
+	newServer(t, b, tp, func(ctx context.Context, req *kafka.Message) ([]byte, error) {
+		//等待消费者的span结束后再检查处理函数的span
+		time.Sleep(100 * time.Millisecond)
+		recording <- trace.SpanFromContext(ctx).IsRecording()
+		return req.Value, nil
+	})
+	client := newClient(t, b)
+	resp, err := client.Invoke(context.Background(), rpc.MethodName("echo", "Say"), []byte("hi"))
+	if err != nil {
+		t.Fatalf("Invoke: %v", err)
+	}
+	if string(resp) != "hi" {
+		t.Fatalf("expect hi, got %q", resp)
+	}
+	if !<-recording {
+		t.Fatal("handler ctx should carry a span that is still recording")
+	}
+}
+
+func TestInvokeReturnsSendError(t *testing.T) {
+	b := newBroker(t)
+	client := newClient(t, b)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	//请求topic只有一个分区,发往不存在的分区时投递失败
+	_, err := client.Invoke(ctx, rpc.MethodName("echo", "Say"), []byte("hi"), msghelper.WithPartition(5))
+	if _, ok := err.(kafka.Error); !ok {
+		t.Fatalf("expect the delivery error, got %v", err)
+	}
+}
+
+func TestInvokeHandlerError(t *testing.T) {
+	b := newBroker(t)
+	serve(t, b, nil, map[string]rpc.Handler{
+		"Find": func(ctx context.Context, req *kafka.Message) ([]byte, error) {
+			return nil, rpc.Errorf(rpc.NotFound, "user %s not found", req.Value)
+		},
+		"Fail": func(ctx context.Context, req *kafka.Message) ([]byte, error) {
+			return nil, errors.New("database is down")
+		},
+		"Panic": func(ctx context.Context, req *kafka.Message) ([]byte, error) {
+			panic("boom")
+		},
+	})
+	client := newClient(t, b)
+	cases := []struct {
+		method  string
+		code    rpc.Code
+		message string
+	}{
+		{method: "Find", code: rpc.NotFound, message: "user u1 not found"},
+		{method: "Fail", code: rpc.Unknown, message: "database is down"},
+		{method: "Panic", code: rpc.Internal, message: "handler panic: boom"},
+		{method: "Missing", code: rpc.Unimplemented, message: "unknown method /echo/Missing"},
+	}
+	for _, c := range cases {
+		resp, err := client.Invoke(context.Background(), rpc.MethodName("echo", c.method), []byte("u1"))
+		var rerr *rpc.Error
+		if !errors.As(err, &rerr) {
+			t.Fatalf("%s: expect *rpc.Error, got %v", c.method, err)
+		}
+		if rerr.Code != c.code || rerr.Message != c.message || rpc.CodeOf(err) != c.code {
+			t.Fatalf("%s: expect %v %q, got %v %q", c.method, c.code, c.message, rerr.Code, rerr.Message)
+		}
+		if resp != nil {
+			t.Fatalf("%s: expect no response on error, got %q", c.method, resp)
+		}
+	}
+}
+
+func TestInvokeConcurrentCorrelation(t *testing.T) {
+	b := newBroker(t)
+	serve(t, b, nil, map[string]rpc.Handler{
+		"Say": func(ctx context.Context, req *kafka.Message) ([]byte, error) {
+			//让先到的请求后完成,响应的顺序与请求不同
+			var n int
+			fmt.Sscanf(string(req.Value), "%d", &n)
+			time.Sleep(time.Duration(20-n%20) * time.Millisecond)
+			return append([]byte("re:"), req.Value...), nil
+		},
+	})
+	//两个客户端共用响应topic,各自只收到自己的响应
+	clients := []*rpc.Client{newClient(t, b), newClient(t, b)}
+	var wg sync.WaitGroup
+	errs := make(chan error, 40)
+	for i := 0; i < 40; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := fmt.Sprintf("%d", i)
+			resp, err := clients[i%2].Invoke(context.Background(), rpc.MethodName("echo", "Say"), []byte(req))
+			if err != nil {
+				errs <- err
+				return
+			}
+			if string(resp) != "re:"+req {
+				errs <- fmt.Errorf("request %s got response %q", req, resp)
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+	for _, c := range clients {
+		if n := c.InFlight(); n != 0 {
+			t.Fatalf("expect no request in flight, got %d", n)
+		}
+	}
+}
+
+func TestInvokeTimeout(t *testing.T) {
+	b := newBroker(t)
+	release := make(chan struct{})
+	deadlines := make(chan bool, 2)
+	serve(t, b, nil, map[string]rpc.Handler{
+		"Slow": func(ctx context.Context, req *kafka.Message) ([]byte, error) {
+			_, ok := ctx.Deadline()
+			deadlines <- ok
+			<-release
+			return req.Value, nil
+		},
+		"Say": func(ctx context.Context, req *kafka.Message) ([]byte, error) {
+			return req.Value, nil
+		},
+	})
+	client := newClient(t, b)
+	ctx, cancel := context.WithTimeout(context.Background(), 300*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := client.Invoke(ctx, rpc.MethodName("echo", "Slow"), []byte("slow"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expect context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Fatalf("Invoke should return at the deadline, took %v", elapsed)
+	}
+	if n := client.InFlight(); n != 0 {
+		t.Fatalf("timed out request should not stay in flight, got %d", n)
+	}
+	if !<-deadlines {
+		t.Fatal("handler ctx should carry the request deadline")
+	}
+	//超时后到达的响应被忽略,不影响之后的请求
+	close(release)
+	resp, err := client.Invoke(context.Background(), rpc.MethodName("echo", "Say"), []byte("hi"))
+	if err != nil || string(resp) != "hi" {
+		t.Fatalf("expect hi after the timeout, got %q, %v", resp, err)
+	}
+}
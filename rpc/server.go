@@ -0,0 +1,234 @@
+package rpc
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Golang-Tools/kafkahelper/consumerproxy"
+	"github.com/Golang-Tools/kafkahelper/producerproxy"
+	log "github.com/Golang-Tools/loggerhelper/v2"
+	"github.com/Golang-Tools/optparams"
+	"github.com/confluentinc/confluent-kafka-go/kafka"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/Golang-Tools/kafkahelper/rpc"
+
+//Handler 方法的处理函数,返回*Error时客户端会收到对应的状态码,其他错误的状态码为Unknown
+//ctx带有请求的截止时间
+type Handler func(ctx context.Context, req *kafka.Message) ([]byte, error)
+
+//ServerOptions 服务端的设置
+type ServerOptions struct {
+	Concurrency int
+	TopicFunc   TopicFunc
+}
+
+var DefaultServerOptions = ServerOptions{}
+
+//WithConcurrency 设置同时处理的请求数,小于等于1时在消费者的监听goroutine中依次处理
+//并发处理时请求的offset在处理前就可能被提交,进程退出时正在处理的请求不会被重新投递
+//@params n int 同时处理的请求数
+func WithConcurrency(n int) optparams.Option[ServerOptions] {
+	return optparams.NewFuncOption(func(o *ServerOptions) {
+		o.Concurrency = n
+	})
+}
+
+//WithServerTopicFunc 设置服务名到请求topic的映射,需要与客户端一致
+//@params fn TopicFunc 由服务名得到topic
+func WithServerTopicFunc(fn TopicFunc) optparams.Option[ServerOptions] {
+	return optparams.NewFuncOption(func(o *ServerOptions) {
+		o.TopicFunc = fn
+	})
+}
+
+//Server rpc服务端
+type Server struct {
+	Opt      ServerOptions
+	consumer *consumerproxy.ConsumerProxy
+	producer *producerproxy.ProducerProxy
+	lock     sync.RWMutex
+	handlers map[string]Handler
+	sem      chan struct{}
+	wg       sync.WaitGroup
+	stop     func()
+}
+
+//NewServer 创建rpc服务端
+//@params consumer *consumerproxy.ConsumerProxy 接收请求的消费者代理,需要已经Init,同一服务的多个实例使用相同的消费组
+//@params producer *producerproxy.ProducerProxy 发送响应的生产者代理
+func NewServer(consumer *consumerproxy.ConsumerProxy, producer *producerproxy.ProducerProxy, opts ...optparams.Option[ServerOptions]) *Server {
+	s := &Server{
+		Opt:      DefaultServerOptions,
+		consumer: consumer,
+		producer: producer,
+		handlers: map[string]Handler{},
+	}
+	optparams.GetOption(&s.Opt, opts...)
+	if s.Opt.TopicFunc == nil {
+		s.Opt.TopicFunc = DefaultTopicFunc
+	}
+	return s
+}
+
+//Register 注册方法的处理函数,需要在Serve前注册
+//@params service string 服务名
+//@params method string 方法名
+//@params handler Handler 处理函数
+func (s *Server) Register(service, method string, handler Handler) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.handlers[MethodName(service, method)] = handler
+}
+
+//RegisterService 注册一个服务的多个方法
+//@params service string 服务名
+//@params methods map[string]Handler 方法名到处理函数的映射
+func (s *Server) RegisterService(service string, methods map[string]Handler) {
+	for method, handler := range methods {
+		s.Register(service, method, handler)
+	}
+}
+
+//topics 已注册服务对应的topic
+func (s *Server) topics() []string {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+	set := map[string]bool{}
+	for fullMethod := range s.handlers {
+		service, _, _ := splitMethod(fullMethod)
+		set[s.Opt.TopicFunc(service)] = true
+	}
+	topics := make([]string, 0, len(set))
+	for t := range set {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+//Serve 订阅已注册服务对应的topic并开始处理请求
+func (s *Server) Serve() error {
+	topics := s.topics()
+	if len(topics) == 0 {
+		return fmt.Errorf("rpc server has no registered method")
+	}
+	if s.Opt.Concurrency > 1 {
+		s.sem = make(chan struct{}, s.Opt.Concurrency)
+	}
+	err := s.consumer.OnMessageWithContext(s.onRequest)
+	if err != nil {
+		return err
+	}
+	err = s.consumer.SubscribeTopics(topics, nil)
+	if err != nil {
+		return err
+	}
+	s.stop = s.consumer.Watch()
+	return nil
+}
+
+//Stop 停止接收请求并等待正在处理的请求完成,不会关闭生产者和消费者代理
+func (s *Server) Stop() {
+	if s.stop != nil {
+		s.stop()
+	}
+	s.wg.Wait()
+}
+
+//onRequest 收到请求时调用处理函数
+//并发处理时消费者的span在onRequest返回后就会结束,因此在它下面另起一个span覆盖处理过程
+func (s *Server) onRequest(ctx context.Context, msg *kafka.Message) error {
+	if s.sem == nil {
+		s.handle(ctx, msg)
+		return nil
+	}
+	s.sem <- struct{}{}
+	s.wg.Add(1)
+	ctx, span := startHandleSpan(ctx, msg)
+	go func() {
+		defer func() {
+			<-s.sem
+			s.wg.Done()
+		}()
+		err := s.handle(ctx, msg)
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}()
+	return nil
+}
+
+//startHandleSpan 以消费者的span为父创建处理请求的span,使用与父span相同的TracerProvider,没有启用追踪时为空操作
+func startHandleSpan(ctx context.Context, msg *kafka.Message) (context.Context, trace.Span) {
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return tracer.Start(ctx, header(msg, HeaderMethod)+" handle", trace.WithSpanKind(trace.SpanKindServer))
+}
+
+//handle 处理一个请求并发送响应,返回处理函数的错误
+func (s *Server) handle(ctx context.Context, msg *kafka.Message) error {
+	method := header(msg, HeaderMethod)
+	if deadline := header(msg, HeaderDeadline); deadline != "" {
+		if ms, err := strconv.ParseInt(deadline, 10, 64); err == nil {
+			d := time.UnixMilli(ms)
+			if time.Now().After(d) {
+				Logger.Debug("drop expired request", log.Dict{"method": method})
+				return context.DeadlineExceeded
+			}
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithDeadline(ctx, d)
+			defer cancel()
+		}
+	}
+	s.lock.RLock()
+	handler, ok := s.handlers[method]
+	s.lock.RUnlock()
+	var resp []byte
+	var err error
+	if !ok {
+		err = Errorf(Unimplemented, "unknown method %s", method)
+	} else {
+		resp, err = s.call(ctx, handler, msg)
+	}
+	if err != nil {
+		Logger.Debug("rpc handler return error", log.Dict{"method": method, "err": err})
+	}
+	s.reply(ctx, msg, resp, err)
+	return err
+}
+
+//call 调用处理函数,处理函数panic时返回Internal错误
+func (s *Server) call(ctx context.Context, handler Handler, msg *kafka.Message) (resp []byte, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			Logger.Error("rpc handler panic", log.Dict{"method": header(msg, HeaderMethod), "panic": fmt.Sprint(r)})
+			err = Errorf(Internal, "handler panic: %v", r)
+		}
+	}()
+	return handler(ctx, msg)
+}
+
+//reply 将结果发往请求指定的响应topic,请求没有响应topic时不响应
+func (s *Server) reply(ctx context.Context, req *kafka.Message, resp []byte, err error) {
+	replyTo := header(req, HeaderReplyTo)
+	if replyTo == "" {
+		return
+	}
+	msg := &kafka.Message{
+		TopicPartition: kafka.TopicPartition{Topic: &replyTo, Partition: kafka.PartitionAny},
+		Value:          resp,
+		Headers:        append([]kafka.Header{{Key: HeaderCorrelationID, Value: []byte(header(req, HeaderCorrelationID))}}, errorHeaders(err)...),
+	}
+	serr := s.producer.SendWithContext(context.Background(), msg)
+	if serr != nil {
+		Logger.Error("send rpc reply get error", log.Dict{"err": serr, "method": header(req, HeaderMethod), "reply_to": replyTo})
+	}
+}